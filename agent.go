@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- ssh-agent per Profile ---
+//
+// Each profile can have its own ssh-agent, listening on a socket in its
+// home, so keys added in one context are never offered in another.
+// `multiprof agent start|stop|status` manages it by hand; with
+// `ssh_agent = true`, Wrappers start it on first use and `multiprof cleanup`
+// stops it once the profile is idle. Whenever a profile's agent is running,
+// Wrappers point SSH_AUTH_SOCK at it.
+
+const (
+	agentSocketName = ".ssh/multiprof-agent.sock"
+	agentPidName    = ".ssh/multiprof-agent.pid"
+	// maxSocketPath is the shortest limit on Unix socket paths (macOS).
+	maxSocketPath = 103
+)
+
+var agentPidRe = regexp.MustCompile(`SSH_AGENT_PID=(\d+)`)
+
+func agentSocket(home string) string  { return filepath.Join(home, agentSocketName) }
+func agentPidFile(home string) string { return filepath.Join(home, agentPidName) }
+
+// agentRunning reports whether an agent is listening on the socket in home.
+func agentRunning(home string) bool {
+	socket := agentSocket(home)
+	if !fileExists(socket) {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", socket, 200*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// startAgent starts the profile's agent unless it is already running.
+func startAgent(p namedProfile) error {
+	if agentRunning(p.Home) {
+		return nil
+	}
+	socket := agentSocket(p.Home)
+	if len(socket) > maxSocketPath {
+		return fmt.Errorf("the agent socket path %s is longer than %d characters, which sockets do not support", socket, maxSocketPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return err
+	}
+	// A socket left behind by an agent that died.
+	if err := removeIfExists(socket); err != nil {
+		return err
+	}
+	cmd := exec.Command("ssh-agent", "-s", "-a", socket)
+	cmd.Env = append(os.Environ(), "HOME="+p.Home)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("could not start ssh-agent: %w", err)
+	}
+	m := agentPidRe.FindSubmatch(out)
+	if m == nil {
+		return fmt.Errorf("could not find the agent's PID in the output of ssh-agent")
+	}
+	return os.WriteFile(agentPidFile(p.Home), append(m[1], '\n'), 0600)
+}
+
+// stopAgent stops the profile's agent, if it is running.
+func stopAgent(p namedProfile) error {
+	data, err := os.ReadFile(agentPidFile(p.Home))
+	if os.IsNotExist(err) {
+		if agentRunning(p.Home) {
+			return fmt.Errorf("an agent is listening on %s, but multiprof did not start it", agentSocket(p.Home))
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	pid := strings.TrimSpace(string(data))
+	if _, err := strconv.Atoi(pid); err != nil {
+		return fmt.Errorf("invalid PID in %s", agentPidFile(p.Home))
+	}
+	if agentRunning(p.Home) {
+		cmd := exec.Command("ssh-agent", "-k")
+		cmd.Env = append(os.Environ(), "SSH_AGENT_PID="+pid, "SSH_AUTH_SOCK="+agentSocket(p.Home))
+		cmd.Stdout = io.Discard
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("could not stop ssh-agent (PID %s): %w", pid, err)
+		}
+	}
+	if err := removeIfExists(agentSocket(p.Home)); err != nil {
+		return err
+	}
+	return removeIfExists(agentPidFile(p.Home))
+}
+
+// agentKeys returns the number of keys the profile's agent holds.
+func agentKeys(home string) (int, error) {
+	cmd := exec.Command("ssh-add", "-l")
+	cmd.Env = append(os.Environ(), "SSH_AUTH_SOCK="+agentSocket(home))
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		// The agent has no keys.
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strings.Count(string(out), "\n"), nil
+}
+
+func runAgent(args []string) {
+	if len(args) < 1 {
+		exitUsage("Usage: multiprof agent start|stop|status [profile]")
+	}
+	if !slices.Contains([]string{"start", "stop", "status"}, args[0]) {
+		exitFailure(errUsage, "Run 'multiprof help' for a list of commands.", "Unknown agent command '%s'.", args[0])
+	}
+	config, _ := loadConfig(readWrite)
+	var profile namedProfile
+	if len(args) > 2 || (len(args) < 2 && args[0] != "status") {
+		exitUsage("Usage: multiprof agent %s <profile>", args[0])
+	} else if len(args) == 2 {
+		p, ok := config.findProfile(args[1])
+		if !ok {
+			exitFailure(errNotFound, "Run 'multiprof list' to see them.", "No profile is named or has the home '%s'.", args[1])
+		}
+		profile = p
+	}
+
+	switch args[0] {
+	case "start":
+		if agentRunning(profile.Home) {
+			logInfo("The ssh-agent of '%s' is already running.", profile.label())
+			return
+		}
+		if err := startAgent(profile); err != nil {
+			exitWith(err)
+		}
+		logSuccess("Started the ssh-agent of '%s' on %s.", profile.label(), tildePath(agentSocket(profile.Home)))
+		logInfo("Wrappers now use it. Add keys with: multiprof run -p %s -- ssh-add", profile.label())
+	case "stop":
+		running := agentRunning(profile.Home)
+		if err := stopAgent(profile); err != nil {
+			exitWith(err)
+		}
+		if !running {
+			logInfo("The ssh-agent of '%s' is not running.", profile.label())
+			return
+		}
+		logSuccess("Stopped the ssh-agent of '%s'.", profile.label())
+	case "status":
+		profiles := []namedProfile{profile}
+		if profile.Home == "" {
+			profiles = config.allProfiles()
+		}
+		t := newTable(3, "PROFILE", "AGENT", "KEYS", "SOCKET")
+		for _, p := range profiles {
+			running := agentRunning(p.Home)
+			if !running && !p.SSHAgent && profile.Home == "" {
+				continue
+			}
+			state, keys := "stopped", "-"
+			if running {
+				state = "running"
+				if n, err := agentKeys(p.Home); err == nil {
+					keys = strconv.Itoa(n)
+				}
+			}
+			t.add(p.label(), state, keys, tildePath(agentSocket(p.Home)))
+		}
+		if len(t.rows) == 0 {
+			logInfo("No profile has an ssh-agent. Start one with 'multiprof agent start <profile>', or set ssh_agent = true on a profile.")
+			return
+		}
+		t.render(os.Stdout, terminalWidth())
+	}
+}
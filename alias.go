@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// --- Command Aliases ---
+//
+// A profile can give commands short names, as in
+// `aliases = { tf = "terraform", k = "kubectl --context acme" }`, and
+// `multiprof add-aliases` creates a Wrapper for each of them. Where the
+// matching Rule selects a profile defining the alias, its Wrapper runs the
+// aliased command with the alias's arguments in front of its own. Elsewhere it
+// is an ordinary Wrapper for a command of that name, which usually does not
+// exist, so short names work exactly in the directories they were meant for.
+// A Rule's `commands` filter sees the alias, not the command it stands for.
+
+// alias returns the command line the profile's alias name stands for.
+func (p Profile) alias(name string) ([]string, bool) {
+	fields := strings.Fields(p.Aliases[name])
+	return fields, len(fields) > 0
+}
+
+// aliasProfiles returns the profiles that define the alias name.
+func aliasProfiles(config Config, name string) []string {
+	var labels []string
+	for _, p := range config.allProfiles() {
+		if _, ok := p.alias(name); ok {
+			labels = append(labels, p.label())
+		}
+	}
+	return labels
+}
+
+// aliasCommand returns the command the alias name stands for in the first
+// profile defining it, so its Wrapper can borrow that command's completion.
+func aliasCommand(config Config, name string) string {
+	for _, p := range config.allProfiles() {
+		if alias, ok := p.alias(name); ok {
+			return alias[0]
+		}
+	}
+	return ""
+}
+
+func runAddAliases(args []string) {
+	addCmd := flag.NewFlagSet("add-aliases", flag.ExitOnError)
+	onConflict := addCmd.String("on-conflict", conflictAsk, "What to do with existing files multiprof did not create: ask, keep, overwrite or backup.")
+	shellFlag := addCmd.String("shell", "", "Shell to write completion files for: bash, zsh or fish (default: from $SHELL).")
+	positional := parseFlags(addCmd, args)
+	if len(positional) > 1 {
+		exitUsage("Usage: multiprof add-aliases [profile] [--on-conflict ask|keep|overwrite|backup] [--shell bash|zsh|fish]")
+	}
+	shell, err := completionShell(*shellFlag)
+	if err != nil {
+		exitWith(err)
+	}
+	if !validConflictPolicy(*onConflict) {
+		exitUsage("Invalid --on-conflict value '%s'.", *onConflict)
+	}
+	config, _ := loadConfig(readWrite)
+	profiles := config.allProfiles()
+	if len(positional) == 1 {
+		p, ok := config.findProfile(positional[0])
+		if !ok {
+			exitFailure(errNotFound, "Run 'multiprof list' to see them.", "No profile is named or has the home '%s'.", positional[0])
+		}
+		profiles = []namedProfile{p}
+	}
+	var names []string
+	for _, p := range profiles {
+		for name := range p.Aliases {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		logInfo("No profile defines aliases. Add some with e.g. aliases = { tf = \"terraform\" } under [profiles.<name>].")
+		return
+	}
+	slices.Sort(names)
+	warnWrapperDirNotInPath()
+
+	tx := newTransaction(readWrite)
+	var created []string
+	recorded := false
+	for _, name := range names {
+		msgs, err := createWrapper(tx, config, name, shell, *onConflict)
+		if err == errKeptExisting {
+			continue
+		} else if err != nil {
+			tx.abort("%v", err)
+		}
+		created = append(created, msgs...)
+		recorded = recordWrapper(&config, name) || recorded
+	}
+	if recorded {
+		if err := tx.SaveConfig(config); err != nil {
+			tx.abort("Could not save config: %v", err)
+		}
+	}
+	tx.Commit()
+	for _, msg := range created {
+		logSuccess("%s", msg)
+	}
+}
+
+// aliasHint explains why the Wrapper for name found no command, if name is
+// an alias only in profiles other than the one in use.
+func aliasHint(config Config, name string, res resolution) string {
+	profiles := aliasProfiles(config, name)
+	if len(profiles) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("'%s' is an alias in profile(s) %s, but here %s applies.", name, strings.Join(profiles, ", "), profileOrigin(res.Profile))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// --- Audit Log ---
+//
+// With `audit_log = true` in [settings], every Wrapper run is also appended
+// to the audit journal, audit.jsonl in the state directory, with the Rule
+// that matched, the directory, the home and the argument list, none of which
+// the stats journal records. `multiprof log --audit` queries it, for
+// questions such as which profile touched a client's credentials and when.
+// Unlike the stats, the audit log is only compacted by
+// `multiprof compact --audit`.
+
+const (
+	auditJournalName = "audit.jsonl"
+	// maxAuditedArgs limits how much of a command line is recorded, as
+	// encoded in the journal.
+	maxAuditedArgs = 2048
+	// maxAuditedDir limits how much of the directory is recorded; longer
+	// ones keep their end.
+	maxAuditedDir = 1024
+)
+
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	Dir     string    `json:"dir"`
+	Rule    string    `json:"rule,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Profile string    `json:"profile"`
+	Home    string    `json:"home"`
+	Command string    `json:"command"`
+	Args    []string  `json:"args,omitempty"`
+	// Truncated is set if trailing arguments or the start of Dir were left
+	// out to keep the record within the journal's size limit.
+	Truncated bool     `json:"truncated,omitempty"`
+	Outcome   *outcome `json:"outcome,omitempty"`
+}
+
+// recordAudit appends a run to the audit journal if audit_log is on.
+func recordAudit(a access, start time.Time, cmdName string, args []string, res resolution, o *outcome) {
+	config, _ := loadConfig(a)
+	if !config.Settings.AuditLog {
+		return
+	}
+	cwd, _ := os.Getwd()
+	dir, cut := truncateStart(cwd, maxAuditedDir)
+	r := auditRecord{
+		Time:    start,
+		Dir:     dir,
+		Profile: res.Profile.label(),
+		Home:    res.Profile.Home,
+		Command: cmdName,
+		Outcome: o,
+	}
+	if res.Index >= 0 {
+		r.Rule, r.Source = res.Rule.Pattern, res.Rule.source
+	}
+	r.Truncated = cut
+	r.Args, r.Truncated = auditedArgs(r, args[1:])
+	if err := appendRecord(a, auditJournalName, r); err != nil {
+		fmt.Fprintf(os.Stderr, "[WARN] Could not write the audit log: %v\n", err)
+	}
+}
+
+// auditedArgs returns as many of args as fit in r within maxAuditedArgs and
+// the journal's record size, counted as JSON encodes them, and whether any
+// were left out or r was already truncated.
+func auditedArgs(r auditRecord, args []string) ([]string, bool) {
+	truncated := r.Truncated
+	r.Truncated, r.Args = true, []string{""} // The largest the rest can be.
+	line, _ := json.Marshal(r)
+	budget := min(maxAuditedArgs, maxJournalRecord-len(line))
+	var kept []string
+	for _, arg := range args {
+		encoded, _ := json.Marshal(arg)
+		if budget -= len(encoded) + 1; budget < 0 {
+			return kept, true
+		}
+		kept = append(kept, arg)
+	}
+	return kept, truncated
+}
+
+// truncateStart returns s with its start cut off so that it has at most max
+// bytes, and whether it was cut.
+func truncateStart(s string, max int) (string, bool) {
+	if len(s) <= max {
+		return s, false
+	}
+	s = s[len(s)-max+len("..."):]
+	for len(s) > 0 && !utf8.RuneStart(s[0]) {
+		s = s[1:]
+	}
+	return "..." + s, true
+}
+
+// runAuditLog implements `multiprof log --audit`: it lists the last limit
+// runs recorded in the audit journal since the given age (0 for all), newest
+// first.
+func runAuditLog(profile string, failures bool, since time.Duration, limit int, noPager bool) {
+	var cutoff time.Time
+	if since > 0 {
+		cutoff = time.Now().Add(-since)
+	}
+	var runs []auditRecord
+	corrupt, err := readJournal(stateFile(auditJournalName), func(line []byte) error {
+		var r auditRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if (!failures || r.Outcome.failed()) && (profile == "" || r.Profile == profile) && !r.Time.Before(cutoff) {
+			runs = append(runs, r)
+		}
+		return nil
+	})
+	if err != nil {
+		exitFailure(errFailed, "", "Could not read the audit log: %v", err)
+	}
+	if len(runs) == 0 && !jsonOutput {
+		config, _ := loadConfig(readWrite)
+		if !config.Settings.AuditLog {
+			logInfo("No runs audited. Set audit_log = true in [settings] to record them.")
+		} else {
+			logInfo("No audited runs match.")
+		}
+		return
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Time.After(runs[j].Time) })
+	if jsonOutput {
+		printJSON(append([]auditRecord{}, runs[:min(limit, len(runs))]...))
+		if corrupt > 0 {
+			logWarn("Skipped %d damaged record(s); 'multiprof compact --audit' removes them.", corrupt)
+		}
+		return
+	}
+
+	out := newPager(!noPager)
+	t := newTable(5, "TIME", "PROFILE", "RULE", "STATUS", "DIR", "COMMAND")
+	for _, r := range runs[:min(limit, len(runs))] {
+		words := []string{r.Command}
+		for _, arg := range r.Args {
+			if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`;&|<>*?()[]{}~#") {
+				arg = shellQuote(arg)
+			}
+			words = append(words, arg)
+		}
+		command := strings.Join(words, " ")
+		if r.Truncated {
+			command += " ..."
+		}
+		rule := r.Rule
+		if rule == "" {
+			rule = "-" // Profile picked with `multiprof run -p`.
+		}
+		t.add(r.Time.Format("2006-01-02 15:04:05"), r.Profile, rule, r.Outcome.String(), tildePath(r.Dir), command)
+	}
+	t.render(out, terminalWidth())
+	out.Close()
+	if corrupt > 0 {
+		logWarn("Skipped %d damaged record(s); 'multiprof compact --audit' removes them.", corrupt)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAuditKeepsOversizedRuns(t *testing.T) {
+	root := setupDaemonTest(t, "[settings]\naudit_log = true\n")
+	if err := ensureStateDir(readWrite); err != nil {
+		t.Fatal(err)
+	}
+	deep := root
+	for range 40 {
+		deep = filepath.Join(deep, strings.Repeat("d", 40))
+	}
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(deep)
+	args := []string{"tool"}
+	for range 500 {
+		args = append(args, "<>&<>&")
+	}
+
+	recordAudit(readWrite, time.Now(), "tool", args, resolution{Index: -1, Profile: namedProfile{Home: root}}, &outcome{})
+	var records []auditRecord
+	if _, err := readJournal(stateFile(auditJournalName), func(line []byte) error {
+		if len(line) >= maxJournalRecord {
+			t.Errorf("record of %d bytes", len(line))
+		}
+		var r auditRecord
+		err := json.Unmarshal(line, &r)
+		records = append(records, r)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("audit journal holds %d records, want 1", len(records))
+	}
+	r := records[0]
+	if !r.Truncated || len(r.Args) == 0 || len(r.Args) >= len(args)-1 {
+		t.Errorf("kept %d of %d arguments, truncated = %v", len(r.Args), len(args)-1, r.Truncated)
+	}
+	if len(r.Dir) > maxAuditedDir || !strings.HasSuffix(deep, strings.TrimPrefix(r.Dir, "...")) {
+		t.Errorf("Dir = %q, want the end of %q", r.Dir, deep)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// --- Home Bootstrap ---
+//
+// A profile (or a Rule with an inline home) with `autocreate = true` gets its
+// home created the first time a Wrapper needs it, seeded from `skeleton` if
+// set. Without it, a missing home only draws a warning, since a tool
+// started in it tends to fail in confusing ways.
+
+// ensureHome creates p's home if it is missing and the profile allows it,
+// for a command with access a.
+func ensureHome(a access, p namedProfile) error {
+	if p.Encrypted || fileExists(p.Home) {
+		return nil
+	}
+	if !p.Autocreate {
+		where := "the Rule"
+		if p.Name != "" {
+			where = fmt.Sprintf("profile '%s'", p.Name)
+		}
+		fmt.Fprintf(os.Stderr, "[WARN] Home '%s' does not exist. Create it, or set autocreate = true on %s to have it created on first use.\n", p.Home, where)
+		return nil
+	}
+	skeleton := expandPath(p.Skeleton)
+	if skeleton != "" && !fileExists(skeleton) {
+		return fmt.Errorf("skeleton '%s' for home '%s' does not exist", skeleton, p.Home)
+	}
+	created, err := bootstrapHome(a, p.Home, skeleton)
+	if err != nil {
+		return fmt.Errorf("could not create home '%s': %w", p.Home, err)
+	}
+	if created {
+		fmt.Fprintf(os.Stderr, "[INFO] Created home '%s'.\n", p.Home)
+	}
+	return nil
+}
+
+// bootstrapHome creates home, with a copy of skeleton if it is not "", and
+// reports whether it did so rather than another process. The home is
+// assembled next to its final place and renamed into it, so Wrappers
+// starting at the same time never see it half-copied.
+func bootstrapHome(a access, home, skeleton string) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(home), 0755); err != nil {
+		return false, err
+	}
+	staging := fmt.Sprintf("%s.multiprof-new-%d", home, os.Getpid())
+	tx := newTransaction(a)
+	if err := tx.MkdirAll(staging, 0700); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if skeleton != "" {
+		if err := tx.CopyTree(skeleton, staging); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	if err := os.Chmod(staging, 0700); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := os.Rename(staging, home); err != nil {
+		tx.Rollback()
+		if fileExists(home) {
+			debugf("Home '%s' was created by another process", home)
+			return false, nil
+		}
+		return false, err
+	}
+	tx.Commit()
+	return true, nil
+}
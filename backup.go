@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// --- Config Backups ---
+//
+// Every command that changes the config first copies the current file to
+// ~/.config/multiprof/backups/config-<timestamp>.toml, keeping the newest
+// maxConfigBackups. `multiprof restore` lists them and puts one back.
+
+const (
+	backupsDirName   = "backups"
+	maxConfigBackups = 10
+	backupTimeFormat = "20060102-150405.000"
+)
+
+func backupsDir() string {
+	configPath, _ := getConfigPath()
+	return filepath.Join(filepath.Dir(configPath), backupsDirName)
+}
+
+// backupConfig copies the config file to the backups directory, once per
+// transaction, and removes the oldest backups beyond maxConfigBackups.
+func backupConfig(tx *transaction) error {
+	configPath, _ := getConfigPath()
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) || tx.backedUp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	dir := backupsDir()
+	if err := tx.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("config-%s.toml", time.Now().Format(backupTimeFormat))
+	if err := tx.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		return err
+	}
+	tx.backedUp = true
+	backups := listBackups()
+	for len(backups) > maxConfigBackups {
+		oldest := backups[len(backups)-1]
+		if err := tx.Remove(oldest); err != nil {
+			return err
+		}
+		backups = backups[:len(backups)-1]
+	}
+	return nil
+}
+
+// listBackups returns the paths of the config backups, newest first.
+func listBackups() []string {
+	matches, _ := filepath.Glob(filepath.Join(backupsDir(), "config-*.toml"))
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	return matches
+}
+
+// backupTime returns when a backup was made, from its name.
+func backupTime(path string) (time.Time, bool) {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "config-"), ".toml")
+	t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+	return t, err == nil
+}
+
+func runRestore(args []string) {
+	if len(args) > 1 {
+		exitUsage("Usage: multiprof restore [<number>|<file>]")
+	}
+	backups := listBackups()
+	if len(args) == 0 {
+		if len(backups) == 0 {
+			logInfo("There are no backups in %s yet.", backupsDir())
+			return
+		}
+		fmt.Printf("--- Backups in %s (newest first) ---\n", backupsDir())
+		for i, path := range backups {
+			var config Config
+			summary := "does not parse"
+			if _, err := toml.DecodeFile(path, &config); err == nil {
+				summary = fmt.Sprintf("%d Rules, %d profiles", len(config.Rules), len(config.Profiles))
+			}
+			when := filepath.Base(path)
+			if t, ok := backupTime(path); ok {
+				when = t.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%d: %s (%s)\n", i+1, when, summary)
+		}
+		fmt.Println("Restore one with: multiprof restore <number>")
+		return
+	}
+
+	path := expandPath(args[0])
+	if n, err := strconv.Atoi(args[0]); err == nil {
+		if n < 1 || n > len(backups) {
+			exitFailure(errNotFound, "Run 'multiprof restore' to list them.", "There is no backup %d.", n)
+		}
+		path = backups[n-1]
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		exitFailure(errFailed, "", "Could not read %s: %v", path, err)
+	}
+	var config Config
+	if _, err := toml.Decode(string(data), &config); err != nil {
+		exitFailure(errFailed, "", "%s is not a valid config: %v", path, err)
+	}
+
+	// The current config is backed up first, so a restore can be undone.
+	tx := newTransaction(readWrite)
+	if err := tx.WriteConfig(data); err != nil {
+		tx.abort("Could not restore the config: %v", err)
+	}
+	tx.Commit()
+	configPath, _ := getConfigPath()
+	logSuccess("Restored %s from %s (%d Rules).", configPath, path, len(config.Rules))
+	logInfo("The previous config is now the newest backup; 'multiprof restore 1' undoes this.")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Rule Benchmark ---
+//
+// `multiprof bench-rules` times the Rule matcher against a corpus of paths
+// supplied by the user and can write pprof profiles, so slow configurations
+// can be reported with data attached.
+
+func runBenchRules(args []string) {
+	benchCmd := flag.NewFlagSet("bench-rules", flag.ExitOnError)
+	pathsFile := benchCmd.String("paths-file", "", "File with one directory per line to match against the Rules.")
+	iterations := benchCmd.Int("iterations", 100, "How many times to match each path.")
+	cpuProfile := benchCmd.String("cpuprofile", "", "Write a pprof CPU profile to this file.")
+	memProfile := benchCmd.String("memprofile", "", "Write a pprof heap profile to this file.")
+	benchCmd.Parse(args)
+	if *pathsFile == "" || *iterations < 1 {
+		benchCmd.Usage()
+		exitFailure(errUsage, "", "--paths-file is required and --iterations must be positive.")
+	}
+
+	paths, err := readPathsFile(*pathsFile)
+	if err != nil {
+		exitFailure(errFailed, "", "Could not read paths file: %v", err)
+	}
+	if len(paths) == 0 {
+		exitFailure(errFailed, "", "Paths file '%s' contains no paths.", *pathsFile)
+	}
+	config, _ := loadConfig(readWrite)
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			exitFailure(errFailed, "", "Could not create CPU profile: %v", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			exitFailure(errFailed, "", "Could not start CPU profile: %v", err)
+		}
+	}
+
+	durations := make([]time.Duration, 0, len(paths)**iterations)
+	matched := 0
+	start := time.Now()
+	for i := 0; i < *iterations; i++ {
+		for _, path := range paths {
+			t := time.Now()
+			_, ok := matchRule(config, path)
+			durations = append(durations, time.Since(t))
+			if ok && i == 0 {
+				matched++
+			}
+		}
+	}
+	elapsed := time.Since(start)
+	if *cpuProfile != "" {
+		pprof.StopCPUProfile()
+	}
+
+	if *memProfile != "" {
+		f, err := os.Create(*memProfile)
+		if err != nil {
+			exitFailure(errFailed, "", "Could not create heap profile: %v", err)
+		}
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			logError("Could not write heap profile: %v", err)
+		}
+		f.Close()
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	percentile := func(p float64) time.Duration { return durations[int(float64(len(durations)-1)*p)] }
+	fmt.Printf("Rules:       %d\n", len(config.Rules))
+	fmt.Printf("Paths:       %d (%d matched, %d unmatched)\n", len(paths), matched, len(paths)-matched)
+	fmt.Printf("Lookups:     %d in %s\n", len(durations), elapsed.Round(time.Microsecond))
+	fmt.Printf("Mean:        %s\n", (elapsed / time.Duration(len(durations))).Round(time.Nanosecond))
+	fmt.Printf("p50/p90/p99: %s / %s / %s\n", percentile(0.5), percentile(0.9), percentile(0.99))
+	fmt.Printf("Max:         %s\n", durations[len(durations)-1])
+	if *cpuProfile != "" {
+		logInfo("CPU profile written to %s (inspect with: go tool pprof %s)", *cpuProfile, *cpuProfile)
+	}
+	if *memProfile != "" {
+		logInfo("Heap profile written to %s", *memProfile)
+	}
+}
+
+func readPathsFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			paths = append(paths, line)
+		}
+	}
+	return paths, scanner.Err()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Homebrew ---
+//
+// `eval "$(brew shellenv)"` prepends Homebrew's bin directories to PATH. If it
+// runs after the line adding the Wrapper Directory, Homebrew's binaries win
+// and Wrappers without a suffix are silently bypassed. These checks look at
+// both the current PATH and the order of the lines in the shell startup files.
+
+// shellStartupFiles lists each shell's startup files in the order they run.
+var shellStartupFiles = map[string][]string{
+	"zsh":  {".zshenv", ".zprofile", ".zshrc", ".zlogin"},
+	"bash": {".profile", ".bash_profile", ".bashrc"},
+}
+
+// homebrewBinDirs returns the bin directories of Homebrew installations
+// present on this machine.
+func homebrewBinDirs() []string {
+	candidates := []string{"/opt/homebrew/bin", "/usr/local/bin", "/home/linuxbrew/.linuxbrew/bin", expandPath("~/.linuxbrew/bin")}
+	if prefix := os.Getenv("HOMEBREW_PREFIX"); prefix != "" {
+		candidates = append([]string{filepath.Join(prefix, "bin")}, candidates...)
+	}
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, dir := range candidates {
+		if !seen[dir] && fileExists(filepath.Join(dir, "brew")) {
+			dirs = append(dirs, dir)
+		}
+		seen[dir] = true
+	}
+	return dirs
+}
+
+// homebrewWarnings describes ways Homebrew's PATH entries would take
+// precedence over the Wrappers. With a cmdName, it also says whether that
+// command in particular would bypass its Wrapper.
+func homebrewWarnings(config Config, cmdName string) []string {
+	if config.Settings.Suffix != "" {
+		return nil // Suffixed Wrappers never share a name with a Homebrew binary.
+	}
+	brewDirs := homebrewBinDirs()
+	if len(brewDirs) == 0 {
+		return nil
+	}
+	wrapperDir, _ := getWrapperDir()
+	path := filepath.SplitList(os.Getenv("PATH"))
+	wrapperIndex := pathIndex(path, wrapperDir)
+	var warnings []string
+	for _, dir := range brewDirs {
+		brewIndex := pathIndex(path, dir)
+		if wrapperIndex < 0 || brewIndex < 0 || brewIndex > wrapperIndex {
+			continue
+		}
+		msg := fmt.Sprintf("Homebrew's %s comes before the Wrapper Directory in your PATH", dir)
+		if cmdName != "" && fileExists(filepath.Join(dir, cmdName)) {
+			msg += fmt.Sprintf(", so '%s' runs Homebrew's binary directly instead of the Wrapper", cmdName)
+		}
+		warnings = append(warnings, msg+".")
+	}
+	return append(warnings, shellenvOrderWarnings(wrapperDir)...)
+}
+
+// shellenvOrderWarnings reports startup files that run `brew shellenv` after
+// the Wrapper Directory was added to PATH.
+func shellenvOrderWarnings(wrapperDir string) []string {
+	var warnings []string
+	for _, shell := range []string{"bash", "zsh"} {
+		var wrapperLine, brewLine string
+		for _, name := range shellStartupFiles[shell] {
+			path := expandPath(filepath.Join("~/", name))
+			f, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			scanner := bufio.NewScanner(f)
+			for n := 1; scanner.Scan(); n++ {
+				line := strings.TrimSpace(scanner.Text())
+				if strings.HasPrefix(line, "#") {
+					continue
+				}
+				location := fmt.Sprintf("%s:%d", tildePath(path), n)
+				if strings.Contains(line, "brew shellenv") {
+					brewLine = location
+				} else if mentionsDir(line, wrapperDir) {
+					wrapperLine, brewLine = location, ""
+				}
+			}
+			f.Close()
+		}
+		if wrapperLine != "" && brewLine != "" {
+			warnings = append(warnings, fmt.Sprintf("%s runs `brew shellenv` after %s adds the Wrapper Directory to PATH, putting Homebrew first. Move the PATH line below the `brew shellenv` line.", brewLine, wrapperLine))
+		}
+	}
+	return warnings
+}
+
+// mentionsDir reports whether a shell line refers to dir, literally or
+// relative to $HOME.
+func mentionsDir(line, dir string) bool {
+	rel := strings.TrimPrefix(tildePath(dir), "~/")
+	return strings.Contains(line, dir) || strings.Contains(line, "~/"+rel) ||
+		strings.Contains(line, "$HOME/"+rel) || strings.Contains(line, "${HOME}/"+rel)
+}
+
+func indexOf(list []string, dir string) int {
+	for i, entry := range list {
+		if filepath.Clean(entry) == filepath.Clean(dir) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// --- Cleanup Hooks ---
+//
+// A profile may list `cleanup` commands that tear down its state, such as
+// an ssh-agent or a mounted home, once the profile has been idle for a while.
+// Wrappers record when each profile was last used, and `multiprof cleanup`
+// (run by hand or from the systemd timer) or a running `multiprof daemon`
+// runs the hooks of idle profiles.
+
+const (
+	defaultIdleTimeout = 2 * time.Hour
+	lastUsedDirName    = "last-used"
+	systemdUnitName    = "multiprof-cleanup"
+	systemdUserDirName = ".config/systemd/user"
+)
+
+// cleanupTarget is a profile with cleanup commands, an encrypted home, which
+// is locked during cleanup, or an ssh-agent, which is stopped.
+type cleanupTarget struct {
+	namedProfile
+	IdleTimeout time.Duration
+}
+
+func runCleanup(args []string) {
+	cleanupCmd := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	installTimer := cleanupCmd.Bool("install-timer", false, "Install a systemd user timer that runs cleanup periodically.")
+	interval := cleanupCmd.String("interval", "15min", "How often the systemd timer fires.")
+	cleanupCmd.Parse(args)
+
+	if *installTimer {
+		if err := installCleanupTimer(*interval); err != nil {
+			exitFailure(errFailed, "", "Could not install cleanup timer: %v", err)
+		}
+		return
+	}
+
+	config, _ := loadConfig(readWrite)
+	targets, err := collectCleanupTargets(config)
+	if err != nil {
+		exitWith(err)
+	}
+
+	// An explicit profile is cleaned up immediately, regardless of idle time.
+	if name := cleanupCmd.Arg(0); name != "" {
+		profile, ok := config.findProfile(name)
+		if ok {
+			for _, t := range targets {
+				if t.Home == profile.Home {
+					runCleanupTarget(t)
+					return
+				}
+			}
+		}
+		exitFailure(errNotFound, "", "No profile named or with home '%s' has cleanup commands, encryption or an ssh-agent.", name)
+	}
+	cleanupIdle(targets)
+}
+
+// cleanupIdle runs the cleanup of each target idle for longer than its
+// timeout.
+func cleanupIdle(targets []cleanupTarget) {
+	for _, t := range targets {
+		lastUsed, err := profileLastUsed(t.Home)
+		if err != nil {
+			debugf("Skipping '%s': no recorded use since last cleanup", t.label())
+			continue
+		}
+		if idle := time.Since(lastUsed); idle < t.IdleTimeout {
+			debugf("Skipping '%s': idle for %s, timeout is %s", t.label(), idle.Round(time.Second), t.IdleTimeout)
+			continue
+		}
+		runCleanupTarget(t)
+	}
+}
+
+func collectCleanupTargets(config Config) ([]cleanupTarget, error) {
+	defaultTimeout := defaultIdleTimeout
+	if config.Settings.IdleTimeout != "" {
+		d, err := time.ParseDuration(config.Settings.IdleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid settings.idle_timeout '%s': %w", config.Settings.IdleTimeout, err)
+		}
+		defaultTimeout = d
+	}
+
+	var targets []cleanupTarget
+	for _, p := range config.allProfiles() {
+		if len(p.Cleanup) == 0 && !p.Encrypted && !p.SSHAgent {
+			continue
+		}
+		timeout := defaultTimeout
+		if p.IdleTimeout != "" {
+			d, err := time.ParseDuration(p.IdleTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid idle_timeout '%s' for profile '%s': %w", p.IdleTimeout, p.label(), err)
+			}
+			timeout = d
+		}
+		targets = append(targets, cleanupTarget{namedProfile: p, IdleTimeout: timeout})
+	}
+	return targets, nil
+}
+
+func runCleanupTarget(t cleanupTarget) {
+	logInfo("Running cleanup for '%s'...", t.label())
+	for _, c := range t.Cleanup {
+		cmd := shellCommand(c)
+		cmd.Env = append(os.Environ(), "HOME="+t.Home)
+		if fileExists(t.Home) {
+			cmd.Dir = t.Home
+		}
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			logWarn("Cleanup command '%s' failed: %v", c, err)
+		}
+	}
+	if t.SSHAgent {
+		if err := stopAgent(t.namedProfile); err != nil {
+			logWarn("Could not stop the ssh-agent of '%s': %v", t.label(), err)
+		}
+	}
+	if t.Encrypted {
+		if err := unmountHome(t.HomeSpec, t.Home); err != nil {
+			logWarn("Could not lock encrypted home '%s': %v", t.Home, err)
+		}
+	}
+	if err := os.Remove(lastUsedPath(t.Home)); err != nil && !os.IsNotExist(err) {
+		debugf("Could not clear last-used marker: %v", err)
+	}
+	logSuccess("Cleaned up '%s'.", t.label())
+}
+
+func installCleanupTimer(interval string) error {
+	multiprofPath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	tx := newTransaction(readWrite)
+	defer tx.Rollback()
+	unitDir := expandPath(filepath.Join("~/", systemdUserDirName))
+	if err := tx.MkdirAll(unitDir, 0755); err != nil {
+		return err
+	}
+	data := struct{ Executable, Interval string }{Executable: multiprofPath, Interval: interval}
+	units := []struct{ ext, name, text string }{
+		{".service", "cleanup.template.service", cleanupServiceTemplate},
+		{".timer", "cleanup.template.timer", cleanupTimerTemplate},
+	}
+	var written []string
+	for _, u := range units {
+		tmpl, err := loadTemplate(u.name, u.text)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return err
+		}
+		path := filepath.Join(unitDir, systemdUnitName+u.ext)
+		if err := tx.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		written = append(written, path)
+	}
+	tx.Commit()
+	for _, path := range written {
+		logSuccess("Wrote %s", path)
+	}
+	logInfo("Enable the timer with: systemctl --user daemon-reload && systemctl --user enable --now %s.timer", systemdUnitName)
+	return nil
+}
+
+// --- Last-Used Tracking ---
+
+func lastUsedPath(home string) string {
+	return stateFile(lastUsedDirName, url.PathEscape(home))
+}
+
+// markProfileUsed records that home was used now, for a command with
+// access a.
+func markProfileUsed(a access, home string) error {
+	if err := ensureStateDir(a); err != nil {
+		return err
+	}
+	path := lastUsedPath(home)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return nil
+	}
+	return os.WriteFile(path, nil, 0600)
+}
+
+func profileLastUsed(home string) (time.Time, error) {
+	info, err := os.Stat(lastUsedPath(home))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// --- Shell Completions ---
+//
+// Suffixed Wrappers need a completion file telling the shell to complete them
+// like the command they wrap. bash loads them from the bash-completion user
+// directory, zsh from a directory in $fpath: $XDG_DATA_HOME/zsh/site-functions,
+// which the setup instructions add, and fish from its own completions
+// directory. Commands that create Wrappers write the files for the shell
+// given with --shell, or else for the one in $SHELL.
+
+const (
+	shellBash = "bash"
+	shellZsh  = "zsh"
+	shellFish = "fish"
+)
+
+// completionShells are the shells multiprof writes completion files for.
+var completionShells = []string{shellBash, shellZsh, shellFish}
+
+// completionShell returns the shell to write completion files for: the
+// value of --shell if given, otherwise $SHELL if it is supported, or bash.
+func completionShell(flagValue string) (string, error) {
+	if flagValue != "" {
+		if !slices.Contains(completionShells, flagValue) {
+			return "", fmt.Errorf("unsupported shell '%s' (use %s or %s)", flagValue, strings.Join(completionShells[:len(completionShells)-1], ", "), completionShells[len(completionShells)-1])
+		}
+		return flagValue, nil
+	}
+	if shell := filepath.Base(os.Getenv("SHELL")); slices.Contains(completionShells, shell) {
+		return shell, nil
+	}
+	return shellBash, nil
+}
+
+// completionPath returns the completion file of wrapperName for shell.
+func completionPath(shell, wrapperName string) string {
+	switch shell {
+	case shellZsh:
+		return filepath.Join(getZshCompletionDir(), "_"+wrapperName)
+	case shellFish:
+		return filepath.Join(getFishCompletionDir(), wrapperName+".fish")
+	}
+	completionDir, _ := getCompletionDir()
+	return filepath.Join(completionDir, wrapperName)
+}
+
+// completionPaths returns every place a completion file of wrapperName may
+// be, including the legacy bash directory.
+func completionPaths(wrapperName string) []string {
+	var paths []string
+	for _, shell := range completionShells {
+		paths = append(paths, completionPath(shell, wrapperName))
+	}
+	if legacy := filepath.Join(legacyCompletionDir(), wrapperName); !slices.Contains(paths, legacy) {
+		paths = append(paths, legacy)
+	}
+	return paths
+}
+
+// completionDirs returns the directories holding completion files.
+func completionDirs() []string {
+	completionDir, _ := getCompletionDir()
+	return []string{completionDir, legacyCompletionDir(), getZshCompletionDir(), getFishCompletionDir()}
+}
+
+func createCompletionFile(tx *transaction, shell, wrapperName, originalCmd, onConflict string) error {
+	path := completionPath(shell, wrapperName)
+	if err := tx.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create completion directory: %w", err)
+	}
+	if err := clearPath(tx, path, onConflict, isOwnCompletion); err != nil {
+		return err
+	}
+
+	name, builtin := "completion.template.bash", completionTemplate
+	switch shell {
+	case shellZsh:
+		name, builtin = "completion.template.zsh", zshCompletionTemplate
+	case shellFish:
+		name, builtin = "completion.template.fish", fishCompletionTemplate
+	}
+	tmpl, err := loadTemplate(name, builtin)
+	if err != nil {
+		return err
+	}
+	data := struct {
+		WrapperName string
+		OriginalCmd string
+		HookName    string
+	}{
+		WrapperName: wrapperName,
+		OriginalCmd: originalCmd,
+		HookName:    "multiprof_hook_" + strings.ReplaceAll(wrapperName, "-", "_"),
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	return tx.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,4 @@
+# Generated by multiprof for the '{{.WrapperName}}' wrapper.
+
+# Complete '{{.WrapperName}}' exactly like '{{.OriginalCmd}}'.
+complete --command {{.WrapperName}} --wraps {{.OriginalCmd}}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// --- Rule Conditions ---
+//
+// Besides its pattern, a Rule can carry conditions on how multiprof was
+// invoked or on the directory it runs in. A Rule only matches when all of its
+// conditions hold, so later Rules can catch the cases an earlier one
+// excludes.
+
+// invokedCommand is the name of the command being run through multiprof, or
+// empty when no command is involved (e.g. `multiprof shell`).
+var invokedCommand string
+
+// conditionsMet reports whether rule's conditions hold in dir, and if not,
+// why.
+func conditionsMet(rule Rule, dir string) (bool, string) {
+	if rule.InteractiveOnly && !isInteractive() {
+		return false, "interactive_only, but not running on a terminal"
+	}
+	if rule.NonInteractiveOnly && isInteractive() {
+		return false, "non_interactive_only, but running on a terminal"
+	}
+	if len(rule.Commands) > 0 && !slices.Contains(rule.Commands, invokedCommand) {
+		if invokedCommand == "" {
+			return false, "the Rule only applies to the commands " + fmt.Sprint(rule.Commands)
+		}
+		return false, fmt.Sprintf("'%s' is not one of the commands %v", invokedCommand, rule.Commands)
+	}
+	if rule.Host != "" {
+		if ok, reason := hostMatches(rule.Host); !ok {
+			return false, reason
+		}
+	}
+	for _, key := range sortedKeys(rule.WhenEnv) {
+		if ok, reason := envMatches(key, rule.WhenEnv[key]); !ok {
+			return false, reason
+		}
+	}
+	if rule.GitRemote != "" {
+		if ok, reason := gitRemoteMatches(rule.GitRemote, dir); !ok {
+			return false, reason
+		}
+	}
+	return true, ""
+}
+
+// gitRemoteMatches reports whether any remote URL of the repository
+// containing dir matches the glob pattern.
+func gitRemoteMatches(pattern, dir string) (bool, string) {
+	g, err := compileGlob(pattern)
+	if err != nil {
+		return false, fmt.Sprintf("invalid git_remote '%s': %v", pattern, err)
+	}
+	urls := gitRemoteURLs(dir)
+	if len(urls) == 0 {
+		return false, "git_remote is set, but the directory is not in a git repository with remotes"
+	}
+	for _, url := range urls {
+		if g.Match(url) {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("no remote URL matches git_remote '%s'", pattern)
+}
+
+// isInteractive reports whether multiprof was started from a terminal, as
+// opposed to cron, CI or another non-interactive parent. The daemon answers
+// for the Wrapper that asked it; see daemon.go.
+func isInteractive() bool {
+	if serving != nil {
+		return serving.Interactive
+	}
+	return isTerminal(os.Stdin)
+}
+
+// lookupEnv is os.LookupEnv, except that the daemon looks variables up
+// among those the client it answers sent; see daemon.go.
+func lookupEnv(key string) (string, bool) {
+	if serving != nil {
+		return serving.lookupEnv(key)
+	}
+	return os.LookupEnv(key)
+}
+
+// hostMatches reports whether this machine's host name matches the glob
+// pattern. Both the full name and the part before the first dot are tried,
+// so "laptop" matches laptop.example.com.
+func hostMatches(pattern string) (bool, string) {
+	g, err := compileGlob(pattern)
+	if err != nil {
+		return false, fmt.Sprintf("invalid host '%s': %v", pattern, err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return false, fmt.Sprintf("could not determine the host name: %v", err)
+	}
+	short, _, _ := strings.Cut(hostname, ".")
+	if g.Match(hostname) || g.Match(short) {
+		return true, ""
+	}
+	return false, fmt.Sprintf("host '%s' does not match '%s'", hostname, pattern)
+}
+
+// envMatches reports whether the variable key is set to a value matching the
+// glob pattern. An empty pattern instead requires key to be unset or empty.
+func envMatches(key, pattern string) (bool, string) {
+	value, set := lookupEnv(key)
+	if pattern == "" {
+		if value != "" {
+			return false, fmt.Sprintf("when_env requires %s to be unset, but it is set", key)
+		}
+		return true, ""
+	}
+	if !set {
+		return false, fmt.Sprintf("when_env requires %s, but it is not set", key)
+	}
+	g, err := compileGlob(pattern)
+	if err != nil {
+		return false, fmt.Sprintf("invalid when_env pattern '%s' for %s: %v", pattern, key, err)
+	}
+	if !g.Match(value) {
+		return false, fmt.Sprintf("%s does not match when_env pattern '%s'", key, pattern)
+	}
+	return true, ""
+}
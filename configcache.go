@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// --- Config Cache ---
+//
+// Wrappers start on every command, some of them (git in a shell prompt) many
+// times a second, and parsing the TOML config and its includes takes most of
+// that time. So Wrappers and the shell hook keep the parsed config in
+// config.cache in the state directory and use it as long as the config, the
+// files it includes and the multiprof binary are unchanged. That is decided
+// by their paths, sizes and modification times, and by a hash of their
+// contents when these differ or are too recent to trust. Compiled patterns
+// cannot be stored; instead only the globs of Rules whose literal prefix the
+// directory starts with are compiled, each once per run (see outsidePrefix).
+// Deleting the file is always safe.
+
+const configCacheName = "config.cache"
+
+// racyWindow is how recently before the cache was written a file may have
+// changed for its modification time not to be trusted: a change within the
+// same tick of the file system's clock leaves it as it was.
+const racyWindow = 2 * time.Second
+
+// configCache is the content of the cache file.
+type configCache struct {
+	Key string
+	// Written is when the cache was written, and Files the config and the
+	// files it includes as they were then, for checking them without
+	// reading them.
+	Written time.Time
+	Files   []fileStamp
+	Binary  string
+	Config  Config
+	// Rules and Included carry what gob leaves out: the unexported fields,
+	// and enabled = false, which gob would decode as unset.
+	Rules    []cachedRule
+	Included map[string]string
+}
+
+type cachedRule struct {
+	Source   string
+	Disabled bool
+}
+
+type fileStamp struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// configFiles returns the config at configPath and the files matching its
+// include globs, in the order they are read.
+func configFiles(configPath string, includes []string) []string {
+	files := []string{configPath}
+	for _, pattern := range includes {
+		matches, _ := filepath.Glob(expandPath(pattern))
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files
+}
+
+// statFiles returns the stamps of files, or false if one cannot be read.
+func statFiles(files []string) ([]fileStamp, bool) {
+	stamps := make([]fileStamp, len(files))
+	for i, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, false
+		}
+		stamps[i] = fileStamp{path, info.Size(), info.ModTime()}
+	}
+	return stamps, true
+}
+
+// unchanged reports whether the config files and the binary are as they were
+// when the cache was written, judging by their stamps alone.
+func (cache *configCache) unchanged(configPath string) bool {
+	if cache.Binary != binaryStamp() {
+		return false
+	}
+	stamps, ok := statFiles(configFiles(configPath, cache.Config.Include))
+	if !ok || len(stamps) != len(cache.Files) {
+		return false
+	}
+	for i, stamp := range stamps {
+		old := cache.Files[i]
+		if stamp.Path != old.Path || stamp.Size != old.Size || !stamp.ModTime.Equal(old.ModTime) {
+			return false
+		}
+		if !old.ModTime.Before(cache.Written.Add(-racyWindow)) {
+			return false
+		}
+	}
+	return true
+}
+
+// configCacheKey hashes what the config at configPath, whose content is data,
+// was parsed from: the file, the files matching its include globs and the
+// running binary, whose Config type the cache was written with.
+func configCacheKey(configPath string, data []byte, includes []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s %d\n", binaryStamp(), configPath, len(data))
+	h.Write(data)
+	for _, pattern := range includes {
+		matches, _ := filepath.Glob(expandPath(pattern))
+		sort.Strings(matches)
+		for _, path := range matches {
+			content, _ := os.ReadFile(path)
+			fmt.Fprintf(h, "\n%s %d\n", path, len(content))
+			h.Write(content)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newConfigCache returns the cache of config, parsed from data at
+// configPath, or false if a file it was read from is gone.
+func newConfigCache(configPath string, data []byte, config Config) (*configCache, bool) {
+	written := time.Now()
+	stamps, ok := statFiles(configFiles(configPath, config.Include))
+	if !ok {
+		return nil, false
+	}
+	cache := &configCache{
+		Key:      configCacheKey(configPath, data, config.Include),
+		Written:  written,
+		Files:    stamps,
+		Binary:   binaryStamp(),
+		Config:   config,
+		Rules:    make([]cachedRule, len(config.Rules)),
+		Included: config.included,
+	}
+	for i, rule := range config.Rules {
+		cache.Rules[i] = cachedRule{Source: rule.source, Disabled: rule.disabled()}
+	}
+	return cache, true
+}
+
+// upToDate reports whether the cache holds the config at configPath, whose
+// content is data. hashed is set if only hashing could tell, which writing
+// the cache again with the current stamps saves next time.
+func (cache *configCache) upToDate(configPath string, data []byte) (ok, hashed bool) {
+	if cache.unchanged(configPath) {
+		return true, false
+	}
+	if cache.Key != configCacheKey(configPath, data, cache.Config.Include) {
+		return false, false
+	}
+	debugf("Config files were touched but have not changed")
+	return true, true
+}
+
+// config returns the cached config.
+func (cache *configCache) config() Config {
+	config := cache.Config
+	disabled := false
+	for i, rule := range cache.Rules {
+		config.Rules[i].source = rule.Source
+		if rule.Disabled {
+			config.Rules[i].Enabled = &disabled
+		}
+	}
+	config.included = cache.Included
+	return config
+}
+
+// cachedConfig returns the cached config if it was parsed from data at
+// configPath and the files it includes have not changed since.
+func cachedConfig(configPath string, data []byte) (Config, bool) {
+	f, err := os.Open(stateFile(configCacheName))
+	if err != nil {
+		return Config{}, false
+	}
+	defer f.Close()
+	var cache configCache
+	if err := gob.NewDecoder(f).Decode(&cache); err != nil {
+		debugf("Ignoring unreadable config cache: %v", err)
+		return Config{}, false
+	}
+	if len(cache.Rules) != len(cache.Config.Rules) {
+		debugf("Ignoring inconsistent config cache")
+		return Config{}, false
+	}
+	ok, hashed := cache.upToDate(configPath, data)
+	if !ok {
+		debugf("Config cache is out of date")
+		return Config{}, false
+	}
+	config := cache.config()
+	debugf("Using the cached config")
+	if hashed {
+		saveConfigCache(configPath, data, config)
+	}
+	return config, true
+}
+
+// saveConfigCache caches config, parsed from data at configPath, if the state
+// directory exists. A config whose includes could not all be used is not
+// cached, so that the warnings about them are repeated.
+func saveConfigCache(configPath string, data []byte, config Config) {
+	if config.incomplete {
+		return
+	}
+	cache, ok := newConfigCache(configPath, data, config)
+	if !ok {
+		debugf("Not caching the config: a file it was read from is gone")
+		return
+	}
+	if err := ensureStateDir(readOnly); err != nil {
+		debugf("Could not cache the config: %v", err)
+		return
+	}
+	path := stateFile(configCacheName)
+	f, err := os.CreateTemp(filepath.Dir(path), ".config-cache-*")
+	if err != nil {
+		debugf("Could not cache the config: %v", err)
+		return
+	}
+	defer os.Remove(f.Name())
+	err = gob.NewEncoder(f).Encode(cache)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		debugf("Could not cache the config: %v", err)
+	}
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// --- In-place Config Edits ---
+//
+// Rewriting the config from the decoded structs drops the user's comments
+// and layout. Commands that change the config therefore edit the file as
+// text instead: it is split into units (the keys before the first table,
+// [settings], each profile, preset and Wrapper setting, and each Rule, with
+// their sub-tables and the comments directly above them), units that did
+// not change are kept as they are, keys that did change are patched line by
+// line, and new units are added after the last one of their kind. If a
+// changed key cannot be patched (multi-line values, keys in sub-tables), its
+// whole unit is replaced. The edited file is decoded again and must describe
+// exactly the intended config; if it does not, the config is rewritten.
+
+var bareKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// configSections are the tables of the config, in the order new units are
+// added in.
+var configSections = []string{"settings", "profiles", "presets", "wrappers", "rules"}
+
+// tomlValue encodes v as a TOML value.
+func tomlValue(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(map[string]interface{}{"v": v}); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimPrefix(buf.String(), "v = ")), nil
+}
+
+// tomlKey quotes key if it is not a bare key.
+func tomlKey(key string) string {
+	if bareKeyRe.MatchString(key) {
+		return key
+	}
+	quoted, _ := tomlValue(key)
+	return quoted
+}
+
+// tomlInline encodes a decoded TOML value on one line, writing tables as
+// inline tables.
+func tomlInline(v interface{}) string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		var parts []string
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			parts = append(parts, tomlKey(key)+" = "+tomlInline(v[key]))
+		}
+		if len(parts) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(parts, ", ") + " }"
+	case []map[string]interface{}:
+		parts := make([]string, len(v))
+		for i, table := range v {
+			parts[i] = tomlInline(table)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = tomlInline(item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	default:
+		value, _ := tomlValue(v)
+		return value
+	}
+}
+
+// scanLine splits a TOML line into its content and trailing comment. depth
+// is the number of arrays and inline tables the line leaves open (negative
+// if it closes ones opened on earlier lines), and ok is false if the line
+// has a multi-line string, which is not edited in place.
+func scanLine(line string) (content, comment string, depth int, ok bool) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if strings.HasPrefix(line[i:], `"""`) || strings.HasPrefix(line[i:], `'''`) {
+				return line, "", depth, false
+			}
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == '#':
+			return line[:i], line[i:], depth, true
+		}
+	}
+	return line, "", depth, quote == 0
+}
+
+// parseTableHeader returns the key path of a [table] or [[array]] header.
+func parseTableHeader(line string) (path []string, array, ok bool) {
+	content, _, _, ok := scanLine(strings.TrimSpace(line))
+	content = strings.TrimSpace(content)
+	if !ok || !strings.HasPrefix(content, "[") || !strings.HasSuffix(content, "]") {
+		return nil, false, false
+	}
+	inner := content[1 : len(content)-1]
+	if strings.HasPrefix(inner, "[") && strings.HasSuffix(inner, "]") {
+		array, inner = true, inner[1:len(inner)-1]
+	}
+	for {
+		inner = strings.TrimLeft(inner, " \t")
+		var key string
+		if inner != "" && (inner[0] == '"' || inner[0] == '\'') {
+			end := strings.IndexByte(inner[1:], inner[0]) + 1
+			if end == 0 {
+				return nil, false, false
+			}
+			if inner[0] == '"' {
+				var v struct{ K string }
+				if _, err := toml.Decode("K = "+inner[:end+1], &v); err != nil {
+					return nil, false, false
+				}
+				key = v.K
+			} else {
+				key = inner[1:end]
+			}
+			inner = inner[end+1:]
+		} else {
+			end := strings.IndexAny(inner, ".")
+			if end < 0 {
+				end = len(inner)
+			}
+			key = strings.TrimSpace(inner[:end])
+			if !bareKeyRe.MatchString(key) {
+				return nil, false, false
+			}
+			inner = inner[end:]
+		}
+		path = append(path, key)
+		inner = strings.TrimLeft(inner, " \t")
+		if inner == "" {
+			return path, array, true
+		}
+		if inner[0] != '.' {
+			return nil, false, false
+		}
+		inner = inner[1:]
+	}
+}
+
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+// configUnit is one part of the config file, see above.
+type configUnit struct {
+	// section is "" for the keys before the first table. name is the name
+	// of a profile, preset or Wrapper setting.
+	section, name string
+	lines         []string
+	// header is the index of the unit's table header in lines, or -1, and
+	// body the index of its first sub-table header, or len(lines).
+	header, body int
+	// subKeys are the keys of the unit that are written as sub-tables.
+	subKeys []string
+	// pos is the unit's position in the file, or -1 for units encoded by
+	// multiprof.
+	pos int
+}
+
+// splitConfig splits the text of a config file into units. It fails if a
+// table cannot be assigned to a unit, e.g. because the sub-tables of a
+// profile are not next to it.
+func splitConfig(text string) ([]configUnit, bool) {
+	units := []configUnit{{header: -1}}
+	seen := make(map[string]bool)
+	depth := 0
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		cur := &units[len(units)-1]
+		if path, array, ok := parseTableHeader(line); ok && depth == 0 {
+			name, sub := "", false
+			switch section := path[0]; {
+			case !slices.Contains(configSections, section):
+				return nil, false
+			case section == "rules" || section == "settings":
+				sub = len(path) > 1 || (section == "rules") != array
+			case len(path) > 1:
+				name, sub = path[1], len(path) > 2 || array
+			}
+			if sub {
+				if cur.section != path[0] || cur.name != name || cur.header < 0 {
+					return nil, false
+				}
+				keyAt := 1
+				if name != "" {
+					keyAt = 2
+				}
+				cur.subKeys = append(cur.subKeys, path[keyAt])
+				cur.lines = append(cur.lines, line)
+				continue
+			}
+			id := path[0] + "." + name
+			if seen[id] && path[0] != "rules" {
+				return nil, false
+			}
+			seen[id] = true
+			// Comments directly above the header belong to the new unit.
+			n := len(cur.lines)
+			for n > 0 && isCommentLine(cur.lines[n-1]) {
+				n--
+			}
+			lines := append(slices.Clone(cur.lines[n:]), line)
+			cur.lines = cur.lines[:n]
+			if cur.body > n {
+				cur.body = n
+			}
+			units = append(units, configUnit{section: path[0], name: name, lines: lines, header: len(lines) - 1, body: len(lines), pos: len(units)})
+			continue
+		}
+		_, _, d, ok := scanLine(line)
+		if !ok {
+			return nil, false
+		}
+		depth += d
+		if cur.body == len(cur.lines) {
+			cur.body++
+		}
+		cur.lines = append(cur.lines, line)
+	}
+	return units, depth == 0
+}
+
+// patchTable sets (with values already encoded as TOML) and removes keys of
+// the unit's own table. It fails if one of the keys is written as a
+// sub-table or cannot be edited line by line.
+func patchTable(u configUnit, set map[string]string, unset []string) (configUnit, bool) {
+	for _, key := range u.subKeys {
+		if _, ok := set[key]; ok || slices.Contains(unset, key) {
+			return u, false
+		}
+	}
+	lines := slices.Clone(u.lines[:u.body])
+	// keyLines maps the keys of the table to their lines; last is the line
+	// after which new keys are added.
+	keyLines := make(map[string]int)
+	indent, last, depth := "", u.header, 0
+	for i := u.header + 1; i < len(lines); i++ {
+		content, _, d, _ := scanLine(lines[i])
+		if depth == 0 && strings.TrimSpace(content) != "" {
+			keyPart, _, _ := strings.Cut(content, "=")
+			var v map[string]interface{}
+			if _, err := toml.Decode(strings.TrimSpace(keyPart)+" = 0", &v); err == nil && len(v) == 1 {
+				for key := range v {
+					keyLines[key] = i
+				}
+			}
+			indent = lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " \t"))]
+		}
+		depth += d
+		if strings.TrimSpace(content) != "" {
+			last = i
+		}
+	}
+	if last < 0 {
+		// Before the first table, new keys go after any comments.
+		for i := range lines {
+			if strings.TrimSpace(lines[i]) != "" {
+				last = i
+			}
+		}
+	}
+
+	remove := make(map[int]bool)
+	for _, key := range unset {
+		if i, ok := keyLines[key]; ok {
+			if _, _, d, ok := scanLine(lines[i]); !ok || d != 0 {
+				return u, false
+			}
+			remove[i] = true
+		}
+	}
+	var insert []string
+	for _, key := range slices.Sorted(maps.Keys(set)) {
+		i, ok := keyLines[key]
+		if !ok {
+			insert = append(insert, indent+tomlKey(key)+" = "+set[key])
+			continue
+		}
+		content, comment, d, ok := scanLine(lines[i])
+		if !ok || d != 0 {
+			return u, false
+		}
+		keyPart, _, _ := strings.Cut(content, "=")
+		lines[i] = keyPart + "= " + set[key]
+		if comment != "" {
+			space := content[len(strings.TrimRight(content, " \t")):]
+			lines[i] += space + comment
+		}
+	}
+
+	var out []string
+	if last < 0 {
+		out = append(out, insert...)
+	}
+	for i, line := range lines {
+		if !remove[i] {
+			out = append(out, line)
+		}
+		if i == last {
+			out = append(out, insert...)
+		}
+	}
+	u.body = len(out)
+	u.lines = append(out, u.lines[len(lines):]...)
+	return u, true
+}
+
+// tableChanges returns the keys to set, encoded as TOML, and to remove to
+// turn the decoded table old into new.
+func tableChanges(old, new map[string]interface{}) (set map[string]string, unset []string) {
+	set = make(map[string]string)
+	for key, value := range new {
+		if !reflect.DeepEqual(old[key], value) {
+			set[key] = tomlInline(value)
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			unset = append(unset, key)
+		}
+	}
+	return set, unset
+}
+
+// decodedConfig returns config as generic TOML tables, the way it would be
+// written to the file.
+func decodedConfig(config Config) (map[string]interface{}, error) {
+	data, err := encodeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	var decoded map[string]interface{}
+	_, err = toml.Decode(string(data), &decoded)
+	return decoded, err
+}
+
+// unitValue returns the decoded table of a unit other than a Rule, or nil.
+func unitValue(decoded map[string]interface{}, section, name string) map[string]interface{} {
+	switch section {
+	case "":
+		top := make(map[string]interface{})
+		for key, value := range decoded {
+			if !slices.Contains(configSections, key) {
+				top[key] = value
+			}
+		}
+		return top
+	case "settings":
+		settings, _ := decoded[section].(map[string]interface{})
+		return settings
+	default:
+		tables, _ := decoded[section].(map[string]interface{})
+		table, _ := tables[name].(map[string]interface{})
+		return table
+	}
+}
+
+// freshUnit encodes a unit of config, the index'th Rule for "rules".
+func freshUnit(config Config, section, name string, index int) (configUnit, error) {
+	var v interface{}
+	switch section {
+	case "settings":
+		v = map[string]interface{}{section: config.Settings}
+	case "profiles":
+		v = map[string]interface{}{section: map[string]Profile{name: config.Profiles[name]}}
+	case "presets":
+		v = map[string]interface{}{section: map[string]map[string]string{name: config.Presets[name]}}
+	case "wrappers":
+		v = map[string]interface{}{section: map[string]WrapperSettings{name: config.Wrappers[name]}}
+	case "rules":
+		v = map[string]interface{}{section: []Rule{config.Rules[index]}}
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return configUnit{}, err
+	}
+	u := configUnit{section: section, name: name, header: -1, pos: -1}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if path, _, ok := parseTableHeader(line); ok {
+			if u.header < 0 && (name == "" || len(path) > 1) {
+				u.header = len(u.lines)
+			} else if u.header >= 0 {
+				// The encoder puts a sub-table right under its parent's
+				// keys; set it apart as every other table is.
+				if n := len(u.lines); n > 0 && u.lines[n-1] != "" {
+					u.lines = append(u.lines, "")
+				}
+				if len(u.subKeys) == 0 {
+					u.body = len(u.lines)
+				}
+				u.subKeys = append(u.subKeys, path[len(path)-1])
+			}
+		}
+		if u.header >= 0 && (line != "" || len(u.lines) > 0) {
+			u.lines = append(u.lines, line)
+		}
+	}
+	for len(u.lines) > 0 && u.lines[len(u.lines)-1] == "" {
+		u.lines = u.lines[:len(u.lines)-1]
+	}
+	if len(u.subKeys) == 0 {
+		u.body = len(u.lines)
+	}
+	return u, nil
+}
+
+// editConfig returns text, the current config file, edited to describe
+// config. With perKey, changed units are patched key by key where
+// possible; otherwise they are replaced as a whole.
+func editConfig(text string, config Config, perKey bool) (string, error) {
+	config = config.own()
+	units, ok := splitConfig(text)
+	if !ok {
+		return "", fmt.Errorf("the file's layout cannot be edited in place")
+	}
+	var old Config
+	if _, err := toml.Decode(text, &old); err != nil {
+		return "", err
+	}
+	oldTables, err := decodedConfig(old)
+	if err != nil {
+		return "", err
+	}
+	newTables, err := decodedConfig(config)
+	if err != nil {
+		return "", err
+	}
+
+	// change turns a unit with the value old into one with the value new.
+	change := func(u configUnit, old, new map[string]interface{}, index int) (configUnit, error) {
+		if reflect.DeepEqual(old, new) {
+			return u, nil
+		}
+		if perKey || u.section == "" {
+			set, unset := tableChanges(old, new)
+			if patched, ok := patchTable(u, set, unset); ok {
+				return patched, nil
+			} else if u.section == "" {
+				return u, fmt.Errorf("the keys before the first table cannot be edited in place")
+			}
+		}
+		// Replace the table, keeping the comments above it and the blank
+		// line after it.
+		fresh, err := freshUnit(config, u.section, u.name, index)
+		fresh.lines = append(slices.Clone(u.lines[:u.header]), fresh.lines...)
+		fresh.header += u.header
+		fresh.body += u.header
+		fresh.pos = u.pos
+		if last := u.lines[len(u.lines)-1]; strings.TrimSpace(last) == "" {
+			fresh.lines = append(fresh.lines, last)
+		}
+		return fresh, err
+	}
+
+	// Rules that did not change keep their text, wherever they move to.
+	// Otherwise, if the number of Rules is the same, a Rule was edited.
+	oldRules, _ := oldTables["rules"].([]map[string]interface{})
+	newRules, _ := newTables["rules"].([]map[string]interface{})
+	var ruleUnits []configUnit
+	for _, u := range units {
+		if u.section == "rules" {
+			ruleUnits = append(ruleUnits, u)
+		}
+	}
+	if len(ruleUnits) != len(oldRules) {
+		return "", fmt.Errorf("found %d Rules in the file but decoded %d", len(ruleUnits), len(oldRules))
+	}
+	used := make([]bool, len(oldRules))
+	var rules []configUnit
+	for i, rule := range newRules {
+		j := -1
+		if i < len(oldRules) && !used[i] && reflect.DeepEqual(oldRules[i], rule) {
+			j = i
+		} else {
+			for k := range oldRules {
+				if !used[k] && reflect.DeepEqual(oldRules[k], rule) {
+					j = k
+					break
+				}
+			}
+		}
+		switch {
+		case j >= 0:
+			used[j] = true
+			rules = append(rules, ruleUnits[j])
+		case len(newRules) == len(oldRules) && !used[i]:
+			used[i] = true
+			u, err := change(ruleUnits[i], oldRules[i], rule, i)
+			if err != nil {
+				return "", err
+			}
+			rules = append(rules, u)
+		default:
+			u, err := freshUnit(config, "rules", "", i)
+			if err != nil {
+				return "", err
+			}
+			rules = append(rules, u)
+		}
+	}
+
+	// The Rules stay where the first one was.
+	var out []configUnit
+	inFile := make(map[string]bool)
+	rulesPlaced := false
+	for _, u := range units {
+		inFile[u.section+"."+u.name] = true
+		switch {
+		case u.section == "rules":
+			if !rulesPlaced {
+				rulesPlaced = true
+				out = append(out, rules...)
+			}
+		case u.section != "" && u.section != "settings" && u.name == "":
+			// A bare [profiles] table.
+			out = append(out, u)
+		default:
+			value := unitValue(newTables, u.section, u.name)
+			if value == nil && u.section != "" && u.section != "settings" {
+				continue // removed
+			}
+			changed, err := change(u, unitValue(oldTables, u.section, u.name), value, 0)
+			if err != nil {
+				return "", err
+			}
+			out = append(out, changed)
+		}
+	}
+	if !rulesPlaced {
+		out = append(out, rules...)
+	}
+
+	// New units go after the last one of their section, or of the sections
+	// before it.
+	for rank, section := range configSections[:len(configSections)-1] {
+		names := []string{""}
+		if section != "settings" {
+			tables, _ := newTables[section].(map[string]interface{})
+			names = slices.Sorted(maps.Keys(tables))
+		}
+		for _, name := range names {
+			value := unitValue(newTables, section, name)
+			if inFile[section+"."+name] || reflect.DeepEqual(value, unitValue(oldTables, section, name)) {
+				continue
+			}
+			u, err := freshUnit(config, section, name, 0)
+			if err != nil {
+				return "", err
+			}
+			at := 0
+			for i, other := range out {
+				if other.section == "" || slices.Index(configSections, other.section) <= rank {
+					at = i + 1
+				}
+			}
+			out = slices.Insert(out, at, u)
+		}
+	}
+
+	// Units that were not next to each other before are separated by a
+	// blank line.
+	var lines []string
+	prev := -1
+	for _, u := range out {
+		if (u.pos < 0 || u.pos != prev+1) && len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, u.lines...)
+		prev = u.pos
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	edited := strings.Join(lines, "\n") + "\n"
+
+	var check Config
+	want, err1 := encodeConfig(config)
+	_, err2 := toml.Decode(edited, &check)
+	got, err3 := encodeConfig(check)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return "", err
+	}
+	if !bytes.Equal(want, got) {
+		return "", fmt.Errorf("the edited file does not describe the new config")
+	}
+	return edited, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// saveConfigText writes text as the config, applies change to it as
+// loaded and saves it as management commands do, and returns the file.
+func saveConfigText(t *testing.T, text string, change func(*Config)) string {
+	t.Helper()
+	setupDaemonTest(t, text)
+	config, err := parseConfig([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(&config)
+	tx := newTransaction(readWrite)
+	if err := tx.SaveConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+	configPath, _ := getConfigPath()
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+const editBase = `# My config.
+include = ["~/more.toml"]
+
+[settings]
+suffix = "-mp" # Short.
+
+# Work things.
+[profiles.work]
+home = "~/work-home"
+
+[profiles.work.env]
+GIT_AUTHOR_NAME = "Me"
+
+[profiles.play]
+home = "~/play-home"
+
+# Client A.
+[[rules]]
+pattern = "~/a/**"
+profile = "work"
+exclude = ["~/a/x/**", "~/a/y/**"]
+env = { TEAM = "a" }
+
+# Client B.
+[[rules]]
+pattern = "~/b/**"
+home = "~/b-home"
+`
+
+// edited returns editBase with each old string in pairs replaced by the
+// string after it.
+func edited(pairs ...string) string {
+	return strings.NewReplacer(pairs...).Replace(editBase)
+}
+
+func TestSaveConfigKeepsLayout(t *testing.T) {
+	ruleA := `# Client A.
+[[rules]]
+pattern = "~/a/**"
+profile = "work"
+exclude = ["~/a/x/**", "~/a/y/**"]
+env = { TEAM = "a" }
+`
+	ruleB := `# Client B.
+[[rules]]
+pattern = "~/b/**"
+home = "~/b-home"
+`
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"unchanged", func(*Config) {}, editBase},
+		{"changed key", func(c *Config) { c.Settings.Suffix = "-x" },
+			edited(`"-mp" # Short.`, `"-x" # Short.`)},
+		{"sub-table", func(c *Config) {
+			p := c.Profiles["work"]
+			p.Env = map[string]string{"GIT_AUTHOR_NAME": "You"}
+			c.Profiles["work"] = p
+		}, edited(`"Me"`, `"You"`)},
+		{"reordered rules", func(c *Config) { c.Rules[0], c.Rules[1] = c.Rules[1], c.Rules[0] },
+			edited(ruleA+"\n"+ruleB, ruleB+"\n"+ruleA)},
+		{"removed rule", func(c *Config) { c.Rules = c.Rules[1:] },
+			edited(ruleA+"\n", "")},
+		{"removed profile", func(c *Config) { delete(c.Profiles, "play") },
+			edited("[profiles.play]\nhome = \"~/play-home\"\n\n", "")},
+		{"new units", func(c *Config) {
+			c.Profiles["new"] = Profile{HomeSpec: HomeSpec{Home: "~/new-home"}}
+			c.Presets = map[string]map[string]string{"proxy": {"HTTPS_PROXY": "http://p:3128"}}
+			c.Rules = append(c.Rules, Rule{Pattern: "~/c/**", Profile: "new"})
+		}, edited(
+			"home = \"~/play-home\"\n", `home = "~/play-home"
+
+[profiles.new]
+home = "~/new-home"
+
+[presets.proxy]
+HTTPS_PROXY = "http://p:3128"
+`,
+			ruleB, ruleB+`
+[[rules]]
+pattern = "~/c/**"
+profile = "new"
+`)},
+		{"inline table and array", func(c *Config) {
+			c.Rules[0].Env = map[string]string{"TEAM": "a2"}
+			c.Rules[0].Exclude = c.Rules[0].Exclude[:1]
+		}, edited(`["~/a/x/**", "~/a/y/**"]`, `["~/a/x/**"]`, `{ TEAM = "a" }`, `{ TEAM = "a2" }`)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := saveConfigText(t, editBase, tc.change); got != tc.want {
+				t.Errorf("saved config:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSaveConfigRewritesUnsplittableConfig(t *testing.T) {
+	// A sub-table away from its profile cannot be edited as a unit, so the
+	// config is rewritten whole, without its comments.
+	text := `# Comment.
+[profiles.a]
+home = "~/a"
+
+[profiles.b]
+home = "~/b"
+
+[profiles.a.env]
+X = "1"
+`
+	got := saveConfigText(t, text, func(c *Config) {
+		p := c.Profiles["b"]
+		p.Home = "~/b2"
+		c.Profiles["b"] = p
+	})
+	if strings.Contains(got, "# Comment.") {
+		t.Errorf("saved config kept comments it cannot place:\n%s", got)
+	}
+	config, err := parseConfig([]byte(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Profiles["b"].Home != "~/b2" || config.Profiles["a"].Env["X"] != "1" {
+		t.Errorf("saved config lost a setting:\n%s", got)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- Conflict Resolution ---
+//
+// Before multiprof places a Wrapper or completion file it checks whether
+// something is already there. Files multiprof created itself are replaced
+// silently; anything else is a conflict resolved by the --on-conflict policy.
+
+const (
+	conflictAsk       = "ask"
+	conflictKeep      = "keep"
+	conflictOverwrite = "overwrite"
+	conflictBackup    = "backup"
+	generatedMarker   = "Generated by multiprof"
+)
+
+// errKeptExisting is returned when a conflicting file was left in place.
+var errKeptExisting = errors.New("kept existing file")
+
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case conflictAsk, conflictKeep, conflictOverwrite, conflictBackup:
+		return true
+	}
+	return false
+}
+
+// clearPath makes path available for writing. It returns errKeptExisting if
+// a foreign file is there and the policy (or the user) chose to keep it.
+func clearPath(tx *transaction, path, policy string, owned func(string) bool) error {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if owned(path) {
+		debugf("Replacing multiprof-managed file: %s", path)
+		return tx.Remove(path)
+	}
+
+	if policy == conflictAsk {
+		if !isTerminal(os.Stdin) {
+			logWarn("'%s' already exists and was not created by multiprof; keeping it.", path)
+			logInfo("Use --on-conflict=overwrite or --on-conflict=backup to replace it.")
+			return errKeptExisting
+		}
+		policy = askConflict(path)
+	}
+
+	switch policy {
+	case conflictOverwrite:
+		return tx.Remove(path)
+	case conflictBackup:
+		backup := fmt.Sprintf("%s.bak-%s", path, time.Now().Format("20060102-150405"))
+		if err := tx.Rename(path, backup); err != nil {
+			return err
+		}
+		logInfo("Moved existing '%s' to '%s'.", path, backup)
+		return nil
+	default:
+		logInfo("Kept existing '%s'.", path)
+		return errKeptExisting
+	}
+}
+
+func askConflict(path string) string {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("'%s' already exists and was not created by multiprof.\n[k]eep, [o]verwrite or [b]ackup? ", path)
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			return conflictKeep
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "k", "keep", "":
+			return conflictKeep
+		case "o", "overwrite":
+			return conflictOverwrite
+		case "b", "backup":
+			return conflictBackup
+		}
+	}
+}
+
+// isOwnWrapper reports whether path is a symlink to this multiprof binary, a
+// hardlink or Wrapper script (see linkmode.go), or a symlink the Wrapper
+// manifest lists, which may point at a previous install location.
+func isOwnWrapper(path string) bool {
+	return slices.ContainsFunc(wrapperFiles(path), isOwnWrapperFile)
+}
+
+// isOwnWrapperFile is isOwnWrapper for a single file of a Wrapper.
+func isOwnWrapperFile(path string) bool {
+	target, err := os.Readlink(path)
+	if err != nil {
+		if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+			return isWrapperScript(path) || sameAsSelf(path)
+		}
+		return false
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return sameAsSelf(target) || inWrapperManifest(path)
+}
+
+// inWrapperManifest reports whether path is in the Wrapper Directory, or the
+// old one, under the name of a Wrapper the config lists (see
+// wrappermanifest.go).
+func inWrapperManifest(path string) bool {
+	wrapperDir, _ := getWrapperDir()
+	if dir := filepath.Dir(path); dir != wrapperDir && dir != legacyWrapperDir() {
+		return false
+	}
+	return listsWrapper(filepath.Base(path))
+}
+
+// listsWrapper reports whether the config lists a Wrapper whose file is
+// named file.
+func listsWrapper(file string) bool {
+	name, ok := wrapperFileName(file)
+	if !ok {
+		return false
+	}
+	config, _ := loadConfig(readOnly)
+	for cmdName := range config.Wrappers {
+		if config.wrapperName(cmdName) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwnCompletion reports whether path is a completion file multiprof wrote.
+func isOwnCompletion(path string) bool { return hasGeneratedMarker(path) }
+
+// hasGeneratedMarker reports whether a file starts with multiprof's
+// "Generated by" comment.
+func hasGeneratedMarker(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 256)
+	n, _ := f.Read(head)
+	return strings.Contains(string(head[:n]), generatedMarker)
+}
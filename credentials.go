@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// --- Credentials Commands ---
+//
+// A Rule's `credentials_cmd` prints short-lived credentials as KEY=VALUE
+// lines, like `aws configure export-credentials --format env` or a vault
+// login wrapped in a small script. Wrappers run it with the Rule's
+// environment once the profile is active and add what it prints to the
+// command's environment. The output is cached in the state directory,
+// readable only by you, for `credentials_ttl` (default 15m, "0" runs it every
+// time), so most runs pay nothing. If the command fails, the Wrapper fails
+// rather than running the tool without its credentials.
+
+const (
+	defaultCredentialsTTL = 15 * time.Minute
+	credentialsDirName    = "credentials"
+)
+
+var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// credentialsTTL returns how long the output of rule's credentials_cmd is
+// reused.
+func credentialsTTL(rule Rule) (time.Duration, error) {
+	if rule.CredentialsTTL == "" {
+		return defaultCredentialsTTL, nil
+	}
+	ttl, err := time.ParseDuration(rule.CredentialsTTL)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid credentials_ttl '%s' (use a duration such as 15m, or 0 not to cache)", rule.CredentialsTTL)
+	}
+	return ttl, nil
+}
+
+// credentialsCachePath returns the cache file for command run with env. The
+// variables multiprof sets, HOME and the Rule's env among them, are part of
+// the key, so Rules only share a cache when the command would see the same.
+func credentialsCachePath(command string, env *envBuilder) string {
+	h := sha256.New()
+	h.Write([]byte(command))
+	for _, name := range env.Changed() {
+		fmt.Fprintf(h, "\x00%s=%s", name, env.Get(name))
+	}
+	return stateFile(credentialsDirName, hex.EncodeToString(h.Sum(nil)[:16])+".env")
+}
+
+// applyCredentials sets the variables printed by the credentials_cmd of
+// res's Rule in env, running it unless its cached output is fresh, for a
+// command with access a.
+func applyCredentials(a access, env *envBuilder, res resolution) error {
+	rule := res.Rule
+	if rule.CredentialsCmd == "" {
+		return nil
+	}
+	ttl, err := credentialsTTL(rule)
+	if err != nil {
+		return err
+	}
+	cache := credentialsCachePath(rule.CredentialsCmd, env)
+	out, err := os.ReadFile(cache)
+	if info, statErr := os.Stat(cache); err != nil || statErr != nil || time.Since(info.ModTime()) >= ttl {
+		debugf("Running credentials_cmd: %s", rule.CredentialsCmd)
+		if out, err = runCredentialsCmd(rule.CredentialsCmd, env); err != nil {
+			return err
+		}
+		if ttl > 0 {
+			if err := writeCredentialsCache(a, cache, out); err != nil {
+				debugf("Could not cache credentials: %v", err)
+			}
+		}
+	} else {
+		debugf("Using credentials cached in %s", cache)
+	}
+	vars, err := parseDotenv(out)
+	if err != nil {
+		return fmt.Errorf("credentials_cmd printed %w", err)
+	}
+	origin := ruleOrigin(res.Index, rule) + " credentials_cmd"
+	for _, key := range sortedKeys(vars) {
+		env.Set(key, vars[key], origin)
+	}
+	return nil
+}
+
+// runCredentialsCmd runs command with env and returns what it prints. It
+// shares the terminal, so it can ask for a login.
+func runCredentialsCmd(command string, env *envBuilder) ([]byte, error) {
+	cmd := shellCommand(command)
+	cmd.Env = env.Environ()
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credentials_cmd '%s' failed: %w", command, err)
+	}
+	return out, nil
+}
+
+// writeCredentialsCache replaces the cache file atomically, so concurrent
+// Wrappers never read half of it.
+func writeCredentialsCache(a access, path string, data []byte) error {
+	if err := ensureStateDir(a); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".credentials-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// parseDotenv parses KEY=VALUE lines, allowing blank lines, # comments, an
+// `export ` prefix and values in single or double quotes.
+func parseDotenv(data []byte) (map[string]string, error) {
+	vars := make(map[string]string)
+	for i, line := range strings.Split(string(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKeyRe.MatchString(key) {
+			return nil, fmt.Errorf("line %d, which is not KEY=VALUE", i+1)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialsCacheKeepsRuleEnvApart(t *testing.T) {
+	root := setupDaemonTest(t, "")
+	if err := ensureStateDir(readWrite); err != nil {
+		t.Fatal(err)
+	}
+	home := filepath.Join(root, "home")
+	res := func(profile string) resolution {
+		rule := Rule{Pattern: "/**", CredentialsCmd: `echo "TOKEN=$AWS_PROFILE"`}
+		rule.Env = map[string]string{"AWS_PROFILE": profile}
+		return resolution{Index: 0, Rule: rule, Profile: namedProfile{Home: home}}
+	}
+	for _, profile := range []string{"acme", "beta", "acme"} {
+		env := res(profile).environment(os.Environ())
+		if err := applyCredentials(readWrite, env, res(profile)); err != nil {
+			t.Fatal(err)
+		}
+		if got := env.Get("TOKEN"); got != profile {
+			t.Errorf("TOKEN = %q with AWS_PROFILE=%s, want %q", got, profile, profile)
+		}
+	}
+	cached, _ := filepath.Glob(stateFile(credentialsDirName, "*.env"))
+	if len(cached) != 2 {
+		t.Errorf("%d cached credentials, want one per env: %v", len(cached), cached)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- Daemon ---
+//
+// `multiprof daemon` keeps the config loaded and its patterns compiled, and
+// answers match queries on a unix socket in the state directory. Wrappers and
+// the shell hook ask it before loading the config themselves. They send the
+// name they run as, the directory and the terminal state. The daemon asks back
+// for the variables its Rules' when_env conditions refer to, and for the
+// arguments if the Wrapper matches on them, and answers with its config and
+// the matching Rule. No other variable leaves the client. A variable a Rule
+// uses otherwise, such as $WORK in a pattern, is learned when a query needs
+// it: that query is matched by the client, later ones by the daemon.
+//
+// Clients load the config and match themselves if no daemon is running, it
+// does not answer in time, or it runs another binary or uses another config
+// or home. The daemon rereads the config, with its own environment, when it,
+// a file it includes or the multiprof binary changes; project configs and
+// packs are read for each query as usual. With match_strategy = "prompt" on a
+// terminal, Wrappers match locally, since only they can prompt. The daemon
+// also cleans up idle profiles every minute, like `multiprof cleanup` from
+// the systemd timer.
+
+const daemonSocketName = "daemon.sock"
+
+// daemonTimeout bounds how long a Wrapper waits for the daemon before
+// matching locally.
+const daemonTimeout = 100 * time.Millisecond
+
+// daemonCleanupInterval is how often the daemon looks for idle profiles.
+const daemonCleanupInterval = time.Minute
+
+type daemonRequest struct {
+	// Binary, Config and Home identify the client's multiprof binary, config
+	// file and home; a daemon with others lets the client match locally.
+	Binary string
+	Config string
+	Home   string
+	// Wrapper is the name the Wrapper runs as, or "" for the shell hook.
+	Wrapper     string
+	Cwd         string
+	Interactive bool
+	CanPick     bool
+}
+
+// daemonNeeds asks the client for the variables the Rules refer to and, for
+// a Wrapper that matches on its argument, its arguments. Local lets the
+// client match locally instead.
+type daemonNeeds struct {
+	Local bool
+	Env   []string
+	Args  bool
+}
+
+// daemonVars is the client's reply to daemonNeeds, with the variables that
+// are set.
+type daemonVars struct {
+	Env  map[string]string
+	Args []string
+}
+
+type daemonResponse struct {
+	// Local asks the client to match locally. Config and Data, the content
+	// of the config file, spare it parsing the config if set.
+	Local  bool
+	Config *configCache
+	Data   []byte
+	// Problems are those of the Rules matched against the directory, in
+	// strict mode.
+	Problems []string
+	Matched  bool
+	Index    int
+	Rule     Rule
+	Source   string
+	Profile  namedProfile
+	Presets  map[string]map[string]string
+	Err      string
+}
+
+// daemonQuery is a query the daemon is answering.
+type daemonQuery struct {
+	daemonRequest
+	env map[string]string
+	// asked holds the variables the client was asked for, and missed those
+	// looked up without having been asked for.
+	asked  map[string]bool
+	missed []string
+}
+
+func (q *daemonQuery) lookupEnv(key string) (string, bool) {
+	key = envKey(key)
+	if !q.asked[key] {
+		if !slices.Contains(q.missed, key) {
+			q.missed = append(q.missed, key)
+		}
+		return "", false
+	}
+	value, ok := q.env[key]
+	return value, ok
+}
+
+// serving is the query the daemon is answering; isInteractive and lookupEnv
+// report the client's terminal state and variables instead of the daemon's.
+var serving *daemonQuery
+
+func daemonSocketPath() string { return stateFile(daemonSocketName) }
+
+// binaryStamp identifies the running multiprof binary by path, size and
+// modification time.
+func binaryStamp() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return exe
+	}
+	return fmt.Sprintf("%s %d %d", exe, info.Size(), info.ModTime().UnixNano())
+}
+
+// daemonAnswer is what the daemon said about a query.
+type daemonAnswer struct {
+	// resolved is set if the daemon matched the directory: res and ok are
+	// its result, and problems those strict mode stops on.
+	resolved bool
+	res      resolution
+	ok       bool
+	problems []string
+}
+
+// resolve returns the daemon's resolution of dir, first stopping on the
+// problems it found in strict mode, or resolves dir locally if the daemon
+// did not.
+func (a daemonAnswer) resolve(config Config, dir string) (resolution, bool) {
+	if !a.resolved {
+		checkStrictRules(config, dir)
+		return resolveDir(config, dir)
+	}
+	if strictMode(config) {
+		exitOnRuleProblems(a.problems)
+	}
+	return a.res, a.ok
+}
+
+// askDaemon asks the daemon, if one is running, to match for the Wrapper
+// named wrapper, or the shell hook if that is "", in cwd with args. A config
+// the daemon sends along is what loadConfig(readOnly) returns from then on,
+// unless the config file changes.
+func askDaemon(wrapper, cwd string, args []string) daemonAnswer {
+	path := daemonSocketPath()
+	if _, err := os.Stat(path); err != nil {
+		return daemonAnswer{}
+	}
+	conn, err := net.DialTimeout("unix", path, daemonTimeout)
+	if err != nil {
+		debugf("Not using the daemon: %v", err)
+		return daemonAnswer{}
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(daemonTimeout))
+	configPath, _ := getConfigPath()
+	home, _ := userHomeDir()
+	req := daemonRequest{Binary: binaryStamp(), Config: configPath, Home: home, Wrapper: wrapper, Cwd: cwd, Interactive: isInteractive(), CanPick: canPick()}
+	enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+	var needs daemonNeeds
+	var resp daemonResponse
+	err = enc.Encode(req)
+	if err == nil {
+		err = dec.Decode(&needs)
+	}
+	if err == nil && !needs.Local {
+		vars := daemonVars{Env: make(map[string]string)}
+		for _, key := range needs.Env {
+			if value, ok := os.LookupEnv(key); ok {
+				vars.Env[key] = value
+			}
+		}
+		if needs.Args {
+			vars.Args = args
+		}
+		if err = enc.Encode(vars); err == nil {
+			err = dec.Decode(&resp)
+		}
+	}
+	if err != nil {
+		debugf("Not using the daemon: %v", err)
+		return daemonAnswer{}
+	}
+	if resp.Config != nil && !readOnlyConfig.read {
+		config := resp.Config.config()
+		readOnlyConfig.data, readOnlyConfig.config = resp.Data, &config
+	}
+	if needs.Local || resp.Local {
+		debugf("The daemon left matching to the client")
+		return daemonAnswer{}
+	}
+	debugf("Matched by the daemon")
+	answer := daemonAnswer{resolved: true, ok: resp.Matched, problems: resp.Problems, res: resolution{Index: -1}}
+	if resp.Matched {
+		answer.res = resolution{Index: resp.Index, Rule: resp.Rule, Profile: resp.Profile, Presets: resp.Presets}
+		answer.res.Rule.source = resp.Source
+		if resp.Err != "" {
+			answer.res.Err = errors.New(resp.Err)
+		}
+	}
+	return answer
+}
+
+func runDaemon(args []string) {
+	daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
+	if positional := parseFlags(daemonCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof daemon")
+	}
+	path := daemonSocketPath()
+	if conn, err := net.DialTimeout("unix", path, daemonTimeout); err == nil {
+		conn.Close()
+		exitFailure(errExists, "", "A daemon is already running on %s.", path)
+	}
+	os.Remove(path) // Left behind by a daemon that did not stop cleanly.
+	if err := ensureStateDir(readWrite); err != nil {
+		exitFailure(errFailed, "", "Could not create the state directory: %v", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		exitFailure(errFailed, "", "Could not listen on %s: %v", path, err)
+	}
+	os.Chmod(path, 0600)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+	logInfo("Answering match queries on %s. Press Ctrl-C to stop.", path)
+	d := &daemon{binary: binaryStamp(), learned: make(map[string]bool)}
+	go d.cleanupIdleProfiles()
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			break
+		} else if err != nil {
+			debugf("Could not accept a query: %v", err)
+			continue
+		}
+		go d.serve(conn)
+	}
+	os.Remove(path)
+	logInfo("Daemon stopped.")
+}
+
+// daemon holds the config between queries.
+type daemon struct {
+	// mu serializes queries, which each set serving and invokedCommand.
+	mu sync.Mutex
+	// binary is the stamp of the binary the daemon was started from.
+	binary string
+	config Config
+	data   []byte
+	cache  *configCache
+	// learned holds the variables queries looked up besides those of
+	// when_env, such as $NAME in a pattern; later queries ask for them too.
+	learned map[string]bool
+}
+
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+	var req daemonRequest
+	if err := dec.Decode(&req); err != nil {
+		debugf("Ignoring an invalid query: %v", err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.answer(req, enc, dec); err != nil {
+		debugf("Could not answer a query: %v", err)
+	}
+}
+
+// answer resolves the query's directory as the client would, asking it for
+// the variables and arguments that takes.
+func (d *daemon) answer(req daemonRequest, enc *gob.Encoder, dec *gob.Decoder) error {
+	configPath, _ := getConfigPath()
+	home, _ := userHomeDir()
+	config, err := d.loadConfig(configPath)
+	if err == nil && (req.Binary != d.binary || req.Config != configPath || req.Home != home) {
+		err = errors.New("the client runs another multiprof binary or uses another config or home")
+	}
+	if err != nil {
+		// The client reports any problem as it would without a daemon.
+		debugf("Leaving the query to the client: %v", err)
+		return enc.Encode(daemonNeeds{Local: true})
+	}
+
+	invokedCommand = ""
+	if req.Wrapper != "" {
+		invokedCommand = config.wrappedCommand(req.Wrapper)
+	}
+	needs := daemonNeeds{Args: config.Wrappers[invokedCommand].MatchOn == matchOnArg}
+	projectDir := req.Cwd
+	if needs.Args {
+		projectDir = "" // Not known before the arguments are.
+	}
+	needs.Env = d.envNames(config, projectDir)
+	if err := enc.Encode(needs); err != nil {
+		return err
+	}
+	var vars daemonVars
+	if err := dec.Decode(&vars); err != nil {
+		return err
+	}
+	dir := wrapperMatchDir(config, invokedCommand, req.Cwd, vars.Args)
+
+	q := &daemonQuery{daemonRequest: req, env: make(map[string]string), asked: make(map[string]bool)}
+	for _, key := range needs.Env {
+		q.asked[envKey(key)] = true
+	}
+	for key, value := range vars.Env {
+		q.env[envKey(key)] = value
+	}
+	serving = q
+	defer func() { serving = nil }()
+	clear(gitRemoteCache) // Remotes may have changed since the last query.
+
+	resp := daemonResponse{Config: d.cache, Data: d.data}
+	if config.Settings.MatchStrategy == matchPrompt && req.CanPick {
+		resp.Local = true
+		return enc.Encode(resp)
+	}
+	if strictMode(config) {
+		resp.Problems = ruleProblems(config, dir)
+	}
+	res, ok := resolveDir(config, dir)
+	if len(q.missed) > 0 {
+		for _, key := range q.missed {
+			d.learned[key] = true
+		}
+		debugf("Leaving the query to the client, which has %s", strings.Join(q.missed, ", "))
+		return enc.Encode(daemonResponse{Local: true, Config: d.cache, Data: d.data})
+	}
+	if ok {
+		resp.Matched, resp.Index, resp.Rule, resp.Source = true, res.Index, res.Rule, res.Rule.source
+		resp.Profile, resp.Presets = res.Profile, res.Presets
+		if res.Err != nil {
+			resp.Err = res.Err.Error()
+		}
+	}
+	return enc.Encode(resp)
+}
+
+// cleanupIdleProfiles runs the cleanup of idle profiles every
+// daemonCleanupInterval. It holds mu while it loads the config and expands
+// the homes, so that these use the daemon's environment rather than that of
+// a query being served; the hooks themselves run without holding up queries.
+func (d *daemon) cleanupIdleProfiles() {
+	for range time.Tick(daemonCleanupInterval) {
+		targets, err := d.cleanupTargets()
+		if err != nil {
+			logWarn("Not cleaning up idle profiles: %v", err)
+			continue
+		}
+		cleanupIdle(targets)
+	}
+}
+
+// cleanupTargets returns the profiles with cleanup to do in the current
+// config.
+func (d *daemon) cleanupTargets() ([]cleanupTarget, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	configPath, _ := getConfigPath()
+	config, err := d.loadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return collectCleanupTargets(config)
+}
+
+// envNames returns the variables a query needs from the client: strict
+// mode's, those the when_env conditions of the config, packs and the project
+// config of dir, unless dir is "", refer to, and those learned.
+func (d *daemon) envNames(config Config, dir string) []string {
+	names := map[string]bool{strictEnvVar: true}
+	add := func(rules []Rule) {
+		for _, rule := range rules {
+			for key := range rule.WhenEnv {
+				names[key] = true
+			}
+		}
+	}
+	add(config.Rules)
+	for _, pack := range loadPacks() {
+		add(pack.config().Rules)
+	}
+	if dir != "" {
+		if project, found := loadProjectConfig(dir); found {
+			add(project.Rules)
+		}
+	}
+	for key := range d.learned {
+		names[key] = true
+	}
+	return slices.Sorted(maps.Keys(names))
+}
+
+// loadConfig returns the config at configPath, parsing it again if it or a
+// file it includes changed since the last query.
+func (d *daemon) loadConfig(configPath string) (Config, error) {
+	if d.cache != nil && d.cache.unchanged(configPath) {
+		return d.config, nil
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return Config{}, err
+	}
+	if d.cache == nil || d.cache.Key != configCacheKey(configPath, data, d.config.Include) {
+		config, err := parseConfig(data)
+		if err != nil {
+			return config, err
+		}
+		debugf("Loaded %s", configPath)
+		d.config = config
+	}
+	// Without a cache, for a file that is gone, the config is parsed again.
+	d.data = data
+	d.cache, _ = newConfigCache(configPath, data, d.config)
+	return d.config, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// setupDaemonTest points HOME and the config at a temporary directory with
+// config, and returns that directory.
+func setupDaemonTest(t *testing.T, config string) string {
+	t.Helper()
+	root := t.TempDir()
+	t.Setenv("HOME", root)
+	t.Setenv(userHomeEnvVar, "")
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(root, ".config"))
+	readOnlyConfig.read, readOnlyConfig.data, readOnlyConfig.config = false, nil, nil
+	configPath := filepath.Join(root, ".config", configDirBase, configFileName)
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// queryDaemon asks d to match for the shell hook in cwd as a client with
+// the variables env would, and returns its response.
+func queryDaemon(d *daemon, cwd string, env map[string]string) (daemonResponse, error) {
+	client, server := net.Pipe()
+	served := make(chan struct{})
+	go func() {
+		d.serve(server)
+		close(served)
+	}()
+	defer func() {
+		client.Close()
+		<-served // Before another test's daemon sets serving.
+	}()
+	configPath, _ := getConfigPath()
+	home, _ := userHomeDir()
+	req := daemonRequest{Binary: d.binary, Config: configPath, Home: home, Cwd: cwd}
+	enc, dec := gob.NewEncoder(client), gob.NewDecoder(client)
+	var needs daemonNeeds
+	var resp daemonResponse
+	if err := enc.Encode(req); err != nil {
+		return resp, err
+	}
+	if err := dec.Decode(&needs); err != nil {
+		return resp, err
+	}
+	if needs.Local {
+		return daemonResponse{Local: true}, nil
+	}
+	vars := daemonVars{Env: make(map[string]string)}
+	for _, key := range needs.Env {
+		if value, ok := env[key]; ok {
+			vars.Env[key] = value
+		}
+	}
+	if err := enc.Encode(vars); err != nil {
+		return resp, err
+	}
+	err := dec.Decode(&resp)
+	return resp, err
+}
+
+func TestDaemonConcurrentQueries(t *testing.T) {
+	root := setupDaemonTest(t, `
+[profiles.a]
+home = "$PROFILE_ROOT/a"
+cleanup = ["true"]
+
+[[rules]]
+pattern = "~/work/**"
+profile = "a"
+when_env = { STAGE = "a" }
+
+[[rules]]
+pattern = "~/work/**"
+home = "~/b"
+when_env = { STAGE = "b" }
+`)
+	t.Setenv("PROFILE_ROOT", filepath.Join(root, "daemon"))
+	cwd := filepath.Join(root, "work", "project")
+	clientRoot := filepath.Join(root, "client")
+	d := &daemon{binary: binaryStamp(), learned: make(map[string]bool)}
+
+	// $PROFILE_ROOT is no when_env variable: the first query learns it and
+	// is left to the client, later ones ask for it.
+	env := map[string]string{"STAGE": "a", "PROFILE_ROOT": clientRoot}
+	if resp, err := queryDaemon(d, cwd, env); err != nil || !resp.Local {
+		t.Fatalf("first query: %+v, %v; want it left to the client", resp, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		stage := []string{"a", "b"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			env := map[string]string{"STAGE": stage, "PROFILE_ROOT": clientRoot}
+			resp, err := queryDaemon(d, cwd, env)
+			if err != nil {
+				t.Errorf("query %d: %v", i, err)
+				return
+			}
+			if resp.Local || !resp.Matched || resp.Rule.WhenEnv["STAGE"] != stage {
+				t.Errorf("query %d with STAGE=%s: %+v", i, stage, resp)
+				return
+			}
+			if want := filepath.Join(clientRoot, "a"); stage == "a" && resp.Profile.Home != want {
+				t.Errorf("query %d: home %s, want %s", i, resp.Profile.Home, want)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 20 {
+			targets, err := d.cleanupTargets()
+			if err != nil {
+				t.Errorf("cleanupTargets: %v", err)
+				return
+			}
+			// The daemon's own environment, never that of a client.
+			if want := filepath.Join(root, "daemon", "a"); len(targets) != 1 || targets[0].Home != want {
+				t.Errorf("cleanup targets %s, want only %s", fmt.Sprint(targets), want)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// --- Default Home ---
+//
+// Wrappers refuse to run where no Rule matches, which breaks scripts that
+// run wrapped commands from a temporary directory. `default_home` in
+// [settings] names a profile, or a home directory as `run -p` takes it, that
+// Wrappers and `multiprof run` use there instead; "~" keeps the user's own
+// HOME. Inspection commands such as which and check still report that no
+// Rule matches, since the point of a Rule is to be explicit.
+
+// defaultResolution returns the resolution for directories no Rule matches,
+// if settings.default_home is set.
+func defaultResolution(config Config) (resolution, bool, error) {
+	value := config.Settings.DefaultHome
+	if value == "" {
+		return resolution{Index: -1}, false, nil
+	}
+	profiles := config.withPackProfiles(loadPacks())
+	if p, ok := profiles.findProfile(value); ok {
+		return resolution{Index: -1, Profile: p, Presets: profiles.Presets}, true, nil
+	}
+	if !looksLikePath(value) {
+		return resolution{Index: -1}, false, fmt.Errorf("settings: default_home '%s' is neither a profile nor a directory", value)
+	}
+	return resolution{Index: -1, Profile: namedProfile{Home: expandPath(value)}, Presets: profiles.Presets}, true, nil
+}
+
+// looksLikePath reports whether value is written as a directory rather than
+// a profile name.
+func looksLikePath(value string) bool {
+	return value == "~" || strings.HasPrefix(value, "~/") || filepath.IsAbs(value)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// --- Doctor ---
+//
+// `multiprof doctor` answers "why isn't my Wrapper being used?". It checks
+// the pieces that live outside the config: permissions of the files
+// multiprof manages, PATH ordering, the Wrapper symlinks and the commands
+// they wrap, and whether the shell will find the completion files. Each
+// problem comes with the command or edit that fixes it.
+
+type doctor struct {
+	warnings, failures int
+}
+
+func (d *doctor) ok(format string, v ...interface{}) { logSuccess(format, v...) }
+
+func (d *doctor) warn(fix, format string, v ...interface{}) {
+	d.warnings++
+	logWarn(format, v...)
+	if fix != "" {
+		fmt.Printf("       Fix: %s\n", fix)
+	}
+}
+
+// fail reports a problem on stdout like the others, so the report reads in
+// order when piped.
+func (d *doctor) fail(fix, format string, v ...interface{}) {
+	d.failures++
+	fmt.Printf("[FAIL] "+format+"\n", v...)
+	if fix != "" {
+		fmt.Printf("       Fix: %s\n", fix)
+	}
+}
+
+func runDoctor(args []string) {
+	if len(args) > 0 {
+		exitUsage("Usage: multiprof doctor")
+	}
+	d := &doctor{}
+	config := d.checkConfig()
+	d.checkPermissions()
+	d.checkPath(config)
+	d.checkWrappers(config)
+	d.checkCompletions(config)
+
+	fmt.Println()
+	switch {
+	case d.failures > 0:
+		exitFailure(errFailed, "", "Found %d problem(s) and %d warning(s).", d.failures, d.warnings)
+	case d.warnings > 0:
+		logWarn("No problems, but %d warning(s).", d.warnings)
+	default:
+		logSuccess("Everything looks fine.")
+	}
+}
+
+// checkConfig reads the config without exiting on errors, so the remaining
+// checks still run.
+func (d *doctor) checkConfig() Config {
+	fmt.Println("--- Config ---")
+	var config Config
+	configPath, _ := getConfigPath()
+	data, err := os.ReadFile(configPath)
+	switch {
+	case os.IsNotExist(err):
+		d.fail("multiprof init", "No config file at %s.", configPath)
+		return config
+	case err != nil:
+		if problem := permissionProblem(configPath, err); problem != "" {
+			d.fail("", "%s", problem)
+		} else {
+			d.fail("", "Could not read %s: %v", configPath, err)
+		}
+		return config
+	}
+	if _, err := toml.Decode(string(data), &config); err != nil {
+		d.fail("edit "+configPath, "Could not parse %s: %v", configPath, err)
+		return config
+	}
+	config.applyIncludes()
+	if problems := validateConfig(config); len(problems) > 0 {
+		d.fail("run 'multiprof check' for the full list", "Your Rules and profiles have %d problem(s), e.g. %s", len(problems), problems[0])
+	} else {
+		d.ok("%s is valid (%d Rules).", configPath, len(config.Rules))
+	}
+	for _, p := range config.allProfiles() {
+		for _, warning := range homePlacementWarnings(config, p) {
+			d.warn("move the home elsewhere, e.g. under "+tildePath(profilesDir(config)), "%s", warning)
+		}
+	}
+	return config
+}
+
+// checkPermissions checks that the files and directories multiprof manages
+// are readable and writable, and owned by the user rather than by root
+// after a sudo edit.
+func (d *doctor) checkPermissions() {
+	fmt.Println("--- Permissions ---")
+	configPath, _ := getConfigPath()
+	wrapperDir, _ := getWrapperDir()
+	completionDir, _ := getCompletionDir()
+	stateDir, _ := getStateDir()
+	me, _ := user.Current()
+	problems := 0
+	for _, path := range []string{filepath.Dir(configPath), configPath, wrapperDir, legacyWrapperDir(), completionDir, getZshCompletionDir(), stateDir, packsDir()} {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			if info.IsDir() {
+				_, err = os.ReadDir(path)
+			} else {
+				var f *os.File
+				if f, err = os.Open(path); err == nil {
+					f.Close()
+				}
+			}
+		}
+		if problem := permissionProblem(path, err); problem != "" {
+			d.fail("", "%s", problem)
+			problems++
+			continue
+		} else if err != nil {
+			d.fail("", "Could not read '%s': %v", path, err)
+			problems++
+			continue
+		}
+		if owner := fileOwner(info); me != nil && owner != "" && owner != me.Username {
+			recursive := ""
+			if info.IsDir() {
+				recursive = "-R "
+			}
+			d.warn(fmt.Sprintf("sudo chown %s%s %s", recursive, me.Username, path), "'%s' is owned by %s, so multiprof may not be able to update it.", path, owner)
+			problems++
+		}
+	}
+	if problems == 0 {
+		d.ok("The config, Wrapper, completion and state directories are accessible.")
+	}
+}
+
+// checkPath checks that the shell finds the Wrappers before the commands
+// they wrap.
+func (d *doctor) checkPath(config Config) {
+	fmt.Println("--- PATH ---")
+	wrapperDir, _ := getWrapperDir()
+	path := filepath.SplitList(os.Getenv("PATH"))
+	index := pathIndex(path, wrapperDir)
+	if hint := legacyLayoutHint(); hint != "" {
+		d.warn("multiprof migrate-layout", "%s", hint)
+	}
+	if index < 0 {
+		if pathIndex(path, legacyWrapperDir()) >= 0 {
+			d.fail(fmt.Sprintf("multiprof migrate-layout, then replace %s with %s in PATH in your shell startup file", legacyWrapperDir(), wrapperDir), "PATH has the old Wrapper Directory %s, but not the current one, %s.", legacyWrapperDir(), wrapperDir)
+			return
+		}
+		d.fail(fmt.Sprintf("add `export PATH=\"%s:$PATH\"` to your shell startup file", wrapperDir), "The Wrapper Directory %s is not in PATH.", wrapperDir)
+		return
+	}
+	d.ok("The Wrapper Directory is entry %d of %d in PATH.", index+1, len(path))
+	if hint := suffixMismatchHint(config); hint != "" {
+		d.fail("multiprof migrate-suffix", "%s", hint)
+	}
+	if config.Settings.Suffix != "" {
+		return
+	}
+	// Without a suffix, each Wrapper must come first in PATH for its name.
+	for _, cmdName := range ownWrapperNames(wrapperDir, "") {
+		found, err := exec.LookPath(cmdName)
+		if err != nil || isWrapperDir(filepath.Dir(found)) {
+			continue
+		}
+		d.fail(fmt.Sprintf("move the Wrapper Directory before %s in PATH", filepath.Dir(found)), "'%s' runs %s instead of its Wrapper.", cmdName, found)
+	}
+	for _, warning := range homebrewWarnings(config, "") {
+		d.warn("", "%s", warning)
+	}
+}
+
+// checkWrappers checks each Wrapper symlink and the command it wraps.
+func (d *doctor) checkWrappers(config Config) {
+	fmt.Println("--- Wrappers ---")
+	self, _ := os.Executable()
+	checked, problems := 0, d.warnings+d.failures
+	for _, entry := range wrapperEntries() {
+		name, wrapperDir := entry.Name(), entry.dir
+		path := filepath.Join(wrapperDir, name)
+		target, _, ok := wrapperTarget(path)
+		if !ok {
+			continue // Not ours; list-wrappers reports these.
+		}
+		cmdName := config.wrappedCommand(name)
+		checked++
+		switch info, err := os.Stat(target); {
+		case err != nil:
+			d.fail("multiprof sync-wrappers", "Wrapper '%s' points to %s, which does not exist.", name, target)
+			continue
+		case !isOwnWrapper(path):
+			d.warn("", "'%s' in the Wrapper Directory points to %s, not to multiprof.", name, target)
+			continue
+		default:
+			if selfInfo, err := os.Stat(self); err == nil && !os.SameFile(info, selfInfo) {
+				d.warn("multiprof sync-wrappers", "Wrapper '%s' points to another multiprof binary, %s.", name, target)
+			}
+		}
+		if _, err := findTarget(cmdName); err != nil {
+			d.warn(fmt.Sprintf("install '%s', or run: multiprof remove-wrapper %s", cmdName, cmdName), "Wrapper '%s' wraps '%s', which is not found in PATH.", name, cmdName)
+		}
+	}
+	switch {
+	case checked == 0:
+		d.warn("multiprof add-wrapper <command>", "There are no Wrappers yet.")
+	case problems == d.warnings+d.failures:
+		d.ok("All %d Wrappers point to this multiprof and wrap a command found in PATH.", checked)
+	}
+}
+
+// checkCompletions checks that the shell in $SHELL will load the completion
+// files of Wrappers named differently from their command.
+func (d *doctor) checkCompletions(config Config) {
+	wrapperDir, _ := getWrapperDir()
+	var renamed []string
+	for _, cmdName := range wrappedCommands(config, wrapperDir) {
+		if config.wrapperName(cmdName) != cmdName {
+			renamed = append(renamed, cmdName)
+		}
+	}
+	if len(renamed) == 0 {
+		return // Wrappers share their command's name and completions.
+	}
+	fmt.Println("--- Completions ---")
+	shell, _ := completionShell("")
+	loaded := false
+	switch shell {
+	case shellZsh:
+		// fpath is not exported, so look for it being set up in .zshrc.
+		zshrc, _ := os.ReadFile(expandPath("~/.zshrc"))
+		dir := getZshCompletionDir()
+		loaded = bytes.Contains(zshrc, []byte(dir)) || bytes.Contains(zshrc, []byte(tildePath(dir)))
+		if !loaded {
+			d.warn(fmt.Sprintf("add fpath=(\"%s\" $fpath) to ~/.zshrc before compinit", dir), "~/.zshrc does not seem to add %s to fpath, so zsh does not load the completion files for Wrappers.", tildePath(dir))
+		}
+	case shellFish:
+		loaded = true // fish loads its completions directory by itself.
+	default:
+		for _, file := range []string{"/usr/share/bash-completion/bash_completion", "/etc/bash_completion", "/opt/homebrew/etc/profile.d/bash_completion.sh", "/usr/local/etc/profile.d/bash_completion.sh", "/opt/homebrew/etc/bash_completion", "/usr/local/etc/bash_completion"} {
+			loaded = loaded || fileExists(file)
+		}
+		if !loaded {
+			d.warn("install the bash-completion package", "bash-completion does not seem to be installed, so completion files for Wrappers are not loaded.")
+		}
+	}
+	missing := 0
+	for _, name := range renamed {
+		wrapperName := config.wrapperName(name)
+		if target, _, ok := wrapperTarget(filepath.Join(wrapperDir, wrapperName)); !ok || !fileExists(target) {
+			continue // Broken; reported with the Wrappers.
+		}
+		if !fileExists(completionPath(shell, wrapperName)) {
+			d.warn(fmt.Sprintf("multiprof sync-wrappers --shell %s", shell), "Wrapper '%s' has no %s completion file.", wrapperName, shell)
+			missing++
+		}
+	}
+	if name := filepath.Base(os.Getenv("SHELL")); name != shell && name != "." {
+		logInfo("Your shell is %s; multiprof only writes completion files for %s.", name, strings.Join(completionShells, ", "))
+	} else if loaded && missing == 0 {
+		d.ok("%s completion files are in place.", shell)
+	}
+}
+
+// ownWrapperNames returns the commands wrapped by the Wrappers in dir, with
+// suffix removed.
+func ownWrapperNames(dir, suffix string) []string {
+	var names []string
+	for _, name := range wrapperNamesIn(dir) {
+		if isOwnWrapper(filepath.Join(dir, name)) && strings.HasSuffix(name, suffix) {
+			names = append(names, strings.TrimSuffix(name, suffix))
+		}
+	}
+	return names
+}
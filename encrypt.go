@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// --- Encrypted Homes ---
+//
+// A profile with `encrypted = true` keeps its home encrypted at rest. With
+// gocryptfs (the default) the home is a mountpoint for `cipher_dir`; with
+// fscrypt the home is an fscrypt-protected directory. Either way the home is
+// unlocked the first time a wrapper needs it and locked again by cleanup.
+
+const (
+	encryptionGocryptfs = "gocryptfs"
+	encryptionFscrypt   = "fscrypt"
+	keyringService      = "multiprof"
+	cipherDirSuffix     = ".encrypted"
+)
+
+func encryptionBackend(spec HomeSpec) (string, error) {
+	switch spec.Encryption {
+	case "", encryptionGocryptfs:
+		return encryptionGocryptfs, nil
+	case encryptionFscrypt:
+		return encryptionFscrypt, nil
+	default:
+		return "", fmt.Errorf("unknown encryption '%s' (use '%s' or '%s')", spec.Encryption, encryptionGocryptfs, encryptionFscrypt)
+	}
+}
+
+func cipherDir(spec HomeSpec, home string) string {
+	if spec.CipherDir != "" {
+		return expandPath(spec.CipherDir)
+	}
+	return home + cipherDirSuffix
+}
+
+// mountHome unlocks an encrypted home unless it is already unlocked. Without
+// `keyring = true` the backend prompts for the passphrase on the terminal.
+func mountHome(spec HomeSpec, home string) error {
+	backend, err := encryptionBackend(spec)
+	if err != nil {
+		return err
+	}
+	unlocked, err := homeUnlocked(backend, home)
+	if err != nil {
+		return err
+	}
+	if unlocked {
+		debugf("Encrypted home '%s' is already unlocked", home)
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	switch backend {
+	case encryptionGocryptfs:
+		args := []string{"-q"}
+		if spec.Keyring {
+			// One -extpass per argument: a single one is split at spaces,
+			// which would break a home path containing them.
+			for _, arg := range keyringLookupCommand(home) {
+				args = append(args, "-extpass", arg)
+			}
+		}
+		args = append(args, cipherDir(spec, home), home)
+		if err := os.MkdirAll(home, 0700); err != nil {
+			return err
+		}
+		cmd = exec.Command("gocryptfs", args...)
+		cmd.Stdin = os.Stdin
+	case encryptionFscrypt:
+		cmd = exec.Command("fscrypt", "unlock", home)
+		cmd.Stdin = os.Stdin
+		if spec.Keyring {
+			passphrase, err := keyringPassphrase(home)
+			if err != nil {
+				return err
+			}
+			cmd.Stdin = strings.NewReader(passphrase + "\n")
+		}
+	}
+	debugf("Unlocking encrypted home: %s", strings.Join(cmd.Args, " "))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// unmountHome locks an encrypted home if it is currently unlocked.
+func unmountHome(spec HomeSpec, home string) error {
+	backend, err := encryptionBackend(spec)
+	if err != nil {
+		return err
+	}
+	unlocked, err := homeUnlocked(backend, home)
+	if err != nil || !unlocked {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	switch backend {
+	case encryptionGocryptfs:
+		if runtime.GOOS == "linux" {
+			cmd = exec.Command("fusermount", "-u", home)
+		} else {
+			cmd = exec.Command("umount", home)
+		}
+	case encryptionFscrypt:
+		cmd = exec.Command("fscrypt", "lock", home)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func homeUnlocked(backend, home string) (bool, error) {
+	if backend == encryptionFscrypt {
+		out, err := exec.Command("fscrypt", "status", home).Output()
+		if err != nil {
+			return false, fmt.Errorf("fscrypt status failed: %w", err)
+		}
+		return bytes.Contains(out, []byte("Unlocked: Yes")), nil
+	}
+	if _, err := os.Stat(home); os.IsNotExist(err) {
+		return false, nil
+	}
+	return isMountPoint(home)
+}
+
+// keyringLookupCommand returns the command printing the passphrase stored in
+// the OS keyring for a home, under the "multiprof" service.
+func keyringLookupCommand(home string) []string {
+	if runtime.GOOS == "darwin" {
+		return []string{"security", "find-generic-password", "-s", keyringService, "-a", home, "-w"}
+	}
+	return []string{"secret-tool", "lookup", "service", keyringService, "home", home}
+}
+
+func keyringPassphrase(home string) (string, error) {
+	lookup := keyringLookupCommand(home)
+	out, err := exec.Command(lookup[0], lookup[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("could not read passphrase from keyring (%s): %w", lookup[0], err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
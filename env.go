@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// --- Exec Environment ---
+//
+// envBuilder assembles the environment handed to the wrapped command. It
+// remembers which Rule introduced each variable so that an invalid entry can
+// be reported against its source instead of surfacing as an opaque exec error.
+
+const (
+	// maxArgStrLen is Linux's MAX_ARG_STRLEN: the limit for a single
+	// argument or environment string.
+	maxArgStrLen = 32 * 4096
+)
+
+// envBuilder keys its maps by envKey and keeps each variable's name as it
+// was first spelled, so that setting PATH on Windows updates the inherited
+// Path.
+type envBuilder struct {
+	values  map[string]string
+	names   map[string]string
+	order   []string
+	origins map[string]string
+	// drives holds Windows' hidden per-drive "=C:=C:\dir" entries, which
+	// are passed on as they are.
+	drives []string
+}
+
+// envKey returns the key identifying the variable name: the name itself, or
+// on Windows, where names are case-insensitive, its upper-case form.
+func envKey(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+func newEnvBuilder(environ []string) *envBuilder {
+	b := &envBuilder{values: make(map[string]string), names: make(map[string]string), origins: make(map[string]string)}
+	for _, kv := range environ {
+		if runtime.GOOS == "windows" && strings.HasPrefix(kv, "=") {
+			b.drives = append(b.drives, kv)
+			continue
+		}
+		key, value, _ := strings.Cut(kv, "=")
+		b.Set(key, value, "")
+	}
+	return b
+}
+
+// Set assigns a variable. origin describes where the value came from; an
+// empty origin means it was inherited from the calling environment.
+func (b *envBuilder) Set(name, value, origin string) {
+	key := envKey(name)
+	if _, ok := b.values[key]; !ok {
+		b.order = append(b.order, key)
+		b.names[key] = name
+	}
+	b.values[key] = value
+	if origin == "" {
+		delete(b.origins, key)
+	} else {
+		b.origins[key] = origin
+	}
+}
+
+func (b *envBuilder) Get(name string) string { return b.values[envKey(name)] }
+
+// Origin returns what set the variable, or "" if it was inherited.
+func (b *envBuilder) Origin(name string) string { return b.origins[envKey(name)] }
+
+// Environ returns the environment in os.Environ form.
+func (b *envBuilder) Environ() []string {
+	env := make([]string, 0, len(b.drives)+len(b.order))
+	env = append(env, b.drives...)
+	for _, key := range b.order {
+		env = append(env, b.names[key]+"="+b.values[key])
+	}
+	return env
+}
+
+// Changed returns the names of the variables set with an origin, sorted.
+func (b *envBuilder) Changed() []string {
+	var names []string
+	for key := range b.origins {
+		names = append(names, b.names[key])
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b *envBuilder) describe(name string) string {
+	key := envKey(name)
+	if origin, ok := b.origins[key]; ok {
+		return fmt.Sprintf("%s (set by %s)", b.names[key], origin)
+	}
+	return fmt.Sprintf("%s (inherited from your environment)", name)
+}
+
+// Validate checks that the environment and args can be passed to exec.
+func (b *envBuilder) Validate(args []string) error {
+	total := 0
+	for _, arg := range args {
+		total += len(arg) + 1
+	}
+	for _, drive := range b.drives {
+		total += len(drive) + 1
+	}
+	for _, key := range b.order {
+		name, value := b.names[key], b.values[key]
+		entry := name + "=" + value
+		total += len(entry) + 1
+		switch {
+		case name == "" || strings.ContainsAny(name, "=\x00"):
+			return fmt.Errorf("invalid variable name %q%s", name, b.originSuffix(name))
+		case strings.ContainsRune(value, 0):
+			return fmt.Errorf("%s contains a NUL byte", b.describe(name))
+		case runtime.GOOS == "linux" && len(entry) >= maxArgStrLen:
+			return fmt.Errorf("%s is %d bytes, over the %d byte limit for a single variable", b.describe(name), len(entry), maxArgStrLen)
+		}
+	}
+	if b.Get("PATH") == "" {
+		return fmt.Errorf("%s is empty, so the target command cannot be found", b.describe("PATH"))
+	}
+	if limit := execSizeLimit(); total > limit {
+		var largest string
+		for _, key := range b.order {
+			if largest == "" || len(b.values[key]) > len(b.values[largest]) {
+				largest = key
+			}
+		}
+		return fmt.Errorf("arguments and environment total %d bytes, over the %d byte limit; the largest variable is %s", total, limit, b.describe(b.names[largest]))
+	}
+	return nil
+}
+
+func (b *envBuilder) originSuffix(name string) string {
+	if origin, ok := b.origins[envKey(name)]; ok {
+		return " set by " + origin
+	}
+	return ""
+}
+
+// execSizeLimit approximates ARG_MAX, the combined size of arguments and
+// environment accepted by exec.
+func execSizeLimit() int {
+	switch runtime.GOOS {
+	case "linux":
+		return 2 << 20
+	default:
+		return 1 << 20
+	}
+}
+
+func ruleOrigin(index int, rule Rule) string {
+	if rule.source != "" {
+		return fmt.Sprintf("Rule %d ('%s') in %s", index+1, rule.Pattern, rule.source)
+	}
+	return fmt.Sprintf("Rule %d ('%s')", index+1, rule.Pattern)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// --- Failures ---
+//
+// When a Wrapper or a management command fails, the message alone rarely
+// tells the user what to do. Failures are reported as a *failure, which
+// carries a hint on how to fix it and, for resolution failures, an
+// explanation of how each Rule was matched. The explanation is shown when
+// stderr is a terminal, with `multiprof run --explain-error`, or with
+// MULTIPROF_EXPLAIN_ERROR=1; MULTIPROF_EXPLAIN_ERROR=0 turns it off.
+
+const explainErrorEnvVar = "MULTIPROF_EXPLAIN_ERROR"
+
+// explainError is set by `multiprof run --explain-error`.
+var explainError bool
+
+// Kinds of failure, for errors.Is.
+var (
+	errNoRuleMatched  = errors.New("no Rule matched")
+	errInvalidRule    = errors.New("invalid Rule")
+	errTargetNotFound = errors.New("target command not found")
+	errRefused        = errors.New("refused to run")
+	errActivation     = errors.New("could not activate the profile")
+	errStrict         = errors.New("stopped by strict mode")
+
+	errUsage         = errors.New("invalid usage")
+	errNotFound      = errors.New("not found")
+	errExists        = errors.New("already exists")
+	errRefusedChange = errors.New("refused to make the change")
+	errFailed        = errors.New("command failed")
+)
+
+// usageHint is the hint for errUsage failures.
+const usageHint = "Run 'multiprof help' to see how each command is used."
+
+type failure struct {
+	kind    error
+	msg     string
+	hint    string
+	explain func() []string
+}
+
+func (f *failure) Error() string { return f.msg }
+func (f *failure) Unwrap() error { return f.kind }
+
+// exitWith reports err with its hint and, if requested, its explanation,
+// and exits with status 1.
+func exitWith(err error) {
+	logError("%v", err)
+	var f *failure
+	if errors.As(err, &f) {
+		if f.hint != "" {
+			fmt.Fprintf(os.Stderr, "[INFO] %s\n", f.hint)
+		}
+		if f.explain != nil && explainRequested() {
+			for _, line := range f.explain() {
+				fmt.Fprintln(os.Stderr, line)
+			}
+			fmt.Fprintf(os.Stderr, "Set %s=0 to hide this explanation.\n", explainErrorEnvVar)
+		}
+	}
+	printResult(err)
+	os.Exit(1)
+}
+
+// exitFailure reports a failure of kind, with hint unless that is "", and
+// exits with status 1.
+func exitFailure(kind error, hint, format string, v ...interface{}) {
+	exitWith(&failure{kind, fmt.Sprintf(format, v...), hint, nil})
+}
+
+// exitUsage reports that a command was called the wrong way and exits.
+func exitUsage(format string, v ...interface{}) {
+	exitFailure(errUsage, usageHint, format, v...)
+}
+
+func explainRequested() bool {
+	if explainError {
+		return true
+	}
+	switch os.Getenv(explainErrorEnvVar) {
+	case "":
+		return isTerminal(os.Stderr)
+	case "0", "false":
+		return false
+	}
+	return true
+}
+
+// resolveForExec resolves dir for running a command, as a *failure if no
+// usable Rule matches, or errPassthrough (see nomatch.go). answer is the
+// daemon's, if the caller asked it.
+func resolveForExec(config Config, dir string, answer daemonAnswer) (resolution, error) {
+	pickInteractively = true
+	res, ok := answer.resolve(config, dir)
+	explain := func() []string { return explainResolution(config, dir) }
+	if !ok {
+		return resolveNoMatch(config, dir, explain)
+	}
+	if res.Err != nil {
+		return res, &failure{errInvalidRule, res.Err.Error(), "Run 'multiprof check' to validate your config.", explain}
+	}
+	return res, nil
+}
+
+// explainResolution describes how each Rule was matched against dir.
+func explainResolution(config Config, dir string) []string {
+	expanded := expandPath(dir)
+	lines := []string{fmt.Sprintf("How the Rules were matched against %s:", expanded)}
+	if invokedCommand != "" {
+		lines[0] = fmt.Sprintf("How the Rules were matched against %s for '%s':", expanded, invokedCommand)
+	}
+	describe := func(source string, rules []Rule) {
+		lines = append(lines, "  "+source)
+		if len(rules) == 0 {
+			lines = append(lines, "    (no Rules)")
+		}
+		for i, rule := range rules {
+			reason := ruleMismatch(rule, expanded)
+			if reason == "" {
+				reason = "matches"
+			}
+			lines = append(lines, fmt.Sprintf("    Rule %d '%s': %s", i+1, rule.Pattern, reason))
+		}
+	}
+
+	if path, ok := findProjectConfig(expanded); ok {
+		if project, trusted := projectConfigIfTrusted(path); trusted {
+			describe(path+":", project.Rules)
+		} else {
+			lines = append(lines, fmt.Sprintf("  %s: ignored until you run 'multiprof trust %s'", path, filepath.Dir(path)))
+		}
+	}
+	configPath, _ := getConfigPath()
+	describe(configPath+":", config.Rules)
+	for _, pack := range loadPacks() {
+		describe(fmt.Sprintf("Pack '%s':", pack.Name), pack.Rules)
+	}
+	return lines
+}
+
+// projectConfigIfTrusted loads the project config at path if it is trusted,
+// without the warnings loadProjectConfig prints for untrusted ones.
+func projectConfigIfTrusted(path string) (Config, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil || readTrustDB()[path] != hashContent(data) {
+		return Config{}, false
+	}
+	return loadProjectConfig(filepath.Dir(path))
+}
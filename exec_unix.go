@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// replaceProcess replaces multiprof with the program at path.
+func replaceProcess(path string, args, env []string) error {
+	return syscall.Exec(path, args, env)
+}
+
+// setHomeVars points the home directory variables at home.
+func setHomeVars(env *envBuilder, home, origin string) {
+	env.Set("HOME", home, origin)
+}
+
+// shellCommand runs command, one of the config's hook commands, with sh.
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("sh", "-c", command)
+}
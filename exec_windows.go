@@ -0,0 +1,51 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// --- Windows Process and Home ---
+//
+// Windows cannot replace a process with another, so a Wrapper runs the
+// command as a child with its console and exits with its exit code. Programs
+// find the home in USERPROFILE (and, older ones, HOMEDRIVE and HOMEPATH);
+// HOME is set too, for git, ssh and other ports of Unix tools. Commands from
+// the config (pre_exec, post_exec, credentials_cmd, cleanup) run with cmd.exe
+// rather than sh.
+
+// replaceProcess runs the program at path and exits with its exit code.
+// spawnTarget reports a program that could not be run, so unlike on Unix it
+// never returns.
+func replaceProcess(path string, args, env []string) error {
+	os.Exit(spawnTarget(path, args, env, "").Exit)
+	return nil
+}
+
+// setHomeVars points the home directory variables at home.
+func setHomeVars(env *envBuilder, home, origin string) {
+	env.Set("HOME", home, origin)
+	env.Set("USERPROFILE", home, origin)
+	if volume := filepath.VolumeName(home); volume != "" && !strings.HasPrefix(volume, `\\`) {
+		env.Set("HOMEDRIVE", volume, origin)
+		env.Set("HOMEPATH", strings.TrimPrefix(home, volume), origin)
+	}
+}
+
+// shellCommand runs command, one of the config's hook commands, with cmd.exe.
+// The command line is passed as written, since cmd.exe does not follow the
+// quoting rules exec would apply to an argument.
+func shellCommand(command string) *exec.Cmd {
+	shell := os.Getenv("ComSpec")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	cmd := exec.Command(shell)
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `"` + shell + `" /d /s /c "` + command + `"`}
+	return cmd
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// --- Exec Hooks ---
+//
+// `pre_exec` and `post_exec` on a Rule list shell commands run around the
+// wrapped command, in the current directory with the profile's environment:
+// pre_exec to start an agent or mount a volume first, post_exec to undo or
+// report on it afterwards. A failing pre_exec command stops the run. Since
+// post_exec must outlive the command, a Rule with it runs the command as a
+// child process (see spawn.go) instead of exec'ing it; post_exec commands
+// find its exit code in MULTIPROF_EXIT_CODE.
+
+const exitCodeEnvVar = "MULTIPROF_EXIT_CODE"
+
+// runExecHooks runs the kind hook commands with env, stopping at the first
+// that fails. Their output goes to stderr, keeping the wrapped command's
+// stdout its own.
+func runExecHooks(kind string, commands, env []string) error {
+	for _, c := range commands {
+		debugf("Running %s command: %s", kind, c)
+		cmd := shellCommand(c)
+		cmd.Env = env
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s command '%s' failed: %w", kind, c, err)
+		}
+	}
+	return nil
+}
+
+// runPostExec runs rule's post_exec commands after the command ended with
+// result.
+func runPostExec(rule Rule, env *envBuilder, result outcome) {
+	if len(rule.PostExec) == 0 {
+		return
+	}
+	env.Set(exitCodeEnvVar, strconv.Itoa(result.Exit), "multiprof")
+	if err := runExecHooks("post_exec", rule.PostExec, env.Environ()); err != nil {
+		fmt.Fprintf(os.Stderr, "[WARN] %v\n", err)
+	}
+}
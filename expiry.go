@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// --- Rule Expiry ---
+//
+// `expires = "2025-06-30"` on a Rule limits it to a temporary engagement: from
+// the day after, it no longer matches, so the client's home stops taking
+// over once the contract ends. `list` marks expired Rules, and `check` warns
+// about them and, on a terminal or with --prune, removes them from the
+// config.
+
+// expiryLayout is the format of `expires`.
+const expiryLayout = "2006-01-02"
+
+// ruleExpiry returns the moment the Rule stops matching: the end of the day
+// in `expires`, in local time. ok is false if the Rule has no expiry.
+func ruleExpiry(rule Rule) (end time.Time, ok bool, err error) {
+	if rule.Expires == "" {
+		return time.Time{}, false, nil
+	}
+	day, err := time.ParseInLocation(expiryLayout, rule.Expires, time.Local)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid expires '%s' (use YYYY-MM-DD)", rule.Expires)
+	}
+	return day.AddDate(0, 0, 1), true, nil
+}
+
+// expired reports whether the Rule's expiry date has passed. A Rule with an
+// invalid date does not expire; check reports the date.
+func (rule Rule) expired() bool {
+	end, ok, err := ruleExpiry(rule)
+	return err == nil && ok && !time.Now().Before(end)
+}
+
+// expiredRules returns the indices of the expired Rules in config's own file.
+func expiredRules(config Config) []int {
+	var expired []int
+	for i, rule := range config.Rules {
+		if rule.expired() && rule.source == "" {
+			expired = append(expired, i)
+		}
+	}
+	return expired
+}
+
+// expiryWarnings describes the expired Rules in config.
+func expiryWarnings(config Config) []string {
+	var warnings []string
+	for i, rule := range config.Rules {
+		if rule.expired() {
+			warnings = append(warnings, fmt.Sprintf("%s expired on %s and no longer matches.", ruleOrigin(i, rule), rule.Expires))
+		}
+	}
+	return warnings
+}
+
+// pruneExpiredRules removes the expired Rules from config's own file.
+func pruneExpiredRules(config Config) {
+	expired := expiredRules(config)
+	if len(expired) == 0 {
+		return
+	}
+	var kept []Rule
+	for i, rule := range config.Rules {
+		if len(expired) > 0 && expired[0] == i {
+			expired = expired[1:]
+			continue
+		}
+		kept = append(kept, rule)
+	}
+	removed := len(config.Rules) - len(kept)
+	config.Rules = kept
+	tx := newTransaction(readWrite)
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+	logSuccess("Removed %d expired Rule(s).", removed)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// --- Export and Import ---
+//
+// `multiprof export` prints the config with paths under the user's home
+// written as ~/..., so it means the same on another machine or for a
+// teammate. `multiprof import` merges such a file into the local config:
+// new Rules are appended, and Rules, profiles, presets and Wrapper settings
+// that exist locally with different contents are conflicts, resolved by
+// --on-conflict. [settings] describe the local machine and are not imported.
+
+const (
+	formatTOML = "toml"
+	formatJSON = "json"
+)
+
+func runExport(args []string) {
+	exportCmd := flag.NewFlagSet("export", flag.ExitOnError)
+	format := exportCmd.String("format", formatTOML, "Output format: toml or json.")
+	if positional := parseFlags(exportCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof export [--format toml|json]")
+	}
+	config, err := loadConfig(readWrite)
+	if err != nil {
+		exitFailure(errFailed, "Run 'multiprof check' to validate your config.", "Could not parse config: %v", err)
+	}
+	data, err := marshalConfig(portableConfig(config), *format)
+	if err != nil {
+		exitWith(err)
+	}
+	os.Stdout.Write(data)
+}
+
+// portableConfig returns config with paths below the user's home rewritten
+// to start with ~.
+func portableConfig(config Config) Config {
+	out := config
+	out.Settings.ProfilesDir = tildePath(config.Settings.ProfilesDir)
+	out.Include = nil
+	for _, include := range config.Include {
+		out.Include = append(out.Include, tildePath(include))
+	}
+	out.Profiles = make(map[string]Profile, len(config.Profiles))
+	for name, p := range config.Profiles {
+		p.HomeSpec = portableHomeSpec(p.HomeSpec)
+		out.Profiles[name] = p
+	}
+	out.Rules = make([]Rule, len(config.Rules))
+	for i, rule := range config.Rules {
+		out.Rules[i] = portableRule(rule)
+	}
+	return out
+}
+
+func portableHomeSpec(spec HomeSpec) HomeSpec {
+	spec.Home = tildePath(spec.Home)
+	spec.Manifest = tildePath(spec.Manifest)
+	spec.CipherDir = tildePath(spec.CipherDir)
+	spec.Skeleton = tildePath(spec.Skeleton)
+	return spec
+}
+
+func portableRule(rule Rule) Rule {
+	if rule.PatternType != patternRegex {
+		rule.Pattern = tildePath(rule.Pattern)
+	}
+	var excludes []string
+	for _, exclude := range rule.Exclude {
+		excludes = append(excludes, tildePath(exclude))
+	}
+	rule.Exclude = excludes
+	rule.HomeSpec = portableHomeSpec(rule.HomeSpec)
+	return rule
+}
+
+// marshalConfig encodes config as TOML or as JSON with the same keys.
+func marshalConfig(config Config, format string) ([]byte, error) {
+	data, err := encodeConfig(config)
+	if err != nil || format == formatTOML {
+		return data, err
+	}
+	if format != formatJSON {
+		return nil, fmt.Errorf("unknown format '%s' (use toml or json)", format)
+	}
+	var generic map[string]interface{}
+	if _, err := toml.Decode(string(data), &generic); err != nil {
+		return nil, err
+	}
+	data, err = json.MarshalIndent(generic, "", "  ")
+	return append(data, '\n'), err
+}
+
+// unmarshalConfig decodes an exported config in either format.
+func unmarshalConfig(data []byte) (Config, error) {
+	var config Config
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		_, err := toml.Decode(string(data), &config)
+		return config, err
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return config, err
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(generic); err != nil {
+		return config, err
+	}
+	_, err := toml.Decode(buf.String(), &config)
+	return config, err
+}
+
+func runImport(args []string) {
+	importCmd := flag.NewFlagSet("import", flag.ExitOnError)
+	onConflict := importCmd.String("on-conflict", conflictAsk, "What to do with entries that exist with different contents: ask, keep or overwrite.")
+	dryRun := importCmd.Bool("dry-run", false, "Only report what would be imported.")
+	positional := parseFlags(importCmd, args)
+	if len(positional) != 1 {
+		exitUsage("Usage: multiprof import <file|-> [--on-conflict ask|keep|overwrite] [--dry-run]")
+	}
+	switch *onConflict {
+	case conflictAsk, conflictKeep, conflictOverwrite:
+	default:
+		exitUsage("Invalid --on-conflict value '%s'.", *onConflict)
+	}
+	if *onConflict == conflictAsk && (*dryRun || !isTerminal(os.Stdin) || positional[0] == "-") {
+		*onConflict = conflictKeep
+	}
+
+	var data []byte
+	var err error
+	if positional[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(expandPath(positional[0]))
+	}
+	if err != nil {
+		exitFailure(errFailed, "", "Could not read %s: %v", positional[0], err)
+	}
+	imported, err := unmarshalConfig(data)
+	if err != nil {
+		exitFailure(errFailed, "", "Could not parse %s: %v", positional[0], err)
+	}
+	config, err := loadConfig(readWrite)
+	if err != nil {
+		exitFailure(errFailed, "Run 'multiprof check' to validate your config.", "Could not parse config: %v", err)
+	}
+
+	m := &merge{policy: *onConflict}
+	merged := m.apply(config, imported)
+	if imported.Settings.MatchStrategy != config.Settings.MatchStrategy {
+		logWarn("The imported config uses match_strategy '%s' and yours '%s'; the Rules may match differently here.",
+			orDefault(imported.Settings.MatchStrategy, matchFirst), orDefault(config.Settings.MatchStrategy, matchFirst))
+	}
+	for i := len(config.Rules); i < len(merged.Rules); i++ {
+		if j, ok := shadowingRule(merged, i); ok {
+			logWarn("Imported Rule '%s' never matches, because your Rule %d ('%s') comes first.", merged.Rules[i].Pattern, j+1, merged.Rules[j].Pattern)
+			logInfo("Move it up with: multiprof move-rule %d %d", i+1, j+1)
+		}
+	}
+
+	summary := fmt.Sprintf("%d added, %d replaced, %d unchanged, %d conflicting kept", m.added, m.replaced, m.unchanged, m.kept)
+	if *dryRun {
+		logInfo("Dry run: %s.", summary)
+		return
+	}
+	if m.added+m.replaced == 0 {
+		logInfo("Nothing to import: %s.", summary)
+		return
+	}
+	tx := newTransaction(readWrite)
+	if err := tx.SaveConfig(merged); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+	logSuccess("Imported %s: %s.", positional[0], summary)
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// merge merges an imported config into the local one, counting what it did.
+type merge struct {
+	policy                           string
+	added, replaced, unchanged, kept int
+}
+
+func (m *merge) apply(config, imported Config) Config {
+	out := config
+	out.Profiles = mergeMap(m, "profile", config.Profiles, imported.Profiles, func(a, b Profile) bool {
+		a.HomeSpec, b.HomeSpec = portableHomeSpec(a.HomeSpec), portableHomeSpec(b.HomeSpec)
+		return sameEncoding(a, b)
+	})
+	out.Presets = mergeMap(m, "preset", config.Presets, imported.Presets, sameEncoding)
+	out.Wrappers = mergeMap(m, "Wrapper setting", config.Wrappers, imported.Wrappers, sameEncoding)
+
+	out.Rules = append([]Rule(nil), config.Rules...)
+	for _, rule := range imported.Rules {
+		i := indexOfRule(out.Rules, rule)
+		if i < 0 {
+			logInfo("Adding Rule '%s' (%s).", rule.Pattern, describeTarget(rule))
+			out.Rules = append(out.Rules, rule)
+			m.added++
+			continue
+		}
+		if sameEncoding(portableRule(out.Rules[i]), portableRule(rule)) {
+			m.unchanged++
+			continue
+		}
+		if m.resolve(fmt.Sprintf("Rule %d ('%s') uses %s; the imported one uses %s.", i+1, rule.Pattern, describeTarget(out.Rules[i]), describeTarget(rule))) {
+			out.Rules[i] = rule
+		}
+	}
+	return out
+}
+
+// resolve reports a conflict and whether the imported entry replaces the
+// local one.
+func (m *merge) resolve(conflict string) bool {
+	logWarn("Conflict: %s", conflict)
+	replace := m.policy == conflictOverwrite || (m.policy == conflictAsk && confirm("Replace yours with the imported one?"))
+	if replace {
+		m.replaced++
+	} else {
+		m.kept++
+	}
+	return replace
+}
+
+// mergeMap merges the named entries of one section of the config.
+// same reports whether a local and an imported entry are equivalent.
+func mergeMap[V any](m *merge, kind string, local, imported map[string]V, same func(a, b V) bool) map[string]V {
+	if len(imported) == 0 {
+		return local
+	}
+	out := make(map[string]V, len(local)+len(imported))
+	for name, v := range local {
+		out[name] = v
+	}
+	for _, name := range slices.Sorted(maps.Keys(imported)) {
+		v := imported[name]
+		existing, ok := local[name]
+		switch {
+		case !ok:
+			logInfo("Adding %s '%s'.", kind, name)
+			out[name] = v
+			m.added++
+		case same(existing, v):
+			m.unchanged++
+		case m.resolve(fmt.Sprintf("%s '%s' differs from yours.", strings.ToUpper(kind[:1])+kind[1:], name)):
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// indexOfRule returns the local Rule that applies in the same places as
+// rule: the same pattern and conditions. Excludes and targets may differ.
+func indexOfRule(rules []Rule, rule Rule) int {
+	for i, existing := range rules {
+		if existing.PatternType == rule.PatternType && sameRulePattern(existing, rule) && sameEncoding(ruleConditions(existing), ruleConditions(rule)) {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameRulePattern(a, b Rule) bool {
+	if a.PatternType == patternRegex {
+		return a.Pattern == b.Pattern
+	}
+	return filepath.Clean(expandPath(a.Pattern)) == filepath.Clean(expandPath(b.Pattern))
+}
+
+// ruleConditions returns only the conditions of rule.
+func ruleConditions(rule Rule) Rule {
+	return Rule{
+		InteractiveOnly:    rule.InteractiveOnly,
+		NonInteractiveOnly: rule.NonInteractiveOnly,
+		GitRemote:          rule.GitRemote,
+		Commands:           rule.Commands,
+		Host:               rule.Host,
+		WhenEnv:            rule.WhenEnv,
+	}
+}
+
+// sameEncoding reports whether a and b are written the same way in the
+// config.
+func sameEncoding[V any](a, b V) bool {
+	var bufA, bufB bytes.Buffer
+	errA := toml.NewEncoder(&bufA).Encode(map[string]interface{}{"v": a})
+	errB := toml.NewEncoder(&bufB).Encode(map[string]interface{}{"v": b})
+	return errA == nil && errB == nil && bytes.Equal(bufA.Bytes(), bufB.Bytes())
+}
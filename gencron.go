@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// --- Scheduled Jobs ---
+//
+// cron and systemd timers start commands with a minimal environment: no
+// interactive PATH, so no Wrappers, and a working directory that has nothing
+// to do with the job. `multiprof gen-cron` resolves the profile for a
+// directory once, now, and emits a crontab line (or systemd user units) that
+// runs the command through `multiprof run` with that profile pinned, using
+// absolute paths throughout.
+
+var unitNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func runGenCron(args []string) {
+	cronCmd := flag.NewFlagSet("gen-cron", flag.ExitOnError)
+	dirFlag := cronCmd.String("dir", "", "Directory to resolve the profile for and run the command in (default: the current directory).")
+	systemd := cronCmd.Bool("systemd", false, "Print a systemd user service and timer instead; the schedule is then an OnCalendar expression such as \"daily\".")
+	positional := parseFlags(cronCmd, args)
+	if len(positional) < 2 {
+		exitUsage("Usage: multiprof gen-cron \"<schedule>\" [--dir <dir>] [--systemd] [--] <command> [args...]")
+	}
+	schedule, command := positional[0], positional[1:]
+	dir := dirArg(nil)
+	if *dirFlag != "" {
+		dir = dirArg([]string{*dirFlag})
+	}
+
+	config, _ := loadConfig(readWrite)
+	invokedCommand = filepath.Base(command[0])
+	res, ok := resolveDir(config, dir)
+	if !ok {
+		explain := func() []string { return explainResolution(config, dir) }
+		exitWith(&failure{errNoRuleMatched, fmt.Sprintf("No Rule matches '%s', so there is no profile to pin.", dir), noMatchHint(config, dir), explain})
+	}
+	if res.Err != nil {
+		exitFailure(errInvalidRule, "Run 'multiprof check' to validate your config.", "%v", res.Err)
+	}
+	if ruleSetsEnv(res.Rule) {
+		fmt.Fprintf(os.Stderr, "[WARN] Rule %d sets environment variables of its own; the job only gets those of profile '%s'. Move them to the profile to keep them.\n", res.Index+1, res.Profile.label())
+	}
+	// The job must not depend on PATH.
+	if !hasPathSeparator(command[0]) {
+		target, err := findTarget(command[0])
+		if err != nil {
+			exitFailure(errFailed, "", "Could not find '%s' in PATH: %v", command[0], err)
+		}
+		command[0] = target
+	}
+	multiprofPath, _ := os.Executable()
+	profile := res.Profile.Name
+	if profile == "" {
+		profile = res.Profile.Home
+	}
+	runArgs := append([]string{multiprofPath, "run", "-p", profile, "--"}, command...)
+
+	if !*systemd {
+		quoted := make([]string, len(runArgs))
+		for i, arg := range runArgs {
+			quoted[i] = shellQuote(arg)
+		}
+		line := fmt.Sprintf("%s cd %s && %s", schedule, shellQuote(dir), strings.Join(quoted, " "))
+		// cron turns unescaped % into newlines.
+		fmt.Println(strings.ReplaceAll(line, "%", `\%`))
+		return
+	}
+
+	unit := "multiprof-" + strings.Trim(unitNameUnsafe.ReplaceAllString(res.Profile.label()+"-"+filepath.Base(command[0]), "-"), "-")
+	quoted := make([]string, len(runArgs))
+	for i, arg := range runArgs {
+		quoted[i] = systemdQuote(arg)
+	}
+	data := struct{ Unit, Profile, Command, Dir, ExecStart, Schedule string }{
+		unit, res.Profile.label(), strings.Join(command, " "), dir, strings.Join(quoted, " "), schedule,
+	}
+	unitDir := expandPath(filepath.Join("~/", systemdUserDirName))
+	for _, u := range []struct{ ext, name, text string }{
+		{".service", "cron.template.service", cronServiceTemplate},
+		{".timer", "cron.template.timer", cronTimerTemplate},
+	} {
+		tmpl, err := loadTemplate(u.name, u.text)
+		if err != nil {
+			exitWith(err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			exitFailure(errFailed, "", "Could not render %s: %v", u.name, err)
+		}
+		fmt.Printf("# --- %s ---\n%s\n", filepath.Join(unitDir, unit+u.ext), buf.String())
+	}
+	fmt.Printf("# Save the units above, then: systemctl --user daemon-reload && systemctl --user enable --now %s.timer\n", unit)
+}
+
+// ruleSetsEnv reports whether a Rule sets variables beyond its profile's.
+func ruleSetsEnv(rule Rule) bool {
+	return len(rule.Env) > 0 || len(rule.Presets) > 0 || rule.Network != nil
+}
+
+// systemdQuote quotes an ExecStart argument, escaping specifiers.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "$", "$$")
+	return `"` + s + `"`
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenCronLineRuns(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "home")
+	if err := os.Mkdir(home, 0755); err != nil {
+		t.Fatal(err)
+	}
+	setupDaemonTest(t, fmt.Sprintf("[[rules]]\npattern = %q\nhome = %q\n", root+"/**", home))
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(multiprofTestEnvVar, "1")
+
+	out, err := exec.Command(self, "gen-cron", "@daily", "--dir", root, "--", "sh", "-c", `echo "$HOME"`).Output()
+	if err != nil {
+		t.Fatalf("gen-cron: %v", err)
+	}
+	line, ok := strings.CutPrefix(strings.TrimSpace(string(out)), "@daily ")
+	if !ok {
+		t.Fatalf("gen-cron printed %q", out)
+	}
+	// Run the job as cron would, from elsewhere and with its minimal PATH.
+	job := exec.Command("/bin/sh", "-c", line)
+	job.Dir = "/"
+	job.Env = append(os.Environ(), "PATH=/usr/bin:/bin")
+	got, err := job.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", line, err, got)
+	}
+	if strings.TrimSpace(string(got)) != home {
+		t.Errorf("job printed %q, want %q", got, home)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Git Repositories ---
+//
+// Some Rule conditions look at the git repository a directory belongs to.
+// Wrappers run often, so instead of starting git they read .git directly:
+// find the repository by walking up, follow `gitdir:` files (worktrees and
+// submodules) and parse the config file.
+
+// gitDir returns the git directory of the repository containing dir.
+func gitDir(dir string) (string, bool) {
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		dotGit := filepath.Join(dir, ".git")
+		if info, err := os.Stat(dotGit); err == nil {
+			if info.IsDir() {
+				return dotGit, true
+			}
+			// A worktree or submodule: .git is a file pointing elsewhere.
+			data, err := os.ReadFile(dotGit)
+			if err != nil {
+				return "", false
+			}
+			target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+			if !ok {
+				return "", false
+			}
+			target = strings.TrimSpace(target)
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(dir, target)
+			}
+			return target, true
+		}
+		if filepath.Dir(dir) == dir {
+			return "", false
+		}
+	}
+}
+
+// gitWorkTree returns the top directory of the git work tree containing dir.
+func gitWorkTree(dir string) (string, bool) {
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		if fileExists(filepath.Join(dir, ".git")) {
+			return dir, true
+		}
+		if filepath.Dir(dir) == dir {
+			return "", false
+		}
+	}
+}
+
+// gitConfigPath returns the config file of a git directory. Linked worktrees
+// share the config of the main repository, found through `commondir`.
+func gitConfigPath(gitDir string) string {
+	if data, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		common := strings.TrimSpace(string(data))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(gitDir, common)
+		}
+		gitDir = common
+	}
+	return filepath.Join(gitDir, "config")
+}
+
+var gitRemoteCache = make(map[string][]string)
+
+// gitRemoteURLs returns the URLs of all remotes of the repository containing
+// dir, or nil if dir is not in a repository.
+func gitRemoteURLs(dir string) []string {
+	if urls, ok := gitRemoteCache[dir]; ok {
+		return urls
+	}
+	var urls []string
+	if gd, ok := gitDir(dir); ok {
+		urls = readGitConfig(gitConfigPath(gd))["remote"]["url"]
+	}
+	gitRemoteCache[dir] = urls
+	return urls
+}
+
+// readGitConfig parses a git config file into section -> key -> values,
+// ignoring subsection names. Keys are lower-cased, as git treats them.
+func readGitConfig(path string) map[string]map[string][]string {
+	config := make(map[string]map[string][]string)
+	f, err := os.Open(path)
+	if err != nil {
+		return config
+	}
+	defer f.Close()
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			header := strings.Trim(line, "[]")
+			name, _, _ := strings.Cut(header, " ")
+			section = strings.ToLower(strings.TrimSpace(name))
+			if config[section] == nil {
+				config[section] = make(map[string][]string)
+			}
+		case section != "":
+			key, value, _ := strings.Cut(line, "=")
+			key = strings.ToLower(strings.TrimSpace(key))
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			config[section][key] = append(config[section][key], value)
+		}
+	}
+	return config
+}
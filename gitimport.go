@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// --- Importing git includeIf Sections ---
+//
+// Many people already keep one git identity per directory tree with
+// `[includeIf "gitdir:~/work/"]` sections in ~/.gitconfig. That only covers
+// git; `multiprof import-gitconfig-includes` turns each such section into a
+// profile and a Rule, so every wrapped tool sees the same split. The new home
+// gets a .gitconfig including your own ~/.gitconfig and then the identity
+// file, so git behaves there as it did before. Conditions other than gitdir
+// (onbranch, hasconfig) have no multiprof equivalent and are skipped.
+
+// gitInclude is one `[includeIf "gitdir:..."]` section.
+type gitInclude struct {
+	condition string
+	pattern   string // gitdir pattern, without the prefix
+	folded    bool   // gitdir/i: matched case-insensitively
+	paths     []string
+}
+
+// gitImport is what import-gitconfig-includes proposes for a gitInclude.
+type gitImport struct {
+	include gitInclude
+	profile string
+	home    string
+	rule    Rule
+}
+
+func runImportGitconfigIncludes(args []string) {
+	importCmd := flag.NewFlagSet("import-gitconfig-includes", flag.ExitOnError)
+	gitconfigFlag := importCmd.String("gitconfig", "~/.gitconfig", "The git config file to read includeIf sections from.")
+	dryRun := importCmd.Bool("dry-run", false, "Only show the profiles and Rules that would be created.")
+	yes := importCmd.Bool("yes", false, "Do not ask for confirmation.")
+	if positional := parseFlags(importCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof import-gitconfig-includes [--gitconfig <file>] [--dry-run] [--yes]")
+	}
+	gitconfig, err := filepath.Abs(expandPath(*gitconfigFlag))
+	if err != nil {
+		exitWith(err)
+	}
+	includes, err := readGitIncludes(gitconfig)
+	if err != nil {
+		exitFailure(errFailed, "", "Could not read %s: %v", *gitconfigFlag, err)
+	}
+	config, _ := loadConfig(readWrite)
+
+	var imports []gitImport
+	taken := make(map[string]bool)
+	for _, inc := range includes {
+		if inc.pattern == "" {
+			logInfo("Skipping [includeIf \"%s\"]: multiprof has no equivalent condition.", inc.condition)
+			continue
+		}
+		pattern := gitdirPattern(inc.pattern, filepath.Dir(gitconfig))
+		if i, ok := ruleWithPattern(config, pattern); ok {
+			logInfo("Skipping [includeIf \"%s\"]: Rule %d already uses the pattern '%s'.", inc.condition, i+1, pattern)
+			continue
+		}
+		name := gitImportProfileName(config, taken, inc)
+		home := filepath.Join(profilesDir(config), name)
+		if entries, err := os.ReadDir(home); err == nil && len(entries) > 0 {
+			logWarn("Skipping [includeIf \"%s\"]: the home for profile '%s', %s, exists and is not empty.", inc.condition, name, tildePath(home))
+			continue
+		}
+		taken[name] = true
+		imports = append(imports, gitImport{include: inc, profile: name, home: home, rule: Rule{Pattern: pattern, Profile: name}})
+	}
+	if len(imports) == 0 {
+		logInfo("Found nothing to import in %s.", tildePath(gitconfig))
+		return
+	}
+
+	fmt.Printf("Proposed profiles and Rules for the includeIf sections of %s:\n\n", tildePath(gitconfig))
+	for _, imp := range imports {
+		fmt.Printf("  [includeIf \"%s\"]\n", imp.include.condition)
+		if identity := gitIdentity(gitconfig, imp.include.paths); identity != "" {
+			fmt.Printf("    identity: %s\n", identity)
+		}
+		fmt.Printf("    profile:  %s, home %s\n", imp.profile, tildePath(imp.home))
+		fmt.Printf("    Rule:     when in '%s', use profile '%s'\n", imp.rule.Pattern, imp.profile)
+		if imp.include.folded {
+			fmt.Printf("    note:     gitdir/i matches case-insensitively; the Rule does not.\n")
+		}
+		fmt.Println()
+	}
+	if *dryRun {
+		logInfo("Dry run: nothing was changed.")
+		return
+	}
+	if !*yes {
+		if !isTerminal(os.Stdin) {
+			exitFailure(errRefusedChange, "Re-run with --yes.", "Refusing to import without confirmation.")
+		}
+		if !confirm(fmt.Sprintf("Create %d profile(s) and Rule(s)?", len(imports))) {
+			logInfo("Aborted.")
+			return
+		}
+	}
+
+	tx := newTransaction(readWrite)
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]Profile)
+	}
+	for _, imp := range imports {
+		if err := tx.MkdirAll(imp.home, 0700); err != nil {
+			tx.abort("Could not create home: %v", err)
+		}
+		if err := tx.WriteFile(filepath.Join(imp.home, ".gitconfig"), []byte(gitImportConfig(gitconfig, imp.include.paths)), 0644); err != nil {
+			tx.abort("Could not write the .gitconfig of '%s': %v", imp.profile, err)
+		}
+		config.Profiles[imp.profile] = Profile{HomeSpec: HomeSpec{Home: tildePath(imp.home)}}
+		config.Rules = append(config.Rules, imp.rule)
+	}
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+
+	for _, imp := range imports {
+		logSuccess("Created profile '%s' with home %s, used when in '%s'.", imp.profile, tildePath(imp.home), imp.rule.Pattern)
+	}
+	for i := len(config.Rules) - len(imports); i < len(config.Rules); i++ {
+		if j, ok := shadowingRule(config, i); ok {
+			logWarn("Imported Rule '%s' never matches, because your Rule %d ('%s') comes first.", config.Rules[i].Pattern, j+1, config.Rules[j].Pattern)
+			logInfo("Move it up with: multiprof move-rule %d %d", i+1, j+1)
+		}
+	}
+	logInfo("Create Wrappers for your tools, e.g. 'multiprof add-wrapper git'. Once they are in place, the includeIf sections in %s are no longer needed.", tildePath(gitconfig))
+}
+
+// readGitIncludes returns the includeIf sections of a git config file.
+// Unlike readGitConfig it keeps subsection names, which hold the condition.
+func readGitIncludes(path string) ([]gitInclude, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var includes []gitInclude
+	var current *gitInclude
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			current = nil
+			header := strings.Trim(line, "[]")
+			name, sub, _ := strings.Cut(header, " ")
+			if !strings.EqualFold(name, "includeIf") {
+				continue
+			}
+			inc := gitInclude{condition: strings.Trim(strings.TrimSpace(sub), `"`)}
+			if p, ok := strings.CutPrefix(inc.condition, "gitdir:"); ok {
+				inc.pattern = p
+			} else if p, ok := strings.CutPrefix(inc.condition, "gitdir/i:"); ok {
+				inc.pattern, inc.folded = p, true
+			}
+			includes = append(includes, inc)
+			current = &includes[len(includes)-1]
+		case current != nil:
+			key, value, _ := strings.Cut(line, "=")
+			if strings.EqualFold(strings.TrimSpace(key), "path") {
+				current.paths = append(current.paths, strings.Trim(strings.TrimSpace(value), `"`))
+			}
+		}
+	}
+	return includes, scanner.Err()
+}
+
+// gitdirPattern turns a gitdir pattern into a Rule pattern. git matches it
+// against the .git directory of a repository, a Rule against the directory a
+// command runs in: a trailing slash means everything below, a pattern
+// without a leading /, ~/ or ./ may match anywhere, and ./ is relative to
+// the directory of the git config file.
+func gitdirPattern(pattern, configDir string) string {
+	if rest, ok := strings.CutPrefix(pattern, "./"); ok {
+		pattern = filepath.ToSlash(configDir) + "/" + rest
+	} else if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "~/") {
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/**") {
+		return pattern
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(pattern, "/"), "/.git") + "/**"
+}
+
+// ruleWithPattern returns the index of a Rule in the user's config using
+// pattern.
+func ruleWithPattern(config Config, pattern string) (int, bool) {
+	for i, rule := range config.Rules {
+		if rule.PatternType != patternRegex && expandPath(rule.Pattern) == expandPath(pattern) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// gitImportProfileName names the profile for inc after its first include
+// file, as in ~/.gitconfig-work -> work, or else after the directory of its
+// pattern, avoiding existing profiles and those in taken.
+func gitImportProfileName(config Config, taken map[string]bool, inc gitInclude) string {
+	base := ""
+	if len(inc.paths) > 0 {
+		base = strings.TrimPrefix(filepath.Base(inc.paths[0]), ".")
+		base = strings.TrimPrefix(strings.TrimSuffix(base, ".inc"), "gitconfig")
+		base = strings.Trim(base, "-_.")
+	}
+	if base == "" {
+		base = filepath.Base(strings.TrimSuffix(strings.TrimSuffix(inc.pattern, "/"), "/.git"))
+	}
+	if base == "" || strings.ContainsAny(base, `*?[{/\`) || base == "." || base == "~" {
+		base = "git"
+	}
+	name := base
+	for n := 2; ; n++ {
+		if _, exists := config.Profiles[name]; !exists && !taken[name] {
+			return name
+		}
+		name = base + "-" + strconv.Itoa(n)
+	}
+}
+
+// gitIncludePath returns the absolute path of an include file named in
+// gitconfig.
+func gitIncludePath(gitconfig, path string) string {
+	path = expandPath(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(gitconfig), path)
+	}
+	return path
+}
+
+// gitIdentity describes the user.name and user.email set by include files.
+func gitIdentity(gitconfig string, paths []string) string {
+	var name, email string
+	for _, path := range paths {
+		user := readGitConfig(gitIncludePath(gitconfig, path))["user"]
+		if v := user["name"]; len(v) > 0 {
+			name = v[len(v)-1]
+		}
+		if v := user["email"]; len(v) > 0 {
+			email = v[len(v)-1]
+		}
+	}
+	switch {
+	case name != "" && email != "":
+		return fmt.Sprintf("%s <%s>", name, email)
+	case email != "":
+		return "<" + email + ">"
+	}
+	return name
+}
+
+// gitImportConfig returns the .gitconfig of an imported profile's home: your
+// own config, then the include files. Paths are absolute, since git expands
+// ~ with the profile's home.
+func gitImportConfig(gitconfig string, paths []string) string {
+	var b strings.Builder
+	b.WriteString("# Written by multiprof import-gitconfig-includes.\n")
+	for _, path := range append([]string{gitconfig}, paths...) {
+		fmt.Fprintf(&b, "[include]\n\tpath = %s\n", strconv.Quote(gitIncludePath(gitconfig, path)))
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+)
+
+// --- Shell Hook ---
+//
+// Wrappers switch HOME only for the commands they wrap; tools started any
+// other way, such as an editor launched from the shell, never see the
+// profile. `multiprof hook bash|zsh|fish` prints a hook that, before every
+// prompt, exports the matching Rule's HOME and environment into the shell
+// itself, like direnv, and puts back what was there once you leave. The
+// values it replaced are kept in MULTIPROF_HOOK_SAVED, so each run matches
+// as if the hook had never changed anything. credentials_cmd is left to
+// Wrappers: a prompt should not wait for a login.
+
+const hookSavedEnvVar = "MULTIPROF_HOOK_SAVED"
+
+const bashHook = `_multiprof_hook() {
+  local status=$?
+  eval "$(multiprof hook --export bash)"
+  return $status
+}
+if [[ ";${PROMPT_COMMAND:-};" != *";_multiprof_hook;"* ]]; then
+  PROMPT_COMMAND="_multiprof_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+`
+
+const zshHook = `_multiprof_hook() {
+  eval "$(multiprof hook --export zsh)"
+}
+autoload -U add-zsh-hook
+add-zsh-hook precmd _multiprof_hook
+add-zsh-hook chpwd _multiprof_hook
+`
+
+const fishHook = `function __multiprof_hook --on-event fish_prompt
+    multiprof hook --export fish | source
+end
+`
+
+func runHook(args []string) {
+	hookCmd := flag.NewFlagSet("hook", flag.ExitOnError)
+	export := hookCmd.Bool("export", false, "Print the shell code for the current directory; run by the hook before each prompt.")
+	positional := parseFlags(hookCmd, args)
+	hooks := map[string]string{shellBash: bashHook, shellZsh: zshHook, shellFish: fishHook}
+	if len(positional) != 1 || hooks[positional[0]] == "" {
+		exitUsage("Usage: multiprof hook bash|zsh|fish")
+	}
+	shell := positional[0]
+	if !*export {
+		fmt.Print(hooks[shell])
+		return
+	}
+	if shell != shellFish {
+		shell = "sh"
+	}
+	for _, line := range hookExport(shell) {
+		fmt.Println(line)
+	}
+}
+
+// hookExport returns the shell code that brings the shell's environment in
+// line with the Rule matching the current directory.
+func hookExport(shell string) []string {
+	previousHome := os.Getenv(activeHomeEnvVar)
+	var saved map[string]*string
+	if data := os.Getenv(hookSavedEnvVar); data != "" {
+		if err := json.Unmarshal([]byte(data), &saved); err != nil {
+			debugf("Ignoring invalid %s: %v", hookSavedEnvVar, err)
+		}
+	}
+	// Match with the shell's own environment, not the one the hook set.
+	var restoreErr error
+	for key, value := range saved {
+		var err error
+		if value == nil {
+			err = os.Unsetenv(key)
+		} else {
+			err = os.Setenv(key, *value)
+		}
+		if err != nil && restoreErr == nil {
+			restoreErr = fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	want := make(map[string]string)
+	cwd, cwdErr := os.Getwd()
+	var answer daemonAnswer
+	if cwdErr == nil {
+		answer = askDaemon("", cwd, nil)
+	}
+	// Runs before every prompt: only read the config, if the daemon did not
+	// send it.
+	config, err := loadConfig(readOnly)
+	checkStrict(config, err, "Could not load the config")
+	checkStrict(config, restoreErr, "Could not restore the shell's variable")
+	checkStrict(config, cwdErr, "Could not determine the current directory")
+	if res, ok := answer.resolve(config, cwd); ok && res.Err == nil {
+		err := error(nil)
+		if res.Profile.Home != previousHome {
+			err = activateProfile(readOnly, res.Profile)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] Not switching to %s: %v\n", profileOrigin(res.Profile), err)
+		} else {
+			env := res.environment(os.Environ())
+			for _, key := range env.Changed() {
+				want[key] = env.Get(key)
+			}
+		}
+	}
+
+	var lines []string
+	for _, key := range slices.Sorted(maps.Keys(saved)) {
+		if _, ok := want[key]; !ok {
+			lines = append(lines, shellAssign(shell, key, saved[key]))
+		}
+	}
+	replaced := make(map[string]*string)
+	for _, key := range sortedKeys(want) {
+		if value, ok := os.LookupEnv(key); ok {
+			replaced[key] = &value
+		} else {
+			replaced[key] = nil
+		}
+		value := want[key]
+		lines = append(lines, shellAssign(shell, key, &value))
+	}
+	if len(replaced) == 0 {
+		if saved != nil || os.Getenv(hookSavedEnvVar) != "" {
+			lines = append(lines, shellAssign(shell, hookSavedEnvVar, nil))
+		}
+		return lines
+	}
+	data, _ := json.Marshal(replaced)
+	state := string(data)
+	return append(lines, shellAssign(shell, hookSavedEnvVar, &state))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+)
+
+// --- Config Includes ---
+//
+// `include = ["~/.config/multiprof/conf.d/*.toml"]` at the top of the config
+// merges other files at load time, so work, personal and machine-specific
+// Rules can live apart. Included Rules come after the config's own, in the
+// order of the include globs and, within a glob, of the file names. Profiles,
+// presets and Wrapper settings are merged by name; the config's own, then
+// earlier files, take precedence. [settings] and nested includes in included
+// files are ignored.
+//
+// Commands that change the config only ever write the main file: included
+// entries are marked when loaded and left out when it is saved.
+
+// applyIncludes merges the files config includes into it.
+func (c *Config) applyIncludes() {
+	for _, pattern := range c.Include {
+		matches, err := filepath.Glob(expandPath(pattern))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] Ignoring invalid include '%s': %v\n", pattern, err)
+			c.incomplete = true
+			continue
+		}
+		sort.Strings(matches)
+		for _, path := range matches {
+			c.mergeInclude(path)
+		}
+	}
+}
+
+func (c *Config) mergeInclude(path string) {
+	var included Config
+	meta, err := toml.DecodeFile(path, &included)
+	if err != nil {
+		if problem := permissionProblem(path, err); problem != "" {
+			err = fmt.Errorf("%s", problem)
+		}
+		fmt.Fprintf(os.Stderr, "[WARN] Ignoring included config %s: %v\n", path, err)
+		c.incomplete = true
+		return
+	}
+	if meta.IsDefined("settings") || meta.IsDefined("include") {
+		fmt.Fprintf(os.Stderr, "[WARN] Ignoring [settings] and include in %s; they only apply in the main config.\n", path)
+		c.incomplete = true
+	}
+	debugf("Including %s: %d Rules", path, len(included.Rules))
+	if c.included == nil {
+		c.included = make(map[string]string)
+	}
+	for i := range included.Rules {
+		included.Rules[i].source = path
+	}
+	c.Rules = append(c.Rules, included.Rules...)
+	c.Profiles = mergeIncluded(c.Profiles, included.Profiles, c.included, "profiles", path)
+	c.Presets = mergeIncluded(c.Presets, included.Presets, c.included, "presets", path)
+	c.Wrappers = mergeIncluded(c.Wrappers, included.Wrappers, c.included, "wrappers", path)
+}
+
+// mergeIncluded adds the entries of an included section that are not defined
+// yet, recording where each came from under "<section>.<name>".
+func mergeIncluded[V any](own, included map[string]V, origins map[string]string, section, path string) map[string]V {
+	for name, v := range included {
+		if _, ok := own[name]; ok {
+			debugf("Ignoring %s.%s in %s; it is already defined", section, name, path)
+			continue
+		}
+		if own == nil {
+			own = make(map[string]V)
+		}
+		own[name] = v
+		origins[section+"."+name] = path
+	}
+	return own
+}
+
+// own returns the config without the entries merged from included files,
+// i.e. what belongs in the main config file.
+func (c Config) own() Config {
+	fromInclude := func(rule Rule) bool { return rule.source != "" }
+	if len(c.included) == 0 && !slices.ContainsFunc(c.Rules, fromInclude) {
+		return c
+	}
+	out := c
+	out.Rules = nil
+	for _, rule := range c.Rules {
+		if rule.source == "" {
+			out.Rules = append(out.Rules, rule)
+		}
+	}
+	out.Profiles = withoutIncluded(c.Profiles, c.included, "profiles")
+	out.Presets = withoutIncluded(c.Presets, c.included, "presets")
+	out.Wrappers = withoutIncluded(c.Wrappers, c.included, "wrappers")
+	out.included = nil
+	return out
+}
+
+func withoutIncluded[V any](m map[string]V, origins map[string]string, section string) map[string]V {
+	var out map[string]V
+	for name, v := range m {
+		if _, ok := origins[section+"."+name]; ok {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]V)
+		}
+		out[name] = v
+	}
+	return out
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// --- Journals ---
+//
+// A journal is an append-only file in the state directory that many Wrapper
+// processes write to at the same time, such as the usage stats. Each record
+// is one line of JSON written with a single write to a file opened with
+// O_APPEND, so concurrent records never interleave. The remains of a write
+// cut short by a crash are skipped when reading and dropped by compaction.
+//
+// Compaction moves the journal aside before reading it, so Wrappers that
+// append in the meantime start a new file, and appends the records it keeps
+// back the same way. Records therefore carry their own time, and readers do
+// not rely on their order in the file. Writers hold a shared lock on the
+// file while they append, and compaction takes an exclusive one on the file
+// it moved aside, so it waits for Wrappers that opened the journal before it
+// was moved; one that only gets the lock afterwards sees the file has been
+// moved and opens the journal again.
+
+// maxJournalRecord keeps records small enough to be written in one piece.
+const maxJournalRecord = 4096
+
+// appendRecord appends record as one line to the journal name, for a
+// command with access a.
+func appendRecord(a access, name string, record interface{}) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if len(line) >= maxJournalRecord {
+		return fmt.Errorf("record of %d bytes is too large for journal %s", len(line), name)
+	}
+	if err := ensureStateDir(a); err != nil {
+		return err
+	}
+	return appendLines(stateFile(name), [][]byte{line})
+}
+
+// appendLines appends each line with its own write, holding a shared lock
+// on the file.
+func appendLines(path string, lines [][]byte) error {
+	f, err := openJournal(path)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// openJournal opens the journal at path for appending, with a shared lock
+// on the file that is still at path once the lock is held.
+func openJournal(path string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f, false); err != nil {
+			f.Close()
+			return nil, err
+		}
+		opened, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err == nil && os.SameFile(opened, current) {
+			return f, nil
+		}
+		f.Close() // Moved aside by compaction while waiting for the lock.
+	}
+}
+
+// readJournal calls fn with each record of the journal at path and returns
+// the number of lines that could not be parsed.
+func readJournal(path string, fn func(line []byte) error) (corrupt int, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, maxJournalRecord), 1<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			// A torn write has no newline, so the next record is glued to it.
+			corrupt++
+			if line = recoverRecord(line); line == nil {
+				continue
+			}
+		}
+		if fn(line) != nil {
+			corrupt++
+		}
+	}
+	return corrupt, scanner.Err()
+}
+
+// recoverRecord returns the record at the end of a line that starts with the
+// remains of a torn write, or nil.
+func recoverRecord(line []byte) []byte {
+	for i := 1; i < len(line); i++ {
+		if line[i] == '{' && json.Valid(line[i:]) {
+			return line[i:]
+		}
+	}
+	return nil
+}
+
+// compactJournal rewrites the journal name with only the records keep
+// accepts. It returns how many records were kept and dropped, and how many
+// corrupt lines were dropped.
+func compactJournal(name string, keep func(line []byte) bool) (kept, dropped, corrupt int, err error) {
+	path := stateFile(name)
+	aside := fmt.Sprintf("%s.compacting-%d", path, os.Getpid())
+	if err := os.Rename(path, aside); os.IsNotExist(err) {
+		return 0, 0, 0, nil
+	} else if err != nil {
+		return 0, 0, 0, err
+	}
+	// Wait for Wrappers still appending to the file moved aside. Those that
+	// get the lock later append to the journal instead.
+	f, err := os.Open(aside)
+	if err == nil {
+		err = lockFile(f, true)
+	}
+	var lines [][]byte
+	if err == nil {
+		corrupt, err = readJournal(aside, func(line []byte) error {
+			if keep(line) {
+				lines = append(lines, append([]byte(nil), line...))
+			} else {
+				dropped++
+			}
+			return nil
+		})
+	}
+	if f != nil {
+		f.Close()
+	}
+	if err == nil {
+		err = appendLines(path, lines)
+	}
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w (the old records are still in %s)", err, aside)
+	}
+	os.Remove(aside)
+	return len(lines), dropped, corrupt, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCompactJournalKeepsConcurrentRecords(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(userHomeEnvVar, "")
+	const name, writers, records = "test.jsonl", 8, 50
+
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range records {
+				if err := appendRecord(readWrite, name, map[string]int{"writer": w, "n": n}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, _, _, err := compactJournal(name, func([]byte) bool { return true }); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	done <- struct{}{}
+	<-done
+
+	count := 0
+	corrupt, err := readJournal(stateFile(name), func([]byte) error {
+		count++
+		return nil
+	})
+	if err != nil || corrupt != 0 {
+		t.Fatalf("readJournal: %d corrupt, %v", corrupt, err)
+	}
+	if count != writers*records {
+		t.Errorf("journal holds %d records, want %d", count, writers*records)
+	}
+	if aside, _ := filepath.Glob(stateFile(name) + ".compacting-*"); len(aside) > 0 {
+		t.Errorf("left behind: %v", aside)
+	}
+}
+
+func TestCompactJournalWaitsForWriter(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(userHomeEnvVar, "")
+	const name = "test.jsonl"
+	if err := appendRecord(readWrite, name, map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	// A Wrapper that opened the journal before compaction moved it aside.
+	f, err := openJournal(stateFile(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compacted := make(chan int)
+	go func() {
+		kept, _, _, err := compactJournal(name, func([]byte) bool { return true })
+		if err != nil {
+			t.Error(err)
+		}
+		compacted <- kept
+	}()
+	// Wait for the journal to be moved aside, then write to the old file.
+	for {
+		select {
+		case kept := <-compacted:
+			t.Fatalf("compaction kept %d records without waiting for the writer", kept)
+		default:
+		}
+		if _, err := os.Stat(stateFile(name)); os.IsNotExist(err) {
+			break
+		}
+	}
+	if _, err := f.Write([]byte(`{"n":2}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if kept := <-compacted; kept != 2 {
+		t.Errorf("compaction kept %d records, want 2", kept)
+	}
+}
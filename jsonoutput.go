@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// --- JSON Output ---
+//
+// The global --json flag, given before or after the command name, makes the
+// commands that list or inspect things print JSON for scripts and editor
+// integrations: list, list-wrappers, which, check, status, test-rule, log,
+// stats and report. Commands that change things print a commandResult when
+// they finish: whether they succeeded, the files they changed, their
+// messages and, on failure, the kind of failure and its hint.
+// [INFO], [OK] and [WARN] messages then go to stderr, and so does anything
+// else a changing command prints, so that stdout holds nothing but the JSON
+// document; exit statuses are unchanged. Other commands reject --json.
+
+const jsonFlag = "--json"
+
+// jsonOutput is set by --json.
+var jsonOutput bool
+
+// jsonCommands are the commands that print a JSON document of their own.
+var jsonCommands = []string{"list", "list-wrappers", "which", "check", "status", "test-rule", "log", "stats", "report"}
+
+// resultCommands are the commands that print a commandResult.
+var resultCommands = []string{
+	"init", "add-rule", "add-wrapper", "add-aliases", "sync-wrappers", "remove-wrapper",
+	"migrate-suffix", "migrate-layout", "edit-rule", "move-rule", "enable-rule", "disable-rule",
+	"promote", "demote", "cleanup", "profile", "pack", "new", "quickstart", "create-profile",
+	"clone-profile", "delete-profile", "rename-profile", "compact", "restore", "import",
+	"import-gitconfig-includes", "uninstall", "trust", "untrust", "completion", "gen-precommit", "agent",
+}
+
+// commandLineCommands end in a command line of their own, whose arguments
+// are not multiprof's.
+var commandLineCommands = []string{"run", "gen-cron"}
+
+// takeJSONFlag removes --json from args, reporting whether it was there.
+// Arguments after "--" are left alone.
+func takeJSONFlag(args []string) ([]string, bool) {
+	var rest []string
+	found := false
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if arg == jsonFlag || arg == "-json" {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, found
+}
+
+// setJSONOutput handles --json for command, exiting if command does not
+// support it.
+func setJSONOutput(command string, args []string) []string {
+	if !slices.Contains(commandLineCommands, command) {
+		var found bool
+		args, found = takeJSONFlag(args)
+		jsonOutput = jsonOutput || found
+	}
+	switch {
+	case !jsonOutput || slices.Contains(jsonCommands, command):
+	case slices.Contains(resultCommands, command):
+		result = &commandResult{Command: command, Changed: []string{}, Messages: []resultMessage{}}
+		os.Stdout = os.Stderr
+	default:
+		hint := fmt.Sprintf("--json works with %s, and with commands that change things.", strings.Join(jsonCommands, ", "))
+		exitFailure(errUsage, hint, "'%s' has no JSON output.", command)
+	}
+	return args
+}
+
+// commandResult is the JSON form of the outcome of a command that changes
+// things.
+type commandResult struct {
+	Command  string          `json:"command"`
+	OK       bool            `json:"ok"`
+	Changed  []string        `json:"changed"`
+	Messages []resultMessage `json:"messages"`
+	Error    *resultError    `json:"error,omitempty"`
+}
+
+type resultMessage struct {
+	Level string `json:"level"`
+	Text  string `json:"text"`
+}
+
+type resultError struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// result is the outcome of the running command with --json, if it is one
+// of resultCommands.
+var result *commandResult
+
+// jsonStdout is stdout, which printJSON writes to even while os.Stdout is
+// stderr for a commandResult.
+var jsonStdout = os.Stdout
+
+// recordMessage adds a message at level to result, if it is set.
+func recordMessage(level, format string, v ...interface{}) {
+	if result != nil {
+		result.Messages = append(result.Messages, resultMessage{level, fmt.Sprintf(format, v...)})
+	}
+}
+
+// recordChanged adds the paths a committed transaction changed to result,
+// if it is set.
+func recordChanged(paths []string) {
+	if result == nil {
+		return
+	}
+	for _, path := range paths {
+		if !slices.Contains(result.Changed, path) {
+			result.Changed = append(result.Changed, path)
+		}
+	}
+}
+
+// printResult prints result, if it is set, with err as its failure unless
+// that is nil.
+func printResult(err error) {
+	if result == nil {
+		return
+	}
+	r := result
+	result = nil // Printed once, even if printing fails.
+	r.OK = err == nil
+	if err != nil {
+		var f *failure
+		kind, hint := errFailed, ""
+		if errors.As(err, &f) {
+			kind, hint = f.kind, f.hint
+		}
+		r.Error = &resultError{Kind: kind.Error(), Message: err.Error(), Hint: hint}
+	}
+	printJSON(r)
+}
+
+// messageOutput is where [INFO], [OK] and [WARN] messages go.
+func messageOutput() io.Writer {
+	if jsonOutput {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v any) {
+	enc := json.NewEncoder(jsonStdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		exitWith(err)
+	}
+}
+
+// listedProfile and listedRule are the JSON form of `multiprof list`.
+type listedProfile struct {
+	Name string `json:"name"`
+	Home string `json:"home"`
+}
+
+type listedRule struct {
+	Index       int               `json:"index"`
+	Pattern     string            `json:"pattern"`
+	PatternType string            `json:"pattern_type,omitempty"`
+	Profile     string            `json:"profile,omitempty"`
+	Home        string            `json:"home,omitempty"`
+	Exclude     []string          `json:"exclude,omitempty"`
+	Commands    []string          `json:"commands,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Disabled    bool              `json:"disabled,omitempty"`
+	Expires     string            `json:"expires,omitempty"`
+	Expired     bool              `json:"expired,omitempty"`
+}
+
+type listedPack struct {
+	Name  string       `json:"name"`
+	Rules []listedRule `json:"rules"`
+}
+
+type listing struct {
+	Suffix        string          `json:"suffix"`
+	MatchStrategy string          `json:"match_strategy"`
+	Profiles      []listedProfile `json:"profiles"`
+	Rules         []listedRule    `json:"rules"`
+	Packs         []listedPack    `json:"packs"`
+}
+
+func listedRules(rules []Rule) []listedRule {
+	listed := []listedRule{}
+	for i, rule := range rules {
+		listed = append(listed, listedRule{
+			Index:       i + 1,
+			Pattern:     rule.Pattern,
+			PatternType: rule.PatternType,
+			Profile:     rule.Profile,
+			Home:        rule.Home,
+			Exclude:     rule.Exclude,
+			Commands:    rule.Commands,
+			Env:         rule.Env,
+			Source:      rule.source,
+			Disabled:    rule.disabled(),
+			Expires:     rule.Expires,
+			Expired:     rule.expired(),
+		})
+	}
+	return listed
+}
+
+// listedWrapper is the JSON form of an entry of `multiprof list-wrappers`.
+type listedWrapper struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+	// Managed is false for files in the Wrapper Directory that are not
+	// Wrappers; the remaining fields are only set for Wrappers.
+	Managed bool   `json:"managed"`
+	Link    string `json:"link,omitempty"`
+	Kind    string `json:"kind,omitempty"`
+	Own     bool   `json:"points_to_multiprof,omitempty"`
+	Command string `json:"command,omitempty"`
+	// Target is the command the Wrapper runs, or "" if it is not in PATH.
+	Target string `json:"target,omitempty"`
+}
+
+// resolutionJSON is the JSON form of `multiprof which` and
+// `multiprof check <dir>`.
+type resolutionJSON struct {
+	Directory   string        `json:"directory"`
+	Status      string        `json:"status,omitempty"`
+	Matched     bool          `json:"matched"`
+	Fallback    bool          `json:"fallback,omitempty"`
+	Rule        *listedRule   `json:"rule,omitempty"`
+	Profile     string        `json:"profile,omitempty"`
+	Home        string        `json:"home,omitempty"`
+	Environment []envVariable `json:"environment,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+type envVariable struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Origin string `json:"origin"`
+}
+
+// resolutionToJSON describes res with the variables env sets: the matching
+// Rule's or, if not matched, default_home's, if res has a profile.
+func resolutionToJSON(dir string, res resolution, matched bool, env *envBuilder) resolutionJSON {
+	r := resolutionJSON{Directory: dir, Matched: matched, Fallback: !matched && res.Profile.Home != ""}
+	if !matched && !r.Fallback {
+		return r
+	}
+	if res.Index >= 0 {
+		rule := listedRules([]Rule{res.Rule})[0]
+		rule.Index = res.Index + 1
+		r.Rule = &rule
+	}
+	if res.Err != nil {
+		r.Error = res.Err.Error()
+		return r
+	}
+	r.Profile, r.Home = res.Profile.label(), res.Profile.Home
+	if env != nil {
+		for _, key := range env.Changed() {
+			r.Environment = append(r.Environment, envVariable{key, env.Get(key), env.Origin(key)})
+		}
+	}
+	return r
+}
+
+// configCheck is the JSON form of `multiprof check` without a directory.
+type configCheck struct {
+	Config   string   `json:"config"`
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+	Warnings []string `json:"warnings"`
+	Rules    int      `json:"rules"`
+	Profiles int      `json:"profiles"`
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- Latency Budget ---
+//
+// Every Wrapper run pays for loading the config and matching the Rules
+// before the real command starts. That cost grows unnoticed with more Rules,
+// includes, packs and conditions, until a tool just feels slower. Wrappers
+// therefore compare the time spent up to exec with settings.latency_budget
+// and, when it is exceeded, say so on stderr, at most once a day so a slow
+// setup does not drown the command's own output. The hint names the config
+// cache (see configcache.go) when it cannot be used and the daemon (see
+// daemon.go) when it is not running.
+
+const (
+	defaultLatencyBudget = 20 * time.Millisecond
+	latencyHintInterval  = 24 * time.Hour
+	latencyHintName      = "latency-hint"
+)
+
+// processStart is when multiprof started, the start of a Wrapper's overhead.
+var processStart = time.Now()
+
+// latencyBudget returns settings.latency_budget; 0 turns the check off.
+func latencyBudget(config Config) (time.Duration, error) {
+	if config.Settings.LatencyBudget == "" {
+		return defaultLatencyBudget, nil
+	}
+	budget, err := time.ParseDuration(config.Settings.LatencyBudget)
+	if err != nil || budget < 0 {
+		return 0, fmt.Errorf("invalid latency_budget '%s' (use a duration such as 20ms, or 0 to turn the check off)", config.Settings.LatencyBudget)
+	}
+	return budget, nil
+}
+
+// checkLatencyBudget warns if a Wrapper took longer than the budget to get
+// to running cmdName, unless it already did within latencyHintInterval.
+func checkLatencyBudget(a access, config Config, cmdName string) {
+	elapsed := time.Since(processStart)
+	budget, err := latencyBudget(config)
+	if err != nil {
+		debugf("settings: %v", err)
+		budget = defaultLatencyBudget
+	}
+	debugf("Resolved in %s (budget %s)", elapsed.Round(time.Microsecond), budget)
+	if budget == 0 || elapsed <= budget {
+		return
+	}
+	marker := stateFile(latencyHintName)
+	if info, err := os.Stat(marker); err == nil && time.Since(info.ModTime()) < latencyHintInterval {
+		return
+	}
+	var fixes []string
+	if stateDir, _ := getStateDir(); !fileExists(stateDir) {
+		fixes = append(fixes, fmt.Sprintf("create %s so Wrappers can cache the parsed config", tildePath(stateDir)))
+	}
+	if !fileExists(daemonSocketPath()) {
+		fixes = append(fixes, "run 'multiprof daemon' in the background to keep the Rules loaded")
+	}
+	fixes = append(fixes, "run 'multiprof bench-rules' to find slow Rules")
+	// Best effort: without the marker the hint is repeated, nothing worse.
+	if err := ensureStateDir(a); err == nil {
+		if err := os.WriteFile(marker, []byte(elapsed.String()+"\n"), 0600); err != nil {
+			debugf("Could not record the latency hint: %v", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[WARN] multiprof took %s to pick the profile for '%s', over its budget of %s.\n", elapsed.Round(100*time.Microsecond), cmdName, budget)
+	hint := strings.Join(fixes, ", ") + ", or raise settings.latency_budget"
+	fmt.Fprintf(os.Stderr, "[INFO] %s%s. This is shown at most once a day.\n", strings.ToUpper(hint[:1]), hint[1:])
+}
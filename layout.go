@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Directory Layout ---
+//
+// Wrappers live in $XDG_DATA_HOME/multiprof/bin and completion files where
+// bash-completion looks for them ($BASH_COMPLETION_USER_DIR/completions or
+// $XDG_DATA_HOME/bash-completion/completions, for zsh
+// $XDG_DATA_HOME/zsh/site-functions and for fish
+// $XDG_CONFIG_HOME/fish/completions). Earlier versions used
+// ~/.local/bin/multiprof and ~/.local/share/bash-completion/completions
+// regardless of the environment. Wrappers in the legacy directory keep
+// working, and are listed, checked and removed like the others, but new
+// ones are created in the current one; `multiprof migrate-layout` moves the
+// rest over.
+
+const (
+	dataHomeDirName         = ".local/share"
+	wrapperDirName          = "multiprof/bin"               // below the XDG data home
+	completionDirName       = "bash-completion/completions" // below the XDG data home
+	zshCompletionDirName    = "zsh/site-functions"          // below the XDG data home
+	fishCompletionDirName   = "fish/completions"            // below the XDG config home
+	legacyWrapperDirName    = ".local/bin/multiprof"
+	legacyCompletionDirName = ".local/share/bash-completion/completions"
+)
+
+// dataHome returns $XDG_DATA_HOME, or its default ~/.local/share.
+func dataHome() string {
+	if dir := userXDGDir("XDG_DATA_HOME"); dir != "" {
+		return dir
+	}
+	return expandPath(filepath.Join("~/", dataHomeDirName))
+}
+
+func getWrapperDir() (string, error) { return filepath.Join(dataHome(), wrapperDirName), nil }
+
+func getCompletionDir() (string, error) {
+	if dir := os.Getenv("BASH_COMPLETION_USER_DIR"); dir != "" {
+		return filepath.Join(dir, "completions"), nil
+	}
+	if isMacOS() {
+		if dir := homebrewBashCompletionDir(); dir != "" {
+			return dir, nil // See macos.go.
+		}
+	}
+	return filepath.Join(dataHome(), completionDirName), nil
+}
+
+func getZshCompletionDir() string { return filepath.Join(dataHome(), zshCompletionDirName) }
+
+// getFishCompletionDir returns where fish autoloads the user's completions:
+// $XDG_CONFIG_HOME/fish/completions, or ~/.config/fish/completions.
+func getFishCompletionDir() string {
+	return filepath.Join(configHome(), fishCompletionDirName)
+}
+
+func legacyWrapperDir() string { return expandPath(filepath.Join("~/", legacyWrapperDirName)) }
+
+func legacyCompletionDir() string { return expandPath(filepath.Join("~/", legacyCompletionDirName)) }
+
+// wrapperDirs returns the Wrapper Directory followed by the legacy one, if it
+// is a different directory.
+func wrapperDirs() []string {
+	wrapperDir, _ := getWrapperDir()
+	if legacy := legacyWrapperDir(); legacy != wrapperDir {
+		return []string{wrapperDir, legacy}
+	}
+	return []string{wrapperDir}
+}
+
+// findWrapper returns the path of the Wrapper named wrapperName in the
+// Wrapper Directory or else the legacy one, or "" if there is none.
+func findWrapper(wrapperName string) string {
+	for _, dir := range wrapperDirs() {
+		if path := filepath.Join(dir, wrapperName); wrapperExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// wrapperEntry is a Wrapper in one of the Wrapper Directories.
+type wrapperEntry struct {
+	name, dir string
+}
+
+func (e wrapperEntry) Name() string { return e.name }
+
+// wrapperEntries returns the Wrappers in the Wrapper Directory and the
+// legacy one.
+func wrapperEntries() []wrapperEntry {
+	var all []wrapperEntry
+	for _, dir := range wrapperDirs() {
+		for _, name := range wrapperNamesIn(dir) {
+			all = append(all, wrapperEntry{name, dir})
+		}
+	}
+	return all
+}
+
+// legacyLayoutHint describes Wrappers left in the legacy Wrapper Directory,
+// or returns "" if there are none.
+func legacyLayoutHint() string {
+	wrapperDir, _ := getWrapperDir()
+	legacy := legacyWrapperDir()
+	if legacy == wrapperDir {
+		return ""
+	}
+	if n := len(ownWrapperNames(legacy, "")); n > 0 {
+		return fmt.Sprintf("%d Wrapper(s) are still in the old Wrapper Directory %s. Run 'multiprof migrate-layout' to move them to %s.", n, legacy, wrapperDir)
+	}
+	return ""
+}
+
+func runMigrateLayout(args []string) {
+	migrateCmd := flag.NewFlagSet("migrate-layout", flag.ExitOnError)
+	onConflict := migrateCmd.String("on-conflict", conflictAsk, "What to do with existing files multiprof did not create: ask, keep, overwrite or backup.")
+	if positional := parseFlags(migrateCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof migrate-layout [--on-conflict ask|keep|overwrite|backup]")
+	}
+	if !validConflictPolicy(*onConflict) {
+		exitUsage("Invalid --on-conflict value '%s'.", *onConflict)
+	}
+	config, _ := loadConfig(readWrite)
+	wrapperDir, _ := getWrapperDir()
+	completionDir, _ := getCompletionDir()
+	legacy := legacyWrapperDir()
+	if legacy == wrapperDir {
+		logInfo("The Wrapper Directory is %s already; nothing to migrate.", wrapperDir)
+		return
+	}
+	entries, err := os.ReadDir(legacy)
+	if err != nil && !os.IsNotExist(err) {
+		exitFailure(errFailed, "", "Could not read the old Wrapper Directory '%s': %v", legacy, err)
+	}
+
+	tx := newTransaction(readWrite)
+	if err := tx.MkdirAll(wrapperDir, 0755); err != nil {
+		tx.abort("Could not create Wrapper Directory: %v", err)
+	}
+	moved, left := 0, 0
+	for _, entry := range entries {
+		name := entry.Name()
+		oldPath := filepath.Join(legacy, name)
+		if !isOwnWrapperFile(oldPath) {
+			logWarn("Leaving '%s' in place; it is not a multiprof Wrapper.", oldPath)
+			left++
+			continue
+		}
+		newPath := filepath.Join(wrapperDir, name)
+		if err := clearPath(tx, newPath, *onConflict, isOwnWrapperFile); err == errKeptExisting {
+			logWarn("Skipped '%s'.", name)
+			left++
+			continue
+		} else if err != nil {
+			tx.abort("%v", err)
+		}
+		target, _ := os.Readlink(oldPath)
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(legacy, target)
+		}
+		if err := tx.Symlink(target, newPath); err != nil {
+			tx.abort("Could not create Wrapper '%s': %v", newPath, err)
+		}
+		if err := tx.Remove(oldPath); err != nil {
+			tx.abort("Could not remove '%s': %v", oldPath, err)
+		}
+		moved++
+
+		oldCompletion := filepath.Join(legacyCompletionDir(), name)
+		if config.Settings.Suffix == "" || oldCompletion == filepath.Join(completionDir, name) || !isOwnCompletion(oldCompletion) {
+			continue
+		}
+		if err := tx.Remove(oldCompletion); err != nil {
+			tx.abort("Could not remove completion file '%s': %v", oldCompletion, err)
+		}
+		cmdName := strings.TrimSuffix(name, config.Settings.Suffix)
+		if err := createCompletionFile(tx, shellBash, name, cmdName, *onConflict); err == errKeptExisting {
+			logWarn("Completion file for '%s' was not replaced.", name)
+		} else if err != nil {
+			tx.abort("Could not create completion file for '%s': %v", name, err)
+		}
+	}
+	if fileExists(legacy) && left == 0 {
+		if err := tx.Remove(legacy); err != nil {
+			tx.abort("Could not remove '%s': %v", legacy, err)
+		}
+	}
+	tx.Commit()
+
+	if moved > 0 {
+		logSuccess("Moved %d Wrapper(s) from %s to %s.", moved, legacy, wrapperDir)
+	} else {
+		logInfo("No Wrappers in %s to move.", legacy)
+	}
+	if left > 0 {
+		logWarn("%d file(s) were left in %s.", left, legacy)
+	}
+	path := filepath.SplitList(os.Getenv("PATH"))
+	if pathIndex(path, legacy) >= 0 {
+		logInfo("Remove %s from PATH in your shell profile, and add the new Wrapper Directory instead.", legacy)
+	}
+	if pathIndex(path, wrapperDir) < 0 {
+		shell, _ := completionShell("")
+		printPathSetup(wrapperDir, shell)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// --- Link Modes ---
+//
+// A Wrapper is normally a symlink to multiprof, which finds out what to run
+// from the name it was started as. Where symlinks are unavailable (some NFS
+// homes, Windows without developer mode), add-wrapper falls back to a
+// hardlink, which works the same but must be on the same filesystem as the
+// binary, and then to a small shell script that runs
+// `multiprof __wrapper <name>`. --link-mode picks one instead of trying them
+// in turn; the choice is kept as `link` under [wrappers.<command>], so
+// sync-wrappers recreates the Wrapper the same way. On Windows a Wrapper is
+// several files, one per extension (see shims_windows.go).
+
+const (
+	linkAuto     = "auto"
+	linkSymlink  = "symlink"
+	linkHardlink = "hardlink"
+	linkScript   = "script"
+
+	// wrapperScriptCommand is the hidden command Wrapper scripts run.
+	wrapperScriptCommand = "__wrapper"
+)
+
+func validLinkMode(mode string) bool {
+	switch mode {
+	case linkAuto, linkSymlink, linkHardlink, linkScript:
+		return true
+	}
+	return false
+}
+
+// linkWrapper creates the Wrapper at path for the multiprof at
+// multiprofPath with mode, trying each in turn for linkAuto.
+func linkWrapper(tx *transaction, mode, multiprofPath, path string) error {
+	modes := []string{mode}
+	if mode == linkAuto || mode == "" {
+		modes = autoLinkModes
+	}
+	var err error
+	for _, m := range modes {
+		switch m {
+		case linkSymlink:
+			err = tx.Symlink(multiprofPath, linkFile(path))
+		case linkHardlink:
+			err = tx.Link(multiprofPath, linkFile(path))
+		case linkScript:
+			scripts := wrapperScripts(multiprofPath, path)
+			for _, file := range slices.Sorted(maps.Keys(scripts)) {
+				if err = tx.WriteFile(file, []byte(scripts[file]), 0755); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		debugf("Could not create %s as a %s: %v", path, m, err)
+	}
+	return err
+}
+
+// wrapperKind returns how the multiprof Wrapper at path was made, or "" if
+// it is not a Wrapper for the running multiprof. Symlinks to any multiprof
+// count; see isOwnWrapper.
+func wrapperKind(path string) string {
+	for _, file := range wrapperFiles(path) {
+		if kind := fileWrapperKind(file); kind != "" {
+			return kind
+		}
+	}
+	return ""
+}
+
+// fileWrapperKind is wrapperKind for a single file of a Wrapper.
+func fileWrapperKind(path string) string {
+	info, err := os.Lstat(path)
+	switch {
+	case err != nil:
+		return ""
+	case info.Mode()&os.ModeSymlink != 0:
+		if isOwnWrapperFile(path) {
+			return linkSymlink
+		}
+	case !info.Mode().IsRegular():
+	case isWrapperScript(path):
+		return linkScript
+	case sameAsSelf(path):
+		return linkHardlink
+	}
+	return ""
+}
+
+// sameAsSelf reports whether path is the running multiprof binary.
+func sameAsSelf(path string) bool {
+	self, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	a, errA := os.Stat(self)
+	b, errB := os.Stat(path)
+	return errA == nil && errB == nil && os.SameFile(a, b)
+}
+
+// isHardlinkedWrapper reports whether exe, the running binary, is a Wrapper
+// hardlinked into a Wrapper Directory under a name the config lists, rather
+// than multiprof itself, whatever the name and place it was installed under.
+func isHardlinkedWrapper(exe string) bool {
+	return isWrapperDir(filepath.Dir(exe)) && listsWrapper(filepath.Base(exe))
+}
+
+// isWrapperScript reports whether path is a Wrapper script multiprof wrote.
+func isWrapperScript(path string) bool {
+	return hasGeneratedMarker(path) && wrapperScriptTarget(path) != ""
+}
+
+// wrapperScriptTarget returns the multiprof a Wrapper script runs: the
+// quoted path in front of __wrapper, in sh, cmd.exe or PowerShell quoting.
+func wrapperScriptTarget(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		target, _, ok := strings.Cut(strings.TrimSpace(line), " "+wrapperScriptCommand+" ")
+		if !ok {
+			continue
+		}
+		target = strings.TrimPrefix(strings.TrimPrefix(target, "exec "), "& ")
+		switch {
+		case strings.HasPrefix(target, `"`):
+			return strings.Trim(target, `"`)
+		case strings.Contains(target, `'\''`):
+			return strings.ReplaceAll(strings.Trim(target, "'"), `'\''`, "'")
+		default:
+			return strings.ReplaceAll(strings.Trim(target, "'"), "''", "'")
+		}
+	}
+	return ""
+}
+
+// runWrapperScript implements `multiprof __wrapper <name> [args...]`, run
+// by Wrapper scripts: it continues as the Wrapper named name.
+func runWrapperScript(args []string) {
+	if len(args) == 0 {
+		exitUsage("Usage: multiprof %s <wrapper_name> [args...]", wrapperScriptCommand)
+	}
+	os.Args = args
+	runWrapper()
+}
+
+func isRegularFile(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// wrapperTarget returns the multiprof the Wrapper at path runs and how it was
+// made, or ok false if path is neither a symlink nor a hardlink or script
+// Wrapper.
+func wrapperTarget(path string) (target, kind string, ok bool) {
+	for _, file := range wrapperFiles(path) {
+		if target, kind, ok := fileWrapperTarget(file); ok {
+			return target, kind, true
+		}
+	}
+	return "", "", false
+}
+
+// fileWrapperTarget is wrapperTarget for a single file of a Wrapper.
+func fileWrapperTarget(path string) (target, kind string, ok bool) {
+	if target, err := os.Readlink(path); err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		return target, linkSymlink, true
+	}
+	switch kind := fileWrapperKind(path); kind {
+	case linkScript:
+		return wrapperScriptTarget(path), kind, true
+	case linkHardlink:
+		self, _ := os.Executable()
+		return self, kind, true
+	}
+	return "", "", false
+}
+
+// wrapperExists reports whether any file of the Wrapper at path exists.
+func wrapperExists(path string) bool {
+	return slices.ContainsFunc(wrapperFiles(path), fileExists)
+}
+
+// removeWrapper removes the files of the Wrapper at path, returning those it
+// removed.
+func removeWrapper(tx *transaction, path string) ([]string, error) {
+	var removed []string
+	for _, file := range wrapperFiles(path) {
+		if !fileExists(file) {
+			continue
+		}
+		if err := tx.Remove(file); err != nil {
+			return removed, err
+		}
+		removed = append(removed, file)
+	}
+	return removed, nil
+}
+
+// renameWrapper moves the files of the Wrapper at oldPath to newPath.
+func renameWrapper(tx *transaction, oldPath, newPath string) error {
+	newFiles := wrapperFiles(newPath)
+	for i, file := range wrapperFiles(oldPath) {
+		if !fileExists(file) {
+			continue
+		}
+		if err := tx.Rename(file, newFiles[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wrapperNamesIn returns the names of the Wrappers the files in dir make up,
+// whether or not they are multiprof's.
+func wrapperNamesIn(dir string) []string {
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		if name, ok := wrapperFileName(entry.Name()); ok && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
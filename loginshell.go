@@ -0,0 +1,23 @@
+package main
+
+// --- Shell Wrappers ---
+//
+// `multiprof add-wrapper bash --login-safe` makes a Wrapper that drops you
+// into a profile-switched shell like `multiprof shell`, but is run by name
+// (bash_w) and can even be your login shell. It takes care of what shells
+// need beyond other commands: started as a login shell, with a dash in front
+// of argv[0], it starts the real shell as one too; like every Wrapper, it
+// can never exec itself (see shim.go); and it sets SHELL to the real shell,
+// so programs starting $SHELL stay in the same profile.
+
+// loginSafe is set when the running Wrapper has login_safe.
+var loginSafe bool
+
+// shellArgv0 returns argv[0] for the real shell: its name, with a leading
+// dash if the Wrapper was started as a login shell.
+func shellArgv0(cmdName string, login bool) string {
+	if login {
+		return "-" + cmdName
+	}
+	return cmdName
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// --- macOS ---
+//
+// Macs differ from Linux in ways that make the XDG defaults awkward:
+//
+//   - Settings usually live in ~/Library/Application Support. multiprof reads
+//     its config from there when ~/.config/multiprof has none. Wrappers stay
+//     in ~/.local/share/multiprof/bin, which unlike Application Support has
+//     no space in its path to trip up PATH lines.
+//   - Homebrew's bash-completion, the one for the bash 3.2 macOS ships, only
+//     loads $(brew --prefix)/etc/bash_completion.d, so completion files go
+//     there when it is installed and bash-completion@2 is not.
+//   - Apps started from the Dock or Finder get their environment from
+//     launchd rather than a shell profile; `multiprof init` explains how to
+//     give them the Wrapper Directory too.
+//   - System Integrity Protection removes DYLD_* variables when it starts a
+//     protected binary such as /usr/bin/git, or a script run by /bin/sh, so
+//     such variables set by a Rule are silently lost; Wrappers warn about it.
+
+const macConfigDirName = "Library/Application Support/multiprof"
+
+func isMacOS() bool { return runtime.GOOS == "darwin" }
+
+// macConfigPath returns the config file in ~/Library/Application Support.
+func macConfigPath() string {
+	return expandPath(filepath.Join("~/", macConfigDirName, configFileName))
+}
+
+// homebrewBashCompletionDir returns Homebrew's bash_completion.d if only the
+// old bash-completion, which ignores the user completion directory, is
+// installed, or "" otherwise.
+func homebrewBashCompletionDir() string {
+	if fileExists("/usr/share/bash-completion/bash_completion") {
+		return ""
+	}
+	dir := ""
+	for _, bin := range homebrewBinDirs() {
+		prefix := filepath.Dir(bin)
+		if fileExists(filepath.Join(prefix, "etc/profile.d/bash_completion.sh")) {
+			return "" // bash-completion@2 loads the user directory.
+		}
+		if dir == "" && fileExists(filepath.Join(prefix, "etc/bash_completion")) {
+			dir = filepath.Join(prefix, "etc/bash_completion.d")
+		}
+	}
+	return dir
+}
+
+// sipProtectedDirs hold the binaries System Integrity Protection starts
+// without DYLD_* variables.
+var sipProtectedDirs = []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin", "/usr/libexec", "/System"}
+
+// sipProtected reports whether path, or the interpreter of the script at
+// path, is a binary protected by System Integrity Protection.
+func sipProtected(path string) bool {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	for _, dir := range sipProtectedDirs {
+		if within(dir, path) {
+			return true
+		}
+	}
+	if interpreter := scriptInterpreter(path); interpreter != "" && interpreter != path {
+		return sipProtected(interpreter)
+	}
+	return false
+}
+
+// scriptInterpreter returns the program in the #! line of the script at path,
+// or "" if it is not a script.
+func scriptInterpreter(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	rest, ok := strings.CutPrefix(line, "#!")
+	if !ok {
+		return ""
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// sipWarnings describes DYLD_* variables set for the target at path that
+// macOS will remove before it starts.
+func sipWarnings(path string, env *envBuilder) []string {
+	if !isMacOS() {
+		return nil
+	}
+	var dropped []string
+	for _, key := range env.Changed() {
+		if strings.HasPrefix(key, "DYLD_") {
+			dropped = append(dropped, env.describe(key))
+		}
+	}
+	if len(dropped) == 0 || !sipProtected(path) {
+		return nil
+	}
+	return []string{fmt.Sprintf("System Integrity Protection removes %s before running %s, which is a protected system binary or a script run by one. Install the command elsewhere, e.g. with Homebrew, for the variables to take effect.", strings.Join(dropped, ", "), path)}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+)
+
+// --- Home Manifests ---
+//
+// A profile can point `manifest` at a TOML file describing files to render into
+// its home. `multiprof profile sync` renders them, so simple profiles can be
+// reproduced without maintaining a full dotfiles repository.
+
+type Manifest struct {
+	Vars  map[string]string `toml:"vars"`
+	Files []ManifestFile    `toml:"files"`
+}
+type ManifestFile struct {
+	Path    string `toml:"path"`
+	Mode    string `toml:"mode"`
+	Content string `toml:"content"`
+	Source  string `toml:"source"`
+}
+
+// manifestData is what manifest templates can reference, e.g.
+// {{.Identity.email}} or {{.Vars.org}}.
+type manifestData struct {
+	Profile  string
+	Home     string
+	Identity map[string]string
+	Vars     map[string]string
+}
+
+func runProfile(args []string) {
+	if len(args) < 1 {
+		exitUsage("Usage: multiprof profile sync [profile]")
+	}
+	switch args[0] {
+	case "sync":
+		runProfileSync(args[1:])
+	default:
+		exitFailure(errUsage, "Run 'multiprof help' for a list of commands.", "Unknown profile command '%s'.", args[0])
+	}
+}
+
+func runProfileSync(args []string) {
+	config, _ := loadConfig(readWrite)
+	profiles := config.allProfiles()
+	if len(args) > 0 {
+		p, ok := config.findProfile(args[0])
+		if !ok || p.Manifest == "" {
+			exitFailure(errNotFound, "", "No profile named or with home '%s' has a manifest.", args[0])
+		}
+		profiles = []namedProfile{p}
+	}
+
+	var failed []string
+	for _, p := range profiles {
+		if p.Manifest == "" {
+			continue
+		}
+		if err := applyManifest(p); err != nil {
+			logWarn("Could not sync '%s': %v", p.label(), err)
+			failed = append(failed, p.label())
+		}
+	}
+	if len(failed) > 0 {
+		exitFailure(errFailed, "", "Could not sync %s.", strings.Join(failed, ", "))
+	}
+}
+
+func applyManifest(p namedProfile) error {
+	home := p.Home
+	manifestPath := expandPath(p.Manifest)
+	var manifest Manifest
+	if _, err := toml.DecodeFile(manifestPath, &manifest); err != nil {
+		return fmt.Errorf("could not read manifest: %w", err)
+	}
+	data := manifestData{Profile: p.label(), Home: home, Identity: p.Identity, Vars: manifest.Vars}
+	tx := newTransaction(readWrite)
+	defer tx.Rollback()
+	var rendered []string
+
+	for _, file := range manifest.Files {
+		target, err := manifestTarget(home, file.Path)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if file.Mode != "" {
+			m, err := strconv.ParseUint(file.Mode, 8, 32)
+			if err != nil {
+				return fmt.Errorf("invalid mode '%s' for '%s'", file.Mode, file.Path)
+			}
+			mode = os.FileMode(m)
+		}
+
+		text := file.Content
+		if file.Source != "" {
+			source := expandPath(file.Source)
+			if !filepath.IsAbs(source) {
+				source = filepath.Join(filepath.Dir(manifestPath), source)
+			}
+			b, err := os.ReadFile(source)
+			if err != nil {
+				return err
+			}
+			text = string(b)
+		}
+		tmpl, err := template.New(file.Path).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return fmt.Errorf("could not parse template for '%s': %w", file.Path, err)
+		}
+		var content bytes.Buffer
+		if err := tmpl.Execute(&content, data); err != nil {
+			return fmt.Errorf("could not render '%s': %w", file.Path, err)
+		}
+
+		if existing, err := os.ReadFile(target); err == nil && bytes.Equal(existing, content.Bytes()) {
+			if err := os.Chmod(target, mode); err != nil {
+				return err
+			}
+			debugf("Unchanged: %s", target)
+			continue
+		}
+		if err := tx.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := tx.WriteFile(target, content.Bytes(), mode); err != nil {
+			return err
+		}
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+		rendered = append(rendered, target)
+	}
+	tx.Commit()
+	for _, target := range rendered {
+		logSuccess("Rendered %s", target)
+	}
+	return nil
+}
+
+// manifestTarget resolves a manifest path inside home, refusing paths that
+// would escape it.
+func manifestTarget(home, path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return "", fmt.Errorf("manifest path '%s' must be relative to the home", path)
+	}
+	target := filepath.Join(home, path)
+	if target != home && !strings.HasPrefix(target, home+string(os.PathSeparator)) {
+		return "", fmt.Errorf("manifest path '%s' escapes the home", path)
+	}
+	return target, nil
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+func isMountPoint(path string) (bool, error) {
+	return false, errors.New("encrypted homes are not supported on Windows")
+}
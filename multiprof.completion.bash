@@ -0,0 +1,10 @@
+# Generated by multiprof for the 'multiprof' command.
+
+# Ask multiprof for the candidates, which knows its commands, profiles, Rules
+# and Wrappers; with none to offer, -o default completes file names.
+_multiprof() {
+    local IFS=$'\n'
+    COMPREPLY=($("${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+
+complete -o default -F _multiprof multiprof
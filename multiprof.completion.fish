@@ -0,0 +1,15 @@
+# Generated by multiprof for the 'multiprof' command.
+
+# Ask multiprof for the candidates, which knows its commands, profiles, Rules
+# and Wrappers; with none to offer, complete file names.
+function __multiprof_complete
+    set -l words (commandline -opc) (commandline -ct)
+    set -l candidates ($words[1] __complete $words[2..-1] 2>/dev/null)
+    if set -q candidates[1]
+        printf '%s\n' $candidates
+    else
+        __fish_complete_path (commandline -ct)
+    end
+end
+
+complete --command multiprof --no-files --arguments '(__multiprof_complete)'
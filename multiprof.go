@@ -1,21 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	// the initial _ needs to be there,
 	// otherwise we get "embed imported and not used"
 	_ "embed"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
-	"syscall"
-	"text/template"
+	"time"
 
 	"github.com/BurntSushi/toml"
-	"github.com/gobwas/glob"
 )
 
 // --- Embedded Content ---
@@ -29,16 +32,51 @@ var defaultConfigToml string
 //go:embed completion.template.bash
 var completionTemplate string
 
+//go:embed completion.template.zsh
+var zshCompletionTemplate string
+
+//go:embed completion.template.fish
+var fishCompletionTemplate string
+
+//go:embed multiprof.completion.bash
+var selfCompletionBash string
+
+//go:embed multiprof.completion.zsh
+var selfCompletionZsh string
+
+//go:embed multiprof.completion.fish
+var selfCompletionFish string
+
 //go:embed init.txt
 var initHelpText string
 
+//go:embed init.fish.txt
+var initFishHelpText string
+
+//go:embed cleanup.template.service
+var cleanupServiceTemplate string
+
+//go:embed cleanup.template.timer
+var cleanupTimerTemplate string
+
+//go:embed precommit.template.sh
+var precommitTemplate string
+
+//go:embed cron.template.service
+var cronServiceTemplate string
+
+//go:embed cron.template.timer
+var cronTimerTemplate string
+
 // --- Constants ---
 const (
-	configDirName     = ".config/multiprof"
-	configFileName    = "config.toml"
-	wrapperDirName    = ".local/bin/multiprof"
-	completionDirName = ".local/share/bash-completion/completions"
-	debugEnvVar       = "MULTIPROF_DEBUG"
+	configDirName   = ".config/multiprof"
+	configDirBase   = "multiprof" // below the XDG config home
+	configFileName  = "config.toml"
+	stateDirName    = ".local/state/multiprof"
+	profilesDirName = ".local/share/multiprof/profiles"
+	skeletonDirName = "skel"
+	debugEnvVar     = "MULTIPROF_DEBUG"
 )
 
 // --- Global State ---
@@ -50,15 +88,134 @@ func init() {
 
 // --- Configuration Structs ---
 type Config struct {
-	Settings Settings `toml:"settings"`
-	Rules    []Rule   `toml:"rules"`
+	// Include lists globs of further config files to merge; see include.go.
+	Include  []string                     `toml:"include,omitempty"`
+	Settings Settings                     `toml:"settings"`
+	Profiles map[string]Profile           `toml:"profiles,omitempty"`
+	Presets  map[string]map[string]string `toml:"presets,omitempty"`
+	Wrappers map[string]WrapperSettings   `toml:"wrappers,omitempty"`
+	Rules    []Rule                       `toml:"rules"`
+
+	// included maps "<section>.<name>" of entries merged from included files
+	// to the file.
+	included map[string]string
+	// incomplete is set when an include could not be used as written; see
+	// configcache.go.
+	incomplete bool
+}
+
+// WrapperSettings are per-command options, keyed by the wrapped command name.
+// Every Wrapper multiprof creates has an entry, empty if it has no options;
+// see wrappermanifest.go.
+type WrapperSettings struct {
+	// MatchOn is "cwd" (the default) or "arg" to match Rules against the
+	// first file argument instead of the current directory.
+	MatchOn string `toml:"match_on,omitempty"`
+	// LoginSafe marks a Wrapper for a shell; see loginshell.go.
+	LoginSafe bool `toml:"login_safe,omitempty"`
+	// Name is the Wrapper's file name, if not the command name followed by
+	// settings.suffix.
+	Name string `toml:"name,omitempty"`
+	// Link is how the Wrapper is made, if not "auto"; see linkmode.go.
+	Link string `toml:"link,omitempty"`
 }
 type Settings struct {
-	Suffix string `toml:"suffix"`
+	Suffix      string `toml:"suffix"`
+	IdleTimeout string `toml:"idle_timeout,omitempty"`
+	ProfilesDir string `toml:"profiles_dir,omitempty"`
+	// MatchStrategy picks among matching Rules: "first" (the default) or
+	// "longest", the Rule with the longest literal pattern prefix.
+	MatchStrategy string `toml:"match_strategy,omitempty"`
+	// LatencyBudget is how long Wrappers may take before running the
+	// command until they warn about it; see latency.go.
+	LatencyBudget string `toml:"latency_budget,omitempty"`
+	// AuditLog records every Wrapper run in the audit journal; see audit.go.
+	AuditLog bool `toml:"audit_log,omitempty"`
+	// DefaultHome is the profile or home Wrappers use where no Rule
+	// matches; see defaulthome.go.
+	DefaultHome string `toml:"default_home,omitempty"`
+	// OnNoMatch is what Wrappers do where no Rule matches: "error" (the
+	// default), "passthrough" or "prompt"; see nomatch.go.
+	OnNoMatch string `toml:"on_no_match,omitempty"`
+	// Strict stops Wrappers on errors they would otherwise work around;
+	// see strict.go.
+	Strict bool `toml:"strict,omitempty"`
+}
+
+// HomeSpec describes a sandboxed home and how it is prepared and torn down.
+// It is shared by named Profiles and by Rules that set their home inline.
+type HomeSpec struct {
+	Home        string            `toml:"home,omitempty"`
+	Identity    map[string]string `toml:"identity,omitempty"`
+	Manifest    string            `toml:"manifest,omitempty"`
+	Cleanup     []string          `toml:"cleanup,omitempty"`
+	IdleTimeout string            `toml:"idle_timeout,omitempty"`
+	Encrypted   bool              `toml:"encrypted,omitempty"`
+	Encryption  string            `toml:"encryption,omitempty"`
+	CipherDir   string            `toml:"cipher_dir,omitempty"`
+	Keyring     bool              `toml:"keyring,omitempty"`
+	Shell       string            `toml:"shell,omitempty"`
+	// Autocreate creates a missing home on first use, copying Skeleton
+	// into it if set; see autocreate.go.
+	Autocreate bool   `toml:"autocreate,omitempty"`
+	Skeleton   string `toml:"skeleton,omitempty"`
+	// SSHAgent starts a separate ssh-agent for the home on first use; see
+	// agent.go.
+	SSHAgent bool `toml:"ssh_agent,omitempty"`
+}
+type Profile struct {
+	HomeSpec
+	EnvSpec
+	// Aliases maps short command names to the command lines they stand
+	// for in this profile; see alias.go.
+	Aliases map[string]string `toml:"aliases,omitempty"`
+}
+
+// EnvSpec describes the extra environment variables of a profile or Rule.
+type EnvSpec struct {
+	Env     map[string]string `toml:"env,omitempty"`
+	Presets []string          `toml:"presets,omitempty"`
+	Network *Network          `toml:"network,omitempty"`
+	// XDG points the XDG base directories into the home; see xdg.go.
+	XDG bool `toml:"xdg,omitempty"`
 }
 type Rule struct {
-	Pattern string `toml:"pattern"`
-	Home    string `toml:"home"`
+	Pattern     string   `toml:"pattern"`
+	PatternType string   `toml:"pattern_type,omitempty"`
+	Exclude     []string `toml:"exclude,omitempty"`
+	Profile     string   `toml:"profile,omitempty"`
+	HomeSpec
+	EnvSpec
+	TagOutput string `toml:"tag_output,omitempty"`
+
+	InteractiveOnly    bool              `toml:"interactive_only,omitempty"`
+	NonInteractiveOnly bool              `toml:"non_interactive_only,omitempty"`
+	GitRemote          string            `toml:"git_remote,omitempty"`
+	Commands           []string          `toml:"commands,omitempty"`
+	Host               string            `toml:"host,omitempty"`
+	WhenEnv            map[string]string `toml:"when_env,omitempty"`
+
+	// CredentialsCmd prints short-lived credentials as KEY=VALUE lines,
+	// cached for CredentialsTTL; see credentials.go.
+	CredentialsCmd string `toml:"credentials_cmd,omitempty"`
+	CredentialsTTL string `toml:"credentials_ttl,omitempty"`
+
+	// PreExec and PostExec are shell commands run before and after the
+	// wrapped command; see exechooks.go.
+	PreExec  []string `toml:"pre_exec,omitempty"`
+	PostExec []string `toml:"post_exec,omitempty"`
+	// Supervise runs the wrapped command as a child process; see spawn.go.
+	Supervise bool `toml:"supervise,omitempty"`
+	// Enabled = false turns the Rule off without removing it; unset means
+	// enabled.
+	Enabled *bool `toml:"enabled,omitempty"`
+	// Expires is the last day the Rule matches, as YYYY-MM-DD; see
+	// expiry.go.
+	Expires string `toml:"expires,omitempty"`
+
+	// source is the project config, pack or included file the Rule came
+	// from, if any.
+	source string
 }
 
 // --- Main Logic ---
@@ -67,18 +224,32 @@ func main() {
 	log.SetFlags(0)
 	ownExecutable, err := os.Executable()
 	if err != nil {
-		logError("Critical error: Cannot determine own path: %v", err)
-		os.Exit(1)
+		exitFailure(errFailed, "", "Critical error: Cannot determine own path: %v", err)
 	}
 	calledAs := filepath.Base(os.Args[0])
 	ownName := filepath.Base(ownExecutable)
 
-	if calledAs == ownName || calledAs == "main" { // for go run
-		if len(os.Args) < 2 {
+	if strings.TrimSuffix(ownName, filepath.Ext(ownName)) == selftestProbeName {
+		runSelftestProbe()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == wrapperScriptCommand {
+		runWrapperScript(os.Args[2:])
+		return
+	}
+	// A hardlinked Wrapper is the binary itself, under the Wrapper's name.
+	if (calledAs == ownName || calledAs == "main") && !isHardlinkedWrapper(ownExecutable) { // for go run
+		command, args := "", os.Args[1:]
+		if len(args) > 0 && args[0] == jsonFlag {
+			jsonOutput, args = true, args[1:]
+		}
+		if len(args) == 0 {
 			printUsage()
 			return
 		}
-		runManager(os.Args[1], os.Args[2:])
+		command, args = args[0], args[1:]
+		runManager(command, setJSONOutput(command, args))
+		printResult(nil)
 	} else {
 		runWrapper()
 	}
@@ -87,253 +258,1226 @@ func main() {
 func runManager(command string, args []string) {
 	switch command {
 	case "init":
-		runInit()
+		runInit(args)
 	case "add-rule":
 		runAddRule(args)
 	case "add-wrapper":
 		runAddWrapper(args)
+	case "add-aliases":
+		runAddAliases(args)
+	case "sync-wrappers":
+		runSyncWrappers(args)
+	case "remove-wrapper":
+		runRemoveWrapper(args)
 	case "list":
-		runList()
+		runList(args)
+	case "migrate-suffix":
+		runMigrateSuffix(args)
+	case "migrate-layout":
+		runMigrateLayout(args)
+	case "selftest":
+		runSelftest(args)
+	case "list-wrappers":
+		runListWrappers()
+	case "edit-rule":
+		runEditRule(args)
+	case "test-rule":
+		runTestRule(args)
+	case "move-rule":
+		runMoveRule(args)
+	case "enable-rule":
+		runEnableRule(args, true)
+	case "disable-rule":
+		runEnableRule(args, false)
+	case "promote":
+		runShiftRule(args, -1)
+	case "demote":
+		runShiftRule(args, 1)
+	case "cleanup":
+		runCleanup(args)
+	case "profile":
+		runProfile(args)
+	case "pack":
+		runPack(args)
+	case "bench-rules":
+		runBenchRules(args)
+	case "quickstart":
+		runQuickstart(args)
+	case "new":
+		runNew(args)
+	case "create-profile":
+		runCreateProfile(args)
+	case "clone-profile":
+		runCloneProfile(args)
+	case "delete-profile":
+		runDeleteProfile(args)
+	case "rename-profile":
+		runRenameProfile(args)
+	case "shell":
+		runShell(args, false)
+	case "login":
+		runShell(args, true)
+	case "doctor":
+		runDoctor(args)
+	case "status":
+		runStatus(args)
+	case "report":
+		runReport(args)
+	case "stats":
+		runStats(args)
+	case "log":
+		runLog(args)
+	case "agent":
+		runAgent(args)
+	case "compact":
+		runCompact(args)
+	case "restore":
+		runRestore(args)
+	case "export":
+		runExport(args)
+	case "import":
+		runImport(args)
+	case "import-gitconfig-includes":
+		runImportGitconfigIncludes(args)
+	case "info":
+		runInfo()
+	case "uninstall":
+		runUninstall(args)
+	case "run":
+		runRun(args)
+	case "trust":
+		runTrust(args, true)
+	case "untrust":
+		runTrust(args, false)
+	case "which":
+		runWhich(args)
+	case "trace":
+		runTrace(args)
+	case "daemon":
+		runDaemon(args)
+	case "check":
+		runCheck(args)
+	case "env":
+		runEnv(args)
+	case "gen-cron":
+		runGenCron(args)
+	case "gen-precommit":
+		runGenPrecommit(args)
+	case "which-home":
+		runWhichHome(args)
+	case "prompt":
+		runPrompt(args)
+	case "prompt-config":
+		runPromptConfig(args)
+	case "hook":
+		runHook(args)
+	case "completion":
+		runCompletion(args)
+	case "__complete":
+		runComplete(args)
 	case "help", "-h", "--help":
 		printUsage()
 	default:
-		logError("Unknown command '%s'. Run 'multiprof help' for a list of commands.", command)
-		os.Exit(1)
+		exitFailure(errUsage, "Run 'multiprof help' for a list of commands.", "Unknown command '%s'.", command)
 	}
 }
 
 // --- Wrapper Execution ---
 
 func runWrapper() {
-	config, _ := loadConfig()
-	cwd, _ := os.Getwd()
-	expandedCwd := expandPath(cwd)
-	expandedCwdWithSlash := expandedCwd + string(os.PathSeparator)
-	debugf("Checking match for '%s' and '%s'", expandedCwd, expandedCwdWithSlash)
-
-	var newHome string
-	profileMatched := false
-	for _, rule := range config.Rules {
-		expandedPattern := expandPath(rule.Pattern)
-		g, _ := glob.Compile(expandedPattern)
-		if g.Match(expandedCwd) || g.Match(expandedCwdWithSlash) {
-			debugf("Matched Rule with pattern: '%s'", rule.Pattern)
-			newHome = expandPath(rule.Home)
-			profileMatched = true
-			break
+	// A login shell is started with a dash in front of its name.
+	wrapperName, login := strings.CutPrefix(filepath.Base(os.Args[0]), "-")
+	if name, ok := wrapperFileName(wrapperName); ok {
+		wrapperName = name // Without .exe on Windows.
+	}
+	pickInteractively = true
+	cwd, cwdErr := os.Getwd()
+	var answer daemonAnswer
+	if cwdErr == nil {
+		answer = askDaemon(wrapperName, cwd, os.Args[1:])
+	}
+	// The config the daemon sent, if it did and the file is unchanged.
+	config, err := loadConfig(readOnly)
+	checkStrict(config, err, "Could not load the config")
+	targetCmdName := config.wrappedCommand(wrapperName)
+	if _, custom := config.customNamed(wrapperName); !custom && config.Settings.Suffix != "" && !strings.HasSuffix(wrapperName, config.Settings.Suffix) {
+		if hint := suffixMismatchHint(config); hint != "" {
+			fmt.Fprintf(os.Stderr, "[WARN] %s\n", hint)
 		}
 	}
-
-	if !profileMatched {
-		logError("No multiprof Rule matched the current directory: %s", cwd)
-		logInfo("To add a Rule, run: multiprof add-rule --pattern \"%s/**\" --home \"/path/to/home\"", cwd)
-		os.Exit(1)
+	invokedCommand = targetCmdName
+	checkStrict(config, cwdErr, "Could not determine the current directory")
+	dir := wrapperMatchDir(config, targetCmdName, cwd, os.Args[1:])
+	res, err := resolveForExec(config, dir, answer)
+	if errors.Is(err, errPassthrough) {
+		passThrough(targetCmdName, os.Args)
+	} else if err != nil {
+		exitWith(err)
+	}
+	args := os.Args
+	if alias, ok := res.Profile.alias(targetCmdName); ok {
+		debugf("'%s' is an alias for '%s' in %s", targetCmdName, strings.Join(alias, " "), profileOrigin(res.Profile))
+		targetCmdName = alias[0]
+		args = append(alias, os.Args[1:]...)
+	}
+	if config.Wrappers[targetCmdName].LoginSafe {
+		loginSafe = true
+		args = append([]string{shellArgv0(targetCmdName, login)}, args[1:]...)
 	}
-	os.Setenv("HOME", newHome)
-	debugf("Set HOME to: '%s'", newHome)
+	checkLatencyBudget(readOnly, config, targetCmdName)
+	execTarget(readOnly, res, targetCmdName, args)
+}
 
-	wrapperName := filepath.Base(os.Args[0])
-	targetCmdName := strings.TrimSuffix(wrapperName, config.Settings.Suffix)
-	originalPath := os.Getenv("PATH")
-	wrapperDir, _ := getWrapperDir()
-	safePath := strings.ReplaceAll(originalPath, wrapperDir+":", "")
-	os.Setenv("PATH", safePath)
-	debugf("Temporarily searching for '%s' in safe PATH", targetCmdName)
+const (
+	matchOnCwd = "cwd"
+	matchOnArg = "arg"
+)
 
-	targetCmdPath, err := exec.LookPath(targetCmdName)
-	os.Setenv("PATH", originalPath)
+// wrapperMatchDir returns the directory the Wrapper for cmdName, run in cwd
+// with args, matches Rules against.
+func wrapperMatchDir(config Config, cmdName, cwd string, args []string) string {
+	if config.Wrappers[cmdName].MatchOn != matchOnArg {
+		return cwd
+	}
+	dir := argMatchDir(args, cwd)
+	debugf("Matching Rules against the argument directory: %s", dir)
+	return dir
+}
 
+// argMatchDir returns the directory of the first non-flag argument, resolved
+// against cwd: the argument itself if it is a directory, otherwise its parent.
+// Without such an argument it returns cwd.
+func argMatchDir(args []string, cwd string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			if i+1 == len(args) {
+				break
+			}
+			arg = args[i+1]
+		} else if strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+") {
+			continue
+		}
+		path := expandPath(arg)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cwd, path)
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path
+		}
+		return filepath.Dir(path)
+	}
+	return cwd
+}
+
+// execTarget runs cmdName with args (args[0] included) in the resolved
+// profile, for a command with access a. It only returns on success if the
+// command ran as a child process.
+func execTarget(a access, res resolution, cmdName string, args []string) {
+	start := time.Now()
+	// fail records why the run failed before exiting.
+	fail := func(f *failure) {
+		if !dryRunRequested() {
+			recordUse(a, start, cmdName, args, res, &outcome{Exit: 1, Error: f.kind.Error()})
+		}
+		exitWith(f)
+	}
+	env := res.environment(os.Environ())
+	targetCmdPath, err := findTarget(cmdName)
 	if err != nil {
-		logError("Could not find target command '%s' in the system PATH: %v", targetCmdName, err)
-		os.Exit(1)
+		hint := fmt.Sprintf("Install '%s', or remove its Wrapper with: multiprof remove-wrapper %s", cmdName, cmdName)
+		config, _ := loadConfig(a)
+		if suffixMismatchHint(config) != "" {
+			hint = suffixMismatchHint(config)
+		} else if aliasHint(config, cmdName, res) != "" {
+			hint = aliasHint(config, cmdName, res)
+		}
+		fail(&failure{errTargetNotFound, fmt.Sprintf("Could not find target command '%s' in the system PATH: %v", cmdName, err), hint, nil})
+	}
+	if loginSafe {
+		env.Set("SHELL", targetCmdPath, "login-safe Wrapper")
+	}
+	if err := env.Validate(args); err != nil {
+		fail(&failure{errRefused, fmt.Sprintf("Refusing to run '%s': %v", cmdName, err), "Run 'multiprof which' to see where each variable comes from.", nil})
+	}
+	for _, warning := range sipWarnings(targetCmdPath, env) {
+		fmt.Fprintf(os.Stderr, "[WARN] %s\n", warning)
 	}
+	if dryRunRequested() {
+		printDryRun(res, env, targetCmdPath, args)
+		os.Exit(0)
+	}
+	if err := activateProfile(a, res.Profile); err != nil {
+		fail(&failure{errActivation, err.Error(), "", nil})
+	}
+	if res.Rule.CredentialsCmd != "" {
+		if err := applyCredentials(a, env, res); err != nil {
+			fail(&failure{errActivation, fmt.Sprintf("Could not get credentials for '%s': %v", cmdName, err), "Run the Rule's credentials_cmd by hand to see what it needs.", nil})
+		}
+		if err := env.Validate(args); err != nil {
+			fail(&failure{errRefused, fmt.Sprintf("Refusing to run '%s': %v", cmdName, err), "Check what the Rule's credentials_cmd prints.", nil})
+		}
+	}
+	tagMode, err := outputTagMode(res.Rule)
+	if err != nil {
+		fail(&failure{errInvalidRule, err.Error(), "Run 'multiprof check' to validate your config.", nil})
+	}
+	supervisedRun, err := supervised(res.Rule)
+	if err != nil {
+		fail(&failure{errInvalidRule, err.Error(), "", nil})
+	}
+	if err := runExecHooks("pre_exec", res.Rule.PreExec, env.Environ()); err != nil {
+		fail(&failure{errActivation, err.Error(), "Run the Rule's pre_exec commands by hand to see what they need.", nil})
+	}
+	if tagMode == tagHeader {
+		fmt.Fprintf(os.Stderr, "--- [%s] %s ---\n", res.Profile.label(), strings.Join(args, " "))
+	}
+	if supervisedRun || tagMode == tagPrefix || len(res.Rule.PostExec) > 0 {
+		tag := ""
+		if tagMode == tagPrefix {
+			tag = res.Profile.label()
+		}
+		debugf("Spawning: %s", targetCmdPath)
+		result := spawnTarget(targetCmdPath, args, env.Environ(), tag)
+		recordUse(a, start, cmdName, args, res, &result)
+		runPostExec(res.Rule, env, result)
+		os.Exit(result.Exit)
+	}
+	// The outcome of an exec'd target is not known.
+	recordUse(a, start, cmdName, args, res, nil)
 	debugf("Executing: %s", targetCmdPath)
-	syscall.Exec(targetCmdPath, os.Args, os.Environ())
+	if err := replaceProcess(targetCmdPath, args, env.Environ()); err != nil {
+		exitFailure(errFailed, "", "Could not execute '%s': %v", targetCmdPath, err)
+	}
+}
+
+// resolution is the outcome of matching a directory against the Rules.
+type resolution struct {
+	Index   int
+	Rule    Rule
+	Profile namedProfile
+	Presets map[string]map[string]string
+	Err     error
+}
+
+// resolveDir finds the Rule matching dir and the profile it selects. ok is
+// false if no Rule matches; Err is set if the matching Rule is invalid.
+// Rules from a trusted project config covering dir are checked first, then
+// the user's own Rules, then those of installed packs.
+func resolveDir(config Config, dir string) (res resolution, ok bool) {
+	packs := loadPacks()
+	profiles := config.withPackProfiles(packs)
+	if project, found := loadProjectConfig(dir); found {
+		if res, ok := resolveAmong(project, profiles.withProfilesFrom(project), dir); ok {
+			return res, true
+		}
+	}
+	if res, ok := resolveAmong(config, profiles, dir); ok {
+		return res, true
+	}
+	for _, pack := range packs {
+		if res, ok := resolveAmong(pack.config(), profiles.withProfilesFrom(pack.config()), dir); ok {
+			return res, true
+		}
+	}
+	return resolution{Index: -1}, false
+}
+
+// resolveAmong matches dir against the Rules of rules, resolving the
+// matching Rule's profile and presets from profiles.
+func resolveAmong(rules, profiles Config, dir string) (res resolution, ok bool) {
+	// Project and pack files have no settings; the user's strategy applies.
+	rules.Settings.MatchStrategy = profiles.Settings.MatchStrategy
+	index, ok := matchRule(rules, dir)
+	if !ok {
+		return resolution{Index: -1}, false
+	}
+	res = resolution{Index: index, Rule: rules.Rules[index], Presets: profiles.Presets}
+	res.Profile, res.Err = profiles.resolveProfile(res.Rule)
+	if res.Err == nil {
+		if res.Err = checkPresets(res.Profile.EnvSpec, res.Presets); res.Err == nil {
+			res.Err = checkPresets(res.Rule.EnvSpec, res.Presets)
+		}
+	}
+	return res, true
+}
+
+// environment returns base with the profile's HOME, shell and environment
+// variables applied, followed by the Rule's own variables. Each of the two
+// applies its presets, then its network settings, then its env.
+func (res resolution) environment(base []string) *envBuilder {
+	home := res.Profile.Home
+	env := newEnvBuilder(base)
+	homeOrigin := profileOrigin(res.Profile)
+	if res.Index >= 0 {
+		homeOrigin = ruleOrigin(res.Index, res.Rule)
+	}
+	if own, err := userHomeDir(); err == nil {
+		env.Set(userHomeEnvVar, own, "multiprof")
+	}
+	setHomeVars(env, home, homeOrigin+" home")
+	debugf("Set HOME to: '%s'", home)
+	for i, value := range res.activeValues() {
+		env.Set(activeEnvVars[i], value, "multiprof")
+	}
+	if res.Profile.Shell != "" {
+		env.Set("SHELL", expandForHome(res.Profile.Shell, home), profileOrigin(res.Profile)+" shell")
+	}
+	if res.Profile.SSHAgent || agentRunning(home) {
+		env.Set("SSH_AUTH_SOCK", agentSocket(home), profileOrigin(res.Profile)+" ssh-agent")
+	}
+	applyEnvSpec(env, res.Profile.EnvSpec, res.Presets, home, profileOrigin(res.Profile))
+	applyEnvSpec(env, res.Rule.EnvSpec, res.Presets, home, ruleOrigin(res.Index, res.Rule))
+	return env
+}
+
+// activateProfile prepares a profile's home for use: it creates missing
+// homes, unlocks encrypted ones, starts the profile's ssh-agent and records
+// the use for idle cleanup, for a command with access a.
+func activateProfile(a access, p namedProfile) error {
+	if err := ensureHome(a, p); err != nil {
+		return err
+	}
+	if p.Encrypted {
+		if err := mountHome(p.HomeSpec, p.Home); err != nil {
+			return fmt.Errorf("could not unlock encrypted home '%s': %w", p.Home, err)
+		}
+	}
+	if p.SSHAgent && !agentRunning(p.Home) {
+		if err := startAgent(p); err != nil {
+			return fmt.Errorf("could not start the ssh-agent of '%s': %w", p.label(), err)
+		}
+		fmt.Fprintf(os.Stderr, "[INFO] Started the ssh-agent of '%s'.\n", p.label())
+	}
+	if len(p.Cleanup) > 0 || p.Encrypted || p.SSHAgent {
+		if err := markProfileUsed(a, p.Home); err != nil {
+			debugf("Could not record profile use: %v", err)
+		}
+	}
+	return nil
+}
+
+// matchRule returns the index of the first Rule whose pattern matches dir.
+func matchRule(config Config, dir string) (int, bool) {
+	expandedDir := expandPath(dir)
+	expandedDirWithSlash := expandedDir + string(os.PathSeparator)
+	debugf("Checking match for '%s' and '%s'", expandedDir, expandedDirWithSlash)
+	longest, prompt := false, false
+	switch config.Settings.MatchStrategy {
+	case "", matchFirst:
+	case matchLongest:
+		longest = true
+	case matchPrompt:
+		prompt = true
+	default:
+		fmt.Fprintf(os.Stderr, "[WARN] Unknown match_strategy '%s' (use first, longest or prompt); using first.\n", config.Settings.MatchStrategy)
+	}
+	best, bestLen := -1, -1
+	var candidates []int
+	for i, rule := range config.Rules {
+		if reason := ruleMismatch(rule, expandedDir); reason != "" {
+			debugf("Skipping Rule with pattern '%s': %s", rule.Pattern, reason)
+			continue
+		}
+		if prompt {
+			candidates = append(candidates, i)
+			continue
+		}
+		if !longest {
+			debugf("Matched Rule with pattern: '%s'", rule.Pattern)
+			return i, true
+		}
+		// Ties go to the Rule that comes first.
+		if n := len(literalPrefix(rule)); n > bestLen {
+			debugf("Rule with pattern '%s' matches with a literal prefix of %d characters", rule.Pattern, n)
+			best, bestLen = i, n
+		}
+	}
+	if len(candidates) > 0 {
+		best = pickRule(config.Rules, candidates, expandedDir)
+	}
+	if best >= 0 {
+		debugf("Matched Rule with pattern: '%s'", config.Rules[best].Pattern)
+		return best, true
+	}
+	return -1, false
+}
+
+// ruleMismatch returns why rule does not apply in the expanded directory
+// dir, or "" if it does.
+func ruleMismatch(rule Rule, dir string) string {
+	if rule.disabled() {
+		return "disabled"
+	}
+	if rule.expired() {
+		return "expired on " + rule.Expires
+	}
+	dirWithSlash := dir + string(os.PathSeparator)
+	if outsidePrefix(rule, dir, dirWithSlash) {
+		return "pattern does not match"
+	}
+	m, err := compilePattern(rule)
+	if err != nil {
+		return err.Error()
+	}
+	if !m.Match(dir) && !m.Match(dirWithSlash) {
+		return "pattern does not match"
+	}
+	if exclude, ok := excludedBy(rule, dir, dirWithSlash); ok {
+		return fmt.Sprintf("excluded by '%s'", exclude)
+	}
+	if ok, reason := conditionsMet(rule, dir); !ok {
+		return reason
+	}
+	return ""
 }
 
 // --- Management Commands ---
 
-func runInit() {
+func runInit(args []string) {
+	initCmd := flag.NewFlagSet("init", flag.ExitOnError)
+	shellFlag := initCmd.String("shell", "", "Shell to print setup instructions for: bash, zsh or fish (default: from $SHELL).")
+	if positional := parseFlags(initCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof init [--shell bash|zsh|fish]")
+	}
+	shell, err := completionShell(*shellFlag)
+	if err != nil {
+		exitWith(err)
+	}
 	logInfo("Running setup wizard...")
-	createDefaultConfig()
-	logSuccess("Ensured config file exists at ~/.config/multiprof/config.toml")
+	tx := newTransaction(readWrite)
+	if err := createDefaultConfig(tx); err != nil {
+		tx.abort("Could not create config file: %v", err)
+	}
+	configPath, _ := getConfigPath()
+	logSuccess("Ensured config file exists at %s", tildePath(configPath))
 	wrapperDir, _ := getWrapperDir()
-	os.MkdirAll(wrapperDir, 0755)
-	logSuccess("Ensured Wrapper Directory exists at ~/" + strings.TrimPrefix(wrapperDir, os.Getenv("HOME")+"/"))
+	if err := tx.MkdirAll(wrapperDir, 0755); err != nil {
+		tx.abort("Could not create Wrapper Directory: %v", err)
+	}
+	logSuccess("Ensured Wrapper Directory exists at %s", tildePath(wrapperDir))
+	// Wrappers only write their stats and caches into an existing one.
+	stateDir, _ := getStateDir()
+	if err := tx.MkdirAll(stateDir, 0700); err != nil {
+		tx.abort("Could not create the state directory: %v", err)
+	}
+	if err := installSelfCompletion(tx, shell, conflictKeep); err == nil {
+		logSuccess("Installed the %s completion for multiprof at %s", shell, tildePath(completionPath(shell, "multiprof")))
+	} else if err != errKeptExisting {
+		tx.abort("Could not install the completion for multiprof: %v", err)
+	}
 
-	tmpl, err := template.New("init").Parse(initHelpText)
-	if err != nil {
-		logError("Could not parse init template: %v", err)
-		return
+	tx.Commit()
+	printPathSetup(wrapperDir, shell)
+}
+
+// printPathSetup tells the user how to get the Wrapper Directory onto their
+// PATH in shell, or does it for them where multiprof can.
+func printPathSetup(wrapperDir, shell string) {
+	if !ensureUserPath(wrapperDir) {
+		name, builtin := "init.txt", initHelpText
+		if shell == shellFish {
+			name, builtin = "init.fish.txt", initFishHelpText
+		}
+		tmpl, err := loadTemplate(name, builtin)
+		if err != nil {
+			exitWith(err)
+		}
+		data := struct {
+			WrapperDir, ZshCompletionDir, FishCompletionDir string
+			MacOS                                           bool
+		}{wrapperDir, getZshCompletionDir(), getFishCompletionDir(), isMacOS()}
+		if err := tmpl.Execute(os.Stdout, data); err != nil {
+			logError("Could not render init instructions: %v", err)
+		}
+	}
+	config, _ := loadConfig(readWrite)
+	for _, warning := range homebrewWarnings(config, "") {
+		logWarn("%s", warning)
 	}
-	data := struct{ WrapperDir string }{WrapperDir: wrapperDir}
-	tmpl.Execute(os.Stdout, data)
 }
 
 func runAddRule(args []string) {
+	if len(args) == 0 && isInteractive() {
+		runAddRuleWizard()
+		return
+	}
 	addCmd := flag.NewFlagSet("add-rule", flag.ExitOnError)
 	patternFlag := addCmd.String("pattern", "", "Glob pattern to match a directory context.")
 	homeFlag := addCmd.String("home", "", "The directory to use as $HOME when the pattern matches.")
+	profileFlag := addCmd.String("profile", "", "A profile from [profiles] to use when the pattern matches, instead of --home.")
+	regexFlag := addCmd.Bool("regex", false, "Treat --pattern as a regular expression instead of a glob.")
+	var excludeFlag stringListFlag
+	addCmd.Var(&excludeFlag, "exclude", "A glob of directories the Rule must not match. May be repeated.")
+	envFlag := keyValueFlag{}
+	addCmd.Var(envFlag, "env", "An extra environment variable to set, as KEY=VALUE. May be repeated.")
 	addCmd.Parse(args)
-	if *patternFlag == "" || *homeFlag == "" {
-		logError("--pattern and --home flags are required.")
+	if *patternFlag == "" || (*homeFlag == "") == (*profileFlag == "") {
 		addCmd.Usage()
-		os.Exit(1)
+		exitFailure(errUsage, "", "--pattern and exactly one of --home or --profile are required.")
+	}
+	config, _ := loadConfig(readWrite)
+	if _, ok := config.Profiles[*profileFlag]; *profileFlag != "" && !ok {
+		exitFailure(errNotFound, fmt.Sprintf("Define it under [profiles.%s] in your config first.", *profileFlag), "Unknown profile '%s'.", *profileFlag)
+	}
+	newRule := Rule{Pattern: *patternFlag, Profile: *profileFlag, HomeSpec: HomeSpec{Home: *homeFlag}}
+	if *regexFlag {
+		newRule.PatternType = patternRegex
+	}
+	newRule.Exclude = excludeFlag
+	if _, err := compilePattern(newRule); err != nil {
+		exitWith(err)
 	}
-	config, _ := loadConfig()
 	newPattern := expandPath(*patternFlag)
 	for _, rule := range config.Rules {
-		existingPattern := expandPath(rule.Pattern)
-		g, _ := glob.Compile(existingPattern)
-		if g.Match(newPattern) {
+		m, err := compilePattern(rule)
+		if err == nil && !*regexFlag && m.Match(newPattern) {
 			logWarn("New pattern '%s' may be shadowed by existing Rule '%s'.", *patternFlag, rule.Pattern)
 			logInfo("Rule priority is determined by their order in the config file.")
 			break
 		}
 	}
-	config.Rules = append(config.Rules, Rule{Pattern: *patternFlag, Home: *homeFlag})
-	saveConfig(config)
-	logSuccess("Added Rule: when in '%s', use '%s' as HOME.", *patternFlag, *homeFlag)
+	if len(envFlag) > 0 {
+		newRule.Env = envFlag
+	}
+	config.Rules = append(config.Rules, newRule)
+	tx := newTransaction(readWrite)
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+	logSuccess("Added Rule: when in '%s', use %s.", *patternFlag, describeTarget(newRule))
+	target, _ := config.resolveProfile(newRule)
+	for _, warning := range homePlacementWarnings(config, target) {
+		logWarn("%s", warning)
+	}
+	for _, p := range config.allProfiles() {
+		if _, ok := homeInsideRule([]Rule{newRule}, p.Home); ok && p.Home != target.Home {
+			logWarn("Profile '%s': home '%s' is inside a directory the new Rule matches, so tools run there see the profile's files as project files.", p.label(), tildePath(p.Home))
+		}
+	}
 }
 
 func runAddWrapper(args []string) {
-	if len(args) != 1 {
-		logError("Usage: multiprof add-wrapper <command_name>")
+	addCmd := flag.NewFlagSet("add-wrapper", flag.ExitOnError)
+	onConflict := addCmd.String("on-conflict", conflictAsk, "What to do with existing files multiprof did not create: ask, keep, overwrite or backup.")
+	matchOn := addCmd.String("match-on", "", "Match Rules against the current directory (cwd) or the first file argument (arg).")
+	shellFlag := addCmd.String("shell", "", "Shell to write the completion file for: bash, zsh or fish (default: from $SHELL).")
+	loginSafeFlag := addCmd.Bool("login-safe", false, "The command is a shell: keep a login shell's leading dash, never exec a Wrapper and set SHELL.")
+	as := addCmd.String("as", "", "Name the Wrapper this instead of the command name followed by the suffix.")
+	linkMode := addCmd.String("link-mode", "", "How to make the Wrapper: auto (a symlink, else a hardlink, else a script), symlink, hardlink or script (default: auto, or as before).")
+	positional := parseFlags(addCmd, args)
+	if len(positional) == 0 || (*as != "" && len(positional) > 1) {
+		exitUsage("Usage: multiprof add-wrapper [--on-conflict ask|keep|overwrite|backup] [--match-on cwd|arg] [--shell bash|zsh|fish] [--login-safe] [--link-mode auto|symlink|hardlink|script] <command_name>...\n" +
+			"       multiprof add-wrapper [flags] --as <wrapper_name> <command_name>")
+	}
+	shell, err := completionShell(*shellFlag)
+	if err != nil {
+		exitWith(err)
+	}
+	if !validConflictPolicy(*onConflict) {
+		exitUsage("Invalid --on-conflict value '%s'.", *onConflict)
+	}
+	if *linkMode != "" && !validLinkMode(*linkMode) {
+		exitUsage("Invalid --link-mode value '%s' (use auto, symlink, hardlink or script).", *linkMode)
+	}
+	if *matchOn != "" && *matchOn != matchOnCwd && *matchOn != matchOnArg {
+		exitUsage("Invalid --match-on value '%s' (use cwd or arg).", *matchOn)
+	}
+	config, _ := loadConfig(readWrite)
+	if *as != "" {
+		if err := checkWrapperName(config, *as, positional[0]); err != nil {
+			exitWith(err)
+		}
+	}
+	warnWrapperDirNotInPath()
+
+	tx := newTransaction(readWrite)
+	var created, wrapped []string
+	changed := false
+	for _, cmdName := range positional {
+		previous := config.wrapperName(cmdName)
+		before, recorded := config.Wrappers[cmdName]
+		if *as != "" {
+			recordWrapper(&config, cmdName)
+			settings := config.Wrappers[cmdName]
+			settings.Name = *as
+			if *as == cmdName+config.Settings.Suffix {
+				settings.Name = ""
+			}
+			config.Wrappers[cmdName] = settings
+		}
+		if *linkMode != "" {
+			recordWrapper(&config, cmdName)
+			settings := config.Wrappers[cmdName]
+			if settings.Link = *linkMode; *linkMode == linkAuto {
+				settings.Link = ""
+			}
+			config.Wrappers[cmdName] = settings
+		}
+		msgs, err := createWrapper(tx, config, cmdName, shell, *onConflict)
+		if err == errKeptExisting {
+			if recorded {
+				config.Wrappers[cmdName] = before
+			} else {
+				delete(config.Wrappers, cmdName)
+			}
+			continue
+		} else if err != nil {
+			tx.abort("%v", err)
+		}
+		if err := removeRenamedWrapper(tx, config, cmdName, previous); err != nil {
+			tx.abort("%v", err)
+		}
+		created = append(created, msgs...)
+		wrapped = append(wrapped, cmdName)
+		settings := config.Wrappers[cmdName]
+		if *matchOn != "" && *matchOn != settings.MatchOn {
+			settings.MatchOn = *matchOn
+			created = append(created, fmt.Sprintf("'%s' matches Rules on: %s", cmdName, *matchOn))
+		}
+		if *loginSafeFlag && !settings.LoginSafe {
+			settings.LoginSafe = true
+			created = append(created, fmt.Sprintf("'%s' is wrapped as a shell: it can be a login shell and sets SHELL", cmdName))
+		}
+		if settings.MatchOn == matchOnCwd {
+			settings.MatchOn = ""
+		}
+		if !recorded || settings != before {
+			recordWrapper(&config, cmdName)
+			config.Wrappers[cmdName] = settings
+			changed = true
+		}
+	}
+	if changed {
+		if err := tx.SaveConfig(config); err != nil {
+			tx.abort("Could not save config: %v", err)
+		}
+	}
+	tx.Commit()
+	for _, msg := range created {
+		logSuccess("%s", msg)
+	}
+	for _, cmdName := range wrapped {
+		for _, warning := range homebrewWarnings(config, cmdName) {
+			logWarn("%s", warning)
+		}
+	}
+	if hint := staleWrappersHint(config); hint != "" {
+		logWarn("%s", hint)
+	}
+	if len(wrapped) < len(positional) {
 		os.Exit(1)
 	}
+}
+
+func runRemoveWrapper(args []string) {
+	if len(args) != 1 {
+		exitUsage("Usage: multiprof remove-wrapper <command_name>")
+	}
+	config, _ := loadConfig(readWrite)
 	cmdName := args[0]
-	config, _ := loadConfig()
+	if named, ok := config.customNamed(cmdName); ok {
+		cmdName = named
+	}
+	wrapperName := config.wrapperName(cmdName)
+	wrapperDir, _ := getWrapperDir()
+	symlinkPath := findWrapper(wrapperName)
+	if symlinkPath == "" {
+		symlinkPath = filepath.Join(wrapperDir, wrapperName)
+	}
 
-	wrapperName := cmdName + config.Settings.Suffix
+	tx := newTransaction(readWrite)
+	var removed []string
+	if !wrapperExists(symlinkPath) {
+		logWarn("No Wrapper for '%s' exists at %s; it was never created or was already removed.", cmdName, symlinkPath)
+	} else if !isOwnWrapper(symlinkPath) {
+		exitFailure(errRefusedChange, "", "'%s' is not a multiprof Wrapper; leaving it alone.", symlinkPath)
+	} else {
+		files, err := removeWrapper(tx, symlinkPath)
+		if err != nil {
+			tx.abort("Could not remove Wrapper: %v", err)
+		}
+		removed = append(removed, files...)
+	}
+	for _, completionPath := range completionPaths(wrapperName) {
+		if fileExists(completionPath) && isOwnCompletion(completionPath) && indexOf(removed, completionPath) < 0 {
+			if err := tx.Remove(completionPath); err != nil {
+				tx.abort("Could not remove completion file: %v", err)
+			}
+			removed = append(removed, completionPath)
+		}
+	}
+	if _, ok := config.Wrappers[cmdName]; ok {
+		delete(config.Wrappers, cmdName)
+		if err := tx.SaveConfig(config); err != nil {
+			tx.abort("Could not save config: %v", err)
+		}
+		removed = append(removed, fmt.Sprintf("[wrappers.%s] from the config", cmdName))
+	}
+	tx.Commit()
+	for _, path := range removed {
+		logSuccess("Removed %s", path)
+	}
+}
+
+func warnWrapperDirNotInPath() {
 	wrapperDir, _ := getWrapperDir()
-	if !strings.Contains(os.Getenv("PATH"), wrapperDir) {
+	if pathIndex(filepath.SplitList(os.Getenv("PATH")), wrapperDir) < 0 {
 		logWarn("Wrapper Directory '%s' not found in your $PATH.", wrapperDir)
 		logInfo("Please run `multiprof init` and follow the setup instructions.")
 	}
+	if hint := legacyLayoutHint(); hint != "" {
+		logWarn("%s", hint)
+	}
+}
 
+// createWrapper creates the Wrapper symlink for cmdName and, for suffixed
+// Wrappers, its completion file for shell. It returns messages describing
+// what was created, to be shown once the transaction commits.
+func createWrapper(tx *transaction, config Config, cmdName, shell, onConflict string) ([]string, error) {
+	wrapperName := config.wrapperName(cmdName)
+	wrapperDir, _ := getWrapperDir()
 	multiprofPath, _ := os.Executable()
 	symlinkPath := filepath.Join(wrapperDir, wrapperName)
-	if err := os.Symlink(multiprofPath, symlinkPath); err != nil {
-		if !os.IsExist(err) {
-			logError("Failed to create Wrapper: %v", err)
-			os.Exit(1)
+	if err := tx.MkdirAll(wrapperDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create Wrapper Directory: %w", err)
+	}
+	mode := config.Wrappers[cmdName].Link
+	isOwn := func(path string) bool {
+		// A hardlink to a multiprof since replaced is only known as one here.
+		return isOwnWrapperFile(path) || (mode == linkHardlink && isRegularFile(path))
+	}
+	for _, file := range wrapperFiles(symlinkPath) {
+		if err := clearPath(tx, file, onConflict, isOwn); err != nil {
+			if err == errKeptExisting {
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to create Wrapper: %w", err)
 		}
 	}
-	logSuccess("Created Wrapper for '%s' at %s", cmdName, symlinkPath)
+	if err := linkWrapper(tx, mode, multiprofPath, symlinkPath); err != nil {
+		return nil, fmt.Errorf("failed to create Wrapper: %w", err)
+	}
+	if err := recordSuffix(tx, config.Settings.Suffix); err != nil {
+		return nil, fmt.Errorf("could not record the Wrapper suffix: %w", err)
+	}
+	created := []string{fmt.Sprintf("Created Wrapper for '%s' at %s", cmdName, symlinkPath)}
+	if kind := wrapperKind(symlinkPath); kind != linkSymlink {
+		created[0] += fmt.Sprintf(" (as a %s)", kind)
+	}
 
-	if config.Settings.Suffix != "" {
-		if err := createCompletionFile(wrapperName, cmdName); err != nil {
-			logWarn("Could not create completion file: %v", err)
+	if wrapperName != cmdName {
+		completeAs := cmdName
+		if command := aliasCommand(config, cmdName); command != "" {
+			completeAs = command
+		}
+		if err := createCompletionFile(tx, shell, wrapperName, completeAs, onConflict); err == errKeptExisting {
+			logWarn("Completion file for '%s' was not replaced.", wrapperName)
+		} else if err != nil {
+			return nil, fmt.Errorf("could not create completion file: %w", err)
 		} else {
-			logSuccess("Created completion file for '%s'.", wrapperName)
+			created = append(created, fmt.Sprintf("Created completion file for '%s'.", wrapperName))
 		}
 	}
+	return created, nil
+}
 
+func printUsage() {
+	fmt.Print(helpText)
 }
 
-func createCompletionFile(wrapperName, originalCmd string) error {
-	completionDir, err := getCompletionDir()
-	if err != nil {
-		return err
+func runList(args []string) {
+	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
+	noPager := listCmd.Bool("no-pager", false, "Print everything at once instead of one screen at a time.")
+	if positional := parseFlags(listCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof list [--no-pager]")
 	}
-
-	if err := os.MkdirAll(completionDir, 0755); err != nil {
-		return fmt.Errorf("could not create completion directory: %w", err)
+	config, _ := loadConfig(readOnly)
+	if jsonOutput {
+		l := listing{Suffix: config.Settings.Suffix, MatchStrategy: orDefault(config.Settings.MatchStrategy, matchFirst), Profiles: []listedProfile{}, Rules: listedRules(config.Rules), Packs: []listedPack{}}
+		for _, p := range config.allProfiles() {
+			if p.Name != "" {
+				l.Profiles = append(l.Profiles, listedProfile{p.Name, p.Profile.Home})
+			}
+		}
+		for _, pack := range loadPacks() {
+			l.Packs = append(l.Packs, listedPack{pack.Name, listedRules(pack.Rules)})
+		}
+		printJSON(l)
+		return
 	}
-
-	completionFilePath := filepath.Join(completionDir, wrapperName)
-	f, err := os.Create(completionFilePath)
-	if err != nil {
-		return err
+	out := newPager(!*noPager)
+	defer out.Close()
+	width := terminalWidth()
+	fmt.Fprintf(out, "Wrapper Suffix: \"%s\"\n", config.Settings.Suffix)
+	if len(config.Profiles) > 0 {
+		fmt.Fprintln(out, "--- Profiles ---")
+		t := newTable(1, "NAME", "HOME")
+		for _, p := range config.allProfiles() {
+			if p.Name != "" {
+				t.add(p.Name, p.Profile.Home)
+			}
+		}
+		t.render(out, width)
 	}
-	defer f.Close()
-
-	tmpl, err := template.New("completion").Parse(completionTemplate)
-	if err != nil {
-		return err
+	if config.Settings.MatchStrategy == matchLongest {
+		fmt.Fprintln(out, "--- Rules (the most specific matching Rule wins) ---")
+	} else {
+		fmt.Fprintln(out, "--- Rules (checked in order of priority) ---")
 	}
-
-	data := struct {
-		WrapperName string
-		OriginalCmd string
-		HookName    string
-	}{
-		WrapperName: wrapperName,
-		OriginalCmd: originalCmd,
-		HookName:    "multiprof_hook_" + strings.ReplaceAll(wrapperName, "-", "_"),
+	if len(config.Rules) == 0 {
+		fmt.Fprintln(out, "No Rules defined. Use 'multiprof add-rule' to create one.")
+	} else {
+		printRules(out, config.Rules, width)
+	}
+	for _, pack := range loadPacks() {
+		fmt.Fprintf(out, "--- Rules from pack '%s' (checked after your own) ---\n", pack.Name)
+		printRules(out, pack.Rules, width)
 	}
-
-	return tmpl.Execute(f, data)
 }
 
-func printUsage() {
-	fmt.Print(helpText)
+// printRules renders rules as a table, numbered by priority.
+func printRules(w io.Writer, rules []Rule, width int) {
+	t := newTable(3, "#", "WHEN IN", "USE", "DETAILS")
+	for i, rule := range rules {
+		var details []string
+		if rule.disabled() {
+			details = append(details, "DISABLED")
+		}
+		if rule.expired() {
+			details = append(details, "EXPIRED on "+rule.Expires)
+		} else if rule.Expires != "" {
+			details = append(details, "until "+rule.Expires)
+		}
+		for _, exclude := range rule.Exclude {
+			details = append(details, "except in "+exclude)
+		}
+		for _, key := range sortedKeys(rule.Env) {
+			details = append(details, fmt.Sprintf("with %s=%s", key, rule.Env[key]))
+		}
+		if rule.source != "" {
+			details = append(details, "from "+tildePath(rule.source))
+		}
+		t.add(strconv.Itoa(i+1), rule.Pattern, describeTarget(rule), strings.Join(details, "; "))
+	}
+	t.render(w, width)
 }
 
-func runList() {
-	config, _ := loadConfig()
-	fmt.Printf("Wrapper Suffix: \"%s\"\n", config.Settings.Suffix)
-	fmt.Println("--- Rules (checked in order of priority) ---")
-	if len(config.Rules) == 0 {
-		fmt.Println("No Rules defined. Use 'multiprof add-rule' to create one.")
-		return
+func runListWrappers() {
+	config, _ := loadConfig(readOnly)
+	found := 0
+	listed := []listedWrapper{}
+	for i, wrapperDir := range wrapperDirs() {
+		if _, err := os.ReadDir(wrapperDir); err != nil && !os.IsNotExist(err) {
+			exitFailure(errFailed, "", "Could not read Wrapper Directory '%s': %v", wrapperDir, err)
+		}
+		names := wrapperNamesIn(wrapperDir)
+		switch {
+		case jsonOutput:
+		case i > 0 && len(names) == 0:
+			continue
+		case i > 0:
+			fmt.Printf("--- Wrappers in the old Wrapper Directory %s ---\n", wrapperDir)
+		default:
+			fmt.Printf("--- Wrappers in %s ---\n", wrapperDir)
+		}
+		for _, name := range names {
+			path := filepath.Join(wrapperDir, name)
+			w := listedWrapper{Name: name, Dir: wrapperDir}
+			link, kind, ok := wrapperTarget(path)
+			if ok {
+				found++
+				w.Managed, w.Link, w.Kind, w.Own = true, link, kind, isOwnWrapper(path)
+				w.Command = config.wrappedCommand(name)
+				w.Target, _ = findTarget(w.Command)
+			}
+			if jsonOutput {
+				listed = append(listed, w)
+				continue
+			}
+			if !ok {
+				fmt.Printf("%s: not a symlink, not managed by multiprof\n", name)
+				continue
+			}
+			state := ""
+			if kind != linkSymlink {
+				state = fmt.Sprintf(" (%s)", kind)
+			} else if !w.Own {
+				state = " (does not point to multiprof)"
+			}
+			if w.Target != "" {
+				fmt.Printf("%s -> %s%s, wraps '%s' (%s)\n", name, link, state, w.Command, w.Target)
+			} else {
+				fmt.Printf("%s -> %s%s, wraps '%s' (NOT FOUND in PATH)\n", name, link, state, w.Command)
+			}
+		}
 	}
-	for i, rule := range config.Rules {
-		fmt.Printf("%d: When in '%s', use '%s' as HOME.\n", i+1, rule.Pattern, rule.Home)
+	if jsonOutput {
+		printJSON(listed)
+	} else if found == 0 {
+		fmt.Println("No Wrappers found. Use 'multiprof add-wrapper' to create one.")
+	}
+	if hint := suffixMismatchHint(config); hint != "" && found > 0 {
+		logWarn("%s", hint)
 	}
+	if hint := legacyLayoutHint(); hint != "" {
+		logWarn("%s", hint)
+	}
+	if hint := staleWrappersHint(config); hint != "" {
+		logWarn("%s", hint)
+	}
+}
+
+// describeTarget describes what a Rule switches to, for messages.
+func describeTarget(rule Rule) string {
+	if rule.Profile != "" {
+		return fmt.Sprintf("profile '%s'", rule.Profile)
+	}
+	return fmt.Sprintf("'%s' as HOME", rule.Home)
 }
 
 // --- Helpers ---
-func logInfo(format string, v ...interface{})    { fmt.Printf("[INFO] "+format+"\n", v...) }
-func logSuccess(format string, v ...interface{}) { fmt.Printf("[OK] "+format+"\n", v...) }
-func logWarn(format string, v ...interface{})    { fmt.Printf("[WARN] "+format+"\n", v...) }
-func logError(format string, v ...interface{})   { fmt.Fprintf(os.Stderr, "[FAIL] "+format+"\n", v...) }
+func logInfo(format string, v ...interface{}) {
+	fmt.Fprintf(messageOutput(), "[INFO] "+format+"\n", v...)
+	recordMessage("info", format, v...)
+}
+func logSuccess(format string, v ...interface{}) {
+	fmt.Fprintf(messageOutput(), "[OK] "+format+"\n", v...)
+	recordMessage("ok", format, v...)
+}
+func logWarn(format string, v ...interface{}) {
+	fmt.Fprintf(messageOutput(), "[WARN] "+format+"\n", v...)
+	recordMessage("warn", format, v...)
+}
+func logError(format string, v ...interface{}) { fmt.Fprintf(os.Stderr, "[FAIL] "+format+"\n", v...) }
 func debugf(format string, v ...interface{}) {
 	if debugMode {
 		log.Printf("[DEBUG] "+format, v...)
 	}
 }
+
+// keyValueFlag collects repeated KEY=VALUE flags.
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string { return "" }
+func (f keyValueFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected KEY=VALUE, got '%s'", s)
+	}
+	f[key] = value
+	return nil
+}
+
+// stringListFlag collects repeated flags.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string     { return strings.Join(*f, ",") }
+func (f *stringListFlag) Set(s string) error { *f = append(*f, s); return nil }
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// parseFlags parses args with fs, allowing flags to appear after positional
+// arguments, and returns the positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional
+		}
+		// Everything after an explicit "--" terminator is positional.
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...)
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// userHomeEnvVar holds the user's own home while HOME is a profile's, as in
+// Wrappers run from `multiprof shell` or a shell with the multiprof hook.
+const userHomeEnvVar = "MULTIPROF_USER_HOME"
+
+// userHomeDir returns the user's own home, which holds multiprof's files and
+// which ~ and $HOME in the config refer to, even inside a profile.
+func userHomeDir() (string, error) {
+	if home := os.Getenv(userHomeEnvVar); filepath.IsAbs(home) {
+		return home, nil
+	}
+	return os.UserHomeDir()
+}
+
 func expandPath(path string) string {
-	if strings.HasPrefix(path, "~") {
-		homeDir, err := os.UserHomeDir()
-		if err == nil {
-			path = filepath.Join(homeDir, path[1:])
+	homeDir, err := userHomeDir()
+	if err == nil && strings.HasPrefix(path, "~") {
+		path = filepath.Join(homeDir, path[1:])
+	}
+	return os.Expand(path, func(key string) string {
+		if key == "HOME" && err == nil {
+			return homeDir
+		}
+		value, _ := lookupEnv(key)
+		return value
+	})
+}
+
+// dirArg returns the absolute path of the directory given as an optional
+// argument, defaulting to the current directory.
+func dirArg(args []string) string {
+	dir, _ := os.Getwd()
+	if len(args) > 0 {
+		if abs, err := filepath.Abs(expandPath(args[0])); err == nil {
+			dir = abs
 		}
 	}
-	return os.ExpandEnv(path)
+	return dir
 }
-func getWrapperDir() (string, error) { return expandPath(filepath.Join("~/", wrapperDirName)), nil }
-func getCompletionDir() (string, error) {
-	return expandPath(filepath.Join("~/", completionDirName)), nil
+
+// tildePath rewrites a path under the user's home to start with ~, which
+// keeps config entries portable between machines.
+func tildePath(path string) string {
+	home, err := userHomeDir()
+	if err != nil || home == "" {
+		return path
+	}
+	if path == home {
+		return "~"
+	}
+	if strings.HasPrefix(path, home+string(os.PathSeparator)) {
+		return "~" + path[len(home):]
+	}
+	return path
 }
+func getStateDir() (string, error) { return expandPath(filepath.Join("~/", stateDirName)), nil }
+
+// getConfigPath returns $XDG_CONFIG_HOME/multiprof/config.toml, unless only
+// ~/.config/multiprof or, on macOS, ~/Library/Application Support/multiprof
+// has a config.
 func getConfigPath() (string, error) {
-	return expandPath(filepath.Join("~/", configDirName, configFileName)), nil
+	path := filepath.Join(configHome(), configDirBase, configFileName)
+	if fileExists(path) {
+		return path, nil
+	}
+	if fallback := expandPath(filepath.Join("~/", configDirName, configFileName)); fileExists(fallback) {
+		return fallback, nil
+	}
+	if isMacOS() && fileExists(macConfigPath()) {
+		return macConfigPath(), nil
+	}
+	return path, nil
 }
-func createDefaultConfig() error {
+
+// createDefaultConfig writes the default config unless one already exists.
+// A nil transaction writes directly.
+func createDefaultConfig(tx *transaction) error {
 	configPath, _ := getConfigPath()
 	if _, err := os.Stat(configPath); err == nil {
 		return nil // File already exists
 	}
-	os.MkdirAll(filepath.Dir(configPath), 0755)
-	return os.WriteFile(configPath, []byte(defaultConfigToml), 0644)
+	if tx == nil {
+		os.MkdirAll(filepath.Dir(configPath), 0755)
+		return os.WriteFile(configPath, []byte(defaultConfigToml), 0644)
+	}
+	if err := tx.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return err
+	}
+	return tx.WriteFile(configPath, []byte(defaultConfigToml), 0644)
+}
+
+// readOnlyConfig is the config as loaded with readOnly access. A Wrapper
+// loads the config several times per run but reads the file once: from then
+// on, read is set and loadConfig returns config. Until then, config may be
+// the one the daemon sent, parsed from data, and is used if the file still
+// holds data.
+var readOnlyConfig struct {
+	read   bool
+	data   []byte
+	config *Config
 }
-func loadConfig() (Config, error) {
+
+// loadConfig reads the config for a command with access a. With readWrite
+// access the default config is created first if there is none; with
+// readOnly access a missing config just means no Rule matches, and the
+// parsed config is cached (see configcache.go).
+func loadConfig(a access) (Config, error) {
+	if a == readOnly && readOnlyConfig.read {
+		return *readOnlyConfig.config, nil
+	}
 	var config Config
 	configPath, _ := getConfigPath()
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		createDefaultConfig()
+	data, err := os.ReadFile(configPath)
+	switch {
+	case os.IsNotExist(err) && a == readOnly:
+		debugf("No config at %s", configPath)
+	case os.IsNotExist(err):
+		if err := createDefaultConfig(nil); err != nil {
+			debugf("Could not create the default config: %v", err)
+		}
+		data = []byte(defaultConfigToml)
+	case err != nil:
+		exitOnPermissionProblem(configPath, err)
+		return config, err
+	}
+	exists := err == nil
+	if a == readOnly {
+		if readOnlyConfig.config != nil && bytes.Equal(readOnlyConfig.data, data) {
+			readOnlyConfig.read = true
+			return *readOnlyConfig.config, nil
+		}
+		if exists {
+			if cached, ok := cachedConfig(configPath, data); ok {
+				readOnlyConfig.read, readOnlyConfig.data, readOnlyConfig.config = true, data, &cached
+				return cached, nil
+			}
+		}
 	}
-	if _, err := toml.DecodeFile(configPath, &config); err != nil {
+	config, err = parseConfig(data)
+	if err != nil {
 		return config, err
 	}
+	if a == readOnly {
+		readOnlyConfig.read, readOnlyConfig.data, readOnlyConfig.config = true, data, &config
+		if exists {
+			saveConfigCache(configPath, data, config)
+		}
+	}
 	return config, nil
 }
-func saveConfig(config Config) error {
-	configPath, _ := getConfigPath()
-	f, err := os.Create(configPath)
-	if err != nil {
-		return err
+
+// parseConfig decodes the config file's content data and merges the files it
+// includes.
+func parseConfig(data []byte) (Config, error) {
+	var config Config
+	if _, err := toml.Decode(string(data), &config); err != nil {
+		return config, err
 	}
-	defer f.Close()
-	return toml.NewEncoder(f).Encode(config)
+	config.applyIncludes()
+	return config, nil
+}
+
+func encodeConfig(config Config) ([]byte, error) {
+	var buf bytes.Buffer
+	err := toml.NewEncoder(&buf).Encode(config.own())
+	return buf.Bytes(), err
 }
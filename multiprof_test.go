@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// multiprofTestEnvVar makes the test binary run as multiprof itself, so
+// tests can run the commands multiprof generates for its own binary.
+const multiprofTestEnvVar = "MULTIPROF_TEST_AS_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(multiprofTestEnvVar) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestLoadConfigReadOnlyReadsOnce(t *testing.T) {
+	setupDaemonTest(t, "[settings]\nsuffix = \"-a\"\n")
+	config, err := loadConfig(readOnly)
+	if err != nil || config.Settings.Suffix != "-a" {
+		t.Fatalf("loadConfig(readOnly) = suffix %q, %v", config.Settings.Suffix, err)
+	}
+	configPath, _ := getConfigPath()
+	if err := os.WriteFile(configPath, []byte("[settings]\nsuffix = \"-b\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := loadConfig(readOnly); config.Settings.Suffix != "-a" {
+		t.Errorf("loadConfig(readOnly) read the config again: suffix %q", config.Settings.Suffix)
+	}
+	if config, _ := loadConfig(readWrite); config.Settings.Suffix != "-b" {
+		t.Errorf("loadConfig(readWrite) = suffix %q, want the file's", config.Settings.Suffix)
+	}
+}
+
+func TestListCommandsCreateNoConfig(t *testing.T) {
+	setupDaemonTest(t, "")
+	configPath, _ := getConfigPath()
+	if err := os.Remove(configPath); err != nil {
+		t.Fatal(err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(multiprofTestEnvVar, "1")
+
+	for _, args := range [][]string{{"list", "--no-pager"}, {"list-wrappers"}} {
+		if out, err := exec.Command(self, args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v\n%s", args[0], err, out)
+		}
+		if _, err := os.Stat(configPath); err == nil {
+			t.Fatalf("%s created %s", args[0], configPath)
+		}
+	}
+}
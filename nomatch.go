@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --- No Match ---
+//
+// What a Wrapper does where no Rule matches is up to `on_no_match` in
+// [settings]: "error" (the default) fails with a hint, "passthrough" runs the
+// command untouched, as if there were no Wrapper, and "prompt" asks on the
+// terminal which profile to use (see picker.go), failing as usual without
+// one. A default_home (see defaulthome.go) takes precedence over all three.
+
+const (
+	noMatchError       = "error"
+	noMatchPassthrough = "passthrough"
+	noMatchPrompt      = "prompt"
+)
+
+// errPassthrough is returned by resolveForExec when the command is to run
+// untouched.
+var errPassthrough = errors.New("no Rule matched; running the command untouched")
+
+func checkOnNoMatch(config Config) error {
+	switch config.Settings.OnNoMatch {
+	case "", noMatchError, noMatchPassthrough, noMatchPrompt:
+		return nil
+	}
+	return fmt.Errorf("settings: unknown on_no_match '%s' (use error, passthrough or prompt)", config.Settings.OnNoMatch)
+}
+
+// resolveNoMatch decides what to do in dir, which no Rule matches.
+func resolveNoMatch(config Config, dir string, explain func() []string) (resolution, error) {
+	if fallback, found, err := defaultResolution(config); err != nil {
+		return fallback, &failure{errInvalidRule, err.Error(), "Run 'multiprof check' to validate your config.", explain}
+	} else if found {
+		debugf("No Rule matched %s; using default_home '%s'", dir, config.Settings.DefaultHome)
+		return fallback, nil
+	}
+	if err := checkOnNoMatch(config); err != nil {
+		return resolution{Index: -1}, &failure{errInvalidRule, err.Error(), "Run 'multiprof check' to validate your config.", explain}
+	}
+	switch config.Settings.OnNoMatch {
+	case noMatchPassthrough:
+		debugf("No Rule matched %s; passing through", dir)
+		return resolution{Index: -1}, errPassthrough
+	case noMatchPrompt:
+		if res, ok := askProfile(config, dir); ok {
+			return res, nil
+		}
+	}
+	return resolution{Index: -1}, &failure{
+		errNoRuleMatched,
+		fmt.Sprintf("No multiprof Rule matched the directory: %s", dir),
+		noMatchHint(config, dir),
+		explain,
+	}
+}
+
+// inspectNoMatch returns the resolution Wrappers use in dir, which no Rule
+// matches, for commands that only show it. found is false unless
+// default_home applies; nothing is asked.
+func inspectNoMatch(config Config, dir string) (res resolution, found bool) {
+	res, err := resolveNoMatch(config, dir, nil)
+	return res, err == nil
+}
+
+// noMatchBehavior describes what Wrappers do where no Rule matches.
+func noMatchBehavior(config Config) string {
+	switch {
+	case config.Settings.DefaultHome != "":
+		return fmt.Sprintf("use default_home '%s'", config.Settings.DefaultHome)
+	case config.Settings.OnNoMatch == noMatchPassthrough:
+		return "run commands untouched"
+	case config.Settings.OnNoMatch == noMatchPrompt:
+		return "ask for a profile"
+	}
+	return "refuse to run"
+}
+
+// passThrough runs cmdName with args and the environment unchanged, as if
+// it had no Wrapper.
+func passThrough(cmdName string, args []string) {
+	path, err := findTarget(cmdName)
+	if err != nil {
+		exitWith(&failure{errTargetNotFound, fmt.Sprintf("Could not find target command '%s' in the system PATH: %v", cmdName, err), "", nil})
+	}
+	if dryRunRequested() {
+		fmt.Println("Dry run; nothing was executed.")
+		fmt.Printf("Command:   %s\n", path)
+		fmt.Printf("Arguments: %s\n", strings.Join(args, " "))
+		fmt.Println("No Rule matches; on_no_match = \"passthrough\" runs the command untouched.")
+		os.Exit(0)
+	}
+	debugf("Executing untouched: %s", path)
+	if err := replaceProcess(path, args, os.Environ()); err != nil {
+		exitFailure(errFailed, "", "Could not execute '%s': %v", path, err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- Tables and Paging ---
+//
+// List-heavy commands render their rows with table, which aligns columns
+// and, on a terminal, truncates them (the flexible one first) to fit its
+// width. Their output goes through a pager: what fits on one screen is
+// printed directly, longer output is shown with $PAGER (default: less, if
+// installed) or, as a last resort, a tiny built-in pager. --no-pager and a
+// non-terminal stdout print everything directly.
+
+const (
+	defaultPager = "less -FRX"
+	// minColumnWidth is as far as truncation shortens a column.
+	minColumnWidth = 8
+)
+
+// table is a list of rows with aligned columns.
+type table struct {
+	header []string
+	rows   [][]string
+	// flex is the column that is truncated when a row does not fit.
+	flex int
+}
+
+func newTable(flex int, header ...string) *table {
+	return &table{header: header, flex: flex}
+}
+
+func (t *table) add(cells ...string) { t.rows = append(t.rows, cells) }
+
+// render writes the table to w. A width of 0 means no limit.
+func (t *table) render(w io.Writer, width int) {
+	widths := make([]int, len(t.header))
+	for _, row := range append([][]string{t.header}, t.rows...) {
+		for i, cell := range row {
+			if n := utf8.RuneCountInString(cell); i < len(widths) && n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	if width > 0 {
+		total := 0
+		for _, w := range widths {
+			total += w + 2
+		}
+		over := total - 2 - width
+		if over > 0 {
+			cut := min(over, max(widths[t.flex]-minColumnWidth, 0))
+			widths[t.flex] -= cut
+			over -= cut
+		}
+		// If that is not enough, take from the widest of the other columns.
+		for ; over > 0; over-- {
+			widest := slices.Index(widths, slices.Max(widths))
+			if widths[widest] <= minColumnWidth {
+				break
+			}
+			widths[widest]--
+		}
+	}
+	line := func(row []string) {
+		var b strings.Builder
+		for i, cell := range row {
+			if i == len(row)-1 {
+				// No padding after the last column.
+				b.WriteString(truncate(cell, widths[i]))
+				break
+			}
+			cell = truncate(cell, widths[i])
+			b.WriteString(cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+2))
+		}
+		fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+	}
+	line(t.header)
+	for _, row := range t.rows {
+		line(row)
+	}
+}
+
+// truncate shortens s to n characters, marking the cut with "…".
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
+
+// terminalWidth returns the width to render tables for: $COLUMNS, else the
+// terminal's width, or 0 if stdout is not a terminal.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	if !isTerminal(os.Stdout) {
+		return 0
+	}
+	if cols, _, ok := terminalSize(os.Stdout); ok {
+		return cols
+	}
+	return 80
+}
+
+// pager collects a command's output and shows it when closed.
+type pager struct {
+	bytes.Buffer
+	enabled bool
+}
+
+// newPager returns a pager, which only pages if enabled and stdout is a
+// terminal.
+func newPager(enabled bool) *pager {
+	return &pager{enabled: enabled && isTerminal(os.Stdout)}
+}
+
+// Close shows the collected output.
+func (p *pager) Close() {
+	_, rows, ok := terminalSize(os.Stdout)
+	if !p.enabled || !ok || bytes.Count(p.Bytes(), []byte("\n")) < rows {
+		os.Stdout.Write(p.Bytes())
+		return
+	}
+	command, set := os.LookupEnv("PAGER")
+	if !set {
+		if _, err := exec.LookPath("less"); err == nil {
+			command = defaultPager
+		}
+	}
+	if fields := strings.Fields(command); len(fields) > 0 {
+		cmd := exec.Command(fields[0], fields[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(p.Bytes()), os.Stdout, os.Stderr
+		if err := cmd.Run(); err == nil {
+			return
+		}
+		debugf("Pager '%s' failed; using the built-in one", command)
+	}
+	p.page(rows)
+}
+
+// page shows the output one screen at a time, reading keys from the terminal.
+func (p *pager) page(rows int) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		tty = os.Stdin
+	} else {
+		defer tty.Close()
+	}
+	keys := bufio.NewReader(tty)
+	lines := strings.SplitAfter(p.String(), "\n")
+	for start := 0; start < len(lines); start += rows - 1 {
+		end := min(start+rows-1, len(lines))
+		fmt.Print(strings.Join(lines[start:end], ""))
+		if end == len(lines) {
+			return
+		}
+		fmt.Printf("-- %d%% -- Enter for more, q to quit ", end*100/len(lines))
+		answer, err := keys.ReadString('\n')
+		if err != nil || strings.TrimSpace(answer) == "q" {
+			fmt.Println()
+			return
+		}
+	}
+}
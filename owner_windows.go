@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// fileOwner is not reported on Windows, where access is governed by ACLs
+// rather than a single owner and mode.
+func fileOwner(info os.FileInfo) string {
+	return ""
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// --- Rule Packs ---
+//
+// A pack is a named, versioned bundle of Rules, profiles, presets and
+// templates that a team distributes as a git repository or a directory.
+// `multiprof pack install` copies it to ~/.config/multiprof/rules.d/<name>;
+// nothing is merged into the user's config. Pack Rules are checked after the
+// user's own Rules, pack profiles and presets can be referenced from any Rule
+// unless the user defines one of the same name, and pack templates are used
+// where the user has no override of their own.
+//
+// Commands a pack would run (pre_exec, post_exec, credentials_cmd, cleanup,
+// shell and aliases) need trust as a project config does (see project.go):
+// install shows them and asks, and records a hash of the pack file once they
+// are trusted. A pack whose file no longer matches is ignored until it is
+// installed again.
+
+const (
+	packsDirName       = "rules.d"
+	packFileName       = "pack.toml"
+	packSourceFileName = ".multiprof-pack-source"
+)
+
+var packNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Pack is the contents of a pack's pack.toml.
+type Pack struct {
+	Name        string                       `toml:"name"`
+	Version     string                       `toml:"version"`
+	Description string                       `toml:"description,omitempty"`
+	Profiles    map[string]Profile           `toml:"profiles,omitempty"`
+	Presets     map[string]map[string]string `toml:"presets,omitempty"`
+	Rules       []Rule                       `toml:"rules"`
+
+	dir string
+	// hash is the hash of the pack file's content.
+	hash string
+}
+
+func (p Pack) config() Config {
+	return Config{Profiles: p.Profiles, Presets: p.Presets, Rules: p.Rules}
+}
+
+// commands returns the commands the pack would run, each described by
+// where it comes from.
+func (p Pack) commands() []string {
+	var commands []string
+	add := func(where, field string, c ...string) {
+		for _, c := range c {
+			commands = append(commands, fmt.Sprintf("%s %s: %s", where, field, c))
+		}
+	}
+	addHome := func(where string, h HomeSpec) {
+		add(where, "cleanup", h.Cleanup...)
+		if h.Shell != "" {
+			add(where, "shell", h.Shell)
+		}
+	}
+	for i, rule := range p.Rules {
+		where := fmt.Sprintf("Rule %d", i+1)
+		add(where, "pre_exec", rule.PreExec...)
+		add(where, "post_exec", rule.PostExec...)
+		if rule.CredentialsCmd != "" {
+			add(where, "credentials_cmd", rule.CredentialsCmd)
+		}
+		addHome(where, rule.HomeSpec)
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.Profiles)) {
+		profile, where := p.Profiles[name], fmt.Sprintf("Profile '%s'", name)
+		addHome(where, profile.HomeSpec)
+		for _, alias := range slices.Sorted(maps.Keys(profile.Aliases)) {
+			add(where, "alias "+alias, profile.Aliases[alias])
+		}
+	}
+	return commands
+}
+
+// trusted reports whether the pack runs no commands or the user trusted
+// them as they are, according to db (see readTrustDB).
+func (p Pack) trusted(db map[string]string) bool {
+	return len(p.commands()) == 0 || db[filepath.Join(p.dir, packFileName)] == p.hash
+}
+
+// source returns where the pack was installed from.
+func (p Pack) source() string {
+	data, _ := os.ReadFile(filepath.Join(p.dir, packSourceFileName))
+	return strings.TrimSpace(string(data))
+}
+
+func packsDir() string {
+	configPath, _ := getConfigPath()
+	return filepath.Join(filepath.Dir(configPath), packsDirName)
+}
+
+// readPack reads and validates the pack in dir.
+func readPack(dir string) (Pack, error) {
+	var pack Pack
+	path := filepath.Join(dir, packFileName)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		_, err = toml.Decode(string(data), &pack)
+	}
+	if err != nil {
+		return pack, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	if !packNameRe.MatchString(pack.Name) {
+		return pack, fmt.Errorf("%s has an invalid or missing name '%s'", path, pack.Name)
+	}
+	pack.dir, pack.hash = dir, hashContent(data)
+	for i := range pack.Rules {
+		pack.Rules[i].source = path
+	}
+	return pack, nil
+}
+
+// installedPacks returns the installed packs, sorted by name.
+func installedPacks() []Pack {
+	entries, err := os.ReadDir(packsDir())
+	if err != nil {
+		return nil
+	}
+	var packs []Pack
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		pack, err := readPack(filepath.Join(packsDir(), entry.Name()))
+		if err != nil {
+			debugf("Skipping pack: %v", err)
+			continue
+		}
+		packs = append(packs, pack)
+	}
+	return packs
+}
+
+// loadPacks returns the installed packs to use, sorted by name. Packs running
+// commands the user has not trusted are reported on stderr and ignored.
+func loadPacks() []Pack {
+	var packs []Pack
+	var db map[string]string
+	for _, pack := range installedPacks() {
+		if db == nil && len(pack.commands()) > 0 {
+			db = readTrustDB()
+		}
+		if !pack.trusted(db) {
+			fmt.Fprintf(os.Stderr, "[WARN] Ignoring pack '%s': you have not trusted the commands it runs as they are now. Review %s and run `multiprof pack update %s` to trust it again.\n", pack.Name, filepath.Join(pack.dir, packFileName), pack.Name)
+			continue
+		}
+		packs = append(packs, pack)
+	}
+	return packs
+}
+
+// withPackProfiles returns c with the profiles and presets of packs added.
+// The user's own take precedence.
+func (c Config) withPackProfiles(packs []Pack) Config {
+	var merged Config
+	for _, pack := range packs {
+		merged = merged.withProfilesFrom(pack.config())
+	}
+	c.Profiles = merged.withProfilesFrom(c).Profiles
+	return c
+}
+
+func runPack(args []string) {
+	if len(args) < 1 {
+		exitUsage("Usage: multiprof pack install|list|update|remove ...")
+	}
+	switch args[0] {
+	case "install":
+		installCmd := flag.NewFlagSet("pack install", flag.ExitOnError)
+		yes := installCmd.Bool("yes", false, "Trust the commands the pack runs without asking.")
+		positional := parseFlags(installCmd, args[1:])
+		if len(positional) != 1 {
+			exitUsage("Usage: multiprof pack install <git-url|path> [--yes]")
+		}
+		installPack(positional[0], false, *yes)
+	case "list":
+		runPackList()
+	case "update":
+		runPackUpdate(args[1:])
+	case "remove":
+		if len(args) != 2 {
+			exitUsage("Usage: multiprof pack remove <name>")
+		}
+		runPackRemove(args[1])
+	default:
+		exitFailure(errUsage, "Run 'multiprof help' for a list of commands.", "Unknown pack command '%s'.", args[0])
+	}
+}
+
+// installPack installs the pack at source, a local directory or a git URL.
+// With replace, an installed pack of the same name is replaced; with yes,
+// the commands it runs are trusted without asking.
+func installPack(source string, replace, yes bool) {
+	staging, err := os.MkdirTemp("", "multiprof-pack-")
+	if err != nil {
+		exitFailure(errFailed, "", "Could not create a staging directory: %v", err)
+	}
+	err = installPackFrom(source, staging, replace, yes)
+	// Removed before exiting, which skips deferred calls.
+	os.RemoveAll(staging)
+	if err != nil {
+		exitWith(err)
+	}
+}
+
+// installPackFrom installs the pack at source, cloning it into staging if
+// it is not a local directory.
+func installPackFrom(source, staging string, replace, yes bool) error {
+	local := expandPath(source)
+	if info, err := os.Stat(local); err == nil && info.IsDir() {
+		source, _ = filepath.Abs(local)
+		staging = source
+	} else {
+		logInfo("Cloning %s...", source)
+		cmd := exec.Command("git", "clone", "--quiet", "--depth", "1", "--", source, staging)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return &failure{errFailed, fmt.Sprintf("Could not clone '%s': %v", source, err), "", nil}
+		}
+	}
+	pack, err := readPack(staging)
+	if err != nil {
+		return &failure{errFailed, fmt.Sprintf("'%s' is not a valid pack: %v", source, err), "", nil}
+	}
+
+	target := filepath.Join(packsDir(), pack.Name)
+	previous, installed := Pack{}, fileExists(target)
+	if installed {
+		if !replace {
+			return &failure{errExists, fmt.Sprintf("Pack '%s' is already installed.", pack.Name), fmt.Sprintf("Use 'multiprof pack update %s' to update it.", pack.Name), nil}
+		}
+		previous, _ = readPack(target)
+	}
+	db := readTrustDB()
+	installedFile := filepath.Join(target, packFileName)
+	trust := len(pack.commands()) > 0 && db[installedFile] != pack.hash
+	if trust {
+		if err := confirmPackCommands(pack, yes); err != nil {
+			return err
+		}
+	}
+
+	tx := newTransaction(readWrite)
+	// fail rolls back the changes made so far.
+	fail := func(format string, v ...interface{}) error {
+		tx.Rollback()
+		return &failure{errFailed, fmt.Sprintf(format, v...), "All changes made by this command were rolled back.", nil}
+	}
+	if err := tx.MkdirAll(packsDir(), 0755); err != nil {
+		return fail("Could not create '%s': %v", packsDir(), err)
+	}
+	if installed {
+		if err := tx.Remove(target); err != nil {
+			return fail("Could not replace pack '%s': %v", pack.Name, err)
+		}
+	}
+	if err := tx.CopyTree(staging, target); err != nil {
+		return fail("Could not install pack '%s': %v", pack.Name, err)
+	}
+	if gitDir := filepath.Join(target, ".git"); fileExists(gitDir) {
+		if err := tx.Remove(gitDir); err != nil {
+			return fail("Could not install pack '%s': %v", pack.Name, err)
+		}
+	}
+	if err := tx.WriteFile(filepath.Join(target, packSourceFileName), []byte(source+"\n"), 0644); err != nil {
+		return fail("Could not record the source of pack '%s': %v", pack.Name, err)
+	}
+	tx.Commit()
+	if trust {
+		db[installedFile] = pack.hash
+		if err := writeTrustDB(db); err != nil {
+			logWarn("Could not record that you trust pack '%s', so it will be ignored: %v", pack.Name, err)
+		}
+	}
+
+	if installed {
+		logSuccess("Updated pack '%s' from version %s to %s.", pack.Name, versionOrUnknown(previous.Version), versionOrUnknown(pack.Version))
+	} else {
+		logSuccess("Installed pack '%s' %s with %d Rules and %d profiles.", pack.Name, versionOrUnknown(pack.Version), len(pack.Rules), len(pack.Profiles))
+	}
+	return nil
+}
+
+// confirmPackCommands shows the commands pack runs and returns an error
+// unless yes is set or the user trusts them.
+func confirmPackCommands(pack Pack, yes bool) error {
+	fmt.Fprintf(os.Stderr, "Pack '%s' runs these commands:\n", pack.Name)
+	for _, c := range pack.commands() {
+		fmt.Fprintf(os.Stderr, "  %s\n", c)
+	}
+	switch {
+	case yes:
+		return nil
+	case !isTerminal(os.Stdin):
+		return &failure{errRefusedChange, fmt.Sprintf("Refusing to install pack '%s' without trusting the commands it runs.", pack.Name), "Review them and re-run with --yes.", nil}
+	case !confirm("Trust them?"):
+		return &failure{errRefusedChange, fmt.Sprintf("Not installing pack '%s'.", pack.Name), "", nil}
+	}
+	return nil
+}
+
+func versionOrUnknown(version string) string {
+	if version == "" {
+		return "(unversioned)"
+	}
+	return version
+}
+
+func runPackList() {
+	packs := installedPacks()
+	if len(packs) == 0 {
+		fmt.Println("No packs installed. Use 'multiprof pack install <git-url|path>' to install one.")
+		return
+	}
+	db := readTrustDB()
+	for _, pack := range packs {
+		ignored := ""
+		if !pack.trusted(db) {
+			ignored = " (ignored: its commands are not trusted)"
+		}
+		fmt.Printf("%s %s: %d Rules, %d profiles, from %s%s\n", pack.Name, versionOrUnknown(pack.Version), len(pack.Rules), len(pack.Profiles), pack.source(), ignored)
+		if pack.Description != "" {
+			fmt.Printf("   %s\n", pack.Description)
+		}
+	}
+}
+
+func runPackUpdate(args []string) {
+	updateCmd := flag.NewFlagSet("pack update", flag.ExitOnError)
+	yes := updateCmd.Bool("yes", false, "Trust the commands the packs run without asking.")
+	names := parseFlags(updateCmd, args)
+	packs := installedPacks()
+	if len(names) == 0 {
+		for _, pack := range packs {
+			names = append(names, pack.Name)
+		}
+	}
+	for _, name := range names {
+		var found *Pack
+		for i := range packs {
+			if packs[i].Name == name {
+				found = &packs[i]
+			}
+		}
+		if found == nil {
+			exitFailure(errNotFound, "Run 'multiprof pack list' to see them.", "Pack '%s' is not installed.", name)
+		}
+		if found.source() == "" {
+			logWarn("Pack '%s' does not record where it came from; reinstall it to enable updates.", name)
+			continue
+		}
+		installPack(found.source(), true, *yes)
+	}
+}
+
+func runPackRemove(name string) {
+	target := filepath.Join(packsDir(), name)
+	if !packNameRe.MatchString(name) || !fileExists(filepath.Join(target, packFileName)) {
+		exitFailure(errNotFound, "Run 'multiprof pack list' to see them.", "Pack '%s' is not installed.", name)
+	}
+	tx := newTransaction(readWrite)
+	if err := tx.Remove(target); err != nil {
+		tx.abort("Could not remove pack '%s': %v", name, err)
+	}
+	tx.Commit()
+	db := readTrustDB()
+	if file := filepath.Join(target, packFileName); db[file] != "" {
+		delete(db, file)
+		if err := writeTrustDB(db); err != nil {
+			debugf("Could not forget the trust of pack '%s': %v", name, err)
+		}
+	}
+	logSuccess("Removed pack '%s'.", name)
+}
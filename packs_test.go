@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPacksIgnoresUntrustedCommands(t *testing.T) {
+	setupDaemonTest(t, "")
+	write := func(name, content string) {
+		t.Helper()
+		dir := filepath.Join(packsDir(), name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, packFileName), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func() []string {
+		var names []string
+		for _, pack := range loadPacks() {
+			names = append(names, pack.Name)
+		}
+		return names
+	}
+	write("plain", "name = \"plain\"\n[[rules]]\npattern = \"/a/**\"\nhome = \"/h\"\n")
+	write("hooks", "name = \"hooks\"\n[[rules]]\npattern = \"/b/**\"\nhome = \"/h\"\npre_exec = [\"true\"]\n")
+	if got := names(); len(got) != 1 || got[0] != "plain" {
+		t.Fatalf("loadPacks() = %v, want only the pack without commands", got)
+	}
+
+	hooks, err := readPack(filepath.Join(packsDir(), "hooks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTrustDB(map[string]string{filepath.Join(hooks.dir, packFileName): hooks.hash}); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(); len(got) != 2 {
+		t.Errorf("loadPacks() = %v after trusting, want both packs", got)
+	}
+	write("hooks", "name = \"hooks\"\n[[rules]]\npattern = \"/b/**\"\nhome = \"/h\"\npre_exec = [\"false\"]\n")
+	if got := names(); len(got) != 1 {
+		t.Errorf("loadPacks() = %v after the pack changed, want it ignored", got)
+	}
+}
+
+func TestPackCommandsNeedTrust(t *testing.T) {
+	setupDaemonTest(t, "")
+	const rule = "name = \"p\"\n[[rules]]\npattern = \"/a/**\"\nhome = \"/h\"\n"
+	const profile = "name = \"p\"\n[profiles.x]\nhome = \"/h\"\n"
+	for field, pack := range map[string]string{
+		"Rule pre_exec":        rule + "pre_exec = [\"true\"]\n",
+		"Rule post_exec":       rule + "post_exec = [\"true\"]\n",
+		"Rule credentials_cmd": rule + "credentials_cmd = \"true\"\n",
+		"Rule cleanup":         rule + "cleanup = [\"true\"]\n",
+		"Rule shell":           rule + "shell = \"/bin/sh\"\n",
+		"Profile cleanup":      profile + "cleanup = [\"true\"]\n",
+		"Profile shell":        profile + "shell = \"/bin/sh\"\n",
+		"Profile aliases":      profile + "aliases = { k = \"rm -rf ~\" }\n",
+	} {
+		dir := filepath.Join(t.TempDir(), "p")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, packFileName), []byte(pack), 0644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := readPack(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", field, err)
+		}
+		if len(p.commands()) != 1 || p.trusted(nil) {
+			t.Errorf("%s: commands() = %v, trusted = %v; want it to need trust", field, p.commands(), p.trusted(nil))
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+// ensureUserPath does nothing on Unix: the user adds the Wrapper Directory to
+// PATH in their shell profile, following the instructions init prints.
+func ensureUserPath(wrapperDir string) bool { return false }
@@ -0,0 +1,127 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// --- Windows PATH Setup ---
+//
+// Windows has no shell profile to edit: new shells take the user PATH from
+// the registry (HKCU\Environment). init offers to prepend the Wrapper
+// Directory there itself. The value is read and written unexpanded, so
+// entries like %USERPROFILE%\bin survive, and the change is announced to
+// running programs so that new terminals see it.
+
+const (
+	readUserPathScript = `(Get-Item -Path 'HKCU:\Environment').GetValue('Path', '', 'DoNotExpandEnvironmentNames')`
+	// Setting and clearing a dummy variable through .NET broadcasts
+	// WM_SETTINGCHANGE, which New-ItemProperty alone does not.
+	writeUserPathScript = `New-ItemProperty -Path 'HKCU:\Environment' -Name Path -PropertyType ExpandString -Value $env:MULTIPROF_NEW_PATH -Force | Out-Null; ` +
+		`[Environment]::SetEnvironmentVariable('MULTIPROF_PATH_REFRESH', '1', 'User'); ` +
+		`[Environment]::SetEnvironmentVariable('MULTIPROF_PATH_REFRESH', $null, 'User')`
+)
+
+// ensureUserPath offers to add wrapperDir to the user PATH in the registry
+// and checks that the registry now has it.
+func ensureUserPath(wrapperDir string) bool {
+	userPath, err := readUserPath()
+	if err != nil {
+		logWarn("Could not read your user PATH from the registry: %v", err)
+		printManualPathStep(wrapperDir)
+		return true
+	}
+	if pathListContains(userPath, wrapperDir) {
+		logSuccess("The Wrapper Directory is already on your user PATH.")
+		return true
+	}
+	if !confirm(fmt.Sprintf("Add %s to the front of your user PATH?", wrapperDir)) {
+		printManualPathStep(wrapperDir)
+		return true
+	}
+	newPath := wrapperDir
+	if userPath != "" {
+		newPath += ";" + userPath
+	}
+	cmd := powershell(writeUserPathScript)
+	cmd.Env = append(os.Environ(), "MULTIPROF_NEW_PATH="+newPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		logError("Could not update your user PATH: %v: %s", err, strings.TrimSpace(string(out)))
+		printManualPathStep(wrapperDir)
+		return true
+	}
+	if updated, err := readUserPath(); err != nil || !pathListContains(updated, wrapperDir) {
+		logWarn("Your user PATH was written, but reading it back does not show the Wrapper Directory.")
+		printManualPathStep(wrapperDir)
+		return true
+	}
+	logSuccess("Added the Wrapper Directory to your user PATH.")
+	logInfo("New terminals will see it; restart terminals that are already open.")
+	return true
+}
+
+func readUserPath() (string, error) {
+	out, err := powershell(readUserPathScript).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func powershell(script string) *exec.Cmd {
+	return exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", script)
+}
+
+// pathListContains reports whether the ;-separated list, read unexpanded
+// from the registry, contains dir, comparing the way Windows paths compare.
+func pathListContains(list, dir string) bool {
+	want := strings.TrimRight(filepath.Clean(dir), `\`)
+	for _, entry := range filepath.SplitList(list) {
+		if entry == "" {
+			continue
+		}
+		entry = strings.TrimRight(filepath.Clean(expandPercentVars(entry)), `\`)
+		if strings.EqualFold(entry, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPercentVars expands %NAME% references in s as Windows does, leaving
+// those naming unset variables as they are.
+func expandPercentVars(s string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(s, '%')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start+1:], '%')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+		name := s[start+1 : end]
+		if value, ok := os.LookupEnv(name); ok && name != "" {
+			b.WriteString(s[:start] + value)
+			s = s[end+1:]
+			continue
+		}
+		// Not a variable; the closing % may open the next reference.
+		b.WriteString(s[:end])
+		s = s[end:]
+	}
+	return b.String() + s
+}
+
+func printManualPathStep(wrapperDir string) {
+	logInfo("To add the Wrapper Directory to your PATH yourself, run in PowerShell:")
+	fmt.Printf("\n     [Environment]::SetEnvironmentVariable('Path', '%s;' + [Environment]::GetEnvironmentVariable('Path', 'User'), 'User')\n\n", wrapperDir)
+	logInfo("Then open a new terminal.")
+}
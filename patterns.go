@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+
+	"github.com/gobwas/glob"
+)
+
+// --- Rule Patterns ---
+//
+// Rule patterns are globs by default. `pattern_type = "regex"` switches a Rule
+// to a Go regular expression (RE2 syntax), matched against the absolute
+// directory path, for matching that globs cannot express. A Rule's `exclude`
+// globs veto a match, so "all of ~/work except one subtree" needs no second
+// Rule ordered in front of it.
+//
+// By default the first matching Rule wins. With `match_strategy = "longest"`
+// the matching Rule with the longest literal prefix wins instead, so a Rule
+// for ~/work/client-a/** beats one for ~/work/** wherever it sits in the file.
+
+const (
+	patternGlob  = "glob"
+	patternRegex = "regex"
+)
+
+// Values of settings.match_strategy.
+const (
+	matchFirst   = "first"
+	matchLongest = "longest"
+	// matchPrompt asks which matching Rule to use; see picker.go.
+	matchPrompt = "prompt"
+)
+
+type matcher interface {
+	Match(s string) bool
+}
+
+// compiledGlobs and compiledRegexes hold the patterns compiled so far in
+// this run: a Rule is often matched more than once, e.g. for its source and
+// again for the explanation, and the daemon matches it for every query.
+var compiledGlobs = struct {
+	sync.Mutex
+	globs map[string]glob.Glob
+}{globs: make(map[string]glob.Glob)}
+
+var compiledRegexes = struct {
+	sync.Mutex
+	regexes map[string]*regexp.Regexp
+}{regexes: make(map[string]*regexp.Regexp)}
+
+// compileGlob compiles pattern, reusing an earlier compilation.
+func compileGlob(pattern string) (glob.Glob, error) {
+	compiledGlobs.Lock()
+	defer compiledGlobs.Unlock()
+	if g, ok := compiledGlobs.globs[pattern]; ok {
+		return g, nil
+	}
+	g, err := glob.Compile(pattern)
+	if err == nil {
+		compiledGlobs.globs[pattern] = g
+	}
+	return g, err
+}
+
+// compileRegex compiles pattern, reusing an earlier compilation.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	compiledRegexes.Lock()
+	defer compiledRegexes.Unlock()
+	if re, ok := compiledRegexes.regexes[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err == nil {
+		compiledRegexes.regexes[pattern] = re
+	}
+	return re, err
+}
+
+type regexMatcher struct{ re *regexp.Regexp }
+
+func (m regexMatcher) Match(s string) bool { return m.re.MatchString(s) }
+
+// compilePattern compiles a Rule's pattern according to its pattern_type.
+// Globs have ~ expanded; regular expressions are used as written.
+func compilePattern(rule Rule) (matcher, error) {
+	switch rule.PatternType {
+	case "", patternGlob:
+		g, err := compileGlob(expandPath(rule.Pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob '%s': %w", rule.Pattern, err)
+		}
+		return g, nil
+	case patternRegex:
+		re, err := compileRegex(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex '%s': %w", rule.Pattern, err)
+		}
+		return regexMatcher{re}, nil
+	}
+	return nil, fmt.Errorf("unknown pattern_type '%s' in Rule '%s' (use glob or regex)", rule.PatternType, rule.Pattern)
+}
+
+// outsidePrefix reports whether a glob Rule cannot match the expanded
+// directory dir because dir, with or without a trailing slash, does not start
+// with the glob's literal prefix. Matching checks this first, so a run only
+// compiles the globs of Rules that could apply.
+func outsidePrefix(rule Rule, dir, dirWithSlash string) bool {
+	if rule.PatternType != "" && rule.PatternType != patternGlob {
+		return false // A regex is unanchored, so its prefix says nothing.
+	}
+	prefix := literalPrefix(rule)
+	return !strings.HasPrefix(dir, prefix) && !strings.HasPrefix(dirWithSlash, prefix)
+}
+
+// excludedBy returns the first `exclude` glob of rule matching dir, given
+// with and without a trailing slash.
+func excludedBy(rule Rule, dir, dirWithSlash string) (string, bool) {
+	for _, pattern := range rule.Exclude {
+		g, err := compileGlob(expandPath(pattern))
+		if err != nil {
+			debugf("Ignoring invalid exclude '%s': %v", pattern, err)
+			continue
+		}
+		if g.Match(dir) || g.Match(dirWithSlash) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// literalPrefix returns the part of a Rule's pattern before its first
+// wildcard, which is how specific the Rule is under match_strategy "longest".
+func literalPrefix(rule Rule) string {
+	if rule.PatternType == patternRegex {
+		re, err := syntax.Parse(rule.Pattern, syntax.Perl)
+		if err != nil {
+			return ""
+		}
+		return regexLiteralPrefix(re.Simplify())
+	}
+	pattern := expandPath(rule.Pattern)
+	if i := strings.IndexAny(pattern, `*?[{\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// regexLiteralPrefix returns the literal text a parsed regular expression
+// must start with, skipping a leading ^.
+func regexLiteralPrefix(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			return string(re.Rune)
+		}
+	case syntax.OpConcat:
+		var b strings.Builder
+	prefix:
+		for _, sub := range re.Sub {
+			switch sub.Op {
+			case syntax.OpBeginText, syntax.OpBeginLine:
+				continue
+			case syntax.OpLiteral:
+				if sub.Flags&syntax.FoldCase == 0 {
+					b.WriteString(string(sub.Rune))
+					continue
+				}
+			}
+			break prefix
+		}
+		return b.String()
+	}
+	return ""
+}
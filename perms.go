@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// --- Permission Problems ---
+//
+// A config.toml edited with sudo ends up owned by root, and a wrong chmod
+// makes it unreadable. Neither should look like a config without Rules, so
+// permission errors on managed files are reported with the owner and mode of
+// the offending path and the command that fixes it.
+
+// permissionProblem explains a permission error on path, or returns "" if
+// err is not one. When path itself cannot be inspected, the nearest parent
+// directory that can is described instead, since that is the one denying
+// access.
+func permissionProblem(path string, err error) string {
+	if !errors.Is(err, fs.ErrPermission) {
+		return ""
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		path = pathErr.Path
+	}
+	info, statErr := os.Stat(path)
+	for statErr != nil && filepath.Dir(path) != path {
+		path = filepath.Dir(path)
+		info, statErr = os.Stat(path)
+	}
+	if statErr != nil {
+		return fmt.Sprintf("Permission denied reading '%s'.", path)
+	}
+
+	var b strings.Builder
+	owner := fileOwner(info)
+	fmt.Fprintf(&b, "Permission denied reading '%s' (mode %s", path, info.Mode().Perm())
+	if owner != "" {
+		fmt.Fprintf(&b, ", owned by %s", owner)
+	}
+	b.WriteString(").")
+	if me, err := user.Current(); err == nil && owner != "" && owner != me.Username {
+		recursive := ""
+		if info.IsDir() {
+			recursive = "-R "
+		}
+		fmt.Fprintf(&b, " It was probably edited with sudo. Fix it with: sudo chown %s%s %s", recursive, me.Username, path)
+	} else if info.IsDir() {
+		fmt.Fprintf(&b, " Fix it with: chmod u+rwx %s", path)
+	} else {
+		fmt.Fprintf(&b, " Fix it with: chmod u+rw %s", path)
+	}
+	return b.String()
+}
+
+// exitOnPermissionProblem reports a permission error on path and exits.
+// Other errors are left to the caller.
+func exitOnPermissionProblem(path string, err error) {
+	if problem := permissionProblem(path, err); problem != "" {
+		exitFailure(errFailed, "", "%s", problem)
+	}
+}
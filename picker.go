@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// --- Profile Picker ---
+//
+// Run from a terminal, a Wrapper can ask instead of guessing: with
+// on_no_match = "prompt" where no Rule matches, and with match_strategy =
+// "prompt" where several Rules selecting different profiles match. The
+// picker takes the number of an entry or any part of its name, letters in
+// order (so "acm" finds "acme"), narrowing the list until one is left. A
+// profile picked for an unmatched directory can be kept as a new Rule for
+// it, so the question is not asked there again. Without a terminal nothing
+// is asked: unmatched directories fail and ambiguous ones use the first
+// matching Rule.
+
+// pickInteractively is set when resolving for a Wrapper or `multiprof run`;
+// which, check and the other inspection commands never ask.
+var pickInteractively bool
+
+// canPick reports whether the user can be asked.
+func canPick() bool {
+	return pickInteractively && isTerminal(os.Stdin) && isTerminal(os.Stderr)
+}
+
+// fuzzyMatch reports whether the letters of query appear in s in order,
+// ignoring case.
+func fuzzyMatch(query, s string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(query) {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(string(r)):]
+	}
+	return true
+}
+
+// pick asks on stderr which of items to use and returns its index, or false
+// if the user cancels with Enter or at end of input.
+func pick(question string, items []string) (int, bool) {
+	shown := make([]int, len(items))
+	for i := range items {
+		shown[i] = i
+	}
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Fprintln(os.Stderr, question)
+	for {
+		for _, i := range shown {
+			fmt.Fprintf(os.Stderr, "  %d) %s\n", i+1, items[i])
+		}
+		fmt.Fprint(os.Stderr, "Number or name [Enter to cancel]: ")
+		answer, err := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if err != nil || answer == "" {
+			return -1, false
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(items) {
+			return n - 1, true
+		}
+		var matched []int
+		for _, i := range shown {
+			if fuzzyMatch(answer, items[i]) {
+				matched = append(matched, i)
+			}
+		}
+		switch len(matched) {
+		case 0:
+			fmt.Fprintf(os.Stderr, "Nothing matches '%s'.\n", answer)
+		case 1:
+			return matched[0], true
+		default:
+			shown = matched
+		}
+	}
+}
+
+// pickRule asks which of the matching Rules at the indices candidates to
+// use, if they select different profiles, and returns its index.
+func pickRule(rules []Rule, candidates []int, dir string) int {
+	first := rules[candidates[0]]
+	distinct := false
+	for _, i := range candidates[1:] {
+		if rules[i].Profile != first.Profile || rules[i].Home != first.Home {
+			distinct = true
+		}
+	}
+	if !distinct || !canPick() {
+		return candidates[0]
+	}
+	items := make([]string, len(candidates))
+	for n, i := range candidates {
+		items[n] = fmt.Sprintf("%s (Rule %d '%s')", describeTarget(rules[i]), i+1, rules[i].Pattern)
+	}
+	if n, ok := pick(fmt.Sprintf("Several Rules match %s. %s with which one?", tildePath(dir), pickingFor()), items); ok {
+		return candidates[n]
+	}
+	return candidates[0]
+}
+
+// pickingFor says what the choice is for: running the invoked command, or
+// starting `multiprof shell` if there is none.
+func pickingFor() string {
+	if invokedCommand == "" {
+		return "Start the shell"
+	}
+	return fmt.Sprintf("Run '%s'", invokedCommand)
+}
+
+// askProfile asks which profile to use in dir, which no Rule matches, and
+// offers to add a Rule for it. It returns false without a terminal or if
+// the user declines.
+func askProfile(config Config, dir string) (resolution, bool) {
+	profiles := config.withPackProfiles(loadPacks())
+	choices := profiles.allProfiles()
+	if !canPick() || len(choices) == 0 {
+		return resolution{Index: -1}, false
+	}
+	items := make([]string, len(choices))
+	for i, p := range choices {
+		items[i] = tildePath(p.Home)
+		if p.Name != "" {
+			items[i] = fmt.Sprintf("%s (%s)", p.Name, tildePath(p.Home))
+		}
+	}
+	n, ok := pick(fmt.Sprintf("No multiprof Rule matches %s. %s with which profile?", tildePath(dir), pickingFor()), items)
+	if !ok {
+		return resolution{Index: -1}, false
+	}
+	offerRule(config, dir, choices[n])
+	return resolution{Index: -1, Profile: choices[n], Presets: profiles.Presets}, true
+}
+
+// offerRule asks whether to keep the choice of p for dir as a new Rule, and
+// adds it at the end of the config if so.
+func offerRule(config Config, dir string, p namedProfile) {
+	rule := Rule{Pattern: tildePath(expandPath(dir)) + "/**", Profile: p.Name}
+	if p.Name == "" {
+		rule.HomeSpec.Home = tildePath(p.Home)
+	}
+	fmt.Fprintf(os.Stderr, "Add a Rule using %s for '%s' from now on? [y/N] ", describeTarget(rule), rule.Pattern)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return
+	}
+	config.Rules = append(config.Rules, rule)
+	// The user asked for this change, so the read-only access of Wrappers
+	// (see readonly.go) does not apply.
+	tx := newTransaction(readWrite)
+	if err := tx.SaveConfig(config); err != nil {
+		tx.Rollback()
+		fmt.Fprintf(os.Stderr, "[WARN] Could not save the Rule: %v\n", err)
+		return
+	}
+	tx.Commit()
+	fmt.Fprintf(os.Stderr, "[OK] Added Rule %d: when in '%s', use %s.\n", len(config.Rules), rule.Pattern, describeTarget(rule))
+}
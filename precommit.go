@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// --- Pre-commit Hooks ---
+//
+// `multiprof gen-precommit` writes a git pre-commit hook that refuses commits
+// whose author email differs from the `email` in the identity of the
+// repository's profile. The expected values are baked into the hook, so it
+// works without multiprof being on the PATH of whatever runs git.
+
+func runGenPrecommit(args []string) {
+	genCmd := flag.NewFlagSet("gen-precommit", flag.ExitOnError)
+	install := genCmd.Bool("install", false, "Install the hook into the repository instead of printing it.")
+	onConflict := genCmd.String("on-conflict", conflictAsk, "What to do with an existing hook multiprof did not create: ask, keep, overwrite or backup.")
+	positional := parseFlags(genCmd, args)
+	if len(positional) > 1 {
+		exitUsage("Usage: multiprof gen-precommit [dir] [--install] [--on-conflict ask|keep|overwrite|backup]")
+	}
+	if !validConflictPolicy(*onConflict) {
+		exitUsage("Invalid --on-conflict value '%s'.", *onConflict)
+	}
+	dir := dirArg(positional)
+	gd, ok := gitDir(dir)
+	if !ok {
+		exitFailure(errNotFound, "", "'%s' is not inside a git repository.", dir)
+	}
+
+	config, _ := loadConfig(readWrite)
+	invokedCommand = "git"
+	res, ok := resolveDir(config, dir)
+	if !ok {
+		explain := func() []string { return explainResolution(config, dir) }
+		exitWith(&failure{errNoRuleMatched, fmt.Sprintf("No Rule matches '%s', so there is no profile to check commits against.", dir), noMatchHint(config, dir), explain})
+	}
+	if res.Err != nil {
+		exitFailure(errInvalidRule, "Run 'multiprof check' to validate your config.", "%v", res.Err)
+	}
+	email := res.Profile.Identity["email"]
+	if email == "" {
+		exitFailure(errNotFound, "Add `identity = { email = \"...\" }` to it first.", "Profile '%s' has no identity email.", res.Profile.label())
+	}
+
+	tmpl, err := loadTemplate("precommit.template.sh", precommitTemplate)
+	if err != nil {
+		exitWith(err)
+	}
+	data := struct{ Profile, Email, Wrapper string }{res.Profile.label(), email, config.wrapperName("git")}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		exitFailure(errFailed, "", "Could not render the hook: %v", err)
+	}
+	if !*install {
+		fmt.Print(buf.String())
+		return
+	}
+
+	hookPath := filepath.Join(hooksDir(gd), "pre-commit")
+	tx := newTransaction(readWrite)
+	if err := tx.MkdirAll(filepath.Dir(hookPath), 0755); err != nil {
+		tx.abort("Could not create the hooks directory: %v", err)
+	}
+	if err := clearPath(tx, hookPath, *onConflict, hasGeneratedMarker); err == errKeptExisting {
+		os.Exit(1)
+	} else if err != nil {
+		tx.abort("%v", err)
+	}
+	if err := tx.WriteFile(hookPath, buf.Bytes(), 0755); err != nil {
+		tx.abort("Could not write the hook: %v", err)
+	}
+	tx.Commit()
+	logSuccess("Installed %s: commits must use '%s' (profile '%s').", hookPath, email, res.Profile.label())
+}
+
+// hooksDir returns the hooks directory of a git directory, honouring
+// core.hooksPath.
+func hooksDir(gd string) string {
+	configPath := gitConfigPath(gd)
+	if paths := readGitConfig(configPath)["core"]["hookspath"]; len(paths) > 0 {
+		path := expandPath(paths[len(paths)-1])
+		if !filepath.IsAbs(path) {
+			// Relative hook paths are relative to the work tree.
+			path = filepath.Join(filepath.Dir(filepath.Dir(configPath)), path)
+		}
+		return path
+	}
+	return filepath.Join(filepath.Dir(configPath), "hooks")
+}
@@ -0,0 +1,19 @@
+#!/bin/sh
+# Generated by multiprof for profile '{{.Profile}}'.
+# Refuses commits in this repository unless they are made with the profile's
+# identity, catching commits made with the wrong email.
+
+expected_profile={{shellQuote .Profile}}
+expected_email={{shellQuote .Email}}
+
+email=$(git config user.email)
+if [ "$email" != "$expected_email" ]; then
+    echo "multiprof: this repository belongs to profile '$expected_profile'." >&2
+    echo "multiprof: the commit email is '$email', expected '$expected_email'." >&2
+    echo "multiprof: run git through its Wrapper ({{.Wrapper}}) or fix user.email." >&2
+    exit 1
+fi
+if [ -n "$MULTIPROF_ACTIVE_PROFILE" ] && [ "$MULTIPROF_ACTIVE_PROFILE" != "$expected_profile" ]; then
+    echo "multiprof: git is running in profile '$MULTIPROF_ACTIVE_PROFILE', expected '$expected_profile'." >&2
+    exit 1
+fi
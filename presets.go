@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// --- Presets and Network Settings ---
+//
+// Client VPN and proxy requirements usually come with the identity switch.
+// `[presets.<name>]` defines a reusable set of variables that profiles and
+// Rules pull in with `presets = [...]`, and a `network` table sets the proxy
+// and CA bundle variables that the common tools read.
+
+// Network holds the proxy and CA settings of a profile or Rule.
+type Network struct {
+	Proxy      string   `toml:"proxy,omitempty"`
+	HTTPProxy  string   `toml:"http_proxy,omitempty"`
+	HTTPSProxy string   `toml:"https_proxy,omitempty"`
+	NoProxy    []string `toml:"no_proxy,omitempty"`
+	CABundle   string   `toml:"ca_bundle,omitempty"`
+}
+
+// caBundleVars are read by OpenSSL, Python requests, curl, Node, git and the
+// AWS CLI respectively.
+var caBundleVars = []string{"SSL_CERT_FILE", "REQUESTS_CA_BUNDLE", "CURL_CA_BUNDLE", "NODE_EXTRA_CA_CERTS", "GIT_SSL_CAINFO", "AWS_CA_BUNDLE"}
+
+// vars returns the environment variables for the network settings. Proxy
+// variables are set in both cases, since tools disagree on which they read.
+func (n Network) vars(home string) map[string]string {
+	vars := make(map[string]string)
+	setBoth := func(key, value string) {
+		if value != "" {
+			vars[key] = value
+			vars[strings.ToLower(key)] = value
+		}
+	}
+	setBoth("HTTP_PROXY", n.Proxy)
+	setBoth("HTTPS_PROXY", n.Proxy)
+	setBoth("HTTP_PROXY", n.HTTPProxy)
+	setBoth("HTTPS_PROXY", n.HTTPSProxy)
+	setBoth("NO_PROXY", strings.Join(n.NoProxy, ","))
+	if n.CABundle != "" {
+		for _, key := range caBundleVars {
+			vars[key] = expandForHome(n.CABundle, home)
+		}
+	}
+	return vars
+}
+
+// checkPresets returns an error if spec uses a preset that is not defined.
+func checkPresets(spec EnvSpec, presets map[string]map[string]string) error {
+	for _, name := range spec.Presets {
+		if _, ok := presets[name]; !ok {
+			return fmt.Errorf("unknown preset '%s'; define it under [presets.%s]", name, name)
+		}
+	}
+	return nil
+}
+
+// applyEnvSpec sets spec's XDG directories, then the variables of its
+// presets, then its network settings, then its own env, so that more specific
+// settings win.
+func applyEnvSpec(env *envBuilder, spec EnvSpec, presets map[string]map[string]string, home, origin string) {
+	if spec.XDG {
+		setXDGDirs(env, home, origin+" xdg")
+	}
+	for _, name := range spec.Presets {
+		vars := presets[name]
+		for _, key := range sortedKeys(vars) {
+			env.Set(key, expandForHome(vars[key], home), fmt.Sprintf("%s preset '%s'", origin, name))
+		}
+	}
+	if spec.Network != nil {
+		vars := spec.Network.vars(home)
+		for _, key := range sortedKeys(vars) {
+			env.Set(key, vars[key], origin+" network")
+		}
+	}
+	for _, key := range sortedKeys(spec.Env) {
+		env.Set(key, expandForHome(spec.Env[key], home), origin+" env")
+		debugf("Set %s from %s", key, origin)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// --- Profile Management ---
+//
+// Commands that create and change profiles as a whole, home included.
+// `multiprof create-profile` makes the home, seeds it and registers the
+// profile in one transaction; `multiprof clone-profile` does the same with a
+// copy of another profile's home and settings. `multiprof delete-profile`
+// removes a profile no Rule uses any more and, with --purge, its home;
+// `multiprof rename-profile` renames one along with the Rules using it.
+
+// defaultSeedDotfiles are copied from an existing profile or your own home,
+// which hold credentials and caches that should not be copied wholesale.
+const defaultSeedDotfiles = ".gitconfig,.ssh/config"
+
+// defaultCloneExcludes are caches and per-home runtime files, which a cloned
+// home should start without.
+var defaultCloneExcludes = []string{".cache", ".npm/_cacache", ".local/share/Trash", agentSocketName, agentPidName}
+
+func runCreateProfile(args []string) {
+	createCmd := flag.NewFlagSet("create-profile", flag.ExitOnError)
+	homeFlag := createCmd.String("home", "", "Home directory (default: <profiles_dir>/<name>).")
+	fromFlag := createCmd.String("from", "", "Skeleton directory or existing profile to seed the home from (default: ~/.config/multiprof/skel, if present).")
+	dotfilesFlag := createCmd.String("dotfiles", "", "Comma-separated paths to copy from --from (default: everything from a skeleton, "+defaultSeedDotfiles+" from a profile or your home).")
+	positional := parseFlags(createCmd, args)
+	if len(positional) != 1 {
+		exitUsage("Usage: multiprof create-profile <name> [--home <h>] [--from <skeleton|profile>] [--dotfiles <list>]")
+	}
+	name := positional[0]
+	config, _ := loadConfig(readWrite)
+	home := newProfileHome(config, name, *homeFlag)
+
+	source, dotfiles := seedSource(config, *fromFlag)
+	if *dotfilesFlag != "" {
+		dotfiles = splitList(*dotfilesFlag)
+	}
+
+	tx := newTransaction(readWrite)
+	if err := tx.MkdirAll(home, 0700); err != nil {
+		tx.abort("Could not create home: %v", err)
+	}
+	var seeded []string
+	if source != "" {
+		var err error
+		if seeded, err = seedHome(tx, source, home, dotfiles); err != nil {
+			tx.abort("Could not seed the home from '%s': %v", source, err)
+		}
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]Profile)
+	}
+	config.Profiles[name] = Profile{HomeSpec: HomeSpec{Home: tildePath(home)}}
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+
+	logSuccess("Created profile '%s' with home %s.", name, tildePath(home))
+	if source != "" && dotfiles == nil {
+		logInfo("Seeded the home from %s.", tildePath(source))
+	} else if len(seeded) > 0 {
+		logInfo("Copied from %s: %s", tildePath(source), strings.Join(seeded, ", "))
+	}
+	logInfo("Use it in a Rule with: multiprof add-rule --pattern '<dir>/**' --profile %s", name)
+}
+
+func runCloneProfile(args []string) {
+	cloneCmd := flag.NewFlagSet("clone-profile", flag.ExitOnError)
+	homeFlag := cloneCmd.String("home", "", "Home directory of the new profile (default: <profiles_dir>/<dst>).")
+	var excludeFlag stringListFlag
+	cloneCmd.Var(&excludeFlag, "exclude", "A glob of paths in the home, such as '.npm', not to copy. May be repeated; "+strings.Join(defaultCloneExcludes, ", ")+" are always skipped.")
+	positional := parseFlags(cloneCmd, args)
+	if len(positional) != 2 {
+		exitUsage("Usage: multiprof clone-profile <src> <dst> [--home <h>] [--exclude <glob> ...]")
+	}
+	config, _ := loadConfig(readWrite)
+	src, ok := config.findProfile(positional[0])
+	if !ok {
+		exitFailure(errNotFound, "Run 'multiprof list' to see them.", "No profile is named or has the home '%s'.", positional[0])
+	}
+	if src.Encrypted {
+		exitFailure(errRefusedChange, "", "'%s' has an encrypted home, which clone-profile would copy unencrypted.", src.label())
+	}
+	if !fileExists(src.Home) {
+		exitFailure(errNotFound, "", "The home of '%s', %s, does not exist.", src.label(), src.Home)
+	}
+	name := positional[1]
+	home := newProfileHome(config, name, *homeFlag)
+	var exclude []matcher
+	for _, pattern := range append(slices.Clone(defaultCloneExcludes), excludeFlag...) {
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			exitUsage("Invalid exclude '%s': %v", pattern, err)
+		}
+		exclude = append(exclude, g)
+	}
+
+	tx := newTransaction(readWrite)
+	if err := tx.MkdirAll(home, 0700); err != nil {
+		tx.abort("Could not create home: %v", err)
+	}
+	if err := tx.CopyTree(src.Home, home, exclude...); err != nil {
+		tx.abort("Could not copy the home of '%s': %v", src.label(), err)
+	}
+	clone := src.Profile
+	clone.Home = tildePath(home)
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]Profile)
+	}
+	config.Profiles[name] = clone
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+
+	logSuccess("Cloned '%s' into profile '%s' with home %s.", src.label(), name, tildePath(home))
+	logInfo("Use it in a Rule with: multiprof add-rule --pattern '<dir>/**' --profile %s", name)
+}
+
+func runDeleteProfile(args []string) {
+	deleteCmd := flag.NewFlagSet("delete-profile", flag.ExitOnError)
+	purge := deleteCmd.Bool("purge", false, "Also delete the profile's home directory.")
+	yes := deleteCmd.Bool("yes", false, "Do not ask for confirmation.")
+	positional := parseFlags(deleteCmd, args)
+	if len(positional) != 1 {
+		exitUsage("Usage: multiprof delete-profile <name> [--purge [--yes]]")
+	}
+	name := positional[0]
+	config, _ := loadConfig(readWrite)
+	profile, ok := config.Profiles[name]
+	if !ok {
+		exitFailure(errNotFound, "Run 'multiprof list' to see them.", "No profile is named '%s'.", name)
+	}
+	if path, ok := config.included["profiles."+name]; ok {
+		exitFailure(errRefusedChange, "Remove it there.", "Profile '%s' is defined in %s.", name, path)
+	}
+	var users []string
+	for i, rule := range config.Rules {
+		if rule.Profile == name {
+			users = append(users, ruleOrigin(i, rule))
+		}
+	}
+	if len(users) > 0 {
+		exitFailure(errRefusedChange, "Change or remove those Rules first.", "Profile '%s' is still used by %s.", name, strings.Join(users, ", "))
+	}
+	p := namedProfile{Name: name, Profile: profile, Home: expandPath(profile.Home)}
+	purgeHome := *purge && fileExists(p.Home)
+	if purgeHome {
+		if problem := purgeProblem(config, p); problem != "" {
+			exitFailure(errRefusedChange, "Run without --purge to keep it.", "Refusing to delete %s: %s.", p.Home, problem)
+		}
+		if !*yes {
+			if !isTerminal(os.Stdin) {
+				exitFailure(errRefusedChange, "Re-run with --yes.", "Refusing to purge without confirmation.")
+			}
+			if !confirm(fmt.Sprintf("Delete '%s' and everything in it?", p.Home)) {
+				logInfo("Aborted.")
+				return
+			}
+		}
+	}
+	if agentRunning(p.Home) {
+		if err := stopAgent(p); err != nil {
+			logWarn("Could not stop the ssh-agent of '%s': %v", name, err)
+		}
+	}
+
+	tx := newTransaction(readWrite)
+	delete(config.Profiles, name)
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	if purgeHome {
+		if err := tx.Remove(p.Home); err != nil {
+			tx.abort("Could not delete '%s': %v", p.Home, err)
+		}
+	}
+	tx.Commit()
+
+	logSuccess("Deleted profile '%s'.", name)
+	if purgeHome {
+		logSuccess("Deleted its home %s.", tildePath(p.Home))
+	} else if fileExists(p.Home) {
+		logInfo("Its home %s was kept. Use --purge to delete it too.", tildePath(p.Home))
+	}
+}
+
+func runRenameProfile(args []string) {
+	renameCmd := flag.NewFlagSet("rename-profile", flag.ExitOnError)
+	moveHome := renameCmd.Bool("move-home", false, "Also move the home to <profiles_dir>/<new>.")
+	homeFlag := renameCmd.String("home", "", "Also move the home to this directory.")
+	positional := parseFlags(renameCmd, args)
+	if len(positional) != 2 {
+		exitUsage("Usage: multiprof rename-profile <old> <new> [--move-home | --home <h>]")
+	}
+	oldName, newName := positional[0], positional[1]
+	config, _ := loadConfig(readWrite)
+	profile, ok := config.Profiles[oldName]
+	if !ok {
+		exitFailure(errNotFound, "Run 'multiprof list' to see them.", "No profile is named '%s'.", oldName)
+	}
+	if path, ok := config.included["profiles."+oldName]; ok {
+		exitFailure(errRefusedChange, "Rename it there.", "Profile '%s' is defined in %s.", oldName, path)
+	}
+	checkNewProfileName(config, newName)
+	var rules []int
+	for i, rule := range config.Rules {
+		if rule.Profile != oldName {
+			continue
+		}
+		if rule.source != "" {
+			exitFailure(errRefusedChange, "Rename the profile there first.", "%s uses profile '%s' and is not in your config file.", ruleOrigin(i, rule), oldName)
+		}
+		rules = append(rules, i)
+	}
+
+	p := namedProfile{Name: oldName, Profile: profile, Home: expandPath(profile.Home)}
+	home := expandPath(*homeFlag)
+	if home == "" && *moveHome {
+		home = filepath.Join(profilesDir(config), newName)
+	}
+	if home != "" {
+		switch {
+		case p.Encrypted:
+			exitFailure(errRefusedChange, "", "'%s' has an encrypted home, which rename-profile cannot move.", oldName)
+		case !fileExists(p.Home):
+			exitFailure(errNotFound, "", "The home of '%s', %s, does not exist.", oldName, p.Home)
+		case fileExists(home):
+			exitFailure(errExists, "Pass --home to choose another directory.", "'%s' already exists.", home)
+		}
+		if problem := purgeProblem(config, p); problem != "" {
+			exitFailure(errRefusedChange, "", "Refusing to move %s: %s.", p.Home, problem)
+		}
+		// The agent's socket is in the home.
+		if agentRunning(p.Home) {
+			if err := stopAgent(p); err != nil {
+				exitFailure(errFailed, "", "Could not stop the ssh-agent of '%s': %v", oldName, err)
+			}
+			logInfo("Stopped the ssh-agent of '%s'; it starts again on next use or with 'multiprof agent start %s'.", oldName, newName)
+		}
+	}
+
+	tx := newTransaction(readWrite)
+	if home != "" {
+		if err := tx.MkdirAll(filepath.Dir(home), 0755); err != nil {
+			tx.abort("Could not create '%s': %v", filepath.Dir(home), err)
+		}
+		if err := tx.Rename(p.Home, home); err != nil {
+			tx.abort("Could not move the home: %v", err)
+		}
+		if marker := lastUsedPath(p.Home); fileExists(marker) && !fileExists(lastUsedPath(home)) {
+			if err := tx.Rename(marker, lastUsedPath(home)); err != nil {
+				tx.abort("Could not move the last-used marker: %v", err)
+			}
+		}
+		profile.Home = tildePath(home)
+	}
+	delete(config.Profiles, oldName)
+	config.Profiles[newName] = profile
+	for _, i := range rules {
+		config.Rules[i].Profile = newName
+	}
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+
+	logSuccess("Renamed profile '%s' to '%s' and updated %d Rule(s).", oldName, newName, len(rules))
+	if home != "" {
+		logSuccess("Moved its home to %s.", tildePath(home))
+	}
+}
+
+// purgeProblem returns why p's home must not be deleted along with it, or
+// "" if it may be.
+func purgeProblem(config Config, p namedProfile) string {
+	if p.Encrypted {
+		return "it is an encrypted home's mount point"
+	}
+	own, _ := userHomeDir()
+	configPath, _ := getConfigPath()
+	stateDir, _ := getStateDir()
+	for _, dir := range []string{own, configPath, stateDir} {
+		if within(p.Home, dir) {
+			return fmt.Sprintf("it is or contains %s", tildePath(dir))
+		}
+	}
+	for _, other := range config.allProfiles() {
+		if other.Name != p.Name && other.Home == p.Home {
+			return fmt.Sprintf("it is also the home of '%s'", other.label())
+		}
+	}
+	return ""
+}
+
+// checkNewProfileName exits unless a profile can be added under name.
+func checkNewProfileName(config Config, name string) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		exitUsage("Invalid profile name '%s'.", name)
+	}
+	if _, exists := config.Profiles[name]; exists {
+		exitFailure(errExists, "", "A profile named '%s' already exists.", name)
+	}
+}
+
+// newProfileHome checks that a profile can be created under name and returns
+// its home: homeFlag, or a directory named after it in profiles_dir. The home
+// may exist, but only if it is empty.
+func newProfileHome(config Config, name, homeFlag string) string {
+	checkNewProfileName(config, name)
+	home := expandPath(homeFlag)
+	if home == "" {
+		home = filepath.Join(profilesDir(config), name)
+	}
+	if entries, err := os.ReadDir(home); err == nil && len(entries) > 0 {
+		exitFailure(errExists, "Pass --home to choose another directory.", "'%s' already exists and is not empty.", home)
+	}
+	return home
+}
+
+// seedSource returns the directory to seed a new home from, given --from,
+// and the paths to copy from it; nil means all of it.
+func seedSource(config Config, from string) (string, []string) {
+	if from == "" {
+		if dir := defaultSkeletonDir(); fileExists(dir) {
+			return dir, nil
+		}
+		return "", nil
+	}
+	if p, ok := config.findProfile(from); ok {
+		return p.Home, splitList(defaultSeedDotfiles)
+	}
+	dir := expandPath(from)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		exitFailure(errNotFound, "", "'%s' is neither a profile nor a directory.", from)
+	}
+	if own, _ := userHomeDir(); filepath.Clean(dir) == filepath.Clean(own) {
+		return dir, splitList(defaultSeedDotfiles)
+	}
+	return dir, nil
+}
+
+// seedHome copies paths from source into home, or all of source if paths is
+// nil, and returns the paths it copied. Paths missing from source are skipped.
+func seedHome(tx *transaction, source, home string, paths []string) ([]string, error) {
+	if paths == nil {
+		if err := tx.CopyTree(source, home); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	var copied []string
+	for _, rel := range paths {
+		src := filepath.Join(source, rel)
+		if !fileExists(src) {
+			debugf("Skipping '%s': not in %s", rel, source)
+			continue
+		}
+		dst := filepath.Join(home, rel)
+		if err := tx.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return nil, err
+		}
+		if err := tx.CopyTree(src, dst); err != nil {
+			return nil, err
+		}
+		copied = append(copied, rel)
+	}
+	return copied, nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- Profiles ---
+//
+// A Profile is a named home defined once under [profiles] and referenced by
+// any number of Rules via `profile = "<name>"`. Rules that set `home` inline
+// behave as an anonymous profile identified by that home.
+
+// namedProfile is a Profile resolved for use, with its home expanded.
+type namedProfile struct {
+	Name string
+	Profile
+	Home string
+}
+
+// label returns how the profile is shown to the user.
+func (p namedProfile) label() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Home
+}
+
+// resolveProfile returns the profile a Rule selects.
+func (c Config) resolveProfile(rule Rule) (namedProfile, error) {
+	if rule.Profile == "" {
+		if rule.Home == "" {
+			return namedProfile{}, fmt.Errorf("Rule '%s' sets neither 'profile' nor 'home'", rule.Pattern)
+		}
+		return namedProfile{Profile: Profile{HomeSpec: rule.HomeSpec}, Home: expandPath(rule.Home)}, nil
+	}
+	if rule.Home != "" {
+		return namedProfile{}, fmt.Errorf("Rule '%s' sets both 'profile' and 'home'", rule.Pattern)
+	}
+	profile, ok := c.Profiles[rule.Profile]
+	if !ok {
+		return namedProfile{}, fmt.Errorf("Rule '%s' refers to unknown profile '%s'", rule.Pattern, rule.Profile)
+	}
+	if profile.Home == "" {
+		return namedProfile{}, fmt.Errorf("profile '%s' has no 'home'", rule.Profile)
+	}
+	return namedProfile{Name: rule.Profile, Profile: profile, Home: expandPath(profile.Home)}, nil
+}
+
+// allProfiles returns the named profiles sorted by name, followed by the
+// anonymous profiles of Rules with inline homes. Each home appears once.
+func (c Config) allProfiles() []namedProfile {
+	var profiles []namedProfile
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := namedProfile{Name: name, Profile: c.Profiles[name], Home: expandPath(c.Profiles[name].Home)}
+		if p.Home != "" && !seen[p.Home] {
+			seen[p.Home] = true
+			profiles = append(profiles, p)
+		}
+	}
+	for _, rule := range c.Rules {
+		if rule.Profile != "" || rule.Home == "" {
+			continue
+		}
+		p, _ := c.resolveProfile(rule)
+		if !seen[p.Home] {
+			seen[p.Home] = true
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles
+}
+
+// findProfile looks a profile up by name or by home directory.
+func (c Config) findProfile(nameOrHome string) (namedProfile, bool) {
+	home := expandPath(nameOrHome)
+	for _, p := range c.allProfiles() {
+		if p.Name == nameOrHome || p.Home == home {
+			return p, true
+		}
+	}
+	return namedProfile{}, false
+}
+
+// expandForHome expands ~ and environment variables in value, with $HOME
+// referring to the profile's home rather than the caller's.
+func expandForHome(value, home string) string {
+	if value == "~" || strings.HasPrefix(value, "~/") {
+		value = filepath.Join(home, value[1:])
+	}
+	return os.Expand(value, func(key string) string {
+		if key == "HOME" {
+			return home
+		}
+		return os.Getenv(key)
+	})
+}
+
+func profileOrigin(p namedProfile) string {
+	return fmt.Sprintf("profile '%s'", p.label())
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// --- Project Config ---
+//
+// A project can ship a .multiprof.toml in its root with [[rules]], [profiles]
+// and [presets] in the same format as the global config. Wrappers find it by
+// walking up from the directory they match on. Its Rules are checked before
+// the global ones, glob patterns that are not absolute are relative to the
+// project root, and its profiles and presets shadow global ones of the same
+// name for its own Rules only.
+//
+// Because a project file can point HOME and environment variables anywhere,
+// it is ignored until the user runs `multiprof trust`, which records a hash of
+// its contents. Any later change to the file needs to be trusted again.
+
+const (
+	projectConfigName = ".multiprof.toml"
+	trustDBName       = "trusted-projects"
+	// distrusted marks a project file the user chose to ignore.
+	distrusted = "-"
+)
+
+// findProjectConfig returns the nearest .multiprof.toml in dir or above.
+func findProjectConfig(dir string) (string, bool) {
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		path := filepath.Join(dir, projectConfigName)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, true
+		}
+		if filepath.Dir(dir) == dir {
+			return "", false
+		}
+	}
+}
+
+// loadProjectConfig returns the trusted project config covering dir. Untrusted
+// project files are reported on stderr and ignored.
+func loadProjectConfig(dir string) (Config, bool) {
+	path, ok := findProjectConfig(dir)
+	if !ok {
+		return Config{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		debugf("Could not read project config '%s': %v", path, err)
+		return Config{}, false
+	}
+	switch trusted := readTrustDB()[path]; trusted {
+	case hashContent(data):
+	case distrusted:
+		debugf("Ignoring distrusted project config '%s'", path)
+		return Config{}, false
+	case "":
+		fmt.Fprintf(os.Stderr, "[WARN] Ignoring untrusted project config %s. Review it and run `multiprof trust %s` to use it.\n", path, filepath.Dir(path))
+		return Config{}, false
+	default:
+		fmt.Fprintf(os.Stderr, "[WARN] Ignoring project config %s: it changed since you trusted it. Review it and run `multiprof trust %s` again.\n", path, filepath.Dir(path))
+		return Config{}, false
+	}
+
+	var project Config
+	if _, err := toml.Decode(string(data), &project); err != nil {
+		fmt.Fprintf(os.Stderr, "[WARN] Ignoring project config %s: %v\n", path, err)
+		return Config{}, false
+	}
+	root := filepath.Dir(path)
+	for i := range project.Rules {
+		rule := &project.Rules[i]
+		if rule.PatternType != patternRegex {
+			rule.Pattern = projectPattern(root, rule.Pattern)
+		}
+		for j, exclude := range rule.Exclude {
+			rule.Exclude[j] = projectPattern(root, exclude)
+		}
+		rule.source = path
+	}
+	return project, true
+}
+
+// projectPattern makes a relative glob relative to the project root.
+func projectPattern(root, pattern string) string {
+	if filepath.IsAbs(pattern) || strings.HasPrefix(pattern, "~") {
+		return pattern
+	}
+	joined := filepath.Join(root, pattern)
+	if strings.HasSuffix(pattern, "/") {
+		joined += "/"
+	}
+	return joined
+}
+
+// withProfilesFrom returns c with the profiles and presets of project added,
+// replacing those of the same name in c.
+func (c Config) withProfilesFrom(project Config) Config {
+	profiles := make(map[string]Profile, len(c.Profiles)+len(project.Profiles))
+	for name, p := range c.Profiles {
+		profiles[name] = p
+	}
+	for name, p := range project.Profiles {
+		profiles[name] = p
+	}
+	presets := make(map[string]map[string]string, len(c.Presets)+len(project.Presets))
+	for name, p := range c.Presets {
+		presets[name] = p
+	}
+	for name, p := range project.Presets {
+		presets[name] = p
+	}
+	c.Profiles, c.Presets = profiles, presets
+	return c
+}
+
+func hashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readTrustDB maps project config paths to the hash of their trusted
+// contents, or to "-" for files the user distrusted.
+func readTrustDB() map[string]string {
+	db := make(map[string]string)
+	f, err := os.Open(stateFile(trustDBName))
+	if err != nil {
+		return db
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if hash, path, ok := strings.Cut(scanner.Text(), " "); ok {
+			db[path] = hash
+		}
+	}
+	return db
+}
+
+func writeTrustDB(db map[string]string) error {
+	if err := ensureStateDir(readWrite); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, path := range sortedKeys(db) {
+		fmt.Fprintf(&b, "%s %s\n", db[path], path)
+	}
+	tx := newTransaction(readWrite)
+	if err := tx.WriteFile(stateFile(trustDBName), []byte(b.String()), 0600); err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+// runTrust trusts (or with trust=false, distrusts) the project config
+// covering a directory.
+func runTrust(args []string, trust bool) {
+	name := map[bool]string{true: "trust", false: "untrust"}[trust]
+	if len(args) > 1 {
+		exitUsage("Usage: multiprof %s [dir]", name)
+	}
+	dir := dirArg(args)
+	path, ok := findProjectConfig(dir)
+	if !ok {
+		exitFailure(errNotFound, "", "No %s found in '%s' or any directory above it.", projectConfigName, dir)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		exitFailure(errFailed, "", "Could not read '%s': %v", path, err)
+	}
+
+	db := readTrustDB()
+	if trust {
+		db[path] = hashContent(data)
+	} else {
+		db[path] = distrusted
+	}
+	if err := writeTrustDB(db); err != nil {
+		exitFailure(errFailed, "", "Could not update the trust database: %v", err)
+	}
+	if trust {
+		logSuccess("Trusted %s. Wrappers below %s now use its Rules.", path, filepath.Dir(path))
+		logInfo("If the file changes, you will be asked to trust it again.")
+	} else {
+		logSuccess("Wrappers will ignore %s without warning.", path)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Read-Only Wrappers ---
+//
+// A Wrapper only reads multiprof's own files: the config, the Wrappers and
+// their completions are changed by management commands alone, so a Wrapper
+// behaves the same in a locked-down account or on a read-only home.
+// Wrappers, the shell hook, prompts and completion run with readOnly access,
+// which loadConfig, transactions and the state directory are given
+// explicitly: the config is never created, a transaction touching these
+// directories fails with errReadOnly, and the state directory (config
+// cache, stats, last-used markers, all best effort) is only written if it
+// already exists. Homes the config asks for, such as those with autocreate
+// or ssh_agent, are still created. The one exception is a Rule the user
+// asks to keep after picking a profile at the terminal (see picker.go).
+
+// access is what a command may change. The zero value is readOnly, so only
+// a command that asks for it may change anything.
+type access int
+
+const (
+	readOnly access = iota
+	readWrite
+)
+
+var errReadOnly = errors.New("Wrappers do not change multiprof's config, Wrappers or completions")
+
+// readOnlyDirs returns the directories a Wrapper must not change.
+func readOnlyDirs() []string {
+	configPath, _ := getConfigPath()
+	return append(append(wrapperDirs(), filepath.Dir(configPath)), completionDirs()...)
+}
+
+// checkWritable returns errReadOnly if a is readOnly and path is in one of
+// readOnlyDirs or is a parent of one.
+func (a access) checkWritable(path string) error {
+	if a != readOnly {
+		return nil
+	}
+	path = filepath.Clean(path)
+	for _, dir := range readOnlyDirs() {
+		if within(dir, path) || within(path, dir) {
+			return fmt.Errorf("%w: refusing to change %s", errReadOnly, path)
+		}
+	}
+	return nil
+}
+
+// within reports whether path is dir or inside it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Workspace Report ---
+//
+// `multiprof report` puts everything multiprof knows about your setup on
+// one page: each profile with its identity, the Rules selecting it, the disk
+// space its home takes and when a Wrapper last used it, then the Wrappers and
+// every problem `check` would report. It is meant to be read now and then,
+// or attached to a handover when a project ends; --json prints the same for
+// scripts. Measuring homes walks them, so a report of large homes takes a
+// moment.
+
+type reportRule struct {
+	Index   int    `json:"index"`
+	Pattern string `json:"pattern"`
+}
+
+type reportProfile struct {
+	Name        string            `json:"name,omitempty"`
+	Home        string            `json:"home"`
+	Identity    map[string]string `json:"identity,omitempty"`
+	GitIdentity string            `json:"git_identity,omitempty"`
+	Rules       []reportRule      `json:"rules"`
+	DiskUsage   int64             `json:"disk_usage_bytes"`
+	LastUsed    *time.Time        `json:"last_used,omitempty"`
+}
+
+type reportWrapper struct {
+	Command string `json:"command"`
+	Path    string `json:"path"`
+	State   string `json:"state"`
+}
+
+type report struct {
+	Generated time.Time       `json:"generated"`
+	Config    string          `json:"config"`
+	Profiles  []reportProfile `json:"profiles"`
+	Wrappers  []reportWrapper `json:"wrappers"`
+	Problems  []string        `json:"problems"`
+}
+
+func runReport(args []string) {
+	reportCmd := flag.NewFlagSet("report", flag.ExitOnError)
+	asJSON := reportCmd.Bool("json", false, "Print the report as JSON.")
+	noPager := reportCmd.Bool("no-pager", false, "Print everything at once instead of one screen at a time.")
+	if positional := parseFlags(reportCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof report [--json] [--no-pager]")
+	}
+	config, _ := loadConfig(readWrite)
+	r := buildReport(config)
+	if *asJSON || jsonOutput {
+		printJSON(r)
+		return
+	}
+	out := newPager(!*noPager)
+	writeReport(out, r)
+	out.Close()
+}
+
+func buildReport(config Config) report {
+	configPath, _ := getConfigPath()
+	r := report{Generated: time.Now().Round(time.Second), Config: configPath, Profiles: []reportProfile{}, Wrappers: []reportWrapper{}}
+	for _, p := range config.allProfiles() {
+		rp := reportProfile{Name: p.Name, Home: p.Home, Identity: p.Identity, GitIdentity: homeGitIdentity(p.Home), Rules: []reportRule{}}
+		for i, rule := range config.Rules {
+			if selected, err := config.resolveProfile(rule); err == nil && selected.Home == p.Home {
+				rp.Rules = append(rp.Rules, reportRule{Index: i + 1, Pattern: rule.Pattern})
+			}
+		}
+		rp.DiskUsage = diskUsage(p.Home)
+		if t, err := profileLastUsed(p.Home); err == nil {
+			rp.LastUsed = &t
+		}
+		r.Profiles = append(r.Profiles, rp)
+	}
+	wrapperDir, _ := getWrapperDir()
+	for _, cmdName := range slices.Sorted(maps.Keys(config.Wrappers)) {
+		path := filepath.Join(wrapperDir, config.wrapperName(cmdName))
+		r.Wrappers = append(r.Wrappers, reportWrapper{Command: cmdName, Path: path, State: wrapperState(config, cmdName)})
+	}
+	r.Problems = validateConfig(config)
+	for _, p := range config.allProfiles() {
+		for _, warning := range homePlacementWarnings(config, p) {
+			r.Problems = append(r.Problems, fmt.Sprintf("Profile '%s': %s", p.label(), warning))
+		}
+	}
+	for _, w := range r.Wrappers {
+		if w.State != wrapperOK {
+			r.Problems = append(r.Problems, fmt.Sprintf("Wrapper '%s' is %s; run 'multiprof sync-wrappers'", w.Command, w.State))
+		}
+	}
+	if r.Problems == nil {
+		r.Problems = []string{}
+	}
+	return r
+}
+
+func writeReport(w io.Writer, r report) {
+	fmt.Fprintf(w, "multiprof report, %s\nConfig: %s\n\n", r.Generated.Format("2006-01-02 15:04"), tildePath(r.Config))
+	fmt.Fprintln(w, "--- Profiles ---")
+	if len(r.Profiles) == 0 {
+		fmt.Fprintln(w, "No profiles.")
+	}
+	for _, p := range r.Profiles {
+		if p.Name != "" {
+			fmt.Fprintln(w, p.Name)
+		} else {
+			fmt.Fprintln(w, "(inline home)")
+		}
+		fmt.Fprintf(w, "  Home:      %s (%s)\n", tildePath(p.Home), formatSize(p.DiskUsage))
+		if len(p.Identity) > 0 {
+			var pairs []string
+			for _, key := range sortedKeys(p.Identity) {
+				pairs = append(pairs, key+"="+p.Identity[key])
+			}
+			fmt.Fprintf(w, "  Identity:  %s\n", strings.Join(pairs, ", "))
+		}
+		if p.GitIdentity != "" {
+			fmt.Fprintf(w, "  Git:       %s\n", p.GitIdentity)
+		}
+		if p.LastUsed != nil {
+			fmt.Fprintf(w, "  Last used: %s (%s ago)\n", p.LastUsed.Format("2006-01-02 15:04"), formatAge(time.Since(*p.LastUsed)))
+		} else {
+			fmt.Fprintln(w, "  Last used: never")
+		}
+		if len(p.Rules) == 0 {
+			fmt.Fprintln(w, "  Rules:     none")
+		}
+		for i, rule := range p.Rules {
+			label := "  Rules:    "
+			if i > 0 {
+				label = "           "
+			}
+			fmt.Fprintf(w, "%s %d: '%s'\n", label, rule.Index, rule.Pattern)
+		}
+	}
+
+	fmt.Fprintln(w, "\n--- Wrappers ---")
+	if len(r.Wrappers) == 0 {
+		fmt.Fprintln(w, "No Wrappers.")
+	} else {
+		t := newTable(1, "COMMAND", "WRAPPER", "STATE")
+		for _, wr := range r.Wrappers {
+			t.add(wr.Command, tildePath(wr.Path), wr.State)
+		}
+		t.render(w, terminalWidth())
+	}
+
+	fmt.Fprintln(w, "\n--- Problems ---")
+	if len(r.Problems) == 0 {
+		fmt.Fprintln(w, "None found.")
+	}
+	for _, problem := range r.Problems {
+		fmt.Fprintf(w, "  - %s\n", problem)
+	}
+}
+
+// homeGitIdentity describes the git user set in home's .gitconfig or the
+// files it includes.
+func homeGitIdentity(home string) string {
+	gitconfig := filepath.Join(home, ".gitconfig")
+	if !fileExists(gitconfig) {
+		return ""
+	}
+	paths := append([]string{gitconfig}, readGitConfig(gitconfig)["include"]["path"]...)
+	return gitIdentity(gitconfig, paths)
+}
+
+// diskUsage returns the size of the files under dir, skipping what it
+// cannot read.
+func diskUsage(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// formatSize formats a number of bytes, e.g. 1.2 GB.
+func formatSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+// formatAge formats a duration coarsely, e.g. 3 days.
+func formatAge(d time.Duration) string {
+	switch {
+	case d < 2*time.Hour:
+		return fmt.Sprintf("%d min", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
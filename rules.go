@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// --- Rule Editing ---
+//
+// Commands that modify existing Rules. Rules are addressed by the 1-based
+// index shown by `multiprof list`, which is also their priority.
+
+func runMoveRule(args []string) {
+	if len(args) != 2 {
+		exitUsage("Usage: multiprof move-rule <from> <to>")
+	}
+	config, _ := loadConfig(readWrite)
+	from := parseRuleIndex(config, args[0])
+	to := parseRuleIndex(config, args[1])
+	moveRule(config, from, to)
+}
+
+// runShiftRule implements promote (delta -1) and demote (delta +1).
+func runShiftRule(args []string, delta int) {
+	if len(args) != 1 {
+		exitUsage("Usage: multiprof promote|demote <index>")
+	}
+	config, _ := loadConfig(readWrite)
+	from := parseRuleIndex(config, args[0])
+	to := from + delta
+	if to < 0 {
+		logInfo("Rule %d already has the highest priority.", from+1)
+		return
+	}
+	if to >= len(config.Rules) || config.Rules[to].source != "" {
+		logInfo("Rule %d already has the lowest priority.", from+1)
+		return
+	}
+	moveRule(config, from, to)
+}
+
+func moveRule(config Config, from, to int) {
+	if from == to {
+		logInfo("Rule %d is already at position %d.", from+1, to+1)
+		return
+	}
+	rule := config.Rules[from]
+	rules := append(config.Rules[:from:from], config.Rules[from+1:]...)
+	rules = append(rules[:to:to], append([]Rule{rule}, rules[to:]...)...)
+	config.Rules = rules
+
+	tx := newTransaction(readWrite)
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+	logSuccess("Moved Rule '%s' from position %d to %d.", rule.Pattern, from+1, to+1)
+}
+
+// disabled reports whether the Rule is turned off with `enabled = false`.
+func (rule Rule) disabled() bool {
+	return rule.Enabled != nil && !*rule.Enabled
+}
+
+// runEnableRule implements enable-rule and disable-rule.
+func runEnableRule(args []string, enable bool) {
+	command, state := "disable-rule", "disabled"
+	if enable {
+		command, state = "enable-rule", "enabled"
+	}
+	if len(args) != 1 {
+		exitUsage("Usage: multiprof %s <index>", command)
+	}
+	config, _ := loadConfig(readWrite)
+	index := parseRuleIndex(config, args[0])
+	rule := &config.Rules[index]
+	if rule.disabled() != enable {
+		logInfo("Rule %d ('%s') is already %s.", index+1, rule.Pattern, state)
+		return
+	}
+	if enable {
+		rule.Enabled = nil
+	} else {
+		rule.Enabled = new(bool)
+	}
+	tx := newTransaction(readWrite)
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+	if enable {
+		logSuccess("Enabled Rule %d ('%s').", index+1, rule.Pattern)
+	} else {
+		logSuccess("Disabled Rule %d ('%s'); 'multiprof enable-rule %d' turns it back on.", index+1, rule.Pattern, index+1)
+	}
+}
+
+// parseRuleIndex converts a 1-based Rule index argument to a slice index,
+// exiting with an error if it is out of range.
+func parseRuleIndex(config Config, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(config.Rules) {
+		exitFailure(errNotFound, ruleIndexHint(config), "Invalid Rule index '%s'.", arg)
+	}
+	if source := config.Rules[n-1].source; source != "" {
+		exitFailure(errRefusedChange, "Edit that file instead.", "Rule %d comes from %s.", n, source)
+	}
+	return n - 1
+}
+
+func ruleIndexHint(config Config) string {
+	if len(config.Rules) == 0 {
+		return "There are no Rules yet."
+	}
+	return fmt.Sprintf("Use a number from 1 to %d, as shown by 'multiprof list'.", len(config.Rules))
+}
+
+func runEditRule(args []string) {
+	editCmd := flag.NewFlagSet("edit-rule", flag.ExitOnError)
+	patternFlag := editCmd.String("pattern", "", "New pattern.")
+	homeFlag := editCmd.String("home", "", "New home directory; replaces the Rule's profile.")
+	profileFlag := editCmd.String("profile", "", "New profile from [profiles]; replaces the Rule's home.")
+	envFlag := keyValueFlag{}
+	editCmd.Var(envFlag, "env", "Set an environment variable, as KEY=VALUE. May be repeated.")
+	var unsetEnvFlag stringListFlag
+	editCmd.Var(&unsetEnvFlag, "unset-env", "Remove an environment variable. May be repeated.")
+	positional := parseFlags(editCmd, args)
+	if len(positional) != 1 || (*homeFlag != "" && *profileFlag != "") {
+		exitUsage("Usage: multiprof edit-rule <index> [--pattern <p>] [--home <h> | --profile <name>] [--env KEY=VALUE ...] [--unset-env KEY ...]")
+	}
+	config, _ := loadConfig(readWrite)
+	index := parseRuleIndex(config, positional[0])
+	rule := config.Rules[index]
+	edited := rule
+	edited.Env = make(map[string]string, len(rule.Env))
+	for key, value := range rule.Env {
+		edited.Env[key] = value
+	}
+
+	if editCmd.NFlag() == 0 {
+		if !isInteractive() {
+			exitUsage("Pass the fields to change as flags, or run edit-rule on a terminal to be prompted for them.")
+		}
+		promptRuleEdit(config, &edited)
+	} else {
+		if *patternFlag != "" {
+			edited.Pattern = *patternFlag
+		}
+		if *homeFlag != "" {
+			edited.Home, edited.Profile = *homeFlag, ""
+		}
+		if *profileFlag != "" {
+			edited.Profile, edited.Home = *profileFlag, ""
+		}
+		for key, value := range envFlag {
+			edited.Env[key] = value
+		}
+		for _, key := range unsetEnvFlag {
+			delete(edited.Env, key)
+		}
+	}
+	if len(edited.Env) == 0 {
+		edited.Env = nil
+	}
+	if _, ok := config.Profiles[edited.Profile]; edited.Profile != "" && !ok {
+		exitFailure(errNotFound, fmt.Sprintf("Define it under [profiles.%s] in your config first.", edited.Profile), "Unknown profile '%s'.", edited.Profile)
+	}
+	if _, err := compilePattern(edited); err != nil {
+		exitWith(err)
+	}
+
+	if reflect.DeepEqual(rule, edited) {
+		logInfo("Rule %d is unchanged.", index+1)
+		return
+	}
+
+	config.Rules[index] = edited
+	tx := newTransaction(readWrite)
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+	logSuccess("Updated Rule %d: when in '%s', use %s.", index+1, edited.Pattern, describeTarget(edited))
+}
+
+// promptRuleEdit asks for new values of a Rule's pattern, target and
+// environment, keeping the current ones by default.
+func promptRuleEdit(config Config, rule *Rule) {
+	w := wizard{bufio.NewReader(os.Stdin)}
+	fmt.Println("Editing a Rule. Press Enter to keep the current value in brackets.")
+	rule.Pattern = w.ask("Pattern", rule.Pattern)
+	current := rule.Home
+	if rule.Profile != "" {
+		current = rule.Profile
+	}
+	if target := w.ask("Profile name or home directory", current); target != current {
+		if _, ok := config.Profiles[target]; ok {
+			rule.Profile, rule.Home = target, ""
+		} else {
+			rule.Home, rule.Profile = target, ""
+		}
+	}
+	for _, key := range sortedKeys(rule.Env) {
+		fmt.Printf("  %s=%s\n", key, rule.Env[key])
+	}
+	for {
+		answer := w.ask("Environment change (KEY=VALUE to set, KEY= to remove, Enter when done)", "")
+		if answer == "" {
+			return
+		}
+		key, value, ok := strings.Cut(answer, "=")
+		switch {
+		case !ok || key == "":
+			logWarn("Expected KEY=VALUE.")
+		case value == "":
+			delete(rule.Env, key)
+		default:
+			rule.Env[key] = value
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+)
+
+// --- Ad-hoc Commands ---
+//
+// `multiprof run -- <command> [args...]` runs any command the way a Wrapper
+// for it would, without creating the Wrapper first. -p picks the profile
+// directly instead of matching the current directory against the Rules.
+
+func runRun(args []string) {
+	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
+	profileFlag := runCmd.String("p", "", "Profile to use, by name or home (default: the one matching the current directory).")
+	runCmd.BoolVar(&dryRun, "dry-run", false, "Print what would be run instead of running it.")
+	runCmd.BoolVar(&explainError, "explain-error", false, "On failure, show how the Rules were matched.")
+	runCmd.BoolVar(&supervise, "supervise", false, "Run the command as a child process and record how it ended.")
+	runCmd.Parse(args)
+	command := runCmd.Args()
+	if len(command) == 0 {
+		exitUsage("Usage: multiprof run [-p profile] [--dry-run] [--explain-error] [--supervise] -- <command> [args...]")
+	}
+
+	invokedCommand = filepath.Base(command[0])
+	config, err := loadConfig(readWrite)
+	checkStrict(config, err, "Could not load the config")
+	var res resolution
+	if *profileFlag != "" {
+		p, ok := config.withPackProfiles(loadPacks()).findProfile(*profileFlag)
+		if !ok {
+			exitFailure(errNotFound, "Run 'multiprof list' to see them.", "No profile named '%s' or with that home exists.", *profileFlag)
+		}
+		res = resolution{Index: -1, Profile: p}
+	} else {
+		cwd, err := os.Getwd()
+		checkStrict(config, err, "Could not determine the current directory")
+		if res, err = resolveForExec(config, cwd, daemonAnswer{}); errors.Is(err, errPassthrough) {
+			passThrough(command[0], command)
+		} else if err != nil {
+			exitWith(err)
+		}
+	}
+	execTarget(readWrite, res, command[0], command)
+}
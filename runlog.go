@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+)
+
+// --- Run Log ---
+//
+// `multiprof log` lists recent Wrapper runs from the stats journal. How a run
+// ended is recorded for targets run by the spawn backend (see spawn.go) and
+// for runs the Wrapper refused or could not start; targets that replace the
+// Wrapper via exec show "-". --failures lists only the failed runs, grouped
+// by profile.
+
+const defaultLogLimit = 20
+
+func runLog(args []string) {
+	logCmd := flag.NewFlagSet("log", flag.ExitOnError)
+	failures := logCmd.Bool("failures", false, "Only list failed runs, grouped by profile.")
+	profile := logCmd.String("profile", "", "Only list runs in this profile.")
+	limit := logCmd.Int("n", defaultLogLimit, "How many runs to list (per profile with --failures).")
+	noPager := logCmd.Bool("no-pager", false, "Print everything at once instead of one screen at a time.")
+	audit := logCmd.Bool("audit", false, "List runs from the audit log, with their Rule and full arguments.")
+	since := logCmd.String("since", "", "With --audit, only list runs in this period, e.g. 7d or 12h.")
+	if positional := parseFlags(logCmd, args); len(positional) > 0 || *limit < 1 || (*since != "" && !*audit) {
+		exitUsage("Usage: multiprof log [--failures] [--profile <name>] [-n <count>] [--audit [--since <age>]] [--no-pager]")
+	}
+	if *audit {
+		var age time.Duration
+		if *since != "" {
+			var err error
+			if age, err = parseAge(*since); err != nil {
+				exitWith(err)
+			}
+		}
+		runAuditLog(*profile, *failures, age, *limit, *noPager)
+		return
+	}
+
+	var runs []statRecord
+	corrupt, err := readJournal(stateFile(statsJournalName), func(line []byte) error {
+		var r statRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if (!*failures || r.Outcome.failed()) && (*profile == "" || r.Profile == *profile) {
+			runs = append(runs, r)
+		}
+		return nil
+	})
+	if err != nil {
+		exitFailure(errFailed, "", "Could not read the run log: %v", err)
+	}
+	if len(runs) == 0 && !jsonOutput {
+		if *failures {
+			logInfo("No failed runs recorded.")
+		} else {
+			logInfo("No Wrapper runs recorded yet.")
+		}
+		return
+	}
+	// Records are not necessarily in order; see journal.go.
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Time.After(runs[j].Time) })
+	if jsonOutput {
+		// A flat list, newest first; with --failures, the limit still
+		// applies per profile.
+		listed, perProfile := []statRecord{}, map[string]int{}
+		for _, r := range runs {
+			if *failures && perProfile[r.Profile] < *limit {
+				perProfile[r.Profile]++
+				listed = append(listed, r)
+			} else if !*failures && len(listed) < *limit {
+				listed = append(listed, r)
+			}
+		}
+		printJSON(listed)
+		if corrupt > 0 {
+			logWarn("Skipped %d damaged record(s); 'multiprof compact' removes them.", corrupt)
+		}
+		return
+	}
+
+	out := newPager(!*noPager)
+	width := terminalWidth()
+	if *failures {
+		byProfile := make(map[string][]statRecord)
+		for _, r := range runs {
+			byProfile[r.Profile] = append(byProfile[r.Profile], r)
+		}
+		for _, name := range slices.Sorted(maps.Keys(byProfile)) {
+			failed := byProfile[name]
+			fmt.Fprintf(out, "--- %s (%d failed runs) ---\n", name, len(failed))
+			printRuns(out, failed[:min(*limit, len(failed))], width)
+		}
+	} else {
+		printRuns(out, runs[:min(*limit, len(runs))], width)
+	}
+	out.Close()
+	if corrupt > 0 {
+		logWarn("Skipped %d damaged record(s); 'multiprof compact' removes them.", corrupt)
+	}
+}
+
+// printRuns renders runs as a table, newest first.
+func printRuns(w io.Writer, runs []statRecord, width int) {
+	t := newTable(4, "TIME", "PROFILE", "STATUS", "TOOK", "COMMAND")
+	for _, r := range runs {
+		t.add(r.Time.Format("2006-01-02 15:04:05"), r.Profile, r.Outcome.String(), r.Outcome.duration(), r.Command)
+	}
+	t.render(w, width)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// --- Project Scaffolding ---
+//
+// `multiprof new <client> <dir>` performs the whole onboarding dance for a
+// new context in one transaction: the project directory, a profile home
+// seeded from the skeleton, the profile and Rule, and standard Wrappers.
+// `multiprof quickstart` is the first-run equivalent: init, one Rule, its home
+// and Wrappers, for users who have not set anything up yet.
+
+const defaultScaffoldWrappers = "git,ssh,aws"
+
+func runNew(args []string) {
+	newCmd := flag.NewFlagSet("new", flag.ExitOnError)
+	homeFlag := newCmd.String("home", "", "Profile home directory (default: <profiles_dir>/<client>).")
+	templateFlag := newCmd.String("template", "", "Directory to seed the profile home from (default: ~/.config/multiprof/skel, if present).")
+	wrapFlag := newCmd.String("wrap", defaultScaffoldWrappers, "Comma-separated commands to create Wrappers for.")
+	onConflict := newCmd.String("on-conflict", conflictAsk, "What to do with existing Wrapper files multiprof did not create.")
+	shellFlag := newCmd.String("shell", "", "Shell to write completion files for: bash, zsh or fish (default: from $SHELL).")
+	positional := parseFlags(newCmd, args)
+	if len(positional) != 2 {
+		exitUsage("Usage: multiprof new <client> <dir> [--home <h>] [--template <dir>] [--wrap git,ssh,aws]")
+	}
+	if !validConflictPolicy(*onConflict) {
+		exitUsage("Invalid --on-conflict value '%s'.", *onConflict)
+	}
+	shell, err := completionShell(*shellFlag)
+	if err != nil {
+		exitWith(err)
+	}
+	client := positional[0]
+	projectDir, err := filepath.Abs(expandPath(positional[1]))
+	if err != nil {
+		exitUsage("Invalid directory '%s': %v", positional[1], err)
+	}
+
+	config, _ := loadConfig(readWrite)
+	if _, exists := config.Profiles[client]; exists {
+		exitFailure(errExists, "", "A profile named '%s' already exists.", client)
+	}
+	home := expandPath(*homeFlag)
+	if home == "" {
+		home = filepath.Join(profilesDir(config), client)
+	}
+	skeleton := expandPath(*templateFlag)
+	if skeleton == "" {
+		if dir := defaultSkeletonDir(); fileExists(dir) {
+			skeleton = dir
+		}
+	} else if !fileExists(skeleton) {
+		exitFailure(errNotFound, "", "Template directory '%s' does not exist.", skeleton)
+	}
+
+	tx := newTransaction(readWrite)
+	var created []string
+	if err := tx.MkdirAll(projectDir, 0755); err != nil {
+		tx.abort("Could not create project directory: %v", err)
+	}
+	created = append(created, "Project directory: "+projectDir)
+	if err := tx.MkdirAll(home, 0700); err != nil {
+		tx.abort("Could not create profile home: %v", err)
+	}
+	created = append(created, "Profile home:      "+home)
+	if skeleton != "" {
+		if err := tx.CopyTree(skeleton, home); err != nil {
+			tx.abort("Could not copy template '%s': %v", skeleton, err)
+		}
+		created = append(created, "Seeded home from:  "+skeleton)
+	}
+
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]Profile)
+	}
+	config.Profiles[client] = Profile{HomeSpec: HomeSpec{Home: tildePath(home)}}
+	pattern := tildePath(projectDir) + "/**"
+	if i, ok := matchRule(config, projectDir); ok {
+		logWarn("'%s' is already matched by Rule %d ('%s'); the new Rule will be shadowed by it.", projectDir, i+1, config.Rules[i].Pattern)
+	}
+	config.Rules = append(config.Rules, Rule{Pattern: pattern, Profile: client})
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	created = append(created, fmt.Sprintf("Profile '%s' and Rule '%s'", client, pattern))
+
+	var wrappers []string
+	for _, cmdName := range strings.Split(*wrapFlag, ",") {
+		if cmdName = strings.TrimSpace(cmdName); cmdName == "" {
+			continue
+		}
+		if _, err := createWrapper(tx, config, cmdName, shell, *onConflict); err == errKeptExisting {
+			continue
+		} else if err != nil {
+			tx.abort("%v", err)
+		}
+		recordWrapper(&config, cmdName)
+		wrappers = append(wrappers, config.wrapperName(cmdName))
+	}
+	if len(wrappers) > 0 {
+		if err := tx.SaveConfig(config); err != nil {
+			tx.abort("Could not save config: %v", err)
+		}
+	}
+	if len(wrappers) > 0 {
+		created = append(created, "Wrappers:          "+strings.Join(wrappers, ", "))
+	}
+	tx.Commit()
+
+	logSuccess("Set up '%s':", client)
+	for _, line := range created {
+		fmt.Println("  " + line)
+	}
+	fmt.Println()
+	logInfo("Next steps:")
+	fmt.Printf("  1. cd %s\n", tildePath(projectDir))
+	if len(wrappers) > 0 {
+		fmt.Printf("  2. Configure your tools through the Wrappers, e.g. `%s config --global user.email you@%s.example`\n", wrappers[0], client)
+	}
+	warnWrapperDirNotInPath()
+}
+
+func runQuickstart(args []string) {
+	quickCmd := flag.NewFlagSet("quickstart", flag.ExitOnError)
+	patternFlag := quickCmd.String("pattern", "", "Glob pattern for the directories of your first context.")
+	homeFlag := quickCmd.String("home", "", "The directory to use as $HOME there; created if missing.")
+	wrapFlag := quickCmd.String("wrap", "git,ssh", "Comma-separated commands to create Wrappers for.")
+	onConflict := quickCmd.String("on-conflict", conflictAsk, "What to do with existing Wrapper files multiprof did not create.")
+	shellFlag := quickCmd.String("shell", "", "Shell to write completion files for: bash, zsh or fish (default: from $SHELL).")
+	quickCmd.Parse(args)
+	if *patternFlag == "" || *homeFlag == "" || len(quickCmd.Args()) > 0 {
+		exitUsage("Usage: multiprof quickstart --pattern <p> --home <h> [--wrap git,ssh]")
+	}
+	if !validConflictPolicy(*onConflict) {
+		exitUsage("Invalid --on-conflict value '%s'.", *onConflict)
+	}
+	shell, err := completionShell(*shellFlag)
+	if err != nil {
+		exitWith(err)
+	}
+	rule := Rule{Pattern: *patternFlag, HomeSpec: HomeSpec{Home: *homeFlag}}
+	if _, err := compilePattern(rule); err != nil {
+		exitWith(err)
+	}
+	home := expandPath(*homeFlag)
+	if !filepath.IsAbs(home) {
+		exitUsage("--home must be an absolute path (or start with ~), got '%s'.", *homeFlag)
+	}
+
+	type entry struct{ what, where string }
+	var created []entry
+	tx := newTransaction(readWrite)
+	configPath, _ := getConfigPath()
+	if !fileExists(configPath) {
+		if err := createDefaultConfig(tx); err != nil {
+			tx.abort("Could not create config file: %v", err)
+		}
+		created = append(created, entry{"Config file", configPath})
+	}
+	wrapperDir, _ := getWrapperDir()
+	if !fileExists(wrapperDir) {
+		if err := tx.MkdirAll(wrapperDir, 0755); err != nil {
+			tx.abort("Could not create Wrapper Directory: %v", err)
+		}
+		created = append(created, entry{"Wrapper Directory", wrapperDir})
+	}
+	config, err := loadConfig(readWrite)
+	if err != nil {
+		tx.abort("Could not read config: %v", err)
+	}
+	for _, existing := range config.Rules {
+		if existing.Pattern == rule.Pattern {
+			tx.abort("A Rule with pattern '%s' already exists.", rule.Pattern)
+		}
+	}
+
+	if !fileExists(home) {
+		if err := tx.MkdirAll(home, 0700); err != nil {
+			tx.abort("Could not create home: %v", err)
+		}
+		created = append(created, entry{"Home", home})
+	}
+	config.Rules = append(config.Rules, rule)
+	if err := tx.SaveConfig(config); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	created = append(created, entry{"Rule", fmt.Sprintf("%d: '%s' -> '%s'", len(config.Rules), rule.Pattern, rule.Home)})
+
+	for _, cmdName := range strings.Split(*wrapFlag, ",") {
+		if cmdName = strings.TrimSpace(cmdName); cmdName == "" {
+			continue
+		}
+		if _, err := createWrapper(tx, config, cmdName, shell, *onConflict); err == errKeptExisting {
+			continue
+		} else if err != nil {
+			tx.abort("%v", err)
+		}
+		recordWrapper(&config, cmdName)
+		created = append(created, entry{"Wrapper", filepath.Join(wrapperDir, config.wrapperName(cmdName))})
+	}
+	if len(config.Wrappers) > 0 {
+		if err := tx.SaveConfig(config); err != nil {
+			tx.abort("Could not save config: %v", err)
+		}
+	}
+	tx.Commit()
+
+	logSuccess("Quickstart complete. Created:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, e := range created {
+		fmt.Fprintf(w, "  %s\t%s\n", e.what, e.where)
+	}
+	w.Flush()
+	fmt.Println()
+	printPathSetup(wrapperDir, shell)
+}
+
+// profilesDir is where new profile homes are created by default.
+func profilesDir(config Config) string {
+	if config.Settings.ProfilesDir != "" {
+		return expandPath(config.Settings.ProfilesDir)
+	}
+	return expandPath(filepath.Join("~/", profilesDirName))
+}
+
+// defaultSkeletonDir holds files copied into every new profile home.
+func defaultSkeletonDir() string {
+	configPath, _ := getConfigPath()
+	return filepath.Join(filepath.Dir(configPath), skeletonDirName)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- Completion for multiprof ---
+//
+// multiprof completes its own subcommands and flags, and the Rule indices,
+// profile, Wrapper and pack names they take. The completion scripts stay
+// thin: they hand the words typed so far to the hidden `multiprof __complete`,
+// which knows the commands below and reads the config, and complete file
+// names when it has nothing to offer. `multiprof completion` installs the
+// script for your shell next to the Wrappers' completion files; init does so
+// too. cliCommands must list every command runManager knows.
+
+// A completer returns the candidates for an argument. nil means multiprof has
+// none to offer, so the shell completes file names.
+type completer func(config Config) []string
+
+// cliCommand describes a subcommand for completion.
+type cliCommand struct {
+	args   []completer          // positional arguments, in order
+	bools  []string             // flags without a value
+	values map[string]completer // flags taking a value
+}
+
+func words(w ...string) completer { return func(Config) []string { return w } }
+
+var (
+	conflictPolicies = words(conflictAsk, conflictKeep, conflictOverwrite, conflictBackup)
+	shellNames       = words(completionShells...)
+)
+
+func profileNames(config Config) []string {
+	var names []string
+	for _, p := range config.allProfiles() {
+		if p.Name != "" {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+func ruleIndices(config Config) []string {
+	indices := make([]string, len(config.Rules))
+	for i := range config.Rules {
+		indices[i] = strconv.Itoa(i + 1)
+	}
+	return indices
+}
+
+func wrapperNames(config Config) []string {
+	wrapperDir, _ := getWrapperDir()
+	return wrappedCommands(config, wrapperDir)
+}
+
+func packNames(Config) []string {
+	var names []string
+	for _, pack := range installedPacks() {
+		names = append(names, pack.Name)
+	}
+	return names
+}
+
+var cliCommands = map[string]cliCommand{
+	"init":                      {values: map[string]completer{"shell": shellNames}},
+	"add-rule":                  {bools: []string{"regex"}, values: map[string]completer{"pattern": nil, "home": nil, "profile": profileNames, "exclude": nil, "env": nil}},
+	"add-wrapper":               {args: []completer{nil}, bools: []string{"login-safe"}, values: map[string]completer{"on-conflict": conflictPolicies, "match-on": words(matchOnCwd, matchOnArg), "shell": shellNames, "as": nil, "link-mode": words(linkAuto, linkSymlink, linkHardlink, linkScript)}},
+	"add-aliases":               {args: []completer{profileNames}, values: map[string]completer{"on-conflict": conflictPolicies, "shell": shellNames}},
+	"remove-wrapper":            {args: []completer{wrapperNames}},
+	"sync-wrappers":             {bools: []string{"dry-run"}, values: map[string]completer{"on-conflict": conflictPolicies, "shell": shellNames}},
+	"list":                      {bools: []string{"no-pager", "json"}},
+	"migrate-suffix":            {values: map[string]completer{"from": nil, "to": nil, "on-conflict": conflictPolicies}},
+	"migrate-layout":            {values: map[string]completer{"on-conflict": conflictPolicies}},
+	"selftest":                  {bools: []string{"keep"}},
+	"list-wrappers":             {bools: []string{"json"}},
+	"edit-rule":                 {args: []completer{ruleIndices}, values: map[string]completer{"pattern": nil, "home": nil, "profile": profileNames, "env": nil, "unset-env": nil}},
+	"test-rule":                 {bools: []string{"regex", "json"}, values: map[string]completer{"pattern": nil, "exclude": nil}},
+	"move-rule":                 {args: []completer{ruleIndices, ruleIndices}},
+	"promote":                   {args: []completer{ruleIndices}},
+	"enable-rule":               {args: []completer{ruleIndices}},
+	"disable-rule":              {args: []completer{ruleIndices}},
+	"demote":                    {args: []completer{ruleIndices}},
+	"cleanup":                   {args: []completer{profileNames}, bools: []string{"install-timer"}, values: map[string]completer{"interval": nil}},
+	"profile":                   {args: []completer{words("sync")}},
+	"profile sync":              {args: []completer{profileNames}},
+	"pack":                      {args: []completer{words("install", "list", "update", "remove")}},
+	"pack install":              {args: []completer{nil}, bools: []string{"yes"}},
+	"pack list":                 {},
+	"pack update":               {args: []completer{packNames}, bools: []string{"yes"}},
+	"pack remove":               {args: []completer{packNames}},
+	"bench-rules":               {values: map[string]completer{"paths-file": nil, "iterations": nil, "cpuprofile": nil, "memprofile": nil}},
+	"quickstart":                {values: map[string]completer{"pattern": nil, "home": nil, "wrap": nil, "on-conflict": conflictPolicies, "shell": shellNames}},
+	"new":                       {args: []completer{nil, nil}, values: map[string]completer{"home": nil, "template": nil, "wrap": nil, "on-conflict": conflictPolicies, "shell": shellNames}},
+	"create-profile":            {args: []completer{nil}, values: map[string]completer{"home": nil, "from": nil, "dotfiles": nil}},
+	"clone-profile":             {args: []completer{profileNames, nil}, values: map[string]completer{"home": nil, "exclude": nil}},
+	"delete-profile":            {args: []completer{profileNames}, bools: []string{"purge", "yes"}},
+	"rename-profile":            {args: []completer{profileNames, nil}, bools: []string{"move-home"}, values: map[string]completer{"home": nil}},
+	"shell":                     {args: []completer{nil}},
+	"login":                     {args: []completer{nil}},
+	"doctor":                    {},
+	"status":                    {args: []completer{nil}, bools: []string{"json"}},
+	"report":                    {bools: []string{"json", "no-pager"}},
+	"stats":                     {bools: []string{"no-pager", "json"}, values: map[string]completer{"since": nil}},
+	"log":                       {bools: []string{"failures", "no-pager", "audit", "json"}, values: map[string]completer{"profile": profileNames, "n": nil, "since": nil}},
+	"agent":                     {args: []completer{words("start", "stop", "status")}},
+	"agent start":               {args: []completer{profileNames}},
+	"agent stop":                {args: []completer{profileNames}},
+	"agent status":              {args: []completer{profileNames}},
+	"compact":                   {bools: []string{"audit"}, values: map[string]completer{"max-age": nil}},
+	"restore":                   {args: []completer{nil}},
+	"export":                    {values: map[string]completer{"format": words(formatTOML, formatJSON)}},
+	"import":                    {args: []completer{nil}, bools: []string{"dry-run"}, values: map[string]completer{"on-conflict": words(conflictAsk, conflictKeep, conflictOverwrite)}},
+	"import-gitconfig-includes": {bools: []string{"dry-run", "yes"}, values: map[string]completer{"gitconfig": nil}},
+	"info":                      {},
+	"daemon":                    {},
+	"uninstall":                 {bools: []string{"purge", "yes"}},
+	"run":                       {bools: []string{"dry-run", "explain-error", "supervise"}, values: map[string]completer{"p": profileNames}},
+	"trust":                     {args: []completer{nil}},
+	"untrust":                   {args: []completer{nil}},
+	"trace":                     {args: []completer{nil}, values: map[string]completer{"cmd": wrapperNames}},
+	"which":                     {args: []completer{nil}, bools: []string{"json"}, values: map[string]completer{"cmd": wrapperNames}},
+	"check":                     {args: []completer{nil}, bools: []string{"quiet", "prune", "json"}, values: map[string]completer{"cmd": wrapperNames}},
+	"env":                       {args: []completer{nil}, values: map[string]completer{"shell": words("sh", shellFish)}},
+	"gen-cron":                  {bools: []string{"systemd"}, values: map[string]completer{"dir": nil}},
+	"gen-precommit":             {args: []completer{nil}, bools: []string{"install"}, values: map[string]completer{"on-conflict": conflictPolicies}},
+	"which-home":                {args: []completer{nil}},
+	"prompt":                    {args: []completer{nil}, values: map[string]completer{"format": nil, "cmd": wrapperNames}},
+	"prompt-config":             {bools: []string{"bash", "zsh", "fish", "starship", "powerline"}},
+	"hook":                      {args: []completer{words(shellBash, shellZsh, shellFish)}, bools: []string{"export"}},
+	"completion":                {bools: []string{"print"}, values: map[string]completer{"shell": shellNames, "on-conflict": conflictPolicies}},
+	"help":                      {},
+}
+
+// flagName returns how a flag is written on the command line.
+func flagName(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+// completeWords returns the candidates for the last of typed, the arguments
+// typed after `multiprof`.
+func completeWords(config Config, typed []string) []string {
+	current := typed[len(typed)-1]
+	if len(typed) == 1 {
+		return withPrefix(topLevelCommands(), current)
+	}
+	name, rest := typed[0], typed[1:]
+	if _, ok := cliCommands[name+" "+rest[0]]; ok && len(rest) > 1 {
+		name, rest = name+" "+rest[0], rest[1:]
+	}
+	cmd, ok := cliCommands[name]
+	if !ok {
+		return nil
+	}
+
+	// Find which positional argument, or which flag's value, is being typed.
+	position := 0
+	for i := 0; i < len(rest)-1; i++ {
+		word := rest[i]
+		if word == "--" {
+			return nil // A command line follows, as with run and gen-cron.
+		}
+		if !strings.HasPrefix(word, "-") {
+			position++
+			continue
+		}
+		if complete, ok := cmd.values[strings.TrimLeft(word, "-")]; ok {
+			if i == len(rest)-2 {
+				if complete == nil {
+					return nil
+				}
+				return withPrefix(complete(config), current)
+			}
+			i++
+		}
+	}
+
+	if strings.HasPrefix(current, "-") {
+		var flags []string
+		for _, name := range cmd.bools {
+			flags = append(flags, flagName(name))
+		}
+		if slices.Contains(resultCommands, typed[0]) {
+			flags = append(flags, jsonFlag)
+		}
+		for name := range cmd.values {
+			flags = append(flags, flagName(name))
+		}
+		slices.Sort(flags)
+		return withPrefix(flags, current)
+	}
+	if position >= len(cmd.args) || cmd.args[position] == nil {
+		return nil
+	}
+	return withPrefix(cmd.args[position](config), current)
+}
+
+// topLevelCommands returns the names in cliCommands, without the
+// subcommands of agent, pack and profile.
+func topLevelCommands() []string {
+	var names []string
+	for name := range cliCommands {
+		if !strings.Contains(name, " ") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func withPrefix(candidates []string, prefix string) []string {
+	var matching []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matching = append(matching, c)
+		}
+	}
+	return matching
+}
+
+// runComplete implements the hidden `multiprof __complete`, which the
+// completion scripts call with the words typed after `multiprof`, the last
+// one being completed.
+func runComplete(args []string) {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	// Completing must never create or change files, such as a default config.
+	config, _ := loadConfig(readOnly)
+	for _, candidate := range completeWords(config, args) {
+		fmt.Println(candidate)
+	}
+}
+
+func runCompletion(args []string) {
+	completionCmd := flag.NewFlagSet("completion", flag.ExitOnError)
+	shellFlag := completionCmd.String("shell", "", "Shell to write the completion script for: bash, zsh or fish (default: from $SHELL).")
+	onConflict := completionCmd.String("on-conflict", conflictAsk, "What to do with an existing file multiprof did not create: ask, keep, overwrite or backup.")
+	printScript := completionCmd.Bool("print", false, "Print the script instead of installing it.")
+	if positional := parseFlags(completionCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof completion [--shell bash|zsh|fish] [--print] [--on-conflict ask|keep|overwrite|backup]")
+	}
+	shell, err := completionShell(*shellFlag)
+	if err != nil {
+		exitWith(err)
+	}
+	if !validConflictPolicy(*onConflict) {
+		exitUsage("Invalid --on-conflict value '%s'.", *onConflict)
+	}
+	if *printScript {
+		fmt.Print(selfCompletionScript(shell))
+		return
+	}
+	tx := newTransaction(readWrite)
+	if err := installSelfCompletion(tx, shell, *onConflict); err == errKeptExisting {
+		return
+	} else if err != nil {
+		tx.abort("%v", err)
+	}
+	tx.Commit()
+	logSuccess("Installed the %s completion for multiprof at %s.", shell, tildePath(completionPath(shell, "multiprof")))
+	logInfo("It takes effect in new shells.")
+}
+
+func selfCompletionScript(shell string) string {
+	switch shell {
+	case shellZsh:
+		return selfCompletionZsh
+	case shellFish:
+		return selfCompletionFish
+	}
+	return selfCompletionBash
+}
+
+// installSelfCompletion writes the completion script for multiprof itself.
+func installSelfCompletion(tx *transaction, shell, onConflict string) error {
+	path := completionPath(shell, "multiprof")
+	if err := tx.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create completion directory: %w", err)
+	}
+	if err := clearPath(tx, path, onConflict, isOwnCompletion); err != nil {
+		return err
+	}
+	return tx.WriteFile(path, []byte(selfCompletionScript(shell)), 0644)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- Self-test ---
+//
+// `multiprof selftest` checks that Wrappers work on this machine end to end:
+// it builds a throwaway HOME with its own config, Rules, homes and Wrappers
+// under a temporary directory, runs the Wrappers in a matrix of directories
+// and checks which HOME the wrapped command saw. The wrapped command is
+// a copy of multiprof named selftestProbeName, which only reports its
+// environment.
+
+const selftestProbeName = "multiprof-selftest-probe"
+
+// runSelftestProbe is the wrapped command of the self-test.
+func runSelftestProbe() {
+	fmt.Printf("HOME=%s\n", os.Getenv("HOME"))
+	fmt.Printf("PROFILE=%s\n", os.Getenv(activeProfileEnvVar))
+}
+
+type selftestScenario struct {
+	name     string
+	dir      string // relative to the work directory
+	wantHome string // relative to the homes directory; "" if no Rule should match
+}
+
+var selftestScenarios = []selftestScenario{
+	{"a directory below a Rule's pattern", "acme/app", "acme"},
+	{"the pattern's own directory (dir/** matching dir)", "acme", "acme"},
+	{"a later Rule when an earlier one does not match", "other", "work"},
+	{"a later Rule when an exclude vetoes an earlier one", "acme/sandbox", "work"},
+	{"no Rule matching", "../elsewhere", ""},
+}
+
+func runSelftest(args []string) {
+	selftestCmd := flag.NewFlagSet("selftest", flag.ExitOnError)
+	keep := selftestCmd.Bool("keep", false, "Keep the temporary directory for inspection.")
+	selftestCmd.Parse(args)
+
+	root, err := os.MkdirTemp("", "multiprof-selftest-")
+	if err != nil {
+		exitFailure(errFailed, "", "Could not create a temporary directory: %v", err)
+	}
+	if *keep {
+		logInfo("Keeping the test files in %s.", root)
+	} else {
+		defer os.RemoveAll(root)
+	}
+
+	passed, failed := 0, 0
+	for _, suffix := range []string{"_w", ""} {
+		phase := fmt.Sprintf("suffix %q", suffix)
+		if suffix == "" {
+			phase += ", Wrapper shadowing the command in PATH"
+		}
+		fmt.Printf("--- %s ---\n", phase)
+		st, err := setupSelftest(filepath.Join(root, "suffix"+suffix), suffix)
+		if err != nil {
+			fmt.Printf("FAIL  setup: %v\n", err)
+			failed++
+			continue
+		}
+		for _, sc := range selftestScenarios {
+			if err := st.run(sc); err != nil {
+				fmt.Printf("FAIL  %s: %v\n", sc.name, err)
+				failed++
+			} else {
+				fmt.Printf("PASS  %s\n", sc.name)
+				passed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		hint := fmt.Sprintf("Run again with --keep and %s=1 in the failing directory to investigate.", debugEnvVar)
+		exitFailure(errFailed, hint, "%d of %d checks failed.", failed, passed+failed)
+	}
+	logSuccess("All %d checks passed.", passed)
+}
+
+// selftestSetup is one self-test HOME with its Wrapper.
+type selftestSetup struct {
+	base    string
+	wrapper string
+	env     []string
+}
+
+// setupSelftest creates a HOME, config, homes, directories and a Wrapper
+// below base.
+func setupSelftest(base, suffix string) (selftestSetup, error) {
+	st := selftestSetup{base: base}
+	ownExecutable, err := os.Executable()
+	if err != nil {
+		return st, err
+	}
+	home := filepath.Join(base, "home")
+	homes := filepath.Join(base, "homes")
+	work := filepath.Join(base, "work")
+	binDir := filepath.Join(base, "bin")
+	for _, dir := range []string{
+		home, binDir, filepath.Join(homes, "acme"), filepath.Join(homes, "work"),
+		filepath.Join(work, "acme", "app"), filepath.Join(work, "acme", "sandbox"),
+		filepath.Join(work, "other"), filepath.Join(base, "elsewhere"),
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return st, err
+		}
+	}
+
+	config := Config{
+		Settings: Settings{Suffix: suffix},
+		Rules: []Rule{
+			{
+				Pattern:  filepath.Join(work, "acme") + "/**",
+				Exclude:  []string{filepath.Join(work, "acme", "sandbox") + "/**"},
+				HomeSpec: HomeSpec{Home: filepath.Join(homes, "acme")},
+			},
+			{Pattern: work + "/**", HomeSpec: HomeSpec{Home: filepath.Join(homes, "work")}},
+		},
+	}
+	data, err := encodeConfig(config)
+	if err != nil {
+		return st, err
+	}
+	configPath := filepath.Join(home, configDirName, configFileName)
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return st, err
+	}
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		return st, err
+	}
+
+	// The wrapped command is a copy of multiprof, so it can tell from its own
+	// file name that it is the probe even when the Wrapper has the same name.
+	// The Wrapper goes into the Wrapper Directory of the test HOME, which is
+	// first in PATH.
+	exeSuffix := filepath.Ext(ownExecutable)
+	exe, err := os.ReadFile(ownExecutable)
+	if err != nil {
+		return st, err
+	}
+	if err := os.WriteFile(filepath.Join(binDir, selftestProbeName+exeSuffix), exe, 0755); err != nil {
+		return st, fmt.Errorf("could not create the test command: %w", err)
+	}
+	wrapperDir := filepath.Join(home, dataHomeDirName, wrapperDirName)
+	if err := os.MkdirAll(wrapperDir, 0755); err != nil {
+		return st, err
+	}
+	// Made as add-wrapper would, so on Windows it is a .cmd script that
+	// exec.Command finds by its extension.
+	st.wrapper = filepath.Join(wrapperDir, selftestProbeName+suffix)
+	tx := newTransaction(readWrite)
+	if err := linkWrapper(tx, linkAuto, ownExecutable, st.wrapper); err != nil {
+		return st, fmt.Errorf("could not create the test Wrapper: %w", err)
+	}
+	tx.Commit()
+
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		switch {
+		case key == "HOME", key == "USERPROFILE", key == "PATH", key == "XDG_DATA_HOME", key == "XDG_CONFIG_HOME", strings.HasPrefix(key, "MULTIPROF_"):
+			continue
+		}
+		st.env = append(st.env, kv)
+	}
+	path := wrapperDir + string(os.PathListSeparator) + binDir + string(os.PathListSeparator) + os.Getenv("PATH")
+	st.env = append(st.env, "HOME="+home, "USERPROFILE="+home, "PATH="+path)
+	return st, nil
+}
+
+// run runs the Wrapper in the scenario's directory and checks the HOME the
+// wrapped command saw.
+func (st selftestSetup) run(sc selftestScenario) error {
+	dir := filepath.Join(st.base, "work", sc.dir)
+	// A Wrapper that finds itself instead of the command would loop forever.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, st.wrapper)
+	cmd.Dir, cmd.Env = dir, st.env
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out; the Wrapper probably ran itself instead of the command")
+	}
+	if sc.wantHome == "" {
+		if err == nil || !strings.Contains(string(out), "No multiprof Rule matched") {
+			return fmt.Errorf("expected the Wrapper to refuse to run, got: %s", strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	want := "HOME=" + filepath.Join(st.base, "homes", sc.wantHome)
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected %s, got: %s", want, strings.TrimSpace(string(out)))
+}
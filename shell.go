@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// --- Profile Shells ---
+//
+// `multiprof shell` starts an interactive shell inside the profile matching a
+// directory, and `multiprof login` starts it as a login shell. The shell is
+// the profile's `shell` setting, falling back to $SHELL and then /bin/sh.
+// Where no Rule matches, default_home and on_no_match apply as for Wrappers;
+// with on_no_match = "passthrough" the shell starts untouched.
+
+func runShell(args []string, login bool) {
+	if len(args) > 1 {
+		exitUsage("Usage: multiprof shell|login [dir]")
+	}
+	dir := dirArg(args)
+	if len(args) == 1 {
+		if err := os.Chdir(dir); err != nil {
+			exitFailure(errFailed, "", "Could not enter '%s': %v", dir, err)
+		}
+	}
+
+	config, _ := loadConfig(readWrite)
+	res, err := resolveForExec(config, dir, daemonAnswer{})
+	passthrough := errors.Is(err, errPassthrough)
+	var env *envBuilder
+	switch {
+	case passthrough:
+		env = newEnvBuilder(os.Environ())
+	case err != nil:
+		exitWith(err)
+	default:
+		if err := activateProfile(readWrite, res.Profile); err != nil {
+			exitWith(err)
+		}
+		env = res.environment(os.Environ())
+	}
+
+	shell := env.Get("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+		env.Set("SHELL", shell, "multiprof default shell")
+	}
+	shellPath, err := exec.LookPath(shell)
+	if err != nil {
+		exitFailure(errTargetNotFound, "", "Could not find shell '%s': %v", shell, err)
+	}
+	if err := env.Validate(nil); err != nil {
+		exitFailure(errRefused, "Run 'multiprof which' to see where each variable comes from.", "Refusing to start shell: %v", err)
+	}
+
+	// By convention a login shell is started with a leading dash in argv[0].
+	argv0 := filepath.Base(shellPath)
+	if login {
+		argv0 = "-" + argv0
+	}
+	if passthrough {
+		logInfo("No Rule matches '%s'; starting your own shell.", dir)
+	} else {
+		logInfo("Entering profile '%s' (HOME=%s). Exit the shell to leave it.", res.Profile.label(), res.Profile.Home)
+	}
+	if err := replaceProcess(shellPath, []string{argv0}, env.Environ()); err != nil {
+		exitFailure(errFailed, "", "Could not start shell '%s': %v", shellPath, err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --- Prompt Integration ---
+//
+// Commands run through multiprof see which profile they run in via the
+// MULTIPROF_ACTIVE_* variables. `multiprof env` prints the same variables for
+// a directory as shell code, so a `cd` hook can export them and prompt
+// segments can render the profile without invoking multiprof on every prompt.
+// `prompt` is the cheap lookup for prompts that do invoke it, and
+// `prompt-config` prints ready-made segments for PS1 and popular prompts.
+
+const (
+	activeProfileEnvVar = "MULTIPROF_ACTIVE_PROFILE"
+	activeRuleEnvVar    = "MULTIPROF_ACTIVE_RULE"
+	activeHomeEnvVar    = "MULTIPROF_ACTIVE_HOME"
+)
+
+var activeEnvVars = []string{activeProfileEnvVar, activeRuleEnvVar, activeHomeEnvVar}
+
+// activeValues returns the MULTIPROF_ACTIVE_* values for res, in the order
+// of activeEnvVars.
+func (res resolution) activeValues() []string {
+	return []string{res.Profile.label(), res.Rule.Pattern, res.Profile.Home}
+}
+
+func runEnv(args []string) {
+	envCmd := flag.NewFlagSet("env", flag.ExitOnError)
+	shell := envCmd.String("shell", "sh", "Syntax to print: sh (bash, zsh, ...) or fish.")
+	positional := parseFlags(envCmd, args)
+	if len(positional) > 1 || (*shell != "sh" && *shell != "fish") {
+		exitUsage("Usage: multiprof env [dir] [--shell sh|fish]")
+	}
+	dir := dirArg(positional)
+
+	config, _ := loadConfig(readOnly) // Run from a cd hook, which must not create files.
+	res, ok := resolveDir(config, dir)
+	if ok && res.Err != nil {
+		logError("%v", res.Err)
+		ok = false
+	}
+	for i, key := range activeEnvVars {
+		var value *string
+		if ok {
+			value = &res.activeValues()[i]
+		}
+		fmt.Println(shellAssign(*shell, key, value))
+	}
+}
+
+// shellAssign returns sh or fish code exporting key with value, or unsetting
+// it if value is nil.
+func shellAssign(shell, key string, value *string) string {
+	switch {
+	case value == nil && shell == "fish":
+		return fmt.Sprintf("set -e %s;", key)
+	case value == nil:
+		return fmt.Sprintf("unset %s;", key)
+	case shell == "fish":
+		return fmt.Sprintf("set -gx %s %s;", key, shellQuote(*value))
+	}
+	return fmt.Sprintf("export %s=%s;", key, shellQuote(*value))
+}
+
+// shellQuote quotes s for POSIX shells and fish.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runWhichHome prints the profile for dir and nothing else, exiting 1 when no
+// Rule matches. It is called on every prompt, so it does no more than load
+// the config and match, and never writes a file.
+func runWhichHome(args []string) {
+	if len(args) > 1 {
+		exitUsage("Usage: multiprof which-home [dir]")
+	}
+	dir := dirArg(args)
+	config, _ := loadConfig(readOnly)
+	res, ok := resolveDir(config, dir)
+	if !ok || res.Err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(res.Profile.label())
+}
+
+// runPrompt prints the profile for dir in --format, or nothing when no Rule
+// matches. It runs on every prompt, so it only loads the config and matches,
+// never writes a file, and always exits 0 so a prompt need not check.
+func runPrompt(args []string) {
+	promptCmd := flag.NewFlagSet("prompt", flag.ExitOnError)
+	format := promptCmd.String("format", "{profile}", "What to print; {profile} and {home} are replaced.")
+	promptCmd.StringVar(&invokedCommand, "cmd", "", "Match as if running this command, for Rules with a `commands` filter.")
+	positional := parseFlags(promptCmd, args)
+	if len(positional) > 1 {
+		exitUsage("Usage: multiprof prompt [dir] [--format <format>] [--cmd <command>]")
+	}
+	dir := dirArg(positional)
+	config, _ := loadConfig(readOnly) // Never create a default config from a prompt.
+	res, ok := resolveDir(config, dir)
+	if !ok || res.Err != nil {
+		return
+	}
+	fmt.Print(strings.NewReplacer("{profile}", res.Profile.label(), "{home}", tildePath(res.Profile.Home)).Replace(*format))
+}
+
+const bashPromptConfig = `# Add to ~/.bashrc, after anything else that sets PS1:
+PS1='$(multiprof prompt --format "[{profile}] ")'"$PS1"
+`
+
+const zshPromptConfig = `# Add to ~/.zshrc, after anything else that sets PROMPT:
+setopt PROMPT_SUBST
+PROMPT='$(multiprof prompt --format "[{profile}] ")'"$PROMPT"
+`
+
+const fishPromptConfig = `# Add to ~/.config/fish/config.fish, after anything else defining fish_prompt:
+functions --copy fish_prompt __multiprof_fish_prompt
+function fish_prompt
+    multiprof prompt --format '[{profile}] '
+    __multiprof_fish_prompt
+end
+`
+
+const starshipPromptConfig = `# Add to ~/.config/starship.toml, and "${custom.multiprof}" to your format
+# if you set one.
+[custom.multiprof]
+command = "multiprof prompt"
+when = true
+shell = ["sh"]
+format = "([\\[$output\\]]($style) )"
+style = "bold purple"
+`
+
+const powerlinePromptConfig = `# powerline-go shows the MULTIPROF_ACTIVE_PROFILE variable exported by the
+# hook from 'multiprof env' (see 'multiprof help'). Add shell-var to your
+# -modules and pass:
+-modules "venv,user,host,ssh,cwd,shell-var,perms,git,jobs,exit,root" -shell-var MULTIPROF_ACTIVE_PROFILE
+`
+
+func runPromptConfig(args []string) {
+	promptCmd := flag.NewFlagSet("prompt-config", flag.ExitOnError)
+	configs := map[string]*bool{
+		bashPromptConfig:      promptCmd.Bool("bash", false, "Print a PS1 snippet for bash."),
+		zshPromptConfig:       promptCmd.Bool("zsh", false, "Print a PROMPT snippet for zsh."),
+		fishPromptConfig:      promptCmd.Bool("fish", false, "Print a fish_prompt wrapper for fish."),
+		starshipPromptConfig:  promptCmd.Bool("starship", false, "Print a starship custom module."),
+		powerlinePromptConfig: promptCmd.Bool("powerline", false, "Print powerline-go flags."),
+	}
+	promptCmd.Parse(args)
+	var chosen []string
+	for config, set := range configs {
+		if *set {
+			chosen = append(chosen, config)
+		}
+	}
+	if len(chosen) != 1 {
+		exitUsage("Usage: multiprof prompt-config --bash | --zsh | --fish | --starship | --powerline")
+	}
+	fmt.Print(chosen[0])
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// --- Finding the Real Command ---
+//
+// With suffix = "", a Wrapper has the same name as the command it wraps and
+// shadows it by coming first in PATH, so it must find the real command
+// without finding itself. PATH spells directories in many ways (a trailing
+// slash, ~, a symlinked directory, the last entry without a separator), so
+// instead of editing the PATH string, findTarget compares each entry with
+// the Wrapper Directories as files and skips any that is one of them, then
+// still skips anything that is a multiprof Wrapper, wherever it lives. A
+// Wrapper can thus never exec itself or another Wrapper. The command's own
+// PATH is left alone, so the tools it starts are wrapped too.
+
+// sameDir reports whether the PATH entry entry is the directory dir.
+func sameDir(entry, dir string) bool {
+	entry = expandPath(entry)
+	if filepath.Clean(entry) == filepath.Clean(dir) {
+		return true
+	}
+	a, errA := os.Stat(entry)
+	b, errB := os.Stat(dir)
+	return errA == nil && errB == nil && os.SameFile(a, b)
+}
+
+// pathIndex returns the index of dir among the PATH entries, or -1.
+func pathIndex(entries []string, dir string) int {
+	for i, entry := range entries {
+		if entry != "" && sameDir(entry, dir) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isWrapperDir reports whether the PATH entry entry is a Wrapper Directory.
+func isWrapperDir(entry string) bool {
+	for _, dir := range wrapperDirs() {
+		if sameDir(entry, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// findTarget looks up cmdName in PATH like exec.LookPath, skipping the
+// Wrapper Directories and any other multiprof Wrapper. A name with a path
+// separator is not looked up, but must not be a Wrapper either.
+func findTarget(cmdName string) (string, error) {
+	if hasPathSeparator(cmdName) {
+		found, err := exec.LookPath(cmdName)
+		if err != nil {
+			return "", err
+		}
+		if isOwnWrapper(cmdName) || isOwnWrapperFile(found) {
+			return "", fmt.Errorf("%w: %s is one of multiprof's Wrappers", exec.ErrNotFound, cmdName)
+		}
+		return found, nil
+	}
+	for _, entry := range filepath.SplitList(os.Getenv("PATH")) {
+		if entry == "" {
+			entry = "." // An empty entry is the current directory.
+		}
+		if isWrapperDir(entry) {
+			debugf("Skipping Wrapper Directory %s in PATH", entry)
+			continue
+		}
+		path := filepath.Join(expandPath(entry), cmdName)
+		if isOwnWrapper(path) {
+			debugf("Skipping Wrapper %s", path)
+			continue
+		}
+		if found, err := exec.LookPath(path); err == nil && !isOwnWrapperFile(found) {
+			return found, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no %s in PATH outside multiprof's Wrappers", exec.ErrNotFound, cmdName)
+}
+
+// hasPathSeparator reports whether name is a path rather than a command name
+// to look up in PATH, as exec.LookPath decides.
+func hasPathSeparator(name string) bool {
+	return strings.ContainsRune(name, os.PathSeparator) || runtime.GOOS == "windows" && strings.ContainsRune(name, '/')
+}
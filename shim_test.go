@@ -0,0 +1,47 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindTargetPath(t *testing.T) {
+	root := setupDaemonTest(t, "")
+	script := filepath.Join(root, "script.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", "")
+
+	if found, err := findTarget(script); err != nil || found != script {
+		t.Errorf("findTarget(%q) = %q, %v; want %q", script, found, err, script)
+	}
+	t.Chdir(root)
+	relative := "." + string(os.PathSeparator) + "script.sh"
+	if found, err := findTarget(relative); err != nil || found != relative {
+		t.Errorf("findTarget(%q) = %q, %v; want %q", relative, found, err, relative)
+	}
+	if _, err := findTarget(filepath.Join(root, "missing")); err == nil {
+		t.Error("findTarget found a file that does not exist")
+	}
+}
+
+func TestFindTargetPathRejectsWrapper(t *testing.T) {
+	root := setupDaemonTest(t, "")
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapper := filepath.Join(root, "git")
+	if err := os.Symlink(self, wrapper); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := findTarget(wrapper); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("findTarget(%q) = %q, %v; want a Wrapper to be rejected", wrapper, found, err)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// autoLinkModes are the ways add-wrapper tries to create a Wrapper, in order.
+var autoLinkModes = []string{linkSymlink, linkHardlink, linkScript}
+
+// wrapperFiles returns the files that can make up the Wrapper at path.
+func wrapperFiles(path string) []string { return []string{path} }
+
+// linkFile returns the file a symlink or hardlink Wrapper at path is.
+func linkFile(path string) string { return path }
+
+// wrapperFileName returns the name of the Wrapper a file in the Wrapper
+// Directory belongs to.
+func wrapperFileName(file string) (string, bool) { return file, true }
+
+// wrapperScripts returns the files and contents of a script Wrapper at path
+// running multiprofPath. The script takes its Wrapper name from its own file
+// name, so renaming it works as with a symlink.
+func wrapperScripts(multiprofPath, path string) map[string]string {
+	return map[string]string{path: fmt.Sprintf("#!/bin/sh\n# %s: Wrapper script.\nexec %s %s \"${0##*/}\" \"$@\"\n",
+		generatedMarker, shellQuote(multiprofPath), wrapperScriptCommand)}
+}
@@ -0,0 +1,54 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Windows only runs files with an executable extension, so a symlink or
+// hardlink Wrapper is named <wrapper>.exe, and a script Wrapper is a .cmd
+// file, for cmd.exe and anything using CreateProcess, plus a .ps1 file, which
+// PowerShell prefers and which passes arguments without cmd.exe's quoting.
+var wrapperExts = []string{".exe", ".cmd", ".ps1"}
+
+// autoLinkModes are the ways add-wrapper tries to create a Wrapper, in
+// order. Symlinks need developer mode and hardlinks break when multiprof is
+// upgraded, so Wrappers are scripts unless asked otherwise.
+var autoLinkModes = []string{linkScript}
+
+// wrapperFiles returns the files that can make up the Wrapper at path.
+func wrapperFiles(path string) []string {
+	files := make([]string, len(wrapperExts))
+	for i, ext := range wrapperExts {
+		files[i] = path + ext
+	}
+	return files
+}
+
+// linkFile returns the file a symlink or hardlink Wrapper at path is.
+func linkFile(path string) string { return path + ".exe" }
+
+// wrapperFileName returns the name of the Wrapper a file in the Wrapper
+// Directory belongs to.
+func wrapperFileName(file string) (string, bool) {
+	for _, ext := range wrapperExts {
+		if name, ok := strings.CutSuffix(file, ext); ok && name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// wrapperScripts returns the files and contents of a script Wrapper at path
+// running multiprofPath. The scripts take their Wrapper name from their own
+// file name, so renaming them works as with a symlink.
+func wrapperScripts(multiprofPath, path string) map[string]string {
+	return map[string]string{
+		path + ".cmd": fmt.Sprintf("@echo off\r\nrem %s: Wrapper script.\r\n\"%s\" %s \"%%~n0\" %%*\r\nexit /b %%ERRORLEVEL%%\r\n",
+			generatedMarker, multiprofPath, wrapperScriptCommand),
+		path + ".ps1": fmt.Sprintf("# %s: Wrapper script.\r\n& '%s' %s ([IO.Path]::GetFileNameWithoutExtension($PSCommandPath)) @args\r\nexit $LASTEXITCODE\r\n",
+			generatedMarker, strings.ReplaceAll(multiprofPath, "'", "''"), wrapperScriptCommand),
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// forwardedSignals are relayed to a spawned target. SIGWINCH lets a target
+// that is not in the terminal's foreground process group follow resizes too.
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGWINCH}
+
+// terminalSignals are those a terminal sends its whole foreground process
+// group, a target sharing it included.
+var terminalSignals = []os.Signal{os.Interrupt, syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGWINCH}
@@ -0,0 +1,10 @@
+package main
+
+import "os"
+
+// forwardedSignals are relayed to a spawned target.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// terminalSignals are those a console sends every process attached to it,
+// a target sharing it included.
+var terminalSignals = []os.Signal{os.Interrupt}
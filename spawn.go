@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- Spawn Backend ---
+//
+// Normally the wrapper replaces itself with the target via syscall.Exec
+// (on Windows, which cannot, it runs it as below; see exec_windows.go).
+// Features that need to see the target's output or outcome, or to run after
+// it (post_exec), instead run it as a child process: signals are forwarded,
+// and its exit status is propagated and recorded with the run (see
+// `multiprof log`) along with how long it took. `supervise = true` on a Rule,
+// MULTIPROF_SUPERVISE=1 or `multiprof run --supervise` ask for this without
+// any such feature, so that every run's outcome is recorded.
+
+const (
+	superviseEnvVar = "MULTIPROF_SUPERVISE"
+	tagOutputEnvVar = "MULTIPROF_TAG_OUTPUT"
+	tagPrefix       = "prefix"
+	tagHeader       = "header"
+)
+
+// outputTagMode returns how output should be tagged: per Rule via
+// `tag_output`, or for every invocation via MULTIPROF_TAG_OUTPUT.
+func outputTagMode(rule Rule) (string, error) {
+	mode := rule.TagOutput
+	switch v := os.Getenv(tagOutputEnvVar); v {
+	case "":
+	case "1", "true", tagPrefix:
+		mode = tagPrefix
+	case tagHeader:
+		mode = tagHeader
+	default:
+		return "", fmt.Errorf("invalid %s value '%s' (use 1, prefix or header)", tagOutputEnvVar, v)
+	}
+	switch mode {
+	case "", tagPrefix, tagHeader:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid tag_output '%s' in Rule '%s' (use prefix or header)", mode, rule.Pattern)
+}
+
+// supervise is set by `multiprof run --supervise`.
+var supervise bool
+
+// supervised reports whether the target should run as a child process even
+// without a feature that needs it: per Rule via `supervise`, or for every
+// invocation via --supervise or MULTIPROF_SUPERVISE.
+func supervised(rule Rule) (bool, error) {
+	switch v := os.Getenv(superviseEnvVar); v {
+	case "":
+	case "1", "true":
+		return true, nil
+	case "0", "false":
+		return supervise, nil
+	default:
+		return false, fmt.Errorf("invalid %s value '%s' (use 1 or 0)", superviseEnvVar, v)
+	}
+	return supervise || rule.Supervise, nil
+}
+
+// outcome is how a run ended. It is only known for targets run as a child
+// process and for runs that could not start.
+type outcome struct {
+	Exit   int    `json:"exit"`
+	Signal string `json:"signal,omitempty"`
+	// Error is why the target could not be run at all.
+	Error string `json:"error,omitempty"`
+	// DurationMS is how long the target ran.
+	DurationMS int64 `json:"duration_ms,omitempty"`
+}
+
+func (o *outcome) failed() bool {
+	return o != nil && (o.Exit != 0 || o.Error != "")
+}
+
+func (o *outcome) String() string {
+	switch {
+	case o == nil:
+		return "-"
+	case o.Error != "":
+		return "error: " + o.Error
+	case o.Signal != "":
+		return "signal: " + o.Signal
+	case o.Exit == 0:
+		return "ok"
+	}
+	return fmt.Sprintf("exit %d", o.Exit)
+}
+
+// duration returns how long the run took, or "-" if it is not known.
+func (o *outcome) duration() string {
+	if o == nil || o.DurationMS == 0 {
+		return "-"
+	}
+	return (time.Duration(o.DurationMS) * time.Millisecond).String()
+}
+
+// spawnTarget runs path as a child process and returns how it ended. With a
+// non-empty tag, every line of its output is prefixed with "[tag] ".
+func spawnTarget(path string, args, env []string, tag string) outcome {
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Args[0] = args[0]
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	var copiers sync.WaitGroup
+	if tag != "" {
+		prefix := "[" + tag + "] "
+		stdout, err := prefixPipe(os.Stdout, prefix, &copiers)
+		if err != nil {
+			logError("Could not create output pipe: %v", err)
+			return outcome{Exit: 1, Error: err.Error()}
+		}
+		stderr, err := prefixPipe(os.Stderr, prefix, &copiers)
+		if err != nil {
+			logError("Could not create output pipe: %v", err)
+			return outcome{Exit: 1, Error: err.Error()}
+		}
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+	}
+
+	start := time.Now()
+	err := cmd.Start()
+	if tag != "" {
+		// The write ends now belong to the child; close ours so the
+		// copiers see EOF when it exits.
+		cmd.Stdout.(*os.File).Close()
+		cmd.Stderr.(*os.File).Close()
+	}
+	if err != nil {
+		logError("Could not execute '%s': %v", path, err)
+		return outcome{Exit: 1, Error: err.Error()}
+	}
+
+	// A child sharing our terminal already receives the signals the terminal
+	// generates (Ctrl-C, Ctrl-\, hangup, resize), so those are only caught
+	// (to keep us alive), not forwarded, in that case.
+	fromTerminal := isTerminal(os.Stdin)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if fromTerminal && slices.Contains(terminalSignals, sig) {
+				continue
+			}
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	copiers.Wait()
+	result := waitOutcome(err)
+	result.DurationMS = max(time.Since(start).Milliseconds(), 1)
+	return result
+}
+
+// waitOutcome converts the result of Wait into an outcome with a
+// shell-style exit code.
+func waitOutcome(err error) outcome {
+	if err == nil {
+		return outcome{}
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		logError("%v", err)
+		return outcome{Exit: 1, Error: err.Error()}
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return outcome{Exit: 128 + int(status.Signal()), Signal: status.Signal().String()}
+	}
+	return outcome{Exit: exitErr.ExitCode()}
+}
+
+// prefixPipe returns the write end of a pipe whose lines are copied to dst
+// with prefix prepended.
+func prefixPipe(dst io.Writer, prefix string, copiers *sync.WaitGroup) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	copiers.Add(1)
+	go func() {
+		defer copiers.Done()
+		defer r.Close()
+		prefixLines(dst, r, prefix)
+	}()
+	return w, nil
+}
+
+func prefixLines(dst io.Writer, src io.Reader, prefix string) {
+	reader := bufio.NewReader(src)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			io.WriteString(dst, prefix+line)
+		}
+		if err != nil {
+			if line != "" && !strings.HasSuffix(line, "\n") {
+				io.WriteString(dst, "\n")
+			}
+			return
+		}
+	}
+}
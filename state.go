@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// --- State Directory ---
+//
+// Mutable per-machine data (last-used markers, logs, caches, ...) lives under
+// ~/.local/state/multiprof, separate from the config so that the config can
+// be synced between machines and the state can be wiped at any time.
+
+// stateFile returns the path of a file or directory inside the state dir.
+func stateFile(name ...string) string {
+	stateDir, _ := getStateDir()
+	return filepath.Join(append([]string{stateDir}, name...)...)
+}
+
+// ensureStateDir creates the state directory, readable only by the user,
+// for a write with access a. With readOnly access it is never created, and
+// an error says so if it does not exist yet.
+func ensureStateDir(a access) error {
+	stateDir, _ := getStateDir()
+	if a == readOnly {
+		if _, err := os.Stat(stateDir); err != nil {
+			return fmt.Errorf("not creating the state directory %s: %w", stateDir, err)
+		}
+		return nil
+	}
+	return os.MkdirAll(stateDir, 0700)
+}
+
+func runInfo() {
+	multiprofPath, _ := os.Executable()
+	configPath, _ := getConfigPath()
+	wrapperDir, _ := getWrapperDir()
+	completionDir, _ := getCompletionDir()
+	stateDir, _ := getStateDir()
+	locations := []struct{ name, path string }{
+		{"Executable", multiprofPath},
+		{"Config file", configPath},
+		{"Wrapper Directory", wrapperDir},
+		{"Completion directory", completionDir},
+		{"State directory", stateDir},
+		{"Old Wrapper Directory", legacyWrapperDir()},
+		{"Systemd user units", expandPath(filepath.Join("~/", systemdUserDirName))},
+	}
+	for _, l := range locations {
+		status := ""
+		if _, err := os.Stat(l.path); os.IsNotExist(err) {
+			if l.path == legacyWrapperDir() {
+				continue // Only of interest until migrate-layout removes it.
+			}
+			status = " (missing)"
+		}
+		fmt.Printf("%-22s %s%s\n", l.name+":", l.path, status)
+	}
+}
+
+func runUninstall(args []string) {
+	uninstallCmd := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	purge := uninstallCmd.Bool("purge", false, "Also delete the config and state directories.")
+	yes := uninstallCmd.Bool("yes", false, "Do not ask for confirmation.")
+	uninstallCmd.Parse(args)
+
+	configPath, _ := getConfigPath()
+	stateDir, _ := getStateDir()
+	if *purge && !*yes {
+		if !isTerminal(os.Stdin) {
+			exitFailure(errRefusedChange, "Re-run with --yes.", "Refusing to purge without confirmation.")
+		}
+		if !confirm(fmt.Sprintf("Delete '%s' and '%s'?", filepath.Dir(configPath), stateDir)) {
+			logInfo("Aborted.")
+			return
+		}
+	}
+
+	tx := newTransaction(readWrite)
+	var removed []string
+	remove := func(path string) {
+		if err := tx.Remove(path); err != nil {
+			tx.abort("Could not remove '%s': %v", path, err)
+		}
+		removed = append(removed, path)
+	}
+
+	for _, wrapperDir := range wrapperDirs() {
+		entries, _ := os.ReadDir(wrapperDir)
+		foreign := 0
+		for _, e := range entries {
+			if path := filepath.Join(wrapperDir, e.Name()); isOwnWrapperFile(path) {
+				remove(path)
+			} else {
+				foreign++
+			}
+		}
+		if fileExists(wrapperDir) && foreign == 0 {
+			remove(wrapperDir)
+		}
+	}
+
+	for _, dir := range completionDirs() {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if path := filepath.Join(dir, e.Name()); isOwnCompletion(path) && indexOf(removed, path) < 0 {
+				remove(path)
+			}
+		}
+	}
+
+	unitDir := expandPath(filepath.Join("~/", systemdUserDirName))
+	for _, ext := range []string{".service", ".timer"} {
+		if path := filepath.Join(unitDir, systemdUnitName+ext); fileExists(path) {
+			remove(path)
+		}
+	}
+
+	if *purge {
+		for _, dir := range []string{stateDir, filepath.Dir(configPath)} {
+			if fileExists(dir) {
+				remove(dir)
+			}
+		}
+	}
+	tx.Commit()
+
+	for _, path := range removed {
+		logSuccess("Removed %s", path)
+	}
+	if len(removed) == 0 {
+		logInfo("Nothing to remove.")
+	}
+	if !*purge {
+		logInfo("Your config and state were kept. Use --purge to delete them too.")
+	}
+	logInfo("Remove the Wrapper Directory from PATH in your shell profile, then delete the multiprof binary.")
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Usage Stats ---
+//
+// Each Wrapper run appends the command and profile to the stats journal,
+// and how the run ended if that is known (see runlog.go). `multiprof stats`
+// summarizes it, and `multiprof compact` drops old records so the journal
+// does not grow forever.
+
+const statsJournalName = "stats.jsonl"
+
+// statRecord leaves out the arguments and directory, which only the opt-in
+// audit log (see audit.go) records.
+type statRecord struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Profile string    `json:"profile"`
+	Outcome *outcome  `json:"outcome,omitempty"`
+}
+
+// recordUse adds a Wrapper run that started at start to the stats, with its
+// outcome if known. Failures only show up in debug output; they must not
+// keep the command from running.
+func recordUse(a access, start time.Time, cmdName string, args []string, res resolution, o *outcome) {
+	r := statRecord{Time: start, Command: cmdName, Profile: res.Profile.label(), Outcome: o}
+	if err := appendRecord(a, statsJournalName, r); err != nil {
+		debugf("Could not record stats: %v", err)
+	}
+	recordAudit(a, start, cmdName, args, res, o)
+}
+
+// parseAge parses a duration such as "90d" or "12h".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days '%s'", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func runStats(args []string) {
+	statsCmd := flag.NewFlagSet("stats", flag.ExitOnError)
+	since := statsCmd.String("since", "", "Only count runs in this period, e.g. 7d or 12h.")
+	noPager := statsCmd.Bool("no-pager", false, "Print everything at once instead of one screen at a time.")
+	if positional := parseFlags(statsCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof stats [--since <age>] [--no-pager]")
+	}
+	var cutoff time.Time
+	if *since != "" {
+		age, err := parseAge(*since)
+		if err != nil {
+			exitWith(err)
+		}
+		cutoff = time.Now().Add(-age)
+	}
+
+	type usage struct {
+		runs int
+		last time.Time
+	}
+	byProfile, byCommand := map[string]*usage{}, map[string]*usage{}
+	count := func(m map[string]*usage, key string, t time.Time) {
+		u, ok := m[key]
+		if !ok {
+			u = &usage{}
+			m[key] = u
+		}
+		u.runs++
+		if t.After(u.last) {
+			u.last = t
+		}
+	}
+	total := 0
+	corrupt, err := readJournal(stateFile(statsJournalName), func(line []byte) error {
+		var r statRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if r.Time.Before(cutoff) {
+			return nil
+		}
+		total++
+		count(byProfile, r.Profile, r.Time)
+		count(byCommand, r.Command, r.Time)
+		return nil
+	})
+	if err != nil {
+		exitFailure(errFailed, "", "Could not read the stats: %v", err)
+	}
+	// byRuns returns the keys of m, most runs first.
+	byRuns := func(m map[string]*usage) []string {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if m[keys[i]].runs != m[keys[j]].runs {
+				return m[keys[i]].runs > m[keys[j]].runs
+			}
+			return keys[i] < keys[j]
+		})
+		return keys
+	}
+	if jsonOutput {
+		type usageJSON struct {
+			Name     string    `json:"name"`
+			Runs     int       `json:"runs"`
+			LastUsed time.Time `json:"last_used"`
+		}
+		list := func(m map[string]*usage) []usageJSON {
+			l := []usageJSON{}
+			for _, key := range byRuns(m) {
+				l = append(l, usageJSON{key, m[key].runs, m[key].last})
+			}
+			return l
+		}
+		printJSON(struct {
+			Total     int         `json:"total"`
+			ByProfile []usageJSON `json:"by_profile"`
+			ByCommand []usageJSON `json:"by_command"`
+		}{total, list(byProfile), list(byCommand)})
+		if corrupt > 0 {
+			logWarn("Skipped %d damaged record(s); 'multiprof compact' removes them.", corrupt)
+		}
+		return
+	}
+	if total == 0 {
+		logInfo("No Wrapper runs recorded yet.")
+		return
+	}
+
+	out := newPager(!*noPager)
+	width := terminalWidth()
+	print := func(title, column string, m map[string]*usage) {
+		fmt.Fprintf(out, "--- %s ---\n", title)
+		t := newTable(1, "RUNS", column, "LAST USED")
+		for _, key := range byRuns(m) {
+			t.add(strconv.Itoa(m[key].runs), key, m[key].last.Format("2006-01-02 15:04"))
+		}
+		t.render(out, width)
+	}
+	print("Runs by profile", "PROFILE", byProfile)
+	print("Runs by command", "COMMAND", byCommand)
+	fmt.Fprintf(out, "%d runs in total.\n", total)
+	out.Close()
+	if corrupt > 0 {
+		logWarn("Skipped %d damaged record(s); 'multiprof compact' removes them.", corrupt)
+	}
+}
+
+func runCompact(args []string) {
+	compactCmd := flag.NewFlagSet("compact", flag.ExitOnError)
+	maxAge := compactCmd.String("max-age", "90d", "Drop records older than this, e.g. 30d.")
+	audit := compactCmd.Bool("audit", false, "Compact the audit log instead of the stats.")
+	if positional := parseFlags(compactCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof compact [--max-age <age>] [--audit]")
+	}
+	age, err := parseAge(*maxAge)
+	if err != nil {
+		exitWith(err)
+	}
+	cutoff := time.Now().Add(-age)
+	name, what := statsJournalName, "the stats"
+	if *audit {
+		name, what = auditJournalName, "the audit log"
+	}
+	kept, dropped, corrupt, err := compactJournal(name, func(line []byte) bool {
+		var r struct{ Time time.Time } // Common to both kinds of record.
+		return json.Unmarshal(line, &r) == nil && !r.Time.Before(cutoff)
+	})
+	if err != nil {
+		exitFailure(errFailed, "", "Could not compact %s: %v", what, err)
+	}
+	logSuccess("Compacted %s: kept %d records, dropped %d older than %s and %d damaged.", what, kept, dropped, *maxAge, corrupt)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+)
+
+// --- Status ---
+//
+// `multiprof status [dir]` is the one-stop overview: the Rule, profile and
+// home for a directory, as `which` shows them, the state of each Wrapper in
+// the config and of the command it wraps, whether PATH finds the Wrappers,
+// and the warnings `check` and `doctor` would print about the config. It
+// only reads; doctor explains how to fix what status reports.
+
+// wrapperStatus is the state of one Wrapper listed in the config.
+type wrapperStatus struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	// State is wrapperOK, wrapperMissing or wrapperStale.
+	State string `json:"state"`
+	// Target is the command the Wrapper runs, or "" if it is not in PATH.
+	Target string `json:"target,omitempty"`
+}
+
+// pathStatus says whether PATH finds the Wrappers before the commands they
+// wrap.
+type pathStatus struct {
+	// Index is the position of the Wrapper Directory in PATH, from 1, or 0
+	// if it is missing.
+	Index    int      `json:"index"`
+	Entries  int      `json:"entries"`
+	Problems []string `json:"problems"`
+}
+
+type status struct {
+	resolutionJSON
+	Wrappers []wrapperStatus `json:"wrappers"`
+	Path     pathStatus      `json:"path"`
+	Warnings []string        `json:"warnings"`
+}
+
+func runStatus(args []string) {
+	statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
+	positional := parseFlags(statusCmd, args)
+	if len(positional) > 1 {
+		exitUsage("Usage: multiprof status [dir]")
+	}
+	dir := dirArg(positional)
+	config, _ := loadConfig(readWrite)
+	s := buildStatus(config, dir)
+	if jsonOutput {
+		printJSON(s)
+		return
+	}
+
+	fmt.Printf("Directory: %s\n", tildePath(dir))
+	switch {
+	case !s.Matched:
+		fmt.Printf("Rule:      none; Wrappers %s here\n", noMatchBehavior(config))
+	case s.Rule == nil:
+		fmt.Println("Rule:      none (profile chosen explicitly)")
+	default:
+		fmt.Printf("Rule:      %d: '%s'\n", s.Rule.Index, s.Rule.Pattern)
+		if s.Rule.Source != "" {
+			fmt.Printf("From:      %s\n", s.Rule.Source)
+		}
+	}
+	if s.Error != "" {
+		fmt.Printf("Error:     %s\n", s.Error)
+	} else if s.Matched || s.Fallback {
+		fmt.Printf("Profile:   %s\n", s.Profile)
+		fmt.Printf("HOME:      %s\n", tildePath(s.Home))
+	}
+
+	if s.Path.Index > 0 {
+		fmt.Printf("PATH:      the Wrapper Directory is entry %d of %d\n", s.Path.Index, s.Path.Entries)
+	} else {
+		fmt.Println("PATH:      the Wrapper Directory is missing")
+	}
+
+	fmt.Println("\n--- Wrappers ---")
+	if len(s.Wrappers) == 0 {
+		fmt.Println("None yet; add one with 'multiprof add-wrapper <command>'.")
+	} else {
+		t := newTable(2, "WRAPPER", "STATE", "WRAPS")
+		for _, w := range s.Wrappers {
+			target := tildePath(w.Target)
+			if w.Target == "" {
+				target = fmt.Sprintf("'%s' NOT FOUND in PATH", w.Command)
+			}
+			t.add(w.Name, w.State, target)
+		}
+		t.render(os.Stdout, terminalWidth())
+	}
+
+	problems := append(slices.Clone(s.Path.Problems), s.Warnings...)
+	fmt.Println("\n--- Warnings ---")
+	if len(problems) == 0 {
+		logSuccess("None.")
+		return
+	}
+	for _, problem := range problems {
+		logWarn("%s", problem)
+	}
+	logInfo("Run 'multiprof doctor' for how to fix them.")
+}
+
+func buildStatus(config Config, dir string) status {
+	res, ok := resolveDir(config, dir)
+	fallback := false
+	if !ok {
+		res, fallback = inspectNoMatch(config, dir)
+	}
+	var env *envBuilder
+	if (ok || fallback) && res.Err == nil {
+		env = res.environment(os.Environ())
+	}
+	s := status{resolutionJSON: resolutionToJSON(dir, res, ok, env), Wrappers: []wrapperStatus{}, Warnings: []string{}}
+
+	for _, cmdName := range slices.Sorted(maps.Keys(config.Wrappers)) {
+		w := wrapperStatus{Name: config.wrapperName(cmdName), Command: cmdName, State: wrapperState(config, cmdName)}
+		w.Target, _ = findTarget(cmdName)
+		if w.State == wrapperMissing {
+			s.Warnings = append(s.Warnings, fmt.Sprintf("The Wrapper '%s' in the config does not exist; 'multiprof sync-wrappers' creates it.", w.Name))
+		}
+		s.Wrappers = append(s.Wrappers, w)
+	}
+	s.Path = buildPathStatus(config)
+
+	s.Warnings = append(s.Warnings, validateConfig(config)...)
+	for _, p := range config.allProfiles() {
+		s.Warnings = append(s.Warnings, homePlacementWarnings(config, p)...)
+	}
+	s.Warnings = append(s.Warnings, expiryWarnings(config)...)
+	for _, hint := range []string{suffixMismatchHint(config), legacyLayoutHint()} {
+		if hint != "" {
+			s.Warnings = append(s.Warnings, hint)
+		}
+	}
+	return s
+}
+
+// buildPathStatus checks PATH like doctor does, without the fixes.
+func buildPathStatus(config Config) pathStatus {
+	wrapperDir, _ := getWrapperDir()
+	path := filepath.SplitList(os.Getenv("PATH"))
+	p := pathStatus{Index: pathIndex(path, wrapperDir) + 1, Entries: len(path), Problems: []string{}}
+	if p.Index == 0 {
+		p.Problems = append(p.Problems, fmt.Sprintf("The Wrapper Directory %s is not in PATH.", wrapperDir))
+		return p
+	}
+	if config.Settings.Suffix != "" {
+		return p
+	}
+	for _, cmdName := range ownWrapperNames(wrapperDir, "") {
+		found, err := exec.LookPath(cmdName)
+		if err != nil || isWrapperDir(filepath.Dir(found)) {
+			continue
+		}
+		p.Problems = append(p.Problems, fmt.Sprintf("'%s' runs %s instead of its Wrapper.", cmdName, found))
+	}
+	return p
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gobwas/glob"
+)
+
+// --- Strict Mode ---
+//
+// Wrappers normally make do with what they have: a config that cannot be read
+// counts as empty, Rules with an invalid glob are skipped, and the user is
+// left with a puzzling "No multiprof Rule matched". With `strict = true` in
+// [settings] or MULTIPROF_STRICT=1, Wrappers, `multiprof run` and the shell
+// hook stop with the actual problem instead: a config that cannot be read or
+// parsed, an invalid pattern, exclude, host, git_remote or when_env glob in a
+// Rule that is matched, a current directory that cannot be determined, or a
+// variable that cannot be set. A config that cannot be parsed cannot turn
+// strict mode on, so set the variable to catch that too; MULTIPROF_STRICT=0
+// turns strict mode off whatever the config says.
+
+const strictEnvVar = "MULTIPROF_STRICT"
+
+// strictHint tells the user how to get past a strict mode failure.
+const strictHint = "Strict mode is on; set " + strictEnvVar + "=0 to run anyway."
+
+// strictMode reports whether problems stop Wrappers.
+func strictMode(config Config) bool {
+	value, _ := lookupEnv(strictEnvVar)
+	switch value {
+	case "":
+		return config.Settings.Strict
+	case "0", "false":
+		return false
+	}
+	return true
+}
+
+// checkStrict stops with err, described by format and args, in strict mode,
+// and otherwise only mentions it with MULTIPROF_DEBUG set.
+func checkStrict(config Config, err error, format string, args ...any) {
+	if err == nil {
+		return
+	}
+	msg := fmt.Sprintf(format, args...) + ": " + err.Error()
+	if !strictMode(config) {
+		debugf("Ignoring error: %s", msg)
+		return
+	}
+	exitWith(&failure{errStrict, msg, strictHint, nil})
+}
+
+// checkStrictRules stops in strict mode if a Rule matched against dir, in
+// the project config, config or packs, has an invalid glob.
+func checkStrictRules(config Config, dir string) {
+	if strictMode(config) {
+		exitOnRuleProblems(ruleProblems(config, dir))
+	}
+}
+
+// ruleProblems describes the invalid globs of the Rules matched against dir,
+// in the project config, config and packs.
+func ruleProblems(config Config, dir string) []string {
+	var problems []string
+	addProblems := func(rules []Rule) {
+		for i, rule := range rules {
+			problems = append(problems, ruleGlobProblems(ruleOrigin(i, rule), rule)...)
+		}
+	}
+	if project, found := loadProjectConfig(dir); found {
+		addProblems(project.Rules)
+	}
+	addProblems(config.Rules)
+	for _, pack := range loadPacks() {
+		addProblems(pack.config().Rules)
+	}
+	return problems
+}
+
+// exitOnRuleProblems stops with the first of problems, if there are any.
+func exitOnRuleProblems(problems []string) {
+	if len(problems) == 0 {
+		return
+	}
+	hint := strictHint
+	if len(problems) > 1 {
+		hint = fmt.Sprintf("%d more problem(s); run 'multiprof check' to see them all. %s", len(problems)-1, strictHint)
+	}
+	exitWith(&failure{errInvalidRule, problems[0], hint, nil})
+}
+
+// ruleGlobProblems describes each pattern and glob of rule that does not
+// compile, prefixing them with prefix.
+func ruleGlobProblems(prefix string, rule Rule) []string {
+	var problems []string
+	if _, err := compilePattern(rule); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+	}
+	for _, exclude := range rule.Exclude {
+		if _, err := glob.Compile(expandPath(exclude)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid exclude '%s': %v", prefix, exclude, err))
+		}
+	}
+	if rule.Host != "" {
+		if _, err := glob.Compile(rule.Host); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid host '%s': %v", prefix, rule.Host, err))
+		}
+	}
+	if rule.GitRemote != "" {
+		if _, err := glob.Compile(rule.GitRemote); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid git_remote '%s': %v", prefix, rule.GitRemote, err))
+		}
+	}
+	for _, key := range sortedKeys(rule.WhenEnv) {
+		if pattern := rule.WhenEnv[key]; pattern != "" {
+			if _, err := glob.Compile(pattern); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid when_env pattern '%s' for %s: %v", prefix, pattern, key, err))
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Suffix Migration ---
+//
+// Wrapper and completion file names include settings.suffix, so editing the
+// setting orphans every existing Wrapper. The suffix Wrappers were last
+// created with is recorded in the state directory; a mismatch with the
+// setting is reported, and `multiprof migrate-suffix` renames the Wrappers,
+// regenerates their completions and updates the setting in one transaction.
+
+const wrapperSuffixStateName = "wrapper-suffix"
+
+// recordedSuffix returns the suffix Wrappers were last created with.
+func recordedSuffix() (string, bool) {
+	data, err := os.ReadFile(stateFile(wrapperSuffixStateName))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(string(data), "\n"), true
+}
+
+// recordSuffix notes that Wrappers now use suffix.
+func recordSuffix(tx *transaction, suffix string) error {
+	if recorded, ok := recordedSuffix(); ok && recorded == suffix {
+		return nil
+	}
+	stateDir, _ := getStateDir()
+	if err := tx.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+	return tx.WriteFile(stateFile(wrapperSuffixStateName), []byte(suffix+"\n"), 0600)
+}
+
+// suffixMismatchHint explains how to migrate Wrappers created with a
+// different suffix than the configured one, or returns "".
+func suffixMismatchHint(config Config) string {
+	recorded, ok := recordedSuffix()
+	if !ok || recorded == config.Settings.Suffix {
+		return ""
+	}
+	return fmt.Sprintf("Wrappers were created with suffix \"%s\" but settings.suffix is now \"%s\". Run 'multiprof migrate-suffix' to rename them.", recorded, config.Settings.Suffix)
+}
+
+func runMigrateSuffix(args []string) {
+	config, _ := loadConfig(readWrite)
+	recorded, known := recordedSuffix()
+	migrateCmd := flag.NewFlagSet("migrate-suffix", flag.ExitOnError)
+	from := migrateCmd.String("from", recorded, "The suffix existing Wrappers have (default: the one they were created with).")
+	to := migrateCmd.String("to", config.Settings.Suffix, "The new suffix (default: settings.suffix).")
+	onConflict := migrateCmd.String("on-conflict", conflictAsk, "What to do with existing files multiprof did not create: ask, keep, overwrite or backup.")
+	migrateCmd.Parse(args)
+	if len(migrateCmd.Args()) > 0 {
+		exitUsage("Usage: multiprof migrate-suffix [--from <suffix>] [--to <suffix>]")
+	}
+	if !validConflictPolicy(*onConflict) {
+		exitUsage("Invalid --on-conflict value '%s'.", *onConflict)
+	}
+	migrateCmd.Visit(func(f *flag.Flag) { known = known || f.Name == "from" })
+	if !known {
+		exitFailure(errRefusedChange, "Pass it with --from.", "The suffix of your existing Wrappers was not recorded.")
+	}
+	if *from == *to {
+		logInfo("Wrappers already use suffix \"%s\"; nothing to migrate.", *to)
+		return
+	}
+
+	if hint := legacyLayoutHint(); hint != "" {
+		exitFailure(errRefusedChange, "Migrate the suffix after that.", "%s", hint)
+	}
+	wrapperDir, _ := getWrapperDir()
+	if _, err := os.ReadDir(wrapperDir); err != nil && !os.IsNotExist(err) {
+		exitFailure(errFailed, "", "Could not read Wrapper Directory '%s': %v", wrapperDir, err)
+	}
+
+	tx := newTransaction(readWrite)
+	var migrated []string
+	for _, oldName := range wrapperNamesIn(wrapperDir) {
+		oldPath := filepath.Join(wrapperDir, oldName)
+		if !strings.HasSuffix(oldName, *from) || !isOwnWrapper(oldPath) {
+			continue
+		}
+		if _, custom := config.customNamed(oldName); custom {
+			continue // Named by its `name` setting, not the suffix.
+		}
+		cmdName := strings.TrimSuffix(oldName, *from)
+		// With a shorter old suffix (typically ""), Wrappers that already
+		// have the new one were migrated before.
+		if cmdName == "" || (len(*to) > len(*from) && strings.HasSuffix(oldName, *to)) {
+			continue
+		}
+		newName := cmdName + *to
+		newPath := filepath.Join(wrapperDir, newName)
+		kept := false
+		for _, file := range wrapperFiles(newPath) {
+			if err := clearPath(tx, file, *onConflict, isOwnWrapperFile); err == errKeptExisting {
+				kept = true
+				break
+			} else if err != nil {
+				tx.abort("%v", err)
+			}
+		}
+		if kept {
+			logWarn("Skipped '%s'.", oldName)
+			continue
+		}
+		if err := renameWrapper(tx, oldPath, newPath); err != nil {
+			tx.abort("Could not rename Wrapper '%s': %v", oldName, err)
+		}
+		// Regenerate completions for the shells that had them.
+		var shells []string
+		for _, shell := range completionShells {
+			if oldCompletion := completionPath(shell, oldName); *from != "" && fileExists(oldCompletion) && isOwnCompletion(oldCompletion) {
+				if err := tx.Remove(oldCompletion); err != nil {
+					tx.abort("Could not remove completion file '%s': %v", oldCompletion, err)
+				}
+				shells = append(shells, shell)
+			}
+		}
+		if len(shells) == 0 {
+			shell, _ := completionShell("")
+			shells = []string{shell}
+		}
+		for _, shell := range shells {
+			if *to == "" {
+				break // Unsuffixed Wrappers use their command's completions.
+			}
+			if err := createCompletionFile(tx, shell, newName, cmdName, *onConflict); err == errKeptExisting {
+				logWarn("Completion file for '%s' was not replaced.", newName)
+			} else if err != nil {
+				tx.abort("Could not create completion file for '%s': %v", newName, err)
+			}
+		}
+		migrated = append(migrated, fmt.Sprintf("%s -> %s", oldName, newName))
+	}
+	if config.Settings.Suffix != *to {
+		config.Settings.Suffix = *to
+		if err := tx.SaveConfig(config); err != nil {
+			tx.abort("Could not save config: %v", err)
+		}
+	}
+	if err := recordSuffix(tx, *to); err != nil {
+		tx.abort("Could not record the Wrapper suffix: %v", err)
+	}
+	tx.Commit()
+
+	if len(migrated) == 0 {
+		logSuccess("No Wrappers with suffix \"%s\" found; settings.suffix is now \"%s\".", *from, *to)
+		return
+	}
+	logSuccess("Migrated %d Wrappers from suffix \"%s\" to \"%s\":", len(migrated), *from, *to)
+	for _, line := range migrated {
+		fmt.Println("  " + line)
+	}
+	if *to == "" {
+		warnWrapperDirNotInPath()
+	}
+}
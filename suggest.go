@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- No-match Suggestions ---
+//
+// A directory that no Rule matches is usually a new project next to ones
+// that are covered, or a typo'd pattern. Instead of a generic add-rule hint,
+// the failure names the Rules whose directories are closest to it and, when
+// an ancestor directory already has Rules for some of its children, suggests
+// the matching Rule for this child, with a home laid out like theirs.
+
+// maxSuggestions is how many close Rules are listed.
+const maxSuggestions = 3
+
+// ruleDir returns the directory a glob Rule is rooted at: its literal prefix
+// up to the last separator.
+func ruleDir(rule Rule) string {
+	if rule.PatternType == patternRegex {
+		return ""
+	}
+	prefix := literalPrefix(rule)
+	if strings.HasSuffix(prefix, "/") {
+		return filepath.Clean(prefix)
+	}
+	return filepath.Dir(prefix)
+}
+
+func pathComponents(path string) []string {
+	return strings.Split(strings.Trim(filepath.ToSlash(filepath.Clean(path)), "/"), "/")
+}
+
+// commonComponents counts the leading path components a and b share.
+func commonComponents(a, b string) int {
+	ca, cb := pathComponents(a), pathComponents(b)
+	n := 0
+	for n < len(ca) && n < len(cb) && ca[n] == cb[n] {
+		n++
+	}
+	return n
+}
+
+// noMatchHint suggests how to make a Rule match dir.
+func noMatchHint(config Config, dir string) string {
+	dir = expandPath(dir)
+	home, _ := userHomeDir()
+	// Sharing only the home directory (or less) is no sign of closeness.
+	minShared := len(pathComponents(home)) + 1
+
+	type candidate struct {
+		index, shared int
+		dir           string
+	}
+	var close []candidate
+	for i, rule := range config.Rules {
+		rd := ruleDir(rule)
+		if rd == "" || rd == "." {
+			continue
+		}
+		if shared := commonComponents(rd, dir); shared >= minShared {
+			close = append(close, candidate{i, shared, rd})
+		}
+	}
+	sort.SliceStable(close, func(a, b int) bool { return close[a].shared > close[b].shared })
+
+	var lines []string
+	for i, c := range close {
+		if i == maxSuggestions {
+			break
+		}
+		rule := config.Rules[c.index]
+		lines = append(lines, fmt.Sprintf("Did you mean Rule %d ('%s')? It covers %s.", c.index+1, rule.Pattern, tildePath(c.dir)))
+	}
+
+	// The deepest ancestor of dir with Rules for other children of it.
+	pattern, home2 := tildePath(dir)+"/**", "/path/to/home"
+	if len(close) > 0 {
+		best := close[0]
+		ancestor := filepath.Join(append([]string{string(os.PathSeparator)}, pathComponents(dir)[:best.shared]...)...)
+		if rel, err := filepath.Rel(ancestor, dir); err == nil && rel != "." && filepath.Dir(best.dir) == ancestor {
+			child := strings.Split(filepath.ToSlash(rel), "/")[0]
+			pattern = tildePath(filepath.Join(ancestor, child)) + "/**"
+			home2 = siblingHome(config, config.Rules[best.index], filepath.Base(best.dir), child)
+		}
+	}
+	lines = append(lines, fmt.Sprintf("To add a Rule, run: multiprof add-rule --pattern \"%s\" --home \"%s\"", pattern, home2))
+	return strings.Join(lines, "\n[INFO] ")
+}
+
+// siblingHome suggests a home for a new sibling directory named child, laid
+// out like the home of the sibling Rule for siblingName if that home is named
+// after it.
+func siblingHome(config Config, sibling Rule, siblingName, child string) string {
+	p, err := config.resolveProfile(sibling)
+	if err != nil || filepath.Base(p.Home) != siblingName {
+		return "/path/to/home"
+	}
+	return tildePath(filepath.Join(filepath.Dir(p.Home), child))
+}
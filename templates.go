@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// --- Templates ---
+//
+// Every template multiprof renders (shell completions, init instructions,
+// systemd units and manifest files) has the functions below available. The
+// embedded templates can be replaced by dropping a file with the same name
+// into ~/.config/multiprof/templates, or into the templates directory of an
+// installed pack.
+
+const templatesDirName = "templates"
+
+var templateFuncs = template.FuncMap{
+	"env": os.Getenv,
+	"hostname": func() string {
+		name, _ := os.Hostname()
+		return name
+	},
+	"now": time.Now,
+	// trimPrefix takes the prefix first so it reads well in pipelines:
+	// {{ .Home | trimPrefix "/home/" }}.
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"shellQuote": shellQuote,
+	"jsonEscape": func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b[1 : len(b)-1])
+	},
+}
+
+func templatesDir() string {
+	configPath, _ := getConfigPath()
+	return filepath.Join(filepath.Dir(configPath), templatesDirName)
+}
+
+// loadTemplate parses the embedded template name, or the user's replacement
+// for it if one exists.
+func loadTemplate(name, builtin string) (*template.Template, error) {
+	text, source := builtin, "built-in template "+name
+	candidates := []string{filepath.Join(templatesDir(), name)}
+	for _, pack := range loadPacks() {
+		candidates = append(candidates, filepath.Join(pack.dir, templatesDirName, name))
+	}
+	for _, path := range candidates {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		debugf("Using template override: %s", path)
+		text, source = string(data), path
+		break
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", source, err)
+	}
+	return tmpl, nil
+}
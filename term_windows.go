@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procGetConsoleScreenBufferInfo = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleScreenBufferInfo")
+
+// isTerminal reports whether f is attached to a console.
+func isTerminal(f *os.File) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(f.Fd()), &mode) == nil
+}
+
+// terminalSize returns the size of the visible window of the console f is
+// attached to.
+func terminalSize(f *os.File) (cols, rows int, ok bool) {
+	var info struct {
+		Size, CursorPosition     struct{ X, Y int16 }
+		Attributes               uint16
+		Left, Top, Right, Bottom int16
+		MaximumWindowSize        struct{ X, Y int16 }
+	}
+	r, _, _ := procGetConsoleScreenBufferInfo.Call(f.Fd(), uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return 0, 0, false
+	}
+	return int(info.Right-info.Left) + 1, int(info.Bottom-info.Top) + 1, true
+}
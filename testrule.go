@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// --- Trying Out Rules ---
+//
+// `multiprof test-rule --pattern <p> [dirs...]` shows what adding a Rule
+// would do before it is added: for each sample directory (default: the
+// current one), whether the proposed pattern matches, which Rule matches
+// today and which would after `add-rule` appends it. Existing Rules that
+// keep the new one from matching, or that it would take over from with
+// match_strategy = "longest", are listed as conflicts. Only the Rules in your
+// own config are considered; project configs and packs are left out.
+
+// testedDir is one row of the test-rule matrix.
+type testedDir struct {
+	Directory string `json:"directory"`
+	Matches   bool   `json:"matches"`
+	// Now and After are the 1-based index of the Rule that matches before
+	// and after adding the new one, or 0 for none. The new Rule's index is
+	// one more than the number of Rules.
+	Now   int `json:"now"`
+	After int `json:"after"`
+}
+
+func runTestRule(args []string) {
+	testCmd := flag.NewFlagSet("test-rule", flag.ExitOnError)
+	patternFlag := testCmd.String("pattern", "", "The proposed pattern.")
+	regexFlag := testCmd.Bool("regex", false, "Treat --pattern as a regular expression instead of a glob.")
+	var excludeFlag stringListFlag
+	testCmd.Var(&excludeFlag, "exclude", "A glob of directories the Rule must not match. May be repeated.")
+	dirs := parseFlags(testCmd, args)
+	if *patternFlag == "" {
+		exitUsage("Usage: multiprof test-rule --pattern <p> [--regex] [--exclude <glob> ...] [dir...]")
+	}
+	if len(dirs) == 0 {
+		cwd, _ := os.Getwd()
+		dirs = []string{cwd}
+	}
+	rule := Rule{Pattern: *patternFlag, Exclude: excludeFlag}
+	if *regexFlag {
+		rule.PatternType = patternRegex
+	}
+	if _, err := compilePattern(rule); err != nil {
+		exitWith(err)
+	}
+
+	config, _ := loadConfig(readWrite)
+	preview := config
+	preview.Rules = append(append([]Rule(nil), config.Rules...), rule)
+	newIndex := len(preview.Rules) - 1
+	var rows []testedDir
+	// A conflict is an existing Rule that wins over the new one in dir, or
+	// that the new one takes over from.
+	type conflict struct {
+		index     int
+		dir       string
+		takesOver bool
+	}
+	var conflicts []conflict
+	seen := map[int]bool{}
+	for _, dir := range dirs {
+		dir = dirArg([]string{dir})
+		row := testedDir{Directory: dir}
+		_, row.Matches = matchRule(Config{Settings: config.Settings, Rules: []Rule{rule}}, dir)
+		if i, ok := matchRule(config, dir); ok {
+			row.Now = i + 1
+		}
+		if i, ok := matchRule(preview, dir); ok {
+			row.After = i + 1
+		}
+		c := conflict{index: -1, dir: dir}
+		switch {
+		case row.Matches && row.After != newIndex+1:
+			c.index = row.After - 1
+		case row.Now > 0 && row.After == newIndex+1:
+			c.index, c.takesOver = row.Now-1, true
+		}
+		if c.index >= 0 && !seen[c.index] {
+			seen[c.index] = true
+			conflicts = append(conflicts, c)
+		}
+		rows = append(rows, row)
+	}
+
+	if jsonOutput {
+		printJSON(rows)
+		return
+	}
+	describe := func(index int) string {
+		switch {
+		case index == 0:
+			return "-"
+		case index == newIndex+1:
+			return fmt.Sprintf("%d (new)", index)
+		}
+		return fmt.Sprintf("%d '%s'", index, preview.Rules[index-1].Pattern)
+	}
+	t := newTable(0, "DIRECTORY", "NEW RULE", "NOW", "AFTER ADDING")
+	for _, row := range rows {
+		matches := "no match"
+		if row.Matches {
+			matches = "matches"
+		}
+		t.add(tildePath(row.Directory), matches, describe(row.Now), describe(row.After))
+	}
+	t.render(os.Stdout, terminalWidth())
+
+	if len(conflicts) == 0 {
+		logSuccess("No conflicts with your existing Rules.")
+		return
+	}
+	for _, c := range conflicts {
+		rule := config.Rules[c.index]
+		if c.takesOver {
+			logWarn("Rule %d ('%s') matches %s today; the new Rule would take over there.", c.index+1, rule.Pattern, tildePath(c.dir))
+			continue
+		}
+		logWarn("Rule %d ('%s') matches %s first, so the new Rule would not be used there.", c.index+1, rule.Pattern, tildePath(c.dir))
+		if config.Settings.MatchStrategy != matchLongest {
+			logInfo("After adding it, 'multiprof move-rule %d %d' gives the new Rule priority.", newIndex+1, c.index+1)
+		}
+	}
+}
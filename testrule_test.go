@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestTestRuleRelativeDirs(t *testing.T) {
+	root := setupDaemonTest(t, "")
+	if err := os.MkdirAll(filepath.Join(root, "work", "acme"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(multiprofTestEnvVar, "1")
+
+	cmd := exec.Command(self, "--json", "test-rule", "--pattern", "~/work/**", filepath.Join("work", "acme"), filepath.Join(root, "work", "acme"))
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("test-rule: %v", err)
+	}
+	var rows []testedDir
+	if err := json.Unmarshal(out, &rows); err != nil {
+		t.Fatalf("test-rule printed %q: %v", out, err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("test-rule printed %d rows, want 2", len(rows))
+	}
+	for _, row := range rows {
+		if !row.Matches || row.Directory != filepath.Join(root, "work", "acme") {
+			t.Errorf("row %+v, want %s to match", row, filepath.Join(root, "work", "acme"))
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// --- Match Tracing ---
+//
+// `multiprof trace [dir] [--cmd <command>]` shows the whole matching process
+// for a directory: every Rule in the order it is checked, its pattern as it
+// looks after ~ and variables are expanded, each exclude and condition, and
+// the step at which it failed or matched. Glob syntax causes most surprises,
+// so a pattern that almost matches comes with a hint, e.g. that '*' stops at
+// '/'. Invalid exclude globs, which matching otherwise skips silently, are
+// shown as such.
+
+// envVarRef finds $VAR and ${VAR} references in a pattern.
+var envVarRef = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)\}?`)
+
+func runTrace(args []string) {
+	traceCmd := flag.NewFlagSet("trace", flag.ExitOnError)
+	traceCmd.StringVar(&invokedCommand, "cmd", "", "Match as if this command were being run, for Rules with `commands`.")
+	positional := parseFlags(traceCmd, args)
+	if len(positional) > 1 {
+		exitUsage("Usage: multiprof trace [dir] [--cmd <command>]")
+	}
+	dir := expandPath(dirArg(positional))
+	config, _ := loadConfig(readWrite)
+	if invokedCommand != "" {
+		fmt.Printf("Tracing %s for '%s', match_strategy %s.\n", dir, invokedCommand, orDefault(config.Settings.MatchStrategy, matchFirst))
+	} else {
+		fmt.Printf("Tracing %s, match_strategy %s.\n", dir, orDefault(config.Settings.MatchStrategy, matchFirst))
+	}
+
+	used := false
+	traceSource := func(source string, rules []Rule) {
+		fmt.Printf("\n%s\n", source)
+		if len(rules) == 0 {
+			fmt.Println("  (no Rules)")
+			return
+		}
+		winner := -1
+		if i, ok := matchRule(Config{Settings: config.Settings, Rules: rules}, dir); ok && !used {
+			winner, used = i, true
+		}
+		for i, rule := range rules {
+			fmt.Printf("  Rule %d '%s'\n", i+1, rule.Pattern)
+			for _, line := range traceRule(rule, dir) {
+				fmt.Printf("      %s\n", line)
+			}
+			if i == winner {
+				fmt.Println("      => USED")
+			}
+		}
+	}
+	if path, ok := findProjectConfig(dir); ok {
+		if project, trusted := projectConfigIfTrusted(path); trusted {
+			traceSource(path+":", project.Rules)
+		} else {
+			fmt.Printf("\n%s: ignored until you run 'multiprof trust %s'\n", path, filepath.Dir(path))
+		}
+	}
+	configPath, _ := getConfigPath()
+	traceSource(configPath+":", config.Rules)
+	for _, pack := range loadPacks() {
+		traceSource(fmt.Sprintf("Pack '%s':", pack.Name), pack.Rules)
+	}
+	fmt.Println()
+	if !used {
+		logWarn("No Rule matches; Wrappers will %s here.", noMatchBehavior(config))
+	}
+}
+
+// traceRule describes each step of matching rule against the expanded
+// directory dir.
+func traceRule(rule Rule, dir string) []string {
+	var lines []string
+	if rule.disabled() {
+		return append(lines, "disabled with enabled = false")
+	}
+	if rule.expired() {
+		return append(lines, "expired on "+rule.Expires)
+	}
+	m, err := compilePattern(rule)
+	if err != nil {
+		return append(lines, "ERROR: "+err.Error())
+	}
+	dirWithSlash := dir + string(os.PathSeparator)
+	if rule.PatternType == patternRegex {
+		lines = append(lines, "regex:   "+rule.Pattern)
+	} else {
+		lines = append(lines, "glob:    "+expandPath(rule.Pattern))
+	}
+	if !m.Match(dir) && !m.Match(dirWithSlash) {
+		lines = append(lines, fmt.Sprintf("no match against %s or %s", dir, dirWithSlash))
+		for _, hint := range globHints(rule, dir) {
+			lines = append(lines, "hint:    "+hint)
+		}
+		return lines
+	}
+	lines = append(lines, "pattern matches")
+	excluded := false
+	for _, pattern := range rule.Exclude {
+		expanded := expandPath(pattern)
+		g, err := glob.Compile(expanded)
+		switch {
+		case err != nil:
+			lines = append(lines, fmt.Sprintf("exclude '%s': INVALID, ignored: %v", pattern, err))
+		case !excluded && (g.Match(dir) || g.Match(dirWithSlash)):
+			excluded = true
+			lines = append(lines, fmt.Sprintf("exclude '%s' (%s) matches, so the Rule does not apply", pattern, expanded))
+		case !excluded:
+			lines = append(lines, fmt.Sprintf("exclude '%s' (%s) does not match", pattern, expanded))
+		}
+	}
+	if excluded {
+		return lines
+	}
+	if hasConditions(rule) {
+		if ok, reason := conditionsMet(rule, dir); !ok {
+			return append(lines, "condition not met: "+reason)
+		}
+		lines = append(lines, "conditions met")
+	}
+	return append(lines, "MATCHES")
+}
+
+// globHints explains common reasons a glob Rule does not match dir, which
+// it nearly does.
+func globHints(rule Rule, dir string) []string {
+	if rule.PatternType == patternRegex {
+		return nil
+	}
+	var hints []string
+	for _, ref := range envVarRef.FindAllStringSubmatch(rule.Pattern, -1) {
+		if _, set := os.LookupEnv(ref[1]); !set {
+			hints = append(hints, fmt.Sprintf("$%s is not set, so it expands to nothing.", ref[1]))
+		}
+	}
+	expanded := expandPath(rule.Pattern)
+	if !filepath.IsAbs(expanded) && !strings.HasPrefix(expanded, "*") {
+		hints = append(hints, "The pattern is not an absolute path, so it never matches; start it with / or ~/.")
+	}
+	if g, err := glob.Compile(strings.ToLower(expanded)); err == nil && (g.Match(strings.ToLower(dir)) || g.Match(strings.ToLower(dir)+"/")) {
+		hints = append(hints, "The pattern matches if case is ignored, but globs are case-sensitive.")
+	}
+	if real, err := filepath.EvalSymlinks(dir); err == nil && real != dir {
+		if g, err := glob.Compile(expanded); err == nil && (g.Match(real) || g.Match(real+"/")) {
+			hints = append(hints, fmt.Sprintf("The pattern matches %s, where the symlinked directory points; patterns are matched against the path as given.", real))
+		}
+	}
+	prefix := literalPrefix(Rule{Pattern: expanded})
+	base := strings.TrimSuffix(prefix, "/")
+	if !within(base, dir) && !within(filepath.Dir(prefix), dir) {
+		return hints
+	}
+	switch {
+	case !strings.ContainsAny(expanded, "*?[{"):
+		hints = append(hints, "The pattern has no wildcard, so it only matches that exact directory; end it with /** to include subdirectories.")
+	case strings.HasSuffix(expanded, "/*") && !strings.Contains(expanded, "**"):
+		hints = append(hints, "'*' does not match across '/', so /* only covers direct subdirectories; use /** for any depth.")
+	case strings.Contains(expanded, "*") && !strings.Contains(expanded, "**"):
+		hints = append(hints, "'*' does not match across '/'; use '**' where the pattern should span several directories.")
+	}
+	return hints
+}
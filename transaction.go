@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// --- Transactions ---
+//
+// Management commands often touch several files: the config, a Wrapper
+// symlink, a completion file. A transaction records how to undo each change
+// so a failure midway can roll everything back instead of leaving partial
+// state behind. Removed and overwritten files are moved aside and only
+// deleted on Commit. In a Wrapper, transactions refuse to touch the config,
+// Wrappers and completions (see readonly.go).
+
+type transaction struct {
+	undo     []func() error
+	finalize []func() error
+	done     bool
+	// backedUp is set once the config has been backed up.
+	backedUp bool
+	// access limits what the transaction may change (see readonly.go).
+	access access
+	// changed holds the paths changed, for --json (see jsonoutput.go).
+	changed []string
+}
+
+// newTransaction starts a transaction for a command with access a.
+func newTransaction(a access) *transaction { return &transaction{access: a} }
+
+// MkdirAll creates path and any missing parents, undoing only the
+// directories it actually created.
+func (tx *transaction) MkdirAll(path string, perm os.FileMode) error {
+	if err := tx.access.checkWritable(path); err != nil {
+		return err
+	}
+	var created []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		created = append(created, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if err := os.MkdirAll(path, perm); err != nil {
+		return err
+	}
+	// created runs from the deepest directory up; Rollback undoes in reverse,
+	// so add the shallowest first to remove the deepest first.
+	for _, dir := range slices.Backward(created) {
+		tx.undo = append(tx.undo, func() error { return os.Remove(dir) })
+		tx.changed = append(tx.changed, dir)
+	}
+	return nil
+}
+
+// WriteFile writes data to path, moving any existing file aside first.
+func (tx *transaction) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := tx.access.checkWritable(path); err != nil {
+		return err
+	}
+	if err := tx.moveAside(path); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error { return removeIfExists(path) })
+	tx.changed = append(tx.changed, path)
+	return os.WriteFile(path, data, perm)
+}
+
+// Symlink creates newname pointing at oldname.
+func (tx *transaction) Symlink(oldname, newname string) error {
+	if err := tx.access.checkWritable(newname); err != nil {
+		return err
+	}
+	if err := os.Symlink(oldname, newname); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error { return os.Remove(newname) })
+	tx.changed = append(tx.changed, newname)
+	return nil
+}
+
+// Link creates newname as a hardlink to oldname.
+func (tx *transaction) Link(oldname, newname string) error {
+	if err := tx.access.checkWritable(newname); err != nil {
+		return err
+	}
+	if err := os.Link(oldname, newname); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error { return os.Remove(newname) })
+	tx.changed = append(tx.changed, newname)
+	return nil
+}
+
+// Remove deletes path once the transaction commits.
+func (tx *transaction) Remove(path string) error {
+	if err := tx.access.checkWritable(path); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return err
+	}
+	tx.changed = append(tx.changed, path)
+	return tx.moveAside(path)
+}
+
+// Rename moves oldpath to newpath, which must not exist.
+func (tx *transaction) Rename(oldpath, newpath string) error {
+	for _, path := range []string{oldpath, newpath} {
+		if err := tx.access.checkWritable(path); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Lstat(newpath); err == nil {
+		return fmt.Errorf("'%s' already exists", newpath)
+	}
+	if err := os.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error { return os.Rename(newpath, oldpath) })
+	tx.changed = append(tx.changed, oldpath, newpath)
+	return nil
+}
+
+// CopyTree copies the directory src into dst, creating dst if needed.
+// Existing files in dst are replaced; symlinks are copied as symlinks.
+// Paths relative to src that match one of exclude are skipped, directories
+// with everything in them.
+func (tx *transaction) CopyTree(src, dst string, exclude ...matcher) error {
+	return filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		for _, m := range exclude {
+			if rel != "." && m.Match(filepath.ToSlash(rel)) {
+				debugf("Skipping excluded path: %s", path)
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return tx.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := tx.moveAside(target); err != nil {
+				return err
+			}
+			return tx.Symlink(link, target)
+		case d.Type().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return tx.WriteFile(target, data, info.Mode().Perm())
+		}
+		debugf("Skipping special file: %s", path)
+		return nil
+	})
+}
+
+// SaveConfig writes config to the config file, editing the current file in
+// place so its comments and layout are kept (see configedit.go).
+func (tx *transaction) SaveConfig(config Config) error {
+	configPath, _ := getConfigPath()
+	if original, err := os.ReadFile(configPath); err == nil {
+		for _, perKey := range []bool{true, false} {
+			edited, err := editConfig(string(original), config, perKey)
+			if err == nil {
+				return tx.WriteConfig([]byte(edited))
+			}
+			debugf("Could not edit the config in place (per key: %t): %v", perKey, err)
+		}
+		if bytes.Contains(original, []byte("#")) {
+			logWarn("Could not edit %s in place; rewriting it, which drops its comments.", configPath)
+		}
+	}
+	data, err := encodeConfig(config)
+	if err != nil {
+		return err
+	}
+	return tx.WriteConfig(data)
+}
+
+// WriteConfig replaces the config file with data, keeping a backup of the
+// previous version. A config symlinked elsewhere, e.g. into a dotfiles
+// repository, is replaced at its target, and keeps its permissions.
+func (tx *transaction) WriteConfig(data []byte) error {
+	configPath, _ := getConfigPath()
+	if err := backupConfig(tx); err != nil {
+		return fmt.Errorf("could not back up %s: %w", configPath, err)
+	}
+	path, perm := configPath, os.FileMode(0644)
+	if target, err := filepath.EvalSymlinks(configPath); err == nil {
+		path = target
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return tx.WriteFileAtomic(path, data, perm)
+}
+
+// WriteFileAtomic replaces path with data through a temporary file and a
+// rename, so that even after a crash path holds either the old or the new
+// contents, never a truncated file.
+func (tx *transaction) WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := tx.access.checkWritable(path); err != nil {
+		return err
+	}
+	old, err := os.ReadFile(path)
+	existed := err == nil
+	if err := writeFileAtomic(path, data, perm); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error {
+		if existed {
+			return writeFileAtomic(path, old, perm)
+		}
+		return removeIfExists(path)
+	})
+	tx.changed = append(tx.changed, path)
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// moveAside moves an existing path into a new temporary directory next to
+// it, to be restored on Rollback or deleted on Commit. Each call gets its own
+// directory, so moving the same path aside twice keeps both versions.
+func (tx *transaction) moveAside(path string) error {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), "."+filepath.Base(path)+".multiprof-tx-*")
+	if err != nil {
+		return err
+	}
+	aside := filepath.Join(dir, filepath.Base(path))
+	if err := os.Rename(path, aside); err != nil {
+		os.Remove(dir)
+		return err
+	}
+	tx.undo = append(tx.undo, func() error {
+		removeIfExists(path)
+		if err := os.Rename(aside, path); err != nil {
+			return err
+		}
+		return os.Remove(dir)
+	})
+	tx.finalize = append(tx.finalize, func() error { return os.RemoveAll(dir) })
+	return nil
+}
+
+// Commit makes the changes permanent.
+func (tx *transaction) Commit() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	recordChanged(tx.changed)
+	for _, f := range tx.finalize {
+		if err := f(); err != nil {
+			debugf("Transaction cleanup failed: %v", err)
+		}
+	}
+}
+
+// Rollback undoes every change in reverse order. It is a no-op after Commit.
+func (tx *transaction) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := tx.undo[i](); err != nil {
+			logWarn("Rollback step failed: %v", err)
+		}
+	}
+}
+
+// abort rolls back and exits with the failure format describes.
+func (tx *transaction) abort(format string, v ...interface{}) {
+	f := &failure{errFailed, fmt.Sprintf(format, v...), "", nil}
+	tx.Rollback()
+	if len(tx.undo) > 0 {
+		f.hint = "All changes made by this command were rolled back."
+	}
+	exitWith(f)
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
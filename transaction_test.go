@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestTransactionRollbackNestedDirs(t *testing.T) {
+	root := t.TempDir()
+	tx := newTransaction(readWrite)
+	if err := tx.MkdirAll(filepath.Join(root, "a", "b", "c"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.WriteFile(filepath.Join(root, "a", "b", "c", "file"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	if _, err := os.Stat(filepath.Join(root, "a")); !os.IsNotExist(err) {
+		t.Errorf("a/ left behind after Rollback: %v", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("Rollback removed a directory it did not create: %v", err)
+	}
+}
+
+func TestTransactionRollbackRestoresFiles(t *testing.T) {
+	root := t.TempDir()
+	written := filepath.Join(root, "written")
+	removed := filepath.Join(root, "removed")
+	for _, path := range []string{written, removed} {
+		if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx := newTransaction(readWrite)
+	if err := tx.WriteFile(written, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.WriteFileAtomic(written, []byte("newer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	for _, path := range []string{written, removed} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != "old" {
+			t.Errorf("%s = %q, %v after Rollback; want \"old\"", filepath.Base(path), data, err)
+		}
+	}
+	assertOnlyEntries(t, root, "removed", "written")
+}
+
+func TestTransactionCommit(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "file")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tx := newTransaction(readWrite)
+	if err := tx.WriteFile(path, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+	tx.Rollback()
+	if data, err := os.ReadFile(path); err != nil || string(data) != "new" {
+		t.Errorf("file = %q, %v after Commit; want \"new\"", data, err)
+	}
+	assertOnlyEntries(t, root, "file")
+}
+
+// assertOnlyEntries fails t unless dir holds exactly names, so nothing moved
+// aside is left behind.
+func assertOnlyEntries(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if !slices.Equal(got, names) {
+		t.Errorf("%s holds %v, want %v", dir, got, names)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// --- Config Validation ---
+//
+// Wrappers skip Rules they cannot use (an invalid glob, an unknown profile)
+// and only say so with MULTIPROF_DEBUG set. `multiprof check` without a
+// directory finds these problems up front: it parses the config, compiles
+// every pattern, resolves every profile and preset, checks that each home
+// exists and is writable, and reports Rules that can never match because an
+// earlier Rule always wins.
+
+// validateConfig returns a description of each problem in config.
+func validateConfig(config Config) []string {
+	var problems []string
+	profiles := config.withPackProfiles(loadPacks())
+	switch config.Settings.MatchStrategy {
+	case "", matchFirst, matchLongest, matchPrompt:
+	default:
+		problems = append(problems, fmt.Sprintf("settings: unknown match_strategy '%s' (use first, longest or prompt)", config.Settings.MatchStrategy))
+	}
+	if _, err := latencyBudget(config); err != nil {
+		problems = append(problems, fmt.Sprintf("settings: %v", err))
+	}
+	if _, _, err := defaultResolution(config); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := checkOnNoMatch(config); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	for i, rule := range config.Rules {
+		prefix := ruleOrigin(i, rule)
+		problems = append(problems, ruleGlobProblems(prefix, rule)...)
+		if _, _, err := ruleExpiry(rule); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+		}
+		if _, err := credentialsTTL(rule); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+		}
+		p, err := profiles.resolveProfile(rule)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+			continue
+		}
+		for _, spec := range []EnvSpec{p.EnvSpec, rule.EnvSpec} {
+			if err := checkPresets(spec, profiles.Presets); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+			}
+		}
+		if j, ok := shadowingRule(config, i); ok {
+			problems = append(problems, fmt.Sprintf("%s: never matches, because Rule %d ('%s') matches every directory it does and comes first", prefix, j+1, config.Rules[j].Pattern))
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(config.Profiles)) {
+		aliases := config.Profiles[name].Aliases
+		for _, alias := range sortedKeys(aliases) {
+			command := aliases[alias]
+			if alias == "" || strings.ContainsAny(alias, `/\`) || strings.TrimSpace(command) == "" {
+				problems = append(problems, fmt.Sprintf("Profile '%s': invalid alias '%s' = '%s'", name, alias, command))
+			}
+		}
+	}
+	for _, p := range config.allProfiles() {
+		if problem := checkHome(p); problem != "" {
+			problems = append(problems, fmt.Sprintf("Profile '%s': %s", p.label(), problem))
+		}
+	}
+	return problems
+}
+
+// checkHome reports a profile home that does not exist or is not writable.
+func checkHome(p namedProfile) string {
+	info, err := os.Stat(p.Home)
+	switch {
+	case p.Encrypted:
+		// Only a mount point until the home is unlocked.
+		return ""
+	case os.IsNotExist(err) && p.Autocreate:
+		if skeleton := expandPath(p.Skeleton); skeleton != "" && !fileExists(skeleton) {
+			return fmt.Sprintf("skeleton '%s' does not exist", p.Skeleton)
+		}
+		// Created on first use.
+		return ""
+	case os.IsNotExist(err):
+		return fmt.Sprintf("home '%s' does not exist (set autocreate = true to create it on first use)", p.Home)
+	case err != nil:
+		if problem := permissionProblem(p.Home, err); problem != "" {
+			return problem
+		}
+		return err.Error()
+	case !info.IsDir():
+		return fmt.Sprintf("home '%s' is not a directory", p.Home)
+	}
+	f, err := os.CreateTemp(p.Home, ".multiprof-check-*")
+	if err != nil {
+		return fmt.Sprintf("home '%s' is not writable: %v", p.Home, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return ""
+}
+
+// homePlacementWarnings describes how p's home lies inside a directory a
+// Rule matches or inside a git work tree, where tools see the profile's
+// dotfiles as project files and may pick up the project's own config as the
+// profile's. A home that is itself the directory a Rule matches, as in
+// "~/clients/acme/**" with home "~/clients/acme", is fine.
+func homePlacementWarnings(config Config, p namedProfile) []string {
+	var warnings []string
+	if i, ok := homeInsideRule(config.Rules, p.Home); ok {
+		warnings = append(warnings, fmt.Sprintf("Profile '%s': home '%s' is inside a directory matched by Rule %d ('%s'), so tools run there see the profile's files as project files.", p.label(), tildePath(p.Home), i+1, config.Rules[i].Pattern))
+	}
+	if root, ok := gitWorkTree(filepath.Dir(p.Home)); ok {
+		warnings = append(warnings, fmt.Sprintf("Profile '%s': home '%s' is inside the git work tree %s, so git sees the profile's files as untracked files there.", p.label(), tildePath(p.Home), tildePath(root)))
+	}
+	return warnings
+}
+
+// homeInsideRule returns the first of rules whose pattern matches the
+// directory containing home, ignoring the Rules' conditions. Catch-all Rules
+// for your whole home directory or more do not count: they match everything
+// without making it a project.
+func homeInsideRule(rules []Rule, home string) (int, bool) {
+	dir := filepath.Dir(home)
+	dirWithSlash := dir + string(os.PathSeparator)
+	own, _ := userHomeDir()
+	for i, rule := range rules {
+		prefix := literalPrefix(rule)
+		if prefix == "" || within(filepath.Dir(prefix+"x"), own) {
+			continue
+		}
+		m, err := compilePattern(rule)
+		if err != nil || (!m.Match(dir) && !m.Match(dirWithSlash)) {
+			continue
+		}
+		if _, excluded := excludedBy(rule, dir, dirWithSlash); !excluded {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// shadowingRule returns an earlier Rule that matches every directory Rule i
+// matches and always applies there, making Rule i unreachable. It only
+// recognizes the common case of an earlier "<dir>/**" glob without
+// exclusions or conditions covering Rule i's literal prefix.
+func shadowingRule(config Config, i int) (int, bool) {
+	rule := config.Rules[i]
+	if config.Settings.MatchStrategy == matchPrompt {
+		return -1, false // Every matching Rule is offered.
+	}
+	if rule.disabled() || rule.expired() {
+		return -1, false // Turned off on purpose.
+	}
+	for j := 0; j < i; j++ {
+		earlier := config.Rules[j]
+		if earlier.disabled() || earlier.expired() || earlier.PatternType == patternRegex || len(earlier.Exclude) > 0 || hasConditions(earlier) {
+			continue
+		}
+		pattern := expandPath(earlier.Pattern)
+		if rule.PatternType != patternRegex && pattern == expandPath(rule.Pattern) {
+			return j, true
+		}
+		if config.Settings.MatchStrategy == matchLongest {
+			// Only identical patterns tie; more specific ones win.
+			continue
+		}
+		dir, ok := strings.CutSuffix(pattern, "/**")
+		if !ok || strings.ContainsAny(dir, `*?[{\`) {
+			continue
+		}
+		if pattern == "**" || strings.HasPrefix(literalPrefix(rule), dir+"/") {
+			return j, true
+		}
+	}
+	return -1, false
+}
+
+// hasConditions reports whether a Rule only applies in some circumstances.
+func hasConditions(rule Rule) bool {
+	return rule.InteractiveOnly || rule.NonInteractiveOnly || len(rule.Commands) > 0 ||
+		rule.Host != "" || len(rule.WhenEnv) > 0 || rule.GitRemote != ""
+}
+
+// runCheckConfig implements `multiprof check` without a directory. With
+// prune, or if the user agrees on a terminal, it removes expired Rules first.
+func runCheckConfig(quiet, prune bool) {
+	configPath, _ := getConfigPath()
+	config, err := loadConfig(readWrite)
+	if err != nil {
+		if jsonOutput && !quiet {
+			printJSON(configCheck{Config: configPath, Problems: []string{err.Error()}, Warnings: []string{}})
+		} else if !quiet {
+			logError("Could not parse %s: %v", configPath, err)
+		}
+		os.Exit(checkInvalidRule)
+	}
+	if expired := expiredRules(config); len(expired) > 0 {
+		if !quiet && !jsonOutput {
+			for _, warning := range expiryWarnings(config) {
+				logWarn("%s", warning)
+			}
+		}
+		switch {
+		case prune || (!quiet && !jsonOutput && isInteractive() && confirm(fmt.Sprintf("Remove the %d expired Rule(s) from your config?", len(expired)))):
+			pruneExpiredRules(config)
+			config, _ = loadConfig(readWrite)
+		case !quiet && !jsonOutput:
+			logInfo("Run 'multiprof check --prune' to remove them.")
+		}
+	}
+	problems := validateConfig(config)
+	if jsonOutput {
+		c := configCheck{Config: configPath, Valid: len(problems) == 0, Problems: problems, Warnings: []string{}, Rules: len(config.Rules), Profiles: len(config.allProfiles())}
+		if c.Problems == nil {
+			c.Problems = []string{}
+		}
+		for _, p := range config.allProfiles() {
+			c.Warnings = append(c.Warnings, homePlacementWarnings(config, p)...)
+		}
+		c.Warnings = append(c.Warnings, expiryWarnings(config)...)
+		if !quiet {
+			printJSON(c)
+		}
+		if !c.Valid {
+			os.Exit(checkInvalidRule)
+		}
+		return
+	}
+	if !quiet {
+		for _, p := range config.allProfiles() {
+			for _, warning := range homePlacementWarnings(config, p) {
+				logWarn("%s", warning)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		if !quiet {
+			for _, problem := range problems {
+				logError("%s", problem)
+			}
+			logError("Found %d problems in %s.", len(problems), configPath)
+		}
+		os.Exit(checkInvalidRule)
+	}
+	if !quiet {
+		logSuccess("%s is valid: %d Rules and %d profiles checked.", configPath, len(config.Rules), len(config.allProfiles()))
+	}
+}
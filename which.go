@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --- Rule Inspection ---
+//
+// `multiprof which [dir] [--cmd <command>]` shows what a Wrapper would do in
+// a directory: the Rule that matches, the profile and HOME it selects, and
+// every variable it sets. Nothing is mounted or executed. `multiprof check`
+// answers the same question with an exit status, for Makefiles and hooks;
+// without a directory it validates the whole config instead (see
+// validate.go). Setting MULTIPROF_DRY_RUN=1 makes a Wrapper print the same,
+// plus the command it would run, and exit.
+
+const dryRunEnvVar = "MULTIPROF_DRY_RUN"
+
+// dryRun is set by `multiprof run --dry-run`.
+var dryRun bool
+
+func runWhich(args []string) {
+	whichCmd := flag.NewFlagSet("which", flag.ExitOnError)
+	whichCmd.StringVar(&invokedCommand, "cmd", "", "Match as if this command were being run, for Rules with `commands`.")
+	positional := parseFlags(whichCmd, args)
+	if len(positional) > 1 {
+		exitUsage("Usage: multiprof which [dir] [--cmd <command>]")
+	}
+	dir := dirArg(positional)
+	config, _ := loadConfig(readWrite)
+	res, ok := resolveDir(config, dir)
+	fallback := false
+	if !ok {
+		res, fallback = inspectNoMatch(config, dir)
+	}
+	if jsonOutput {
+		var env *envBuilder
+		if (ok || fallback) && res.Err == nil {
+			env = res.environment(os.Environ())
+		}
+		printJSON(resolutionToJSON(dir, res, ok, env))
+		if !ok || res.Err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if !ok {
+		logWarn("No Rule matches '%s'; Wrappers will %s there.", dir, noMatchBehavior(config))
+		if fallback {
+			fmt.Printf("Directory: %s\n", dir)
+			printResolution(res, res.environment(os.Environ()))
+			os.Exit(1)
+		}
+		for _, line := range explainResolution(config, dir) {
+			fmt.Println(line)
+		}
+		logInfo("%s", noMatchHint(config, dir))
+		os.Exit(1)
+	}
+	if res.Err != nil {
+		exitFailure(errInvalidRule, "Run 'multiprof check' to validate your config.", "Rule %d matches '%s' but is invalid: %v", res.Index+1, dir, res.Err)
+	}
+	fmt.Printf("Directory: %s\n", dir)
+	printResolution(res, res.environment(os.Environ()))
+}
+
+// printResolution describes a resolved Rule and the variables it sets.
+func printResolution(res resolution, env *envBuilder) {
+	if res.Index >= 0 {
+		fmt.Printf("Rule:      %d: '%s'\n", res.Index+1, res.Rule.Pattern)
+		if res.Rule.source != "" {
+			fmt.Printf("From:      %s\n", res.Rule.source)
+		}
+	} else {
+		fmt.Println("Rule:      none (profile chosen explicitly or by default_home)")
+	}
+	fmt.Printf("Profile:   %s\n", res.Profile.label())
+	fmt.Printf("HOME:      %s\n", res.Profile.Home)
+	fmt.Println("Environment:")
+	for _, key := range env.Changed() {
+		fmt.Printf("  %s=%s\n", key, env.Get(key))
+		fmt.Printf("      set by %s\n", env.Origin(key))
+	}
+}
+
+func dryRunRequested() bool {
+	if dryRun {
+		return true
+	}
+	switch os.Getenv(dryRunEnvVar) {
+	case "", "0", "false":
+		return false
+	}
+	return true
+}
+
+// printDryRun shows what execTarget would run instead of running it.
+func printDryRun(res resolution, env *envBuilder, path string, args []string) {
+	fmt.Println("Dry run; nothing was executed.")
+	fmt.Printf("Command:   %s\n", path)
+	fmt.Printf("Arguments: %s\n", strings.Join(args, " "))
+	for _, c := range res.Rule.PreExec {
+		fmt.Printf("Pre-exec:  %s\n", c)
+	}
+	for _, c := range res.Rule.PostExec {
+		fmt.Printf("Post-exec: %s\n", c)
+	}
+	printResolution(res, env)
+}
+
+// Exit statuses of `multiprof check`.
+const (
+	checkNoMatch     = 1
+	checkInvalidRule = 2
+	checkNoTarget    = 3
+)
+
+// checkStatuses name the exit statuses of `multiprof check <dir>` in its JSON
+// output.
+var checkStatuses = map[int]string{0: "ok", checkNoMatch: "no_match", checkInvalidRule: "invalid_rule", checkNoTarget: "no_target"}
+
+func runCheck(args []string) {
+	checkCmd := flag.NewFlagSet("check", flag.ExitOnError)
+	checkCmd.StringVar(&invokedCommand, "cmd", "", "Also require this command to resolve to a target in PATH.")
+	quiet := checkCmd.Bool("quiet", false, "Print nothing; only set the exit status.")
+	prune := checkCmd.Bool("prune", false, "Remove expired Rules from the config without asking.")
+	positional := parseFlags(checkCmd, args)
+	if len(positional) == 0 && invokedCommand == "" {
+		runCheckConfig(*quiet, *prune)
+		return
+	}
+	if len(positional) != 1 {
+		exitUsage("Usage: multiprof check [<dir> [--cmd <command>]] [--quiet]")
+	}
+	dir := dirArg(positional)
+	config, _ := loadConfig(readWrite)
+	res, ok := resolveDir(config, dir)
+	fail := func(status int, format string, v ...interface{}) {
+		if jsonOutput && !*quiet {
+			r := resolutionToJSON(dir, res, ok, nil)
+			r.Status, r.Error = checkStatuses[status], fmt.Sprintf(format, v...)
+			printJSON(r)
+		} else if !*quiet {
+			logError(format, v...)
+		}
+		os.Exit(status)
+	}
+
+	if !ok {
+		fail(checkNoMatch, "No Rule matches '%s'.", dir)
+	}
+	if res.Err != nil {
+		fail(checkInvalidRule, "Rule %d matches '%s' but is invalid: %v", res.Index+1, dir, res.Err)
+	}
+	if invokedCommand != "" {
+		if _, err := findTarget(invokedCommand); err != nil {
+			fail(checkNoTarget, "Rule %d matches, but '%s' is not found in PATH.", res.Index+1, invokedCommand)
+		}
+	}
+	if jsonOutput && !*quiet {
+		r := resolutionToJSON(dir, res, ok, nil)
+		r.Status = checkStatuses[0]
+		printJSON(r)
+	} else if !*quiet {
+		logSuccess("'%s' uses profile '%s' (Rule %d: '%s').", dir, res.Profile.label(), res.Index+1, res.Rule.Pattern)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- Add-rule Wizard ---
+//
+// `multiprof add-rule` without flags on a terminal walks through the same
+// choices as the flags: a pattern (suggested from the current directory), a
+// named profile or home directory (created if missing), and a preview of any
+// existing Rule that would win in the current directory, before saving.
+
+// wizard reads answers from the terminal.
+type wizard struct {
+	reader *bufio.Reader
+}
+
+// ask prints question and returns the trimmed answer, or def when the answer
+// is empty. End of input aborts the wizard.
+func (w wizard) ask(question, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	answer, err := w.reader.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Println()
+		exitFailure(errRefusedChange, "", "Aborted; nothing was changed.")
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def
+	}
+	return answer
+}
+
+// yes asks a yes/no question with the given default.
+func (w wizard) yes(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	switch strings.ToLower(w.ask(question+" ("+hint+")", "")) {
+	case "":
+		return def
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func runAddRuleWizard() {
+	config, _ := loadConfig(readWrite)
+	cwd, _ := os.Getwd()
+	w := wizard{bufio.NewReader(os.Stdin)}
+	fmt.Println("Adding a Rule. Press Enter to accept the suggestion in brackets.")
+
+	var rule Rule
+	for {
+		rule.Pattern = w.ask("Directories to match (glob)", tildePath(cwd)+"/**")
+		if _, err := compilePattern(rule); err != nil {
+			logError("%v", err)
+			continue
+		}
+		break
+	}
+
+	var names []string
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		fmt.Println("Existing profiles:")
+		for i, name := range names {
+			fmt.Printf("  %d) %s (HOME '%s')\n", i+1, name, config.Profiles[name].Home)
+		}
+	}
+	suggestedHome := tildePath(filepath.Join(profilesDir(config), filepath.Base(cwd)))
+	createHome := ""
+	for {
+		answer := w.ask("Profile number or name, or a home directory", suggestedHome)
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(names) {
+			rule.Profile = names[n-1]
+			break
+		}
+		if _, ok := config.Profiles[answer]; ok {
+			rule.Profile = answer
+			break
+		}
+		if !strings.ContainsAny(answer, `/\~$`) {
+			logWarn("'%s' is neither a profile nor a path. Enter a path such as ./%s to use a directory.", answer, answer)
+			continue
+		}
+		home := expandPath(answer)
+		if abs, err := filepath.Abs(home); err == nil {
+			home = abs
+		}
+		if info, err := os.Stat(home); err == nil && !info.IsDir() {
+			logError("'%s' is not a directory.", home)
+			continue
+		} else if err != nil {
+			if !w.yes(fmt.Sprintf("'%s' does not exist. Create it?", home), true) {
+				continue
+			}
+			createHome = home
+		}
+		rule.Home = tildePath(home)
+		break
+	}
+
+	preview := config
+	preview.Rules = append(append([]Rule(nil), config.Rules...), rule)
+	if _, matchesHere := matchRule(Config{Rules: []Rule{rule}}, cwd); !matchesHere {
+		logInfo("The new Rule does not match the current directory.")
+	} else if i, ok := matchRule(preview, cwd); ok && i < len(config.Rules) {
+		logWarn("In %s, existing Rule %d ('%s') would still win over the new Rule.", cwd, i+1, config.Rules[i].Pattern)
+		if config.Settings.MatchStrategy != matchLongest {
+			logInfo("Rules are checked in order; use 'multiprof move-rule %d %d' afterwards to give the new Rule priority.", len(preview.Rules), i+1)
+		}
+	}
+
+	fmt.Printf("\nNew Rule: when in '%s', use %s.\n", rule.Pattern, describeTarget(rule))
+	if !w.yes("Add it?", true) {
+		logInfo("Nothing was changed.")
+		return
+	}
+	tx := newTransaction(readWrite)
+	if createHome != "" {
+		if err := tx.MkdirAll(createHome, 0700); err != nil {
+			tx.abort("Could not create '%s': %v", createHome, err)
+		}
+	}
+	if err := tx.SaveConfig(preview); err != nil {
+		tx.abort("Could not save config: %v", err)
+	}
+	tx.Commit()
+	logSuccess("Added Rule: when in '%s', use %s.", rule.Pattern, describeTarget(rule))
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// --- Wrapper Manifest ---
+//
+// Every Wrapper multiprof creates gets a [wrappers.<command>] table in the
+// config, empty unless the Wrapper has options, so the config lists the
+// Wrappers next to the Rules that drive them. On a new machine, or after the
+// multiprof binary has moved and left the symlinks pointing at an old copy,
+// `multiprof sync-wrappers` creates whatever is missing or stale from that
+// list, and removes dead links: symlinks in the Wrapper Directory whose
+// target is gone and which the config does not list. Wrappers found there
+// but not in the config, such as those made before it kept the list, are
+// added to it.
+
+// wrapperName returns the file name of the Wrapper for cmdName.
+func (c Config) wrapperName(cmdName string) string {
+	if name := c.Wrappers[cmdName].Name; name != "" {
+		return name
+	}
+	return cmdName + c.Settings.Suffix
+}
+
+// wrappedCommand returns the command the Wrapper named wrapperName runs.
+func (c Config) wrappedCommand(wrapperName string) string {
+	if cmdName, ok := c.customNamed(wrapperName); ok {
+		return cmdName
+	}
+	return strings.TrimSuffix(wrapperName, c.Settings.Suffix)
+}
+
+// customNamed returns the command whose Wrapper is named wrapperName by its
+// `name` setting.
+func (c Config) customNamed(wrapperName string) (string, bool) {
+	for cmdName, settings := range c.Wrappers {
+		if settings.Name == wrapperName {
+			return cmdName, true
+		}
+	}
+	return "", false
+}
+
+// wrappedCommands returns the commands wrapped by multiprof Wrappers in dir,
+// whether named after settings.suffix or by their `name` setting.
+func wrappedCommands(config Config, dir string) []string {
+	var cmdNames []string
+	for _, name := range wrapperNamesIn(dir) {
+		if !isOwnWrapper(filepath.Join(dir, name)) {
+			continue
+		}
+		if cmdName, ok := config.customNamed(name); ok {
+			cmdNames = append(cmdNames, cmdName)
+		} else if strings.HasSuffix(name, config.Settings.Suffix) {
+			cmdNames = append(cmdNames, strings.TrimSuffix(name, config.Settings.Suffix))
+		}
+	}
+	return cmdNames
+}
+
+// recordWrapper adds cmdName to the Wrappers in config, reporting whether it
+// was new.
+func recordWrapper(config *Config, cmdName string) bool {
+	if _, ok := config.Wrappers[cmdName]; ok {
+		return false
+	}
+	if config.Wrappers == nil {
+		config.Wrappers = make(map[string]WrapperSettings)
+	}
+	config.Wrappers[cmdName] = WrapperSettings{}
+	return true
+}
+
+// Wrapper states, as reported by wrapperState.
+const (
+	wrapperOK      = "ok"
+	wrapperMissing = "missing"
+	wrapperStale   = "stale"
+)
+
+// wrapperState reports whether the Wrapper for cmdName runs the running
+// binary, is missing, or is stale: anything else, such as a link to a
+// multiprof that has since moved.
+func wrapperState(config Config, cmdName string) string {
+	wrapperDir, _ := getWrapperDir()
+	path := filepath.Join(wrapperDir, config.wrapperName(cmdName))
+	if !wrapperExists(path) {
+		return wrapperMissing
+	}
+	if target, _, ok := wrapperTarget(path); ok && sameAsSelf(target) {
+		return wrapperOK
+	}
+	return wrapperStale
+}
+
+// wrapperCurrent reports whether the Wrapper for cmdName is up to date,
+// including, if suffixed, its completion file for shell.
+func wrapperCurrent(config Config, cmdName, shell string) bool {
+	wrapperName := config.wrapperName(cmdName)
+	return wrapperState(config, cmdName) == wrapperOK && (wrapperName == cmdName || fileExists(completionPath(shell, wrapperName)))
+}
+
+// deadWrappers returns the names of symlinks in dir that point to nothing and
+// are not Wrappers config lists.
+func deadWrappers(config Config, dir string) []string {
+	entries, _ := os.ReadDir(dir)
+	var dead []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		name, _ := wrapperFileName(entry.Name())
+		if _, listed := config.Wrappers[config.wrappedCommand(name)]; !listed {
+			dead = append(dead, entry.Name())
+		}
+	}
+	return dead
+}
+
+// staleWrappersHint describes Wrappers listed in config that are missing or
+// point elsewhere than the running multiprof, or returns "" if there are
+// none.
+func staleWrappersHint(config Config) string {
+	n := 0
+	for cmdName := range config.Wrappers {
+		if wrapperState(config, cmdName) != wrapperOK {
+			n++
+		}
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d Wrapper(s) listed in the config are missing or do not point to this multiprof. Run 'multiprof sync-wrappers' to fix them.", n)
+}
+
+func runSyncWrappers(args []string) {
+	syncCmd := flag.NewFlagSet("sync-wrappers", flag.ExitOnError)
+	onConflict := syncCmd.String("on-conflict", conflictAsk, "What to do with existing files multiprof did not create: ask, keep, overwrite or backup.")
+	shellFlag := syncCmd.String("shell", "", "Shell to write completion files for: bash, zsh or fish (default: from $SHELL).")
+	dryRun := syncCmd.Bool("dry-run", false, "Only show what would change.")
+	if positional := parseFlags(syncCmd, args); len(positional) > 0 {
+		exitUsage("Usage: multiprof sync-wrappers [--on-conflict ask|keep|overwrite|backup] [--shell bash|zsh|fish] [--dry-run]")
+	}
+	shell, err := completionShell(*shellFlag)
+	if err != nil {
+		exitWith(err)
+	}
+	if !validConflictPolicy(*onConflict) {
+		exitUsage("Invalid --on-conflict value '%s'.", *onConflict)
+	}
+	config, _ := loadConfig(readWrite)
+	wrapperDir, _ := getWrapperDir()
+
+	var adopted, stale, synced, pruned []string
+	for _, cmdName := range wrappedCommands(config, wrapperDir) {
+		if recordWrapper(&config, cmdName) {
+			adopted = append(adopted, cmdName)
+		}
+	}
+	dead := deadWrappers(config, wrapperDir)
+	if len(config.Wrappers) == 0 && len(dead) == 0 {
+		logInfo("No Wrappers to sync. Create some with 'multiprof add-wrapper <command>...'.")
+		return
+	}
+	for _, cmdName := range slices.Sorted(maps.Keys(config.Wrappers)) {
+		if !wrapperCurrent(config, cmdName, shell) {
+			stale = append(stale, cmdName)
+		}
+	}
+	if *dryRun {
+		if len(adopted) > 0 {
+			logInfo("Would add Wrapper(s) found in %s to the config: %s", tildePath(wrapperDir), strings.Join(adopted, ", "))
+		}
+		if len(stale) > 0 {
+			logInfo("Would create or repoint Wrapper(s): %s", strings.Join(stale, ", "))
+		}
+		if len(dead) > 0 {
+			logInfo("Would remove dead link(s): %s", strings.Join(dead, ", "))
+		}
+		logInfo("Dry run: nothing was changed.")
+		return
+	}
+
+	tx := newTransaction(readWrite)
+	for _, name := range dead {
+		if err := tx.Remove(filepath.Join(wrapperDir, name)); err != nil {
+			tx.abort("Could not remove dead link: %v", err)
+		}
+		for _, path := range completionPaths(name) {
+			if fileExists(path) && isOwnCompletion(path) {
+				if err := tx.Remove(path); err != nil {
+					tx.abort("Could not remove completion file: %v", err)
+				}
+			}
+		}
+		pruned = append(pruned, name)
+	}
+	for _, cmdName := range stale {
+		if _, err := createWrapper(tx, config, cmdName, shell, *onConflict); err == errKeptExisting {
+			continue
+		} else if err != nil {
+			tx.abort("%v", err)
+		}
+		synced = append(synced, config.wrapperName(cmdName))
+	}
+	if len(adopted) > 0 {
+		if err := tx.SaveConfig(config); err != nil {
+			tx.abort("Could not save config: %v", err)
+		}
+	}
+	tx.Commit()
+
+	if len(adopted) > 0 {
+		logSuccess("Added Wrapper(s) found in %s to the config: %s", tildePath(wrapperDir), strings.Join(adopted, ", "))
+	}
+	if len(pruned) > 0 {
+		logSuccess("Removed dead link(s) from %s: %s", tildePath(wrapperDir), strings.Join(pruned, ", "))
+	}
+	if len(synced) > 0 {
+		self, _ := os.Executable()
+		logSuccess("Created or repointed Wrapper(s) at %s: %s", tildePath(self), strings.Join(synced, ", "))
+	} else if len(pruned) == 0 {
+		logSuccess("All %d Wrapper(s) are up to date.", len(config.Wrappers))
+	}
+	warnWrapperDirNotInPath()
+}
+
+// checkWrapperName checks that name can be the file name of the Wrapper for
+// cmdName.
+func checkWrapperName(config Config, name, cmdName string) error {
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("'%s' is not a valid Wrapper name", name)
+	}
+	if name == "multiprof" {
+		return fmt.Errorf("a Wrapper cannot be named 'multiprof'")
+	}
+	for other := range config.Wrappers {
+		if other != cmdName && config.wrapperName(other) == name {
+			return fmt.Errorf("'%s' is already the Wrapper for '%s'", name, other)
+		}
+	}
+	return nil
+}
+
+// removeRenamedWrapper removes the Wrapper for cmdName, and its completion
+// files, if it was called previous and is now named differently.
+func removeRenamedWrapper(tx *transaction, config Config, cmdName, previous string) error {
+	if previous == config.wrapperName(cmdName) {
+		return nil
+	}
+	wrapperDir, _ := getWrapperDir()
+	if path := filepath.Join(wrapperDir, previous); isOwnWrapper(path) {
+		if _, err := removeWrapper(tx, path); err != nil {
+			return fmt.Errorf("could not remove the Wrapper '%s': %w", previous, err)
+		}
+	}
+	for _, path := range completionPaths(previous) {
+		if fileExists(path) && isOwnCompletion(path) {
+			if err := tx.Remove(path); err != nil {
+				return fmt.Errorf("could not remove completion file: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// --- XDG Base Directories ---
+//
+// Many tools read $XDG_CONFIG_HOME, $XDG_DATA_HOME and $XDG_CACHE_HOME before
+// falling back to HOME, so a user who sets them would see those tools ignore
+// the profile. `xdg = true` on a profile or Rule points the three into the
+// profile home. multiprof itself keeps using the user's own directories: the
+// originals are passed on in MULTIPROF_USER_XDG_*, as the home is in
+// MULTIPROF_USER_HOME.
+
+// xdgDirs are the XDG variables `xdg = true` sets, with their defaults below
+// a home.
+var xdgDirs = []struct{ key, dir string }{
+	{"XDG_CONFIG_HOME", ".config"},
+	{"XDG_DATA_HOME", dataHomeDirName},
+	{"XDG_CACHE_HOME", ".cache"},
+}
+
+func userXDGEnvVar(key string) string { return "MULTIPROF_USER_" + key }
+
+// userXDGDir returns the user's own value of the XDG variable key, even inside
+// a profile with `xdg` set, or "" if it is unset or not an absolute path.
+func userXDGDir(key string) string {
+	value, saved := os.LookupEnv(userXDGEnvVar(key))
+	if !saved {
+		value = os.Getenv(key)
+	}
+	if !filepath.IsAbs(value) {
+		return ""
+	}
+	return value
+}
+
+// setXDGDirs points the XDG variables into home, saving the user's own.
+func setXDGDirs(env *envBuilder, home, origin string) {
+	for _, d := range xdgDirs {
+		env.Set(userXDGEnvVar(d.key), userXDGDir(d.key), "multiprof")
+		env.Set(d.key, filepath.Join(home, d.dir), origin)
+	}
+}
+
+// configHome returns the user's $XDG_CONFIG_HOME, or its default ~/.config.
+func configHome() string {
+	if dir := userXDGDir("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+	return expandPath("~/.config")
+}